@@ -32,9 +32,14 @@ type Config struct {
 	PGSSLMode  string
 
 	// Server
-	ServerPort     string
-	JWTSecret      string
-	JWTExpireHours int
+	ServerPort string
+	JWTSecret  string
+	// Access tokens are short-lived; the refresh token of the session (stored
+	// hashed in user_sessions) is rotated on every refresh until it expires
+	JWTAccessTokenMinutes int
+	JWTRefreshTokenHours  int
+	// Role given to self-registered users and to users whose role was removed
+	DefaultUserRole string
 
 	// API
 	PlateAPIURL string
@@ -48,6 +53,63 @@ type Config struct {
 
 	// Logging
 	LogLevel string
+
+	// Mercado Livre notifications
+	MLApplicationID string
+
+	// NBS API gateway
+	NBSBaseURL         string
+	NBSEcommerceAPI    string
+	NBSUserPrefix      string
+	NBSPassword        string
+	NBSPackage         string
+	NBSTokenTTLMinutes int
+
+	// Chave usada para criptografar credenciais salvas no banco (ex.: OAuth do Mercado Livre)
+	CredentialsEncryptionKey string
+
+	// Routing rules
+	RoutingRulesReloadSeconds int
+
+	// Outbound HTTP (Mercado Livre, NBS, IBGE, plate API, Telegram)
+	HTTPTimeoutSeconds         int
+	HTTPMaxRetries             int
+	HTTPBreakerThreshold       int
+	HTTPBreakerCooldownSeconds int
+
+	// Per-operation deadlines, in seconds (0 disables the deadline)
+	DBQueryTimeoutSeconds        int
+	StockQueryTimeoutSeconds     int
+	PlateLookupTimeoutSeconds    int
+	IntegrationTimeoutSeconds    int
+	XMLIntegrationTimeoutSeconds int
+
+	// Order poller (Mercado Livre)
+	OrderPollerEnabled         bool
+	OrderPollerIntervalMinutes int
+	OrderPollerDryRun          bool
+
+	// Scheduled XML invoice sweep
+	XMLSweepEnabled         bool
+	XMLSweepIntervalMinutes int
+	XMLSweepConcurrency     int
+
+	// Deferred XML uploads (Mercado Livre "buffered" shipments)
+	XMLDeferredIntervalMinutes int
+	XMLDeferredRetryMinutes    int
+	XMLDeferredMaxAttempts     int
+
+	// XML integrator run history (0 keeps it forever)
+	XMLIntegratorLogRetentionDays int
+
+	// Notifications (channels and routes live in the database; the Telegram
+	// bot below seeds the first channel when none is configured)
+	NotifyPollSeconds    int
+	NotifyMaxAttempts    int
+	NotifyRetryMinutes   int
+	NotifyDigestHour     int
+	NotifyTelegramToken  string
+	NotifyTelegramChatID string
 }
 
 func Load() *Config {
@@ -73,9 +135,11 @@ func Load() *Config {
 		PGDatabase: getEnv("PG_DATABASE", ""),
 		PGSSLMode:  getEnv("PG_SSL_MODE", "disable"),
 
-		ServerPort:     getEnv("SERVER_PORT", "8080"),
-		JWTSecret:      getEnv("JWT_SECRET", "your-secret-key"),
-		JWTExpireHours: getEnvAsInt("JWT_EXPIRE_HOURS", 168), // 7 dias
+		ServerPort:            getEnv("SERVER_PORT", "8080"),
+		JWTSecret:             getEnv("JWT_SECRET", "your-secret-key"),
+		JWTAccessTokenMinutes: getEnvAsInt("JWT_ACCESS_TOKEN_MINUTES", 15),
+		JWTRefreshTokenHours:  getEnvAsInt("JWT_REFRESH_TOKEN_HOURS", 168), // 7 dias
+		DefaultUserRole:       getEnv("DEFAULT_USER_ROLE", "atendimento"),
 
 		PlateAPIURL: getEnv("PLATE_API_URL", ""),
 		PlateAPIKey: getEnv("PLATE_API_KEY", ""),
@@ -85,6 +149,51 @@ func Load() *Config {
 		CORSAllowedOrigins: strings.Split(getEnv("CORS_ALLOWED_ORIGINS", "http://localhost:3000"), ","),
 
 		LogLevel: getEnv("LOG_LEVEL", "info"),
+
+		MLApplicationID: getEnv("ML_APPLICATION_ID", ""),
+
+		NBSBaseURL:         getEnv("NBS_BASE_URL", "http://10.13.1.19:8080/nbsapi-gateway"),
+		NBSEcommerceAPI:    getEnv("NBS_ECOMMERCE_API", "nbs/ecommerce/hystalo/api"),
+		NBSUserPrefix:      getEnv("NBS_USER_PREFIX", "HYSTALO"),
+		NBSPassword:        getEnv("NBS_PASSWORD", ""),
+		NBSPackage:         getEnv("NBS_PACKAGE", "HYSTALO"),
+		NBSTokenTTLMinutes: getEnvAsInt("NBS_TOKEN_TTL_MINUTES", 30),
+
+		CredentialsEncryptionKey: getEnv("CREDENTIALS_ENCRYPTION_KEY", ""),
+
+		RoutingRulesReloadSeconds: getEnvAsInt("ROUTING_RULES_RELOAD_SECONDS", 60),
+
+		HTTPTimeoutSeconds:         getEnvAsInt("HTTP_TIMEOUT_SECONDS", 30),
+		HTTPMaxRetries:             getEnvAsInt("HTTP_MAX_RETRIES", 3),
+		HTTPBreakerThreshold:       getEnvAsInt("HTTP_BREAKER_THRESHOLD", 5),
+		HTTPBreakerCooldownSeconds: getEnvAsInt("HTTP_BREAKER_COOLDOWN_SECONDS", 30),
+
+		DBQueryTimeoutSeconds:        getEnvAsInt("DB_QUERY_TIMEOUT_SECONDS", 30),
+		StockQueryTimeoutSeconds:     getEnvAsInt("STOCK_QUERY_TIMEOUT_SECONDS", 60),
+		PlateLookupTimeoutSeconds:    getEnvAsInt("PLATE_LOOKUP_TIMEOUT_SECONDS", 60),
+		IntegrationTimeoutSeconds:    getEnvAsInt("INTEGRATION_TIMEOUT_SECONDS", 600),
+		XMLIntegrationTimeoutSeconds: getEnvAsInt("XML_INTEGRATION_TIMEOUT_SECONDS", 300),
+
+		OrderPollerEnabled:         getEnvAsBool("ORDER_POLLER_ENABLED", false),
+		OrderPollerIntervalMinutes: getEnvAsInt("ORDER_POLLER_INTERVAL_MINUTES", 10),
+		OrderPollerDryRun:          getEnvAsBool("ORDER_POLLER_DRY_RUN", false),
+
+		XMLSweepEnabled:         getEnvAsBool("XML_SWEEP_ENABLED", false),
+		XMLSweepIntervalMinutes: getEnvAsInt("XML_SWEEP_INTERVAL_MINUTES", 30),
+		XMLSweepConcurrency:     getEnvAsInt("XML_SWEEP_CONCURRENCY", 4),
+
+		XMLDeferredIntervalMinutes: getEnvAsInt("XML_DEFERRED_INTERVAL_MINUTES", 5),
+		XMLDeferredRetryMinutes:    getEnvAsInt("XML_DEFERRED_RETRY_MINUTES", 60),
+		XMLDeferredMaxAttempts:     getEnvAsInt("XML_DEFERRED_MAX_ATTEMPTS", 5),
+
+		XMLIntegratorLogRetentionDays: getEnvAsInt("XML_INTEGRATOR_LOG_RETENTION_DAYS", 90),
+
+		NotifyPollSeconds:    getEnvAsInt("NOTIFY_POLL_SECONDS", 15),
+		NotifyMaxAttempts:    getEnvAsInt("NOTIFY_MAX_ATTEMPTS", 5),
+		NotifyRetryMinutes:   getEnvAsInt("NOTIFY_RETRY_MINUTES", 1),
+		NotifyDigestHour:     getEnvAsInt("NOTIFY_DIGEST_HOUR", 8),
+		NotifyTelegramToken:  getEnv("NOTIFY_TELEGRAM_BOT_TOKEN", ""),
+		NotifyTelegramChatID: getEnv("NOTIFY_TELEGRAM_CHAT_ID", ""),
 	}
 }
 
@@ -112,3 +221,12 @@ func getEnvAsInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
@@ -102,11 +102,19 @@ func createTables(db *sql.DB) error {
 		`IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='integration_logs' AND xtype='U')
 		CREATE TABLE integration_logs (
 			id UNIQUEIDENTIFIER DEFAULT NEWID() PRIMARY KEY,
-			user_id UNIQUEIDENTIFIER NOT NULL,
+			user_id UNIQUEIDENTIFIER NULL,
 			process_type NVARCHAR(100) NOT NULL,
 			status NVARCHAR(50) NOT NULL,
 			data NVARCHAR(MAX),
+			conta NVARCHAR(50),
+			marketplace NVARCHAR(50),
+			num_pedido NVARCHAR(50),
+			step NVARCHAR(50),
+			attempts INT DEFAULT 0,
+			error_message NVARCHAR(MAX),
 			created_at DATETIME2 DEFAULT GETDATE(),
+			updated_at DATETIME2 DEFAULT GETDATE(),
+			finished_at DATETIME2,
 			FOREIGN KEY (user_id) REFERENCES users(id)
 		)`,
 
@@ -135,6 +143,272 @@ func createTables(db *sql.DB) error {
 			user_id UNIQUEIDENTIFIER,
 			FOREIGN KEY (user_id) REFERENCES users(id)
 		)`,
+
+		`IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='order_poller_contas' AND xtype='U')
+		CREATE TABLE order_poller_contas (
+			conta NVARCHAR(50) PRIMARY KEY,
+			enabled BIT NOT NULL DEFAULT 0,
+			last_run_at DATETIME2,
+			last_found INT DEFAULT 0,
+			last_error NVARCHAR(MAX),
+			updated_at DATETIME2 DEFAULT GETDATE()
+		)`,
+
+		`IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='ml_webhook_events' AND xtype='U')
+		CREATE TABLE ml_webhook_events (
+			id UNIQUEIDENTIFIER DEFAULT NEWID() PRIMARY KEY,
+			topic NVARCHAR(50) NOT NULL,
+			resource NVARCHAR(255) NOT NULL,
+			ml_user_id NVARCHAR(50),
+			conta NVARCHAR(50),
+			status NVARCHAR(20) NOT NULL,
+			attempts INT DEFAULT 1,
+			error_message NVARCHAR(MAX),
+			received_at DATETIME2 DEFAULT GETDATE(),
+			processed_at DATETIME2,
+			CONSTRAINT UQ_ml_webhook_events_resource UNIQUE (topic, resource)
+		)`,
+
+		`IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='ml_credentials' AND xtype='U')
+		CREATE TABLE ml_credentials (
+			conta NVARCHAR(50) PRIMARY KEY,
+			client_id NVARCHAR(100) NOT NULL,
+			client_secret NVARCHAR(MAX) NOT NULL,
+			refresh_token NVARCHAR(MAX) NOT NULL,
+			seller_id NVARCHAR(50),
+			last_refresh_at DATETIME2,
+			last_error NVARCHAR(MAX),
+			updated_by NVARCHAR(255),
+			created_at DATETIME2 DEFAULT GETDATE(),
+			updated_at DATETIME2 DEFAULT GETDATE()
+		)`,
+
+		`IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='routing_rules' AND xtype='U')
+		CREATE TABLE routing_rules (
+			id UNIQUEIDENTIFIER DEFAULT NEWID() PRIMARY KEY,
+			rule_type NVARCHAR(20) NOT NULL,
+			match_value NVARCHAR(100) NOT NULL,
+			schema_name NVARCHAR(50),
+			cod_empresa NVARCHAR(20),
+			cod_fornecedor NVARCHAR(20),
+			conta_nbs NVARCHAR(20),
+			filial NVARCHAR(20),
+			priority INT DEFAULT 0,
+			enabled BIT NOT NULL DEFAULT 1,
+			description NVARCHAR(255),
+			updated_by NVARCHAR(255),
+			created_at DATETIME2 DEFAULT GETDATE(),
+			updated_at DATETIME2 DEFAULT GETDATE(),
+			CONSTRAINT UQ_routing_rules_match UNIQUE (rule_type, match_value)
+		)`,
+
+		`IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='integration_idempotency_keys' AND xtype='U')
+		CREATE TABLE integration_idempotency_keys (
+			idempotency_key NVARCHAR(255) PRIMARY KEY,
+			job_id UNIQUEIDENTIFIER NOT NULL,
+			conta NVARCHAR(50),
+			marketplace NVARCHAR(50),
+			num_pedido NVARCHAR(50),
+			created_at DATETIME2 DEFAULT GETDATE()
+		)`,
+
+		`IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='xml_sweep_runs' AND xtype='U')
+		CREATE TABLE xml_sweep_runs (
+			id UNIQUEIDENTIFIER DEFAULT NEWID() PRIMARY KEY,
+			trigger_type NVARCHAR(20) NOT NULL,
+			triggered_by NVARCHAR(255),
+			status NVARCHAR(20) NOT NULL,
+			total INT DEFAULT 0,
+			uploaded INT DEFAULT 0,
+			waiting INT DEFAULT 0,
+			no_xml INT DEFAULT 0,
+			errors INT DEFAULT 0,
+			error_message NVARCHAR(MAX),
+			items NVARCHAR(MAX),
+			started_at DATETIME2 DEFAULT GETDATE(),
+			finished_at DATETIME2
+		)`,
+
+		`IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='xml_deferred_uploads' AND xtype='U')
+		CREATE TABLE xml_deferred_uploads (
+			id UNIQUEIDENTIFIER DEFAULT NEWID() PRIMARY KEY,
+			num_envio NVARCHAR(50) UNIQUE NOT NULL,
+			num_pedido NVARCHAR(50) NOT NULL,
+			num_prenota NVARCHAR(50) NOT NULL,
+			conta NVARCHAR(50) NOT NULL,
+			buffering_date DATETIME2 NOT NULL,
+			next_attempt_at DATETIME2 NOT NULL,
+			status NVARCHAR(20) NOT NULL,
+			attempts INT DEFAULT 0,
+			ml_status NVARCHAR(100),
+			last_error NVARCHAR(MAX),
+			created_at DATETIME2 DEFAULT GETDATE(),
+			updated_at DATETIME2 DEFAULT GETDATE(),
+			processed_at DATETIME2
+		)`,
+
+		`IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name='IX_xml_deferred_uploads_due')
+		CREATE INDEX IX_xml_deferred_uploads_due ON xml_deferred_uploads (status, next_attempt_at)`,
+
+		`IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='notification_channels' AND xtype='U')
+		CREATE TABLE notification_channels (
+			id INT IDENTITY(1,1) PRIMARY KEY,
+			name NVARCHAR(100) NOT NULL,
+			type NVARCHAR(20) NOT NULL,
+			settings NVARCHAR(MAX) NOT NULL,
+			enabled BIT DEFAULT 1,
+			created_by NVARCHAR(100),
+			created_at DATETIME2 DEFAULT GETDATE(),
+			updated_at DATETIME2 DEFAULT GETDATE()
+		)`,
+
+		`IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='notification_routes' AND xtype='U')
+		CREATE TABLE notification_routes (
+			id INT IDENTITY(1,1) PRIMARY KEY,
+			channel_id INT NOT NULL REFERENCES notification_channels(id) ON DELETE CASCADE,
+			conta NVARCHAR(50) NULL,
+			min_severity NVARCHAR(20) NOT NULL,
+			created_at DATETIME2 DEFAULT GETDATE()
+		)`,
+
+		`IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='notification_templates' AND xtype='U')
+		CREATE TABLE notification_templates (
+			kind NVARCHAR(50) PRIMARY KEY,
+			subject NVARCHAR(500) NOT NULL,
+			body NVARCHAR(MAX) NOT NULL,
+			updated_by NVARCHAR(100),
+			updated_at DATETIME2 DEFAULT GETDATE()
+		)`,
+
+		`IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='notification_outbox' AND xtype='U')
+		CREATE TABLE notification_outbox (
+			id BIGINT IDENTITY(1,1) PRIMARY KEY,
+			channel_id INT NOT NULL REFERENCES notification_channels(id) ON DELETE CASCADE,
+			kind NVARCHAR(50) NOT NULL,
+			severity NVARCHAR(20) NOT NULL,
+			conta NVARCHAR(50),
+			subject NVARCHAR(500) NOT NULL,
+			body NVARCHAR(MAX) NOT NULL,
+			payload NVARCHAR(MAX),
+			status NVARCHAR(20) NOT NULL,
+			attempts INT DEFAULT 0,
+			last_error NVARCHAR(MAX),
+			next_attempt_at DATETIME2 NOT NULL,
+			created_at DATETIME2 DEFAULT GETDATE(),
+			sent_at DATETIME2
+		)`,
+
+		`IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name='IX_notification_outbox_due')
+		CREATE INDEX IX_notification_outbox_due ON notification_outbox (status, next_attempt_at)`,
+
+		`IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='notification_digest_items' AND xtype='U')
+		CREATE TABLE notification_digest_items (
+			id BIGINT IDENTITY(1,1) PRIMARY KEY,
+			kind NVARCHAR(50) NOT NULL,
+			severity NVARCHAR(20) NOT NULL,
+			conta NVARCHAR(50),
+			summary NVARCHAR(1000) NOT NULL,
+			created_at DATETIME2 DEFAULT GETDATE(),
+			sent_at DATETIME2
+		)`,
+
+		`IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name='IX_notification_digest_items_pending')
+		CREATE INDEX IX_notification_digest_items_pending ON notification_digest_items (sent_at, created_at)`,
+
+		`IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='notifications' AND xtype='U')
+		CREATE TABLE notifications (
+			id BIGINT IDENTITY(1,1) PRIMARY KEY,
+			user_id UNIQUEIDENTIFIER NOT NULL,
+			kind NVARCHAR(50) NOT NULL,
+			severity NVARCHAR(20) NOT NULL,
+			conta NVARCHAR(50),
+			title NVARCHAR(500) NOT NULL,
+			message NVARCHAR(MAX) NOT NULL,
+			data NVARCHAR(MAX),
+			read_at DATETIME2,
+			created_at DATETIME2 DEFAULT GETDATE(),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+
+		`IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name='IX_notifications_user')
+		CREATE INDEX IX_notifications_user ON notifications (user_id, read_at, created_at)`,
+
+		`IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='notification_subscriptions' AND xtype='U')
+		CREATE TABLE notification_subscriptions (
+			id INT IDENTITY(1,1) PRIMARY KEY,
+			user_id UNIQUEIDENTIFIER NOT NULL,
+			conta NVARCHAR(50),
+			kind NVARCHAR(50),
+			min_severity NVARCHAR(20) NOT NULL DEFAULT 'info',
+			created_at DATETIME2 DEFAULT GETDATE(),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+
+		`IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='xml_integrator_runs' AND xtype='U')
+		CREATE TABLE xml_integrator_runs (
+			id UNIQUEIDENTIFIER DEFAULT NEWID() PRIMARY KEY,
+			num_pedido NVARCHAR(50) NOT NULL,
+			conta NVARCHAR(50),
+			user_id UNIQUEIDENTIFIER NULL,
+			status NVARCHAR(20) NOT NULL,
+			total INT DEFAULT 0,
+			success_count INT DEFAULT 0,
+			error_count INT DEFAULT 0,
+			error_message NVARCHAR(MAX),
+			started_at DATETIME2 DEFAULT GETDATE(),
+			finished_at DATETIME2
+		)`,
+
+		`IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name='IX_xml_integrator_runs_started')
+		CREATE INDEX IX_xml_integrator_runs_started ON xml_integrator_runs (started_at)`,
+
+		`IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='xml_integrator_logs' AND xtype='U')
+		CREATE TABLE xml_integrator_logs (
+			id BIGINT IDENTITY(1,1) PRIMARY KEY,
+			run_id UNIQUEIDENTIFIER NOT NULL,
+			num_pedido NVARCHAR(50) NOT NULL,
+			conta NVARCHAR(50),
+			level NVARCHAR(20) NOT NULL,
+			step NVARCHAR(100),
+			message NVARCHAR(MAX),
+			created_at DATETIME2 DEFAULT GETDATE()
+		)`,
+
+		`IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name='IX_xml_integrator_logs_run')
+		CREATE INDEX IX_xml_integrator_logs_run ON xml_integrator_logs (run_id)`,
+
+		`IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name='IX_xml_integrator_logs_created')
+		CREATE INDEX IX_xml_integrator_logs_created ON xml_integrator_logs (created_at)`,
+
+		// Roles are named permission sets; users.role holds the role name
+		`IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='roles' AND xtype='U')
+		CREATE TABLE roles (
+			name NVARCHAR(50) PRIMARY KEY,
+			description NVARCHAR(255),
+			created_at DATETIME2 DEFAULT GETDATE(),
+			updated_at DATETIME2 DEFAULT GETDATE()
+		)`,
+
+		`IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='role_permissions' AND xtype='U')
+		CREATE TABLE role_permissions (
+			role_name NVARCHAR(50) NOT NULL,
+			permission NVARCHAR(100) NOT NULL,
+			PRIMARY KEY (role_name, permission),
+			FOREIGN KEY (role_name) REFERENCES roles(name) ON DELETE CASCADE
+		)`,
+
+		// Contas a user can access ('*' for every conta); users without rows
+		// see none. Users that existed before the table keep every conta.
+		`IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='user_contas' AND xtype='U')
+		BEGIN
+			CREATE TABLE user_contas (
+				user_id UNIQUEIDENTIFIER NOT NULL,
+				conta NVARCHAR(50) NOT NULL,
+				PRIMARY KEY (user_id, conta),
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+			);
+			INSERT INTO user_contas (user_id, conta) SELECT id, '*' FROM users;
+		END`,
 	}
 
 	for i, query := range tables {
@@ -148,11 +422,68 @@ func createTables(db *sql.DB) error {
 
 	// Add new columns to existing users table if they don't exist
 	migrationQueries := []string{
+		// user_sessions.token holds the SHA-256 of the session's current refresh token
+		`IF NOT EXISTS (SELECT * FROM sys.columns WHERE object_id = OBJECT_ID('user_sessions') AND name = 'previous_token')
+		ALTER TABLE user_sessions ADD previous_token NVARCHAR(500) NULL`,
+
+		`IF NOT EXISTS (SELECT * FROM sys.columns WHERE object_id = OBJECT_ID('user_sessions') AND name = 'revoked_at')
+		ALTER TABLE user_sessions ADD revoked_at DATETIME2 NULL`,
+
+		`IF NOT EXISTS (SELECT * FROM sys.columns WHERE object_id = OBJECT_ID('user_sessions') AND name = 'last_used_at')
+		ALTER TABLE user_sessions ADD last_used_at DATETIME2 NULL`,
+
+		`IF NOT EXISTS (SELECT * FROM sys.columns WHERE object_id = OBJECT_ID('user_sessions') AND name = 'ip_address')
+		ALTER TABLE user_sessions ADD ip_address NVARCHAR(45) NULL`,
+
+		`IF NOT EXISTS (SELECT * FROM sys.columns WHERE object_id = OBJECT_ID('user_sessions') AND name = 'user_agent')
+		ALTER TABLE user_sessions ADD user_agent NVARCHAR(500) NULL`,
+
+		`IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name='IX_user_sessions_token')
+		CREATE INDEX IX_user_sessions_token ON user_sessions (token)`,
+
+		`IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name='IX_user_sessions_user')
+		CREATE INDEX IX_user_sessions_user ON user_sessions (user_id, revoked_at)`,
+
 		`IF NOT EXISTS (SELECT * FROM sys.columns WHERE object_id = OBJECT_ID('users') AND name = 'is_first_login')
 		ALTER TABLE users ADD is_first_login BIT DEFAULT 1`,
 
 		`IF NOT EXISTS (SELECT * FROM sys.columns WHERE object_id = OBJECT_ID('users') AND name = 'password_changed_at')
 		ALTER TABLE users ADD password_changed_at DATETIME2 DEFAULT GETDATE()`,
+
+		// Integration jobs: integration_logs stores one row per job and the step it reached
+		`IF EXISTS (SELECT * FROM sys.columns WHERE object_id = OBJECT_ID('integration_logs') AND name = 'user_id' AND is_nullable = 0)
+		ALTER TABLE integration_logs ALTER COLUMN user_id UNIQUEIDENTIFIER NULL`,
+
+		// Routing rules: CNPJ of each empresa, checked against the NF-e emitter
+		`IF NOT EXISTS (SELECT * FROM sys.columns WHERE object_id = OBJECT_ID('routing_rules') AND name = 'cnpj')
+		ALTER TABLE routing_rules ADD cnpj NVARCHAR(14)`,
+
+		`IF NOT EXISTS (SELECT * FROM sys.columns WHERE object_id = OBJECT_ID('integration_logs') AND name = 'conta')
+		ALTER TABLE integration_logs ADD conta NVARCHAR(50)`,
+
+		`IF NOT EXISTS (SELECT * FROM sys.columns WHERE object_id = OBJECT_ID('integration_logs') AND name = 'marketplace')
+		ALTER TABLE integration_logs ADD marketplace NVARCHAR(50)`,
+
+		`IF NOT EXISTS (SELECT * FROM sys.columns WHERE object_id = OBJECT_ID('integration_logs') AND name = 'num_pedido')
+		ALTER TABLE integration_logs ADD num_pedido NVARCHAR(50)`,
+
+		`IF NOT EXISTS (SELECT * FROM sys.columns WHERE object_id = OBJECT_ID('integration_logs') AND name = 'step')
+		ALTER TABLE integration_logs ADD step NVARCHAR(50)`,
+
+		`IF NOT EXISTS (SELECT * FROM sys.columns WHERE object_id = OBJECT_ID('integration_logs') AND name = 'attempts')
+		ALTER TABLE integration_logs ADD attempts INT DEFAULT 0`,
+
+		`IF NOT EXISTS (SELECT * FROM sys.columns WHERE object_id = OBJECT_ID('integration_logs') AND name = 'error_message')
+		ALTER TABLE integration_logs ADD error_message NVARCHAR(MAX)`,
+
+		`IF NOT EXISTS (SELECT * FROM sys.columns WHERE object_id = OBJECT_ID('integration_logs') AND name = 'updated_at')
+		ALTER TABLE integration_logs ADD updated_at DATETIME2 DEFAULT GETDATE()`,
+
+		`IF NOT EXISTS (SELECT * FROM sys.columns WHERE object_id = OBJECT_ID('integration_logs') AND name = 'finished_at')
+		ALTER TABLE integration_logs ADD finished_at DATETIME2`,
+
+		`IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name = 'IX_integration_logs_num_pedido')
+		CREATE INDEX IX_integration_logs_num_pedido ON integration_logs (num_pedido)`,
 	}
 
 	for i, query := range migrationQueries {
@@ -1,12 +1,21 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"amz-web-tools/backend/internal/config"
+	"amz-web-tools/backend/internal/httpclient"
+	"amz-web-tools/backend/internal/middleware"
 	"amz-web-tools/backend/internal/models"
+	"amz-web-tools/backend/internal/nbs"
+	"amz-web-tools/backend/internal/notify"
 	"amz-web-tools/backend/internal/services"
 	"amz-web-tools/backend/internal/websocket"
 
@@ -24,36 +33,94 @@ type Handlers struct {
 	importXML     *services.ImportXMLService
 	xmlIntegrator *services.XMLIntegratorService
 	integration   *services.IntegrationService
+	orderPoller   *services.OrderPollerService
+	xmlSweep      *services.XMLSweepService
+	mlWebhook     *services.MLWebhookService
+	mlTokens      *services.MLTokenManager
+	routing       *services.RoutingService
+	roles         *services.RoleService
+	notify        *notify.Service
+	outbound      *httpclient.Client
 }
 
-func New(db *sql.DB, cfg *config.Config, wsHub *websocket.Hub) (*Handlers, error) {
-	auditService := services.NewAuditService(db, cfg)
+// New wires the services. ctx is the server lifetime: background work (integration
+// jobs, XML sweep, deferred XML uploads, notification delivery) is cancelled
+// when it is done.
+func New(ctx context.Context, db *sql.DB, cfg *config.Config, wsHub *websocket.Hub) (*Handlers, error) {
+	// Shared outbound HTTP client: retries, circuit breaker and metrics per host
+	outbound := httpclient.New(httpclient.Options{
+		Timeout:          time.Duration(cfg.HTTPTimeoutSeconds) * time.Second,
+		MaxRetries:       cfg.HTTPMaxRetries,
+		NoRetry:          cfg.HTTPMaxRetries == 0,
+		BreakerThreshold: cfg.HTTPBreakerThreshold,
+		BreakerCooldown:  time.Duration(cfg.HTTPBreakerCooldownSeconds) * time.Second,
+	})
+
+	notifier := notify.New(db, cfg, outbound, wsHub)
+	notifier.Start(ctx)
 
-	stockService, err := services.NewStockService(cfg)
+	auditService := services.NewAuditService(db, cfg, notifier)
+	routingService := services.NewRoutingService(ctx, db, auditService, cfg)
+	roleService := services.NewRoleService(db, cfg.DefaultUserRole)
+
+	stockService, err := services.NewStockService(cfg, routingService)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize stock service: %w", err)
 	}
 
 	importXMLService := services.NewImportXMLService(db)
 
-	xmlIntegratorService, err := services.NewXMLIntegratorService(cfg, wsHub)
+	mlTokenManager := services.NewMLTokenManager(db, cfg, outbound)
+	mercadoLivre := services.NewMercadoLivreMarketplace(mlTokenManager, outbound)
+	marketplaces := services.NewMarketplaceRegistry(mercadoLivre)
+
+	xmlIntegratorService, err := services.NewXMLIntegratorService(cfg, db, wsHub, mercadoLivre, routingService, outbound, notifier)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize XML integrator service: %w", err)
 	}
 
-	integrationService := services.NewIntegrationService(db, stockService.GetOracleDB(), xmlIntegratorService.GetPostgresDB())
+	nbsClient := nbs.NewClient(nbs.Config{
+		BaseURL:      cfg.NBSBaseURL,
+		EcommerceAPI: cfg.NBSEcommerceAPI,
+		UserPrefix:   cfg.NBSUserPrefix,
+		Password:     cfg.NBSPassword,
+		Package:      cfg.NBSPackage,
+		TokenTTL:     time.Duration(cfg.NBSTokenTTLMinutes) * time.Minute,
+	}, outbound)
+
+	integrationService := services.NewIntegrationService(ctx, cfg, db, stockService.GetOracleDB(), xmlIntegratorService.GetPostgresDB(), marketplaces, nbsClient, routingService, outbound, wsHub, notifier)
+	integrationService.RecoverInterruptedJobs(ctx)
+
+	orderPollerService := services.NewOrderPollerService(db, xmlIntegratorService.GetPostgresDB(), integrationService, mercadoLivre, cfg)
+	orderPollerService.Start(ctx)
+
+	xmlSweepService := services.NewXMLSweepService(db, xmlIntegratorService, cfg)
+	xmlSweepService.Start(ctx)
+	xmlIntegratorService.StartDeferredWorker(ctx)
+	xmlIntegratorService.StartLogRetention(ctx)
+
+	mlWebhookService := services.NewMLWebhookService(db, integrationService, xmlIntegratorService, mercadoLivre, cfg)
+	mlWebhookService.Start(ctx)
 
 	return &Handlers{
 		db:            db,
 		config:        cfg,
-		auth:          services.NewAuthService(db),
-		carPlate:      services.NewCarPlateService(db, cfg),
-		dePara:        services.NewDeParaService(db, auditService),
+		auth:          services.NewAuthService(db, cfg, roleService),
+		roles:         roleService,
+		carPlate:      services.NewCarPlateService(db, cfg, outbound),
+		dePara:        services.NewDeParaService(db, auditService, cfg),
 		audit:         auditService,
 		stock:         stockService,
 		importXML:     importXMLService,
 		xmlIntegrator: xmlIntegratorService,
 		integration:   integrationService,
+		orderPoller:   orderPollerService,
+		xmlSweep:      xmlSweepService,
+		mlWebhook:     mlWebhookService,
+		mlTokens:      mlTokenManager,
+		routing:       routingService,
+		notify:        notifier,
+		outbound:      outbound,
 	}, nil
 }
 
@@ -79,9 +146,10 @@ func (h *Handlers) Login(c *gin.Context) {
 		return
 	}
 
-	// Generate JWT token
-	token, err := h.auth.GenerateJWT(user.ID, user.Role, h.config.JWTSecret, h.config.JWTExpireHours)
+	// Open a session and issue its tokens
+	tokens, err := h.auth.CreateSession(c.Request.Context(), user, c.ClientIP(), c.Request.UserAgent())
 	if err != nil {
+		log.Printf("❌ Login: %v", err)
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
 			Message: "Failed to generate token",
@@ -93,18 +161,123 @@ func (h *Handlers) Login(c *gin.Context) {
 		Success: true,
 		Message: "Login successful",
 		Data: gin.H{
-			"token": token,
+			"token":              tokens.AccessToken,
+			"refresh_token":      tokens.RefreshToken,
+			"expires_in":         tokens.ExpiresIn,
+			"refresh_expires_at": tokens.RefreshExpiresAt,
 			"user": gin.H{
-				"id":         user.ID,
-				"email":      user.Email,
-				"name":       user.Name,
-				"department": user.Department,
-				"role":       user.Role,
+				"id":          user.ID,
+				"email":       user.Email,
+				"name":        user.Name,
+				"department":  user.Department,
+				"role":        user.Role,
+				"permissions": tokens.Permissions,
+				"contas":      tokens.Contas,
 			},
 		},
 	})
 }
 
+// RefreshToken exchanges a refresh token for a new token pair. The refresh
+// token is rotated: the one sent can't be used again.
+func (h *Handlers) RefreshToken(c *gin.Context) {
+	var req models.RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Invalid request format",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	tokens, err := h.auth.RefreshSession(c.Request.Context(), req.RefreshToken, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrRefreshTokenReused):
+			c.JSON(http.StatusUnauthorized, models.APIResponse{
+				Success: false,
+				Message: "Refresh token already used; session revoked",
+			})
+		case errors.Is(err, services.ErrInvalidRefreshToken):
+			c.JSON(http.StatusUnauthorized, models.APIResponse{
+				Success: false,
+				Message: "Invalid or expired refresh token",
+			})
+		default:
+			log.Printf("❌ RefreshToken: %v", err)
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Message: "Failed to refresh token",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Token refreshed",
+		Data:    tokens,
+	})
+}
+
+// Logout ends the session of the refresh token in the body or, without one,
+// the session of the bearer token
+func (h *Handlers) Logout(c *gin.Context) {
+	var req models.LogoutRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "Invalid request format",
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+
+	var err error
+	if req.RefreshToken != "" {
+		err = h.auth.RevokeSessionByRefreshToken(c.Request.Context(), req.RefreshToken)
+	} else if token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "); token != "" {
+		claims, parseErr := middleware.ParseToken(token, h.config.JWTSecret)
+		if parseErr != nil {
+			c.JSON(http.StatusUnauthorized, models.APIResponse{
+				Success: false,
+				Message: "Invalid or expired token",
+			})
+			return
+		}
+		err = h.auth.RevokeSession(c.Request.Context(), claims.SessionID)
+	} else {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Refresh token or Authorization header required",
+		})
+		return
+	}
+
+	if err != nil {
+		log.Printf("❌ Logout: %v", err)
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Failed to logout",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Logged out",
+	})
+}
+
+// Sessions returns the session checker used by AuthMiddleware and the
+// WebSocket handler
+func (h *Handlers) Sessions() middleware.SessionChecker {
+	return h.auth
+}
+
 // Register handles user registration
 func (h *Handlers) Register(c *gin.Context) {
 	var req models.RegisterRequest
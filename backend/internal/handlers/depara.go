@@ -1,17 +1,22 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
 	"strconv"
 
+	"amz-web-tools/backend/internal/middleware"
 	"amz-web-tools/backend/internal/models"
+	"amz-web-tools/backend/internal/permissions"
+	"amz-web-tools/backend/internal/services"
 
 	"github.com/gin-gonic/gin"
 )
 
 // GetAvailableTables returns list of available integration tables
 func (h *Handlers) GetAvailableTables(c *gin.Context) {
-	tables, err := h.dePara.GetAvailableTables()
+	tables, err := h.dePara.GetAvailableTables(c.Request.Context(), scopeFor(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
@@ -30,7 +35,7 @@ func (h *Handlers) GetAvailableTables(c *gin.Context) {
 
 // GetTableOptions returns available options for dynamic table selection
 func (h *Handlers) GetTableOptions(c *gin.Context) {
-	options, err := h.dePara.GetTableOptions()
+	options, err := h.dePara.GetTableOptions(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
@@ -75,9 +80,9 @@ func (h *Handlers) SearchDeParaProducts(c *gin.Context) {
 		}
 	}
 
-	products, totalCount, err := h.dePara.SearchProducts(req.TableName, req.Query, req.SearchBy, page, pageSize)
+	products, totalCount, err := h.dePara.SearchProducts(c.Request.Context(), scopeFor(c), req.TableName, req.Query, req.SearchBy, page, pageSize)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
+		c.JSON(scopeErrorStatus(err, http.StatusInternalServerError), models.APIResponse{
 			Success: false,
 			Message: "Failed to search products",
 			Error:   err.Error(),
@@ -115,9 +120,9 @@ func (h *Handlers) GetDeParaProduct(c *gin.Context) {
 		tableName = "MercadoLivre" // Default
 	}
 
-	product, err := h.dePara.GetProductByID(tableName, id)
+	product, err := h.dePara.GetProductByID(c.Request.Context(), scopeFor(c), tableName, id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.APIResponse{
+		c.JSON(scopeErrorStatus(err, http.StatusNotFound), models.APIResponse{
 			Success: false,
 			Message: "Product not found",
 			Error:   err.Error(),
@@ -144,6 +149,10 @@ func (h *Handlers) CreateDeParaProduct(c *gin.Context) {
 		return
 	}
 
+	if !h.canWriteDePara(c, req.TableName) {
+		return
+	}
+
 	// Get user info for audit
 	userID := c.GetString("user_id")
 	userEmail := c.GetString("user_email")
@@ -151,9 +160,9 @@ func (h *Handlers) CreateDeParaProduct(c *gin.Context) {
 	ipAddress := c.ClientIP()
 	userAgent := c.GetHeader("User-Agent")
 
-	err := h.dePara.CreateProduct(req, userID, userEmail, userName, ipAddress, userAgent)
+	err := h.dePara.CreateProduct(c.Request.Context(), scopeFor(c), req, userID, userEmail, userName, ipAddress, userAgent)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
+		c.JSON(scopeErrorStatus(err, http.StatusInternalServerError), models.APIResponse{
 			Success: false,
 			Message: "Failed to create product",
 			Error:   err.Error(),
@@ -189,6 +198,10 @@ func (h *Handlers) UpdateDeParaProduct(c *gin.Context) {
 		return
 	}
 
+	if !h.canWriteDePara(c, tableName) {
+		return
+	}
+
 	// Get user info for audit
 	userID := c.GetString("user_id")
 	userEmail := c.GetString("user_email")
@@ -196,9 +209,9 @@ func (h *Handlers) UpdateDeParaProduct(c *gin.Context) {
 	ipAddress := c.ClientIP()
 	userAgent := c.GetHeader("User-Agent")
 
-	err := h.dePara.UpdateProduct(tableName, id, req, userID, userEmail, userName, ipAddress, userAgent)
+	err := h.dePara.UpdateProduct(c.Request.Context(), scopeFor(c), tableName, id, req, userID, userEmail, userName, ipAddress, userAgent)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
+		c.JSON(scopeErrorStatus(err, http.StatusInternalServerError), models.APIResponse{
 			Success: false,
 			Message: "Failed to update product",
 			Error:   err.Error(),
@@ -224,6 +237,10 @@ func (h *Handlers) DeleteDeParaProduct(c *gin.Context) {
 		tableName = "MercadoLivre" // Default
 	}
 
+	if !h.canWriteDePara(c, tableName) {
+		return
+	}
+
 	// Get user info for audit
 	userID := c.GetString("user_id")
 	userEmail := c.GetString("user_email")
@@ -231,9 +248,9 @@ func (h *Handlers) DeleteDeParaProduct(c *gin.Context) {
 	ipAddress := c.ClientIP()
 	userAgent := c.GetHeader("User-Agent")
 
-	err := h.dePara.DeleteProduct(tableName, id, userID, userEmail, userName, ipAddress, userAgent)
+	err := h.dePara.DeleteProduct(c.Request.Context(), scopeFor(c), tableName, id, userID, userEmail, userName, ipAddress, userAgent)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
+		c.JSON(scopeErrorStatus(err, http.StatusInternalServerError), models.APIResponse{
 			Success: false,
 			Message: "Failed to delete product",
 			Error:   err.Error(),
@@ -250,6 +267,30 @@ func (h *Handlers) DeleteDeParaProduct(c *gin.Context) {
 	})
 }
 
+// canWriteDePara checks the depara:write:{conta} permission for the table and
+// answers the request when it is missing
+func (h *Handlers) canWriteDePara(c *gin.Context, tableName string) bool {
+	conta := h.dePara.TableConta(tableName)
+	if conta == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Invalid DePara table",
+		})
+		return false
+	}
+
+	required := permissions.DeParaWriteFor(conta)
+	if !middleware.HasPermission(c, required) {
+		c.JSON(http.StatusForbidden, models.APIResponse{
+			Success: false,
+			Message: "Insufficient permissions",
+			Error:   "missing permission " + required,
+		})
+		return false
+	}
+	return true
+}
+
 // GetAuditLogs retrieves audit logs for a specific product
 func (h *Handlers) GetAuditLogs(c *gin.Context) {
 	tableName := c.Query("table")
@@ -267,9 +308,9 @@ func (h *Handlers) GetAuditLogs(c *gin.Context) {
 		}
 	}
 
-	logs, err := h.audit.GetAuditLogs(tableName, recordID, limit)
+	logs, err := h.audit.GetAuditLogs(c.Request.Context(), scopeFor(c), tableName, recordID, limit)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
+		c.JSON(scopeErrorStatus(err, http.StatusInternalServerError), models.APIResponse{
 			Success: false,
 			Message: "Failed to retrieve audit logs",
 			Error:   err.Error(),
@@ -298,12 +339,49 @@ func (h *Handlers) ExecuteRollback(c *gin.Context) {
 		return
 	}
 
+	// Rolling back a DePara change needs write access to its conta; other
+	// audited tables (routing rules) are admin-only
+	tableName, err := h.audit.AuditLogTable(c.Request.Context(), auditLogID)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, sql.ErrNoRows) {
+			statusCode = http.StatusNotFound
+		}
+		c.JSON(statusCode, models.APIResponse{
+			Success: false,
+			Message: "Failed to get audit log",
+			Error:   err.Error(),
+		})
+		return
+	}
+	required := permissions.AdminAccess
+	conta := h.dePara.TableConta(tableName)
+	if conta != "" {
+		required = permissions.DeParaWriteFor(conta)
+	}
+	if !middleware.HasPermission(c, required) {
+		c.JSON(http.StatusForbidden, models.APIResponse{
+			Success: false,
+			Message: "Insufficient permissions",
+			Error:   "missing permission " + required,
+		})
+		return
+	}
+	if !scopeFor(c).Allows(conta) {
+		c.JSON(http.StatusForbidden, models.APIResponse{
+			Success: false,
+			Message: "Conta outside the user's scope",
+			Error:   services.ErrOutOfScope.Error(),
+		})
+		return
+	}
+
 	// Get user info for audit
 	userID := c.GetString("user_id")
 	userEmail := c.GetString("user_email")
 	userName := c.GetString("user_name")
 
-	err := h.audit.ExecuteRollback(auditLogID, userID, userEmail, userName)
+	err = h.audit.ExecuteRollback(c.Request.Context(), auditLogID, userID, userEmail, userName)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
@@ -330,9 +408,9 @@ func (h *Handlers) GetDeParaProducts(c *gin.Context) {
 	req.Query = ""
 	req.SearchBy = "sku"
 
-	products, totalCount, err := h.dePara.SearchProducts(req.TableName, req.Query, req.SearchBy, 1, 15)
+	products, totalCount, err := h.dePara.SearchProducts(c.Request.Context(), scopeFor(c), req.TableName, req.Query, req.SearchBy, 1, 15)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
+		c.JSON(scopeErrorStatus(err, http.StatusInternalServerError), models.APIResponse{
 			Success: false,
 			Message: "Failed to get products",
 			Error:   err.Error(),
@@ -1,13 +1,17 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"amz-web-tools/backend/internal/middleware"
 	"amz-web-tools/backend/internal/models"
+	"amz-web-tools/backend/internal/permissions"
 	"amz-web-tools/backend/internal/services"
 
 	"github.com/gin-gonic/gin"
@@ -33,7 +37,7 @@ func (h *Handlers) GetCarPlate(c *gin.Context) {
 	}
 
 	// Get plate data using service
-	plateResult, err := h.carPlate.GetCarPlate(plate, userID)
+	plateResult, err := h.carPlate.GetCarPlate(c.Request.Context(), plate, userID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, models.APIResponse{
 			Success: false,
@@ -118,7 +122,7 @@ func (h *Handlers) GetCarPlateHistory(c *gin.Context) {
 	}
 
 	// Get history from service
-	history, err := h.carPlate.GetPlateHistory(userID, limit)
+	history, err := h.carPlate.GetPlateHistory(c.Request.Context(), userID, limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
@@ -138,7 +142,7 @@ func (h *Handlers) GetCarPlateHistory(c *gin.Context) {
 	})
 }
 
-// ExecuteIntegration executes an integration process
+// ExecuteIntegration creates an integration job and runs it in the background
 func (h *Handlers) ExecuteIntegration(c *gin.Context) {
 	var req models.ExecuteIntegrationRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -150,18 +154,69 @@ func (h *Handlers) ExecuteIntegration(c *gin.Context) {
 		return
 	}
 
-	// Process integration
 	integrationReq := services.IntegrationRequest{
 		Conta:       req.Conta,
 		Marketplace: req.Marketplace,
 		NumPedido:   req.NumPedido,
+		UserID:      c.GetString("user_id"),
+		Scope:       scopeFor(c),
+		// Retries with the same key return the job created by the first request
+		IdempotencyKey: c.GetHeader("Idempotency-Key"),
 	}
 
-	result, err := h.integration.ProcessIntegration(integrationReq)
+	if req.DryRun {
+		h.simulateIntegration(c, integrationReq)
+		return
+	}
+
+	job, err := h.integration.StartIntegration(c.Request.Context(), integrationReq)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrUnsupportedMarketplace) {
+			status = http.StatusBadRequest
+		} else if errors.Is(err, services.ErrIdempotencyKeyReused) {
+			status = http.StatusUnprocessableEntity
+		} else if errors.Is(err, services.ErrOutOfScope) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, models.APIResponse{
 			Success: false,
-			Message: "Failed to process integration",
+			Message: "Failed to start integration",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if job.Replayed {
+		c.JSON(http.StatusOK, models.APIResponse{
+			Success: true,
+			Message: "Integration job already exists for this request",
+			Data:    job,
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, models.APIResponse{
+		Success: true,
+		Message: "Integration job started",
+		Data:    job,
+	})
+}
+
+// simulateIntegration runs the integration in dry-run mode and returns the NBS
+// payloads that would be sent, without touching NBS or fato_StatusVenda
+func (h *Handlers) simulateIntegration(c *gin.Context, req services.IntegrationRequest) {
+	simulation, err := h.integration.SimulateIntegration(c.Request.Context(), req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrUnsupportedMarketplace) {
+			status = http.StatusBadRequest
+		} else if errors.Is(err, services.ErrOutOfScope) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: "Failed to simulate integration",
 			Error:   err.Error(),
 		})
 		return
@@ -169,12 +224,12 @@ func (h *Handlers) ExecuteIntegration(c *gin.Context) {
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
-		Message: "Integration processed successfully",
-		Data:    result,
+		Message: "Integration simulated (dry run)",
+		Data:    simulation,
 	})
 }
 
-// GetIntegrationStatus retrieves integration execution status
+// GetIntegrationStatus retrieves integration job status, progress and logs
 func (h *Handlers) GetIntegrationStatus(c *gin.Context) {
 	integrationID := c.Param("id")
 	if integrationID == "" {
@@ -185,14 +240,47 @@ func (h *Handlers) GetIntegrationStatus(c *gin.Context) {
 		return
 	}
 
-	// TODO: Implement integration status retrieval
+	status, err := h.integration.GetIntegrationStatus(c.Request.Context(), scopeFor(c), integrationID)
+	if err != nil {
+		c.JSON(scopeErrorStatus(err, http.StatusNotFound), models.APIResponse{
+			Success: false,
+			Message: "Integration job not found",
+			Error:   err.Error(),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
-		Data: gin.H{
-			"integration_id": integrationID,
-			"status":         "completed",
-			"progress":       100,
-		},
+		Data:    status,
+	})
+}
+
+// ResumeIntegration resumes a failed integration job from the step where it stopped
+func (h *Handlers) ResumeIntegration(c *gin.Context) {
+	integrationID := c.Param("id")
+	if integrationID == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Integration ID is required",
+		})
+		return
+	}
+
+	job, err := h.integration.ResumeIntegration(c.Request.Context(), scopeFor(c), integrationID)
+	if err != nil {
+		c.JSON(scopeErrorStatus(err, http.StatusBadRequest), models.APIResponse{
+			Success: false,
+			Message: "Failed to resume integration",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, models.APIResponse{
+		Success: true,
+		Message: "Integration job resumed",
+		Data:    job,
 	})
 }
 
@@ -240,7 +328,7 @@ func (h *Handlers) GetStock(c *gin.Context) {
 		return
 	}
 
-	items, err := h.stock.SearchStock(sku)
+	items, err := h.stock.SearchStock(c.Request.Context(), scopeFor(c), sku)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
@@ -255,12 +343,43 @@ func (h *Handlers) GetStock(c *gin.Context) {
 		Message: "Stock search completed",
 		Data: gin.H{
 			"sku":   sku,
-			"items": items,
+			"items": stockItemsFor(c, items),
 			"count": len(items),
 		},
 	})
 }
 
+// scopeFor returns the contas whose data the user can access; admins and users
+// granted every conta are not restricted, users without contas see none
+func scopeFor(c *gin.Context) services.Scope {
+	if middleware.HasPermission(c, permissions.AdminAccess) {
+		return services.Scope{}
+	}
+	return services.ScopeFor(c.GetStringSlice("user_contas"))
+}
+
+// scopeErrorStatus returns 403 for data outside the user's contas and
+// fallback otherwise
+func scopeErrorStatus(err error, fallback int) int {
+	if errors.Is(err, services.ErrOutOfScope) {
+		return http.StatusForbidden
+	}
+	return fallback
+}
+
+// stockItemsFor omits the cost columns for users without stock:view-cost
+func stockItemsFor(c *gin.Context, items []models.StockItem) interface{} {
+	if middleware.HasPermission(c, permissions.StockViewCost) {
+		return items
+	}
+
+	redacted := make([]models.StockItemWithoutCost, len(items))
+	for i, item := range items {
+		redacted[i] = item.WithoutCost()
+	}
+	return redacted
+}
+
 // SearchStock searches for stock by SKU (POST endpoint)
 func (h *Handlers) SearchStock(c *gin.Context) {
 	var req models.StockSearchRequest
@@ -281,7 +400,7 @@ func (h *Handlers) SearchStock(c *gin.Context) {
 		return
 	}
 
-	items, err := h.stock.SearchStock(req.SKU)
+	items, err := h.stock.SearchStock(c.Request.Context(), scopeFor(c), req.SKU)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
@@ -296,7 +415,7 @@ func (h *Handlers) SearchStock(c *gin.Context) {
 		Message: "Stock search completed",
 		Data: gin.H{
 			"sku":   req.SKU,
-			"items": items,
+			"items": stockItemsFor(c, items),
 			"count": len(items),
 		},
 	})
@@ -403,6 +522,16 @@ func (h *Handlers) ResetUserPassword(c *gin.Context) {
 		return
 	}
 
+	// Sessions opened with the old password must log in again
+	if _, err := h.auth.RevokeUserSessions(c.Request.Context(), req.UserID); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Password reset but failed to revoke sessions",
+			Error:   err.Error(),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
 		Message: "Password reset successfully",
@@ -420,6 +549,16 @@ func (h *Handlers) DeleteUser(c *gin.Context) {
 		return
 	}
 
+	// Revoke first so cached sessions of the user stop being accepted
+	if _, err := h.auth.RevokeUserSessions(c.Request.Context(), userID); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Failed to revoke user sessions",
+			Error:   err.Error(),
+		})
+		return
+	}
+
 	err := h.auth.DeleteUser(userID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
@@ -436,6 +575,113 @@ func (h *Handlers) DeleteUser(c *gin.Context) {
 	})
 }
 
+// RevokeUserSessions ends every session of a user (Admin only)
+func (h *Handlers) RevokeUserSessions(c *gin.Context) {
+	userID := c.Param("id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "User ID is required",
+		})
+		return
+	}
+
+	revoked, err := h.auth.RevokeUserSessions(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Failed to revoke user sessions",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "User sessions revoked",
+		Data:    gin.H{"revoked": revoked},
+	})
+}
+
+// GetUserContas returns the contas a user can access (Admin only)
+func (h *Handlers) GetUserContas(c *gin.Context) {
+	userID := c.Param("id")
+	if _, err := h.auth.GetUserByID(userID); err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, sql.ErrNoRows) {
+			statusCode = http.StatusNotFound
+		}
+		c.JSON(statusCode, models.APIResponse{
+			Success: false,
+			Message: "Failed to get user",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	contas, err := h.auth.UserContas(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Failed to get user contas",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    gin.H{"user_id": userID, "contas": contas},
+	})
+}
+
+// SetUserContas replaces the contas a user can access (Admin only). The
+// user's sessions are revoked so the new scope applies immediately.
+func (h *Handlers) SetUserContas(c *gin.Context) {
+	var req models.UserContasRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Invalid request format",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	userID := c.Param("id")
+	contas, err := h.auth.SetUserContas(c.Request.Context(), userID, req.Contas)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidConta) {
+			statusCode = http.StatusBadRequest
+		} else if errors.Is(err, sql.ErrNoRows) {
+			statusCode = http.StatusNotFound
+		}
+		c.JSON(statusCode, models.APIResponse{
+			Success: false,
+			Message: "Failed to update user contas",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	// Tokens issued with the old contas must not outlive the change
+	if _, err := h.auth.RevokeUserSessions(c.Request.Context(), userID); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "User contas updated but failed to revoke sessions",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "User contas updated successfully",
+		Data:    gin.H{"user_id": userID, "contas": contas},
+	})
+}
+
 // ===== FIRST LOGIN HANDLERS =====
 
 // ChangePasswordFirstLogin changes password on first login
@@ -479,7 +725,7 @@ func (h *Handlers) ChangePasswordFirstLogin(c *gin.Context) {
 
 // GetDashboardStats retrieves dashboard statistics
 func (h *Handlers) GetDashboardStats(c *gin.Context) {
-	stats, err := h.carPlate.GetDashboardStats()
+	stats, err := h.carPlate.GetDashboardStats(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
@@ -557,8 +803,8 @@ func (h *Handlers) DebugQueries(c *gin.Context) {
 			"user":    h.carPlate.Config.OracleUser,
 			"service": h.carPlate.Config.OracleService,
 		},
-		"xml_imports_count": h.carPlate.GetXMLImportsCount(),
-		"stock_items_count": h.carPlate.GetStockItemsCount(),
+		"xml_imports_count": h.carPlate.GetXMLImportsCount(c.Request.Context()),
+		"stock_items_count": h.carPlate.GetStockItemsCount(c.Request.Context()),
 	}
 
 	c.JSON(http.StatusOK, models.APIResponse{
@@ -583,7 +829,7 @@ func (h *Handlers) TestDashboardTables(c *gin.Context) {
 
 	for tableName, query := range tables {
 		var count int
-		err := h.carPlate.GetDB().QueryRow(query).Scan(&count)
+		err := h.carPlate.GetDB().QueryRowContext(c.Request.Context(), query).Scan(&count)
 		if err != nil {
 			results[tableName] = map[string]interface{}{
 				"exists": false,
@@ -609,7 +855,7 @@ func (h *Handlers) TestDashboardTables(c *gin.Context) {
 // PopulateTestData populates the dashboard with test data
 func (h *Handlers) PopulateTestData(c *gin.Context) {
 	// Insert test data into car_plate_history
-	_, err := h.carPlate.GetDB().Exec(`
+	_, err := h.carPlate.GetDB().ExecContext(c.Request.Context(), `
 		INSERT INTO car_plate_history (id, plate, response_data, status, error_message, created_at, user_id)
 		VALUES 
 		(NEWID(), 'ABC1234', '{"test": "data"}', 'success', '', GETDATE(), '6D9D42FE-0F7F-490F-B081-D1811ECB8AF1'),
@@ -621,7 +867,7 @@ func (h *Handlers) PopulateTestData(c *gin.Context) {
 	}
 
 	// Insert test data into depara_products
-	_, err = h.carPlate.GetDB().Exec(`
+	_, err = h.carPlate.GetDB().ExecContext(c.Request.Context(), `
 		INSERT INTO depara_products (id, mlbu, type, sku, company, permalink, ship_cost_slow, ship_cost_standard, ship_cost_nextday, pictures, created_at, updated_at)
 		VALUES 
 		(NEWID(), 'MLBU123456', 'product', 'SKU001', 'Company A', 'https://example.com/1', 10.50, 15.00, 25.00, '["https://example.com/img1.jpg"]', GETDATE(), GETDATE()),
@@ -635,7 +881,7 @@ func (h *Handlers) PopulateTestData(c *gin.Context) {
 	}
 
 	// Insert test data into xml_integrator_logs
-	_, err = h.carPlate.GetDB().Exec(`
+	_, err = h.carPlate.GetDB().ExecContext(c.Request.Context(), `
 		INSERT INTO xml_integrator_logs (id, process_id, level, step, message, created_at)
 		VALUES 
 		(NEWID(), 'test-process-1', 'info', '1', 'Test XML import 1', GETDATE()),
@@ -647,7 +893,7 @@ func (h *Handlers) PopulateTestData(c *gin.Context) {
 	}
 
 	// Insert test data into stock_items
-	_, err = h.carPlate.GetDB().Exec(`
+	_, err = h.carPlate.GetDB().ExecContext(c.Request.Context(), `
 		INSERT INTO stock_items (id, cod_item, nome_item, estoque, reservado, created_at)
 		VALUES 
 		(NEWID(), 'ITEM001', 'Test Item 1', 100, 10, GETDATE()),
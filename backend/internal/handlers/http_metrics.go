@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"net/http"
+
+	"amz-web-tools/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetHTTPMetrics returns the outbound HTTP metrics and circuit breaker state per host
+func (h *Handlers) GetHTTPMetrics(c *gin.Context) {
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    h.outbound.Metrics(),
+	})
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"amz-web-tools/backend/internal/models"
+	"amz-web-tools/backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetMLCredentials returns the Mercado Livre OAuth credentials status for each conta.
+// Secrets are never returned.
+func (h *Handlers) GetMLCredentials(c *gin.Context) {
+	credentials, err := h.mlTokens.GetCredentials()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Erro ao buscar credenciais do Mercado Livre",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    credentials,
+	})
+}
+
+// SaveMLCredentials registers or rotates the Mercado Livre OAuth credentials of a conta
+func (h *Handlers) SaveMLCredentials(c *gin.Context) {
+	var req services.MLCredentialsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Parâmetros inválidos",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	status, err := h.mlTokens.SaveCredentials(c.Request.Context(), c.Param("conta"), req, c.GetString("user_email"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Erro ao salvar credenciais do Mercado Livre",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Credenciais salvas com sucesso",
+		Data:    status,
+	})
+}
+
+// RefreshMLToken forces a token refresh for a conta
+func (h *Handlers) RefreshMLToken(c *gin.Context) {
+	status, err := h.mlTokens.Refresh(c.Request.Context(), c.Param("conta"))
+	if err != nil {
+		statusCode := http.StatusBadGateway
+		if errors.Is(err, services.ErrMLCredentialsNotFound) {
+			statusCode = http.StatusNotFound
+		}
+		c.JSON(statusCode, models.APIResponse{
+			Success: false,
+			Message: "Erro ao renovar token do Mercado Livre",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Token renovado com sucesso",
+		Data:    status,
+	})
+}
+
+// DeleteMLCredentials removes the Mercado Livre OAuth credentials of a conta
+func (h *Handlers) DeleteMLCredentials(c *gin.Context) {
+	if err := h.mlTokens.DeleteCredentials(c.Param("conta")); err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, services.ErrMLCredentialsNotFound) {
+			statusCode = http.StatusNotFound
+		}
+		c.JSON(statusCode, models.APIResponse{
+			Success: false,
+			Message: "Erro ao remover credenciais do Mercado Livre",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Credenciais removidas com sucesso",
+	})
+}
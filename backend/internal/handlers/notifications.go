@@ -0,0 +1,506 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"amz-web-tools/backend/internal/models"
+	"amz-web-tools/backend/internal/notify"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetNotificationChannels lists the notification channels with their secrets masked
+func (h *Handlers) GetNotificationChannels(c *gin.Context) {
+	channels, err := h.notify.ListChannels(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Erro ao buscar canais de notificação",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    channels,
+	})
+}
+
+// CreateNotificationChannel creates a notification channel
+func (h *Handlers) CreateNotificationChannel(c *gin.Context) {
+	var req notify.ChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Parâmetros inválidos",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	channel, err := h.notify.CreateChannel(c.Request.Context(), req, c.GetString("user_id"))
+	if err != nil {
+		c.JSON(notificationErrorStatus(err), models.APIResponse{
+			Success: false,
+			Message: "Erro ao criar canal de notificação",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Message: "Canal de notificação criado com sucesso",
+		Data:    channel,
+	})
+}
+
+// UpdateNotificationChannel updates a notification channel. Secret settings
+// sent empty or masked keep their current value.
+func (h *Handlers) UpdateNotificationChannel(c *gin.Context) {
+	id, ok := notificationID(c)
+	if !ok {
+		return
+	}
+
+	var req notify.ChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Parâmetros inválidos",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	channel, err := h.notify.UpdateChannel(c.Request.Context(), id, req)
+	if err != nil {
+		c.JSON(notificationErrorStatus(err), models.APIResponse{
+			Success: false,
+			Message: "Erro ao atualizar canal de notificação",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Canal de notificação atualizado com sucesso",
+		Data:    channel,
+	})
+}
+
+// DeleteNotificationChannel removes a notification channel with its routes and queue
+func (h *Handlers) DeleteNotificationChannel(c *gin.Context) {
+	id, ok := notificationID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.notify.DeleteChannel(c.Request.Context(), id); err != nil {
+		c.JSON(notificationErrorStatus(err), models.APIResponse{
+			Success: false,
+			Message: "Erro ao remover canal de notificação",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Canal de notificação removido com sucesso",
+	})
+}
+
+// TestNotificationChannel sends a test message straight to the channel
+func (h *Handlers) TestNotificationChannel(c *gin.Context) {
+	id, ok := notificationID(c)
+	if !ok {
+		return
+	}
+
+	user := c.GetString("user_email")
+	if user == "" {
+		user = c.GetString("user_id")
+	}
+
+	if err := h.notify.TestChannel(c.Request.Context(), id, user); err != nil {
+		statusCode := http.StatusBadGateway
+		if errors.Is(err, notify.ErrChannelNotFound) {
+			statusCode = http.StatusNotFound
+		}
+		c.JSON(statusCode, models.APIResponse{
+			Success: false,
+			Message: "Erro ao enviar notificação de teste",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Notificação de teste enviada com sucesso",
+	})
+}
+
+// GetNotificationRoutes lists the notification routes
+func (h *Handlers) GetNotificationRoutes(c *gin.Context) {
+	routes, err := h.notify.ListRoutes(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Erro ao buscar rotas de notificação",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    routes,
+	})
+}
+
+// CreateNotificationRoute routes the events of a conta (all when empty) from
+// a minimum severity to a channel
+func (h *Handlers) CreateNotificationRoute(c *gin.Context) {
+	var req notify.RouteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Parâmetros inválidos",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	route, err := h.notify.CreateRoute(c.Request.Context(), req)
+	if err != nil {
+		c.JSON(notificationErrorStatus(err), models.APIResponse{
+			Success: false,
+			Message: "Erro ao criar rota de notificação",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Message: "Rota de notificação criada com sucesso",
+		Data:    route,
+	})
+}
+
+// DeleteNotificationRoute removes a notification route
+func (h *Handlers) DeleteNotificationRoute(c *gin.Context) {
+	id, ok := notificationID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.notify.DeleteRoute(c.Request.Context(), id); err != nil {
+		c.JSON(notificationErrorStatus(err), models.APIResponse{
+			Success: false,
+			Message: "Erro ao remover rota de notificação",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Rota de notificação removida com sucesso",
+	})
+}
+
+// GetNotificationTemplates lists the template in use for each event kind
+func (h *Handlers) GetNotificationTemplates(c *gin.Context) {
+	templates, err := h.notify.ListTemplates(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Erro ao buscar templates de notificação",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    templates,
+	})
+}
+
+// UpdateNotificationTemplate replaces the default template of an event kind
+func (h *Handlers) UpdateNotificationTemplate(c *gin.Context) {
+	var req notify.TemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Parâmetros inválidos",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	template, err := h.notify.SaveTemplate(c.Request.Context(), c.Param("kind"), req, c.GetString("user_id"))
+	if err != nil {
+		c.JSON(notificationErrorStatus(err), models.APIResponse{
+			Success: false,
+			Message: "Erro ao salvar template de notificação",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Template de notificação salvo com sucesso",
+		Data:    template,
+	})
+}
+
+// DeleteNotificationTemplate restores the default template of an event kind
+func (h *Handlers) DeleteNotificationTemplate(c *gin.Context) {
+	if err := h.notify.DeleteTemplate(c.Request.Context(), c.Param("kind")); err != nil {
+		c.JSON(notificationErrorStatus(err), models.APIResponse{
+			Success: false,
+			Message: "Erro ao restaurar template de notificação",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Template padrão restaurado com sucesso",
+	})
+}
+
+// GetNotificationOutbox lists the most recent queued notifications,
+// optionally filtered by status (pending, sent, failed)
+func (h *Handlers) GetNotificationOutbox(c *gin.Context) {
+	limit := 100
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 500 {
+		limit = l
+	}
+
+	items, err := h.notify.ListOutbox(c.Request.Context(), c.Query("status"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Erro ao buscar fila de notificações",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    items,
+	})
+}
+
+// SendNotificationDigest queues the daily digest with the pending failures now
+func (h *Handlers) SendNotificationDigest(c *gin.Context) {
+	total, err := h.notify.SendDigest(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Erro ao enviar resumo de notificações",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Resumo de notificações enfileirado",
+		Data:    gin.H{"items": total},
+	})
+}
+
+// GetNotifications lists the logged-in user's inbox, newest first
+// (?unread=true&page=1&page_size=20)
+func (h *Handlers) GetNotifications(c *gin.Context) {
+	filter := notify.InboxFilter{
+		UnreadOnly: c.Query("unread") == "true",
+		Page:       1,
+		PageSize:   20,
+	}
+	if p, err := strconv.Atoi(c.Query("page")); err == nil && p > 0 {
+		filter.Page = p
+	}
+	if ps, err := strconv.Atoi(c.Query("page_size")); err == nil && ps > 0 && ps <= 100 {
+		filter.PageSize = ps
+	}
+
+	notifications, total, unread, err := h.notify.ListInbox(c.Request.Context(), c.GetString("user_id"), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Erro ao buscar notificações",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"notifications": notifications,
+			"count":         len(notifications),
+			"total_count":   total,
+			"unread_count":  unread,
+			"page":          filter.Page,
+			"page_size":     filter.PageSize,
+			"total_pages":   (total + filter.PageSize - 1) / filter.PageSize,
+		},
+	})
+}
+
+// MarkNotificationRead marks one of the user's notifications as read
+func (h *Handlers) MarkNotificationRead(c *gin.Context) {
+	id, ok := notificationID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.notify.MarkRead(c.Request.Context(), c.GetString("user_id"), int64(id)); err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, notify.ErrNotificationNotFound) {
+			statusCode = http.StatusNotFound
+		}
+		c.JSON(statusCode, models.APIResponse{
+			Success: false,
+			Message: "Erro ao marcar notificação como lida",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Notificação marcada como lida",
+	})
+}
+
+// MarkAllNotificationsRead marks every unread notification of the user as read
+func (h *Handlers) MarkAllNotificationsRead(c *gin.Context) {
+	count, err := h.notify.MarkAllRead(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Erro ao marcar notificações como lidas",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Notificações marcadas como lidas",
+		Data:    gin.H{"count": count},
+	})
+}
+
+// GetNotificationSubscriptions lists the user's subscriptions and the event
+// kinds that can be subscribed to
+func (h *Handlers) GetNotificationSubscriptions(c *gin.Context) {
+	subscriptions, err := h.notify.ListSubscriptions(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Erro ao buscar inscrições",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"subscriptions": subscriptions,
+			"kinds":         notify.InboxEvents(),
+		},
+	})
+}
+
+// SubscribeNotifications subscribes the user to the events of a conta and
+// kind (all when empty) from a minimum severity
+func (h *Handlers) SubscribeNotifications(c *gin.Context) {
+	var req notify.SubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Parâmetros inválidos",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	subscription, err := h.notify.Subscribe(c.Request.Context(), c.GetString("user_id"), req)
+	if err != nil {
+		c.JSON(notificationErrorStatus(err), models.APIResponse{
+			Success: false,
+			Message: "Erro ao criar inscrição",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Message: "Inscrição criada com sucesso",
+		Data:    subscription,
+	})
+}
+
+// UnsubscribeNotifications removes one of the user's subscriptions
+func (h *Handlers) UnsubscribeNotifications(c *gin.Context) {
+	id, ok := notificationID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.notify.Unsubscribe(c.Request.Context(), c.GetString("user_id"), id); err != nil {
+		c.JSON(notificationErrorStatus(err), models.APIResponse{
+			Success: false,
+			Message: "Erro ao remover inscrição",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Inscrição removida com sucesso",
+	})
+}
+
+// notificationID parses the :id path parameter, answering 400 when it is invalid
+func notificationID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "ID inválido",
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+func notificationErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, notify.ErrChannelNotFound), errors.Is(err, notify.ErrRouteNotFound),
+		errors.Is(err, notify.ErrSubscriptionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, notify.ErrInvalidRequest), errors.Is(err, notify.ErrUnknownTemplate):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"net/http"
+
+	"amz-web-tools/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OrderPollerContaRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+}
+
+type OrderPollerRunRequest struct {
+	Conta  string `json:"conta"`
+	DryRun bool   `json:"dry_run"`
+}
+
+// GetOrderPollerContas returns the order poller configuration for each conta
+func (h *Handlers) GetOrderPollerContas(c *gin.Context) {
+	contas, err := h.orderPoller.GetContas()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Erro ao buscar configuração do poller",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    contas,
+	})
+}
+
+// UpdateOrderPollerConta enables or disables the order poller for a conta
+func (h *Handlers) UpdateOrderPollerConta(c *gin.Context) {
+	var req OrderPollerContaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Parâmetros inválidos",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if err := h.orderPoller.SetContaEnabled(c.Param("conta"), *req.Enabled); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Erro ao atualizar configuração do poller",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Configuração do poller atualizada com sucesso",
+	})
+}
+
+// RunOrderPoller runs the order poller immediately and starts a background
+// integration job per pending order; with dry_run it only reports which orders
+// would be integrated
+func (h *Handlers) RunOrderPoller(c *gin.Context) {
+	var req OrderPollerRunRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Parâmetros inválidos",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	results, err := h.orderPoller.RunNow(c.Request.Context(), req.Conta, req.DryRun)
+	if err != nil {
+		c.JSON(http.StatusConflict, models.APIResponse{
+			Success: false,
+			Message: "Erro ao executar o poller",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Busca de pedidos executada com sucesso",
+		Data:    results,
+	})
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"amz-web-tools/backend/internal/models"
+	"amz-web-tools/backend/internal/permissions"
+	"amz-web-tools/backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetPermissions lists the permissions that can be granted to roles
+func (h *Handlers) GetPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    permissions.Catalog(),
+	})
+}
+
+// GetRoles lists the roles with their permissions
+func (h *Handlers) GetRoles(c *gin.Context) {
+	roles, err := h.roles.ListRoles(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Erro ao buscar papéis",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    roles,
+	})
+}
+
+// CreateRole creates a role
+func (h *Handlers) CreateRole(c *gin.Context) {
+	var req models.RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Parâmetros inválidos",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	role, err := h.roles.CreateRole(c.Request.Context(), req)
+	if err != nil {
+		c.JSON(roleErrorStatus(err), models.APIResponse{
+			Success: false,
+			Message: "Erro ao criar papel",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Message: "Papel criado com sucesso",
+		Data:    role,
+	})
+}
+
+// UpdateRole replaces the description and permissions of a role
+func (h *Handlers) UpdateRole(c *gin.Context) {
+	var req models.RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Parâmetros inválidos",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	role, err := h.roles.UpdateRole(c.Request.Context(), c.Param("name"), req)
+	if err != nil {
+		c.JSON(roleErrorStatus(err), models.APIResponse{
+			Success: false,
+			Message: "Erro ao atualizar papel",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Papel atualizado com sucesso",
+		Data:    role,
+	})
+}
+
+// DeleteRole removes a role without users
+func (h *Handlers) DeleteRole(c *gin.Context) {
+	if err := h.roles.DeleteRole(c.Request.Context(), c.Param("name")); err != nil {
+		c.JSON(roleErrorStatus(err), models.APIResponse{
+			Success: false,
+			Message: "Erro ao remover papel",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Papel removido com sucesso",
+	})
+}
+
+func roleErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrRoleNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrRoleExists), errors.Is(err, services.ErrRoleInUse):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrRoleProtected), errors.Is(err, services.ErrInvalidRole):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
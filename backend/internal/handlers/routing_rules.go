@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"amz-web-tools/backend/internal/models"
+	"amz-web-tools/backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetRoutingRules lists the routing rules, optionally filtered by rule_type
+func (h *Handlers) GetRoutingRules(c *gin.Context) {
+	rules, err := h.routing.ListRules(c.Request.Context(), c.Query("rule_type"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Erro ao buscar regras de roteamento",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    rules,
+	})
+}
+
+// CreateRoutingRule creates a routing rule
+func (h *Handlers) CreateRoutingRule(c *gin.Context) {
+	var req models.RoutingRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Parâmetros inválidos",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	rule, err := h.routing.CreateRule(c.Request.Context(), req, c.GetString("user_id"), c.GetString("user_email"), c.GetString("user_name"),
+		c.ClientIP(), c.GetHeader("User-Agent"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Erro ao criar regra de roteamento",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Message: "Regra de roteamento criada com sucesso",
+		Data:    rule,
+	})
+}
+
+// UpdateRoutingRule updates a routing rule
+func (h *Handlers) UpdateRoutingRule(c *gin.Context) {
+	var req models.RoutingRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Parâmetros inválidos",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	rule, err := h.routing.UpdateRule(c.Request.Context(), c.Param("id"), req, c.GetString("user_id"), c.GetString("user_email"),
+		c.GetString("user_name"), c.ClientIP(), c.GetHeader("User-Agent"))
+	if err != nil {
+		statusCode := http.StatusBadRequest
+		if errors.Is(err, services.ErrRoutingRuleNotFound) {
+			statusCode = http.StatusNotFound
+		}
+		c.JSON(statusCode, models.APIResponse{
+			Success: false,
+			Message: "Erro ao atualizar regra de roteamento",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Regra de roteamento atualizada com sucesso",
+		Data:    rule,
+	})
+}
+
+// DeleteRoutingRule removes a routing rule
+func (h *Handlers) DeleteRoutingRule(c *gin.Context) {
+	err := h.routing.DeleteRule(c.Request.Context(), c.Param("id"), c.GetString("user_id"), c.GetString("user_email"),
+		c.GetString("user_name"), c.ClientIP(), c.GetHeader("User-Agent"))
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, services.ErrRoutingRuleNotFound) {
+			statusCode = http.StatusNotFound
+		}
+		c.JSON(statusCode, models.APIResponse{
+			Success: false,
+			Message: "Erro ao remover regra de roteamento",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Regra de roteamento removida com sucesso",
+	})
+}
+
+// ReloadRoutingRules reloads the routing rules cache from the database
+func (h *Handlers) ReloadRoutingRules(c *gin.Context) {
+	if err := h.routing.Reload(); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Erro ao recarregar regras de roteamento",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Regras de roteamento recarregadas com sucesso",
+	})
+}
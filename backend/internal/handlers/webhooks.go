@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"amz-web-tools/backend/internal/models"
+	"amz-web-tools/backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MercadoLivreWebhook receives Mercado Livre notifications (orders_v2, shipments).
+// The notification is acknowledged immediately and processed in background.
+func (h *Handlers) MercadoLivreWebhook(c *gin.Context) {
+	var notification services.MLNotification
+	if err := c.ShouldBindJSON(&notification); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Invalid notification format",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if err := h.mlWebhook.Receive(c.Request.Context(), notification); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrWebhookUnauthorized) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: "Notification rejected",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Notification received",
+	})
+}
@@ -1,9 +1,14 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"amz-web-tools/backend/internal/models"
+	"amz-web-tools/backend/internal/services"
 
 	"github.com/gin-gonic/gin"
 )
@@ -32,10 +37,17 @@ func (h *Handlers) ProcessXMLIntegration(c *gin.Context) {
 		return
 	}
 
+	userID := ""
+	if userIDInterface, exists := c.Get("user_id"); exists {
+		if userIDStr, ok := userIDInterface.(string); ok {
+			userID = userIDStr
+		}
+	}
+
 	// Processar integração XML
-	result, err := h.xmlIntegrator.ProcessXMLIntegration(req.NumPedido)
+	result, err := h.xmlIntegrator.ProcessXMLIntegration(c.Request.Context(), scopeFor(c), req.NumPedido, userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
+		c.JSON(scopeErrorStatus(err, http.StatusInternalServerError), models.APIResponse{
 			Success: false,
 			Message: "Erro ao processar integração XML",
 			Error:   err.Error(),
@@ -46,7 +58,7 @@ func (h *Handlers) ProcessXMLIntegration(c *gin.Context) {
 	c.JSON(http.StatusOK, result)
 }
 
-// GetXMLIntegrationLogs returns logs for a specific process
+// GetXMLIntegrationLogs returns a process run with all its log entries
 func (h *Handlers) GetXMLIntegrationLogs(c *gin.Context) {
 	processID := c.Param("process_id")
 	if processID == "" {
@@ -57,10 +69,13 @@ func (h *Handlers) GetXMLIntegrationLogs(c *gin.Context) {
 		return
 	}
 
-	// Buscar logs do serviço
-	logs, err := h.xmlIntegrator.GetLogs(processID)
+	run, err := h.xmlIntegrator.GetRun(c.Request.Context(), scopeFor(c), processID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrXMLRunNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, models.APIResponse{
 			Success: false,
 			Message: "Erro ao recuperar logs",
 			Error:   err.Error(),
@@ -72,7 +87,154 @@ func (h *Handlers) GetXMLIntegrationLogs(c *gin.Context) {
 		Success: true,
 		Message: "Logs recuperados com sucesso",
 		Data: map[string]interface{}{
-			"logs": logs,
+			"run":  run,
+			"logs": run.Logs,
 		},
 	})
 }
+
+// GetXMLIntegrationLogHistory returns the paginated log history, filtered by
+// process_id, num_pedido, conta, level and date (from/to)
+func (h *Handlers) GetXMLIntegrationLogHistory(c *gin.Context) {
+	filter, err := xmlIntegratorLogFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Parâmetros inválidos",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	logs, totalCount, err := h.xmlIntegrator.ListLogs(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Erro ao recuperar logs",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Logs recuperados com sucesso",
+		Data:    xmlIntegratorPage("logs", logs, len(logs), totalCount, filter),
+	})
+}
+
+// GetXMLIntegrationRuns returns the paginated process run history, filtered by
+// num_pedido, conta, status and date (from/to)
+func (h *Handlers) GetXMLIntegrationRuns(c *gin.Context) {
+	filter, err := xmlIntegratorLogFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Parâmetros inválidos",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	runs, totalCount, err := h.xmlIntegrator.ListRuns(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Erro ao recuperar execuções",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Execuções recuperadas com sucesso",
+		Data:    xmlIntegratorPage("runs", runs, len(runs), totalCount, filter),
+	})
+}
+
+// xmlIntegratorLogFilter reads the history filters from the query string. Dates
+// are YYYY-MM-DD (a "to" date includes the whole day) or RFC 3339.
+func xmlIntegratorLogFilter(c *gin.Context) (services.XMLIntegratorLogFilter, error) {
+	filter := services.XMLIntegratorLogFilter{
+		ProcessID: c.Query("process_id"),
+		NumPedido: c.Query("num_pedido"),
+		Conta:     c.Query("conta"),
+		Level:     c.Query("level"),
+		Status:    c.Query("status"),
+		Scope:     scopeFor(c),
+		Page:      1,
+		PageSize:  50,
+	}
+
+	if p, err := strconv.Atoi(c.Query("page")); err == nil && p > 0 {
+		filter.Page = p
+	}
+	if ps, err := strconv.Atoi(c.Query("page_size")); err == nil && ps > 0 && ps <= 200 {
+		filter.PageSize = ps
+	}
+
+	for _, param := range []string{"from", "to"} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+
+		date, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			date, err = time.ParseInLocation("2006-01-02", value, time.Local)
+			if err != nil {
+				return filter, fmt.Errorf("%s inválido: use YYYY-MM-DD ou RFC 3339", param)
+			}
+			if param == "to" {
+				date = date.AddDate(0, 0, 1)
+			}
+		}
+
+		if param == "from" {
+			filter.From = &date
+		} else {
+			filter.To = &date
+		}
+	}
+
+	return filter, nil
+}
+
+func xmlIntegratorPage(key string, items interface{}, count, totalCount int, filter services.XMLIntegratorLogFilter) gin.H {
+	totalPages := (totalCount + filter.PageSize - 1) / filter.PageSize
+	return gin.H{
+		key:           items,
+		"count":       count,
+		"total_count": totalCount,
+		"page":        filter.Page,
+		"page_size":   filter.PageSize,
+		"total_pages": totalPages,
+		"has_next":    filter.Page < totalPages,
+		"has_prev":    filter.Page > 1,
+	}
+}
+
+// GetScheduledXMLUploads lists the XML uploads deferred until the Mercado Livre
+// buffering date (?status=scheduled|uploaded|failed|cancelled)
+func (h *Handlers) GetScheduledXMLUploads(c *gin.Context) {
+	limit := 100
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 500 {
+		limit = l
+	}
+
+	uploads, err := h.xmlIntegrator.GetScheduledUploads(c.Request.Context(), scopeFor(c), c.Query("status"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Erro ao buscar envios de XML agendados",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    uploads,
+	})
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"amz-web-tools/backend/internal/models"
+	"amz-web-tools/backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetXMLSweepRuns returns the latest XML sweep runs without per-order results
+func (h *Handlers) GetXMLSweepRuns(c *gin.Context) {
+	limit := 20
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 100 {
+		limit = l
+	}
+
+	runs, err := h.xmlSweep.GetRuns(c.Request.Context(), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Erro ao buscar varreduras de XML",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    runs,
+	})
+}
+
+// GetXMLSweepRun returns an XML sweep run with the result of each order
+func (h *Handlers) GetXMLSweepRun(c *gin.Context) {
+	run, err := h.xmlSweep.GetRun(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrXMLSweepRunNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: "Erro ao buscar varredura de XML",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    run,
+	})
+}
+
+// RunXMLSweep runs the XML sweep immediately over every pending invoice
+func (h *Handlers) RunXMLSweep(c *gin.Context) {
+	userID := ""
+	if userIDInterface, exists := c.Get("user_id"); exists {
+		if userIDStr, ok := userIDInterface.(string); ok {
+			userID = userIDStr
+		}
+	}
+
+	run, err := h.xmlSweep.RunNow(c.Request.Context(), services.XMLSweepManual, userID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrXMLSweepRunning) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: "Erro ao executar a varredura de XML",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Varredura de XML executada",
+		Data:    run,
+	})
+}
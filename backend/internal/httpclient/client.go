@@ -0,0 +1,249 @@
+// Package httpclient é a camada comum das chamadas HTTP externas (Mercado
+// Livre, NBS, IBGE, API de placas, Telegram): timeout por tentativa, retentativas
+// com backoff exponencial respeitando Retry-After, circuit breaker e métricas
+// por host.
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Options configura o cliente. Valores zerados usam os padrões.
+type Options struct {
+	Timeout          time.Duration // timeout de cada tentativa
+	MaxRetries       int           // retentativas após a primeira tentativa
+	NoRetry          bool          // desativa as retentativas (MaxRetries zerado usa o padrão)
+	BaseBackoff      time.Duration // espera antes da primeira retentativa, dobrada a cada nova tentativa
+	MaxBackoff       time.Duration // espera máxima entre tentativas, inclusive a pedida pelo Retry-After
+	BreakerThreshold int           // falhas seguidas que abrem o circuito do host
+	BreakerCooldown  time.Duration // tempo com o circuito aberto antes de testar o host de novo
+}
+
+const (
+	defaultTimeout          = 30 * time.Second
+	defaultMaxRetries       = 3
+	defaultBaseBackoff      = 500 * time.Millisecond
+	defaultMaxBackoff       = 30 * time.Second
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// ErrCircuitOpen indica que o host está com o circuito aberto após falhas seguidas
+var ErrCircuitOpen = errors.New("circuit breaker aberto")
+
+type idempotentKey struct{}
+
+// Idempotent marca o contexto de uma requisição não idempotente (POST) como
+// segura para repetir em erros 5xx e de rede. Sem a marca, POSTs só são
+// repetidos em 429, quando o servidor garante que não processou a requisição.
+func Idempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+// Client executa requisições HTTP com retentativas e circuit breaker. Clientes
+// derivados com WithTimeout compartilham os breakers e as métricas.
+type Client struct {
+	http  *http.Client
+	opts  Options
+	hosts *hostRegistry
+}
+
+// New cria o cliente com um registro de hosts próprio
+func New(opts Options) *Client {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	if opts.NoRetry || opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
+	} else if opts.MaxRetries == 0 {
+		opts.MaxRetries = defaultMaxRetries
+	}
+	if opts.BaseBackoff <= 0 {
+		opts.BaseBackoff = defaultBaseBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaultMaxBackoff
+	}
+	if opts.BreakerThreshold <= 0 {
+		opts.BreakerThreshold = defaultBreakerThreshold
+	}
+	if opts.BreakerCooldown <= 0 {
+		opts.BreakerCooldown = defaultBreakerCooldown
+	}
+
+	return &Client{
+		http:  &http.Client{Timeout: opts.Timeout},
+		opts:  opts,
+		hosts: newHostRegistry(),
+	}
+}
+
+// WithTimeout retorna um cliente com outro timeout por tentativa, compartilhando
+// breakers e métricas
+func (c *Client) WithTimeout(timeout time.Duration) *Client {
+	opts := c.opts
+	opts.Timeout = timeout
+	return &Client{
+		http:  &http.Client{Timeout: timeout},
+		opts:  opts,
+		hosts: c.hosts,
+	}
+}
+
+// Metrics retorna as métricas de cada host chamado
+func (c *Client) Metrics() []HostMetrics {
+	return c.hosts.snapshot()
+}
+
+// Get faz um GET com o contexto informado
+func (c *Client) Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Post faz um POST com o contexto informado. body deve ser um *bytes.Reader,
+// *bytes.Buffer ou *strings.Reader para que a requisição possa ser repetida.
+func (c *Client) Post(ctx context.Context, url, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return c.Do(req)
+}
+
+// Do executa a requisição. Respostas 429 e 5xx e erros de rede são repetidos
+// com backoff exponencial (ver Idempotent para POSTs); a última resposta é
+// retornada ao chamador, que continua responsável por fechar o body.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	host := c.hosts.get(req.URL.Host)
+	ctx := req.Context()
+	retryable := isIdempotent(req)
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		if !host.allow(c.opts.BreakerCooldown) {
+			host.recordRejected()
+			return nil, fmt.Errorf("%w para %s", ErrCircuitOpen, req.URL.Host)
+		}
+
+		start := time.Now()
+		resp, err := c.http.Do(req)
+		elapsed := time.Since(start)
+
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		// Cancelamentos do chamador não contam como sucesso nem como falha do host
+		if ctx.Err() != nil {
+			host.recordCanceled(status, elapsed)
+		} else {
+			host.record(status, elapsed, err != nil || status >= 500, c.opts.BreakerThreshold)
+		}
+
+		retry := false
+		switch {
+		case ctx.Err() != nil:
+		case err != nil || status >= 500:
+			retry = retryable
+		case status == http.StatusTooManyRequests:
+			retry = true
+		}
+		// Um body sem GetBody já foi consumido e não pode ser reenviado
+		if req.Body != nil && req.GetBody == nil {
+			retry = false
+		}
+		if !retry || attempt >= c.opts.MaxRetries {
+			return resp, err
+		}
+
+		wait := c.backoff(attempt, resp)
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		log.Printf("🔁 %s %s: %s, nova tentativa em %s (%d/%d)", req.Method, req.URL.Host,
+			describe(status, err), wait.Round(time.Millisecond), attempt+1, c.opts.MaxRetries)
+		host.recordRetry()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// backoff calcula a espera antes da próxima tentativa: o Retry-After da
+// resposta, se houver, ou o backoff exponencial com jitter
+func (c *Client) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if wait, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+			if wait > c.opts.MaxBackoff {
+				wait = c.opts.MaxBackoff
+			}
+			return wait
+		}
+	}
+
+	wait := c.opts.BaseBackoff << uint(attempt)
+	if wait <= 0 || wait > c.opts.MaxBackoff {
+		wait = c.opts.MaxBackoff
+	}
+	// Jitter de até 20% para não sincronizar retentativas de várias goroutines
+	return wait - time.Duration(rand.Int63n(int64(wait)/5+1))
+}
+
+// retryAfter interpreta o header Retry-After em segundos ou como data HTTP
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	marked, _ := req.Context().Value(idempotentKey{}).(bool)
+	return marked
+}
+
+func describe(status int, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("status %d", status)
+}
@@ -0,0 +1,269 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testServer responde com o status atual de status e conta as requisições
+func testServer(t *testing.T, status *atomic.Int32, header http.Header) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		for key, values := range header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(int(status.Load()))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func hostMetrics(t *testing.T, c *Client) HostMetrics {
+	t.Helper()
+
+	metrics := c.Metrics()
+	if len(metrics) != 1 {
+		t.Fatalf("Metrics() = %d hosts, want 1", len(metrics))
+	}
+	return metrics[0]
+}
+
+func TestDoRetriesServerErrors(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	c := New(Options{MaxRetries: 3, BaseBackoff: time.Millisecond})
+	resp, err := c.Get(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || hits.Load() != 3 {
+		t.Errorf("status %d após %d requisições, want 200 após 3", resp.StatusCode, hits.Load())
+	}
+	if m := hostMetrics(t, c); m.Retries != 2 || m.Failures != 2 || m.State != StateClosed {
+		t.Errorf("métricas = %+v", m)
+	}
+}
+
+func TestDoRetriesPostOnlyWhenIdempotent(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusInternalServerError)
+	srv, hits := testServer(t, &status, nil)
+
+	c := New(Options{MaxRetries: 2, BaseBackoff: time.Millisecond, BreakerThreshold: 100})
+
+	resp, err := c.Post(context.Background(), srv.URL, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	resp.Body.Close()
+	if hits.Load() != 1 {
+		t.Errorf("POST sem Idempotent: %d requisições, want 1", hits.Load())
+	}
+
+	hits.Store(0)
+	resp, err = c.Post(Idempotent(context.Background()), srv.URL, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	resp.Body.Close()
+	if hits.Load() != 3 {
+		t.Errorf("POST com Idempotent: %d requisições, want 3", hits.Load())
+	}
+}
+
+func TestDoHonorsRetryAfter(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusTooManyRequests)
+	srv, hits := testServer(t, &status, http.Header{"Retry-After": {"1"}})
+
+	// O Retry-After de 1s é limitado por MaxBackoff
+	c := New(Options{MaxRetries: 1, BaseBackoff: time.Millisecond, MaxBackoff: 100 * time.Millisecond})
+
+	start := time.Now()
+	resp, err := c.Get(context.Background(), srv.URL)
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+
+	if hits.Load() != 2 {
+		t.Errorf("%d requisições, want 2", hits.Load())
+	}
+	if elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Errorf("espera de %v, want o Retry-After limitado a 100ms", elapsed)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"", 0, false},
+		{"0", 0, true},
+		{"2", 2 * time.Second, true},
+		{"-1", 0, false},
+		{"amanhã", 0, false},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, true},
+	}
+	for _, tt := range tests {
+		got, ok := retryAfter(tt.value)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("retryAfter(%q) = (%v, %v), want (%v, %v)", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if got, ok := retryAfter(future); !ok || got < 59*time.Minute || got > time.Hour {
+		t.Errorf("retryAfter(%q) = (%v, %v), want cerca de 1h", future, got, ok)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusInternalServerError)
+	srv, hits := testServer(t, &status, nil)
+
+	const cooldown = 50 * time.Millisecond
+	c := New(Options{MaxRetries: -1, BreakerThreshold: 2, BreakerCooldown: cooldown})
+	get := func() error {
+		resp, err := c.Get(context.Background(), srv.URL)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	// Duas falhas seguidas abrem o circuito
+	get()
+	get()
+	if m := hostMetrics(t, c); m.State != StateOpen {
+		t.Fatalf("estado após 2 falhas = %s, want open", m.State)
+	}
+	if err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("com o circuito aberto: %v, want ErrCircuitOpen", err)
+	}
+	if hits.Load() != 2 {
+		t.Errorf("o circuito aberto não deveria chamar o host: %d requisições", hits.Load())
+	}
+
+	// Após o cooldown, a requisição de teste falha e o circuito reabre
+	time.Sleep(cooldown + 10*time.Millisecond)
+	get()
+	if m := hostMetrics(t, c); m.State != StateOpen || m.Rejected != 1 {
+		t.Fatalf("após o teste com falha: %+v", m)
+	}
+
+	// Um teste com sucesso fecha o circuito
+	time.Sleep(cooldown + 10*time.Millisecond)
+	status.Store(http.StatusOK)
+	if err := get(); err != nil {
+		t.Fatalf("requisição de teste: %v", err)
+	}
+	if m := hostMetrics(t, c); m.State != StateClosed || m.ConsecutiveFailures != 0 {
+		t.Errorf("após o teste com sucesso: %+v", m)
+	}
+}
+
+func TestHalfOpenAllowsSingleProbe(t *testing.T) {
+	h := &hostState{host: "nbs", state: StateOpen, openedAt: time.Now().Add(-time.Minute), statusCodes: map[int]int64{}}
+
+	if !h.allow(time.Second) {
+		t.Fatal("após o cooldown a requisição de teste deveria ser liberada")
+	}
+	if h.allow(time.Second) {
+		t.Error("só uma requisição de teste deveria ser liberada no half-open")
+	}
+}
+
+func TestDoNoRetry(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+	srv, hits := testServer(t, &status, nil)
+
+	tests := []struct {
+		name string
+		opts Options
+		want int32
+	}{
+		{"NoRetry", Options{NoRetry: true}, 1},
+		{"MaxRetries zerado usa o padrão", Options{}, defaultMaxRetries + 1},
+	}
+	for _, tt := range tests {
+		hits.Store(0)
+		tt.opts.BaseBackoff = time.Millisecond
+		tt.opts.BreakerThreshold = 100
+		resp, err := New(tt.opts).Get(context.Background(), srv.URL)
+		if err != nil {
+			t.Fatalf("%s: Get: %v", tt.name, err)
+		}
+		resp.Body.Close()
+		if hits.Load() != tt.want {
+			t.Errorf("%s: %d requisições, want %d", tt.name, hits.Load(), tt.want)
+		}
+	}
+}
+
+func TestDoCanceledIsNotRecorded(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	const cooldown = 50 * time.Millisecond
+	c := New(Options{NoRetry: true, BreakerThreshold: 1, BreakerCooldown: cooldown})
+	canceledGet := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if _, err := c.Get(ctx, srv.URL); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Get cancelado: %v, want context.DeadlineExceeded", err)
+		}
+	}
+
+	// Com o circuito fechado o cancelamento não conta como falha
+	canceledGet()
+	if m := hostMetrics(t, c); m.State != StateClosed || m.Failures != 0 || m.ConsecutiveFailures != 0 {
+		t.Fatalf("após cancelamento com o circuito fechado: %+v", m)
+	}
+
+	// No half-open a requisição de teste cancelada não fecha o circuito e libera
+	// uma nova requisição de teste
+	host := c.hosts.get(strings.TrimPrefix(srv.URL, "http://"))
+	host.mu.Lock()
+	host.state, host.openedAt = StateOpen, time.Now().Add(-time.Minute)
+	host.mu.Unlock()
+
+	canceledGet()
+	if m := hostMetrics(t, c); m.State != StateHalfOpen || m.Failures != 0 {
+		t.Fatalf("após a requisição de teste cancelada: %+v", m)
+	}
+	if !host.allow(cooldown) {
+		t.Error("após o cancelamento uma nova requisição de teste deveria ser liberada")
+	}
+}
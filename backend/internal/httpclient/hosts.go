@@ -0,0 +1,205 @@
+package httpclient
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Estados do circuit breaker
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half_open"
+)
+
+// HostMetrics são os contadores de um host desde o início do processo
+type HostMetrics struct {
+	Host                string        `json:"host"`
+	State               string        `json:"state"`
+	Requests            int64         `json:"requests"`
+	Failures            int64         `json:"failures"`
+	Retries             int64         `json:"retries"`
+	Rejected            int64         `json:"rejected"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	StatusCodes         map[int]int64 `json:"status_codes"`
+	AvgLatencyMs        float64       `json:"avg_latency_ms"`
+	LastStatus          int           `json:"last_status,omitempty"`
+	LastRequestAt       *time.Time    `json:"last_request_at,omitempty"`
+	OpenedAt            *time.Time    `json:"opened_at,omitempty"`
+}
+
+// hostState guarda o circuit breaker e as métricas de um host
+type hostState struct {
+	mu sync.Mutex
+
+	host                string
+	state               string
+	consecutiveFailures int
+	openedAt            time.Time
+	probing             bool // uma requisição de teste está em andamento no half-open
+
+	requests     int64
+	failures     int64
+	retries      int64
+	rejected     int64
+	statusCodes  map[int]int64
+	totalLatency time.Duration
+	lastStatus   int
+	lastRequest  time.Time
+}
+
+// allow informa se uma requisição pode ser feita. Com o circuito aberto, após
+// o cooldown uma única requisição de teste é liberada (half-open).
+func (h *hostState) allow(cooldown time.Duration) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch h.state {
+	case StateOpen:
+		if time.Since(h.openedAt) < cooldown {
+			return false
+		}
+		h.state = StateHalfOpen
+		h.probing = true
+		return true
+	case StateHalfOpen:
+		if h.probing {
+			return false
+		}
+		h.probing = true
+		return true
+	}
+	return true
+}
+
+func (h *hostState) record(status int, latency time.Duration, failed bool, threshold int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.count(status, latency)
+
+	if !failed {
+		if h.state != StateClosed {
+			log.Printf("✅ Circuit breaker de %s fechado", h.host)
+		}
+		h.state = StateClosed
+		h.consecutiveFailures = 0
+		h.probing = false
+		return
+	}
+
+	h.failures++
+	h.consecutiveFailures++
+	if h.state == StateHalfOpen || h.consecutiveFailures >= threshold {
+		if h.state != StateOpen {
+			log.Printf("🚫 Circuit breaker de %s aberto após %d falha(s) seguida(s)", h.host, h.consecutiveFailures)
+		}
+		h.state = StateOpen
+		h.openedAt = time.Now()
+		h.probing = false
+	}
+}
+
+// recordCanceled conta uma requisição cancelada pelo chamador sem alterar o
+// circuito; se era a requisição de teste do half-open, a próxima é liberada
+func (h *hostState) recordCanceled(status int, latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.count(status, latency)
+	h.probing = false
+}
+
+// count atualiza as métricas da requisição; chamado com h.mu travado
+func (h *hostState) count(status int, latency time.Duration) {
+	h.requests++
+	h.totalLatency += latency
+	h.lastStatus = status
+	h.lastRequest = time.Now()
+	if status > 0 {
+		h.statusCodes[status]++
+	}
+}
+
+func (h *hostState) recordRetry() {
+	h.mu.Lock()
+	h.retries++
+	h.mu.Unlock()
+}
+
+func (h *hostState) recordRejected() {
+	h.mu.Lock()
+	h.rejected++
+	h.mu.Unlock()
+}
+
+func (h *hostState) metrics() HostMetrics {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	m := HostMetrics{
+		Host:                h.host,
+		State:               h.state,
+		Requests:            h.requests,
+		Failures:            h.failures,
+		Retries:             h.retries,
+		Rejected:            h.rejected,
+		ConsecutiveFailures: h.consecutiveFailures,
+		StatusCodes:         make(map[int]int64, len(h.statusCodes)),
+		LastStatus:          h.lastStatus,
+	}
+	for status, count := range h.statusCodes {
+		m.StatusCodes[status] = count
+	}
+	if h.requests > 0 {
+		m.AvgLatencyMs = float64(h.totalLatency.Milliseconds()) / float64(h.requests)
+	}
+	if !h.lastRequest.IsZero() {
+		lastRequest := h.lastRequest
+		m.LastRequestAt = &lastRequest
+	}
+	if h.state != StateClosed {
+		openedAt := h.openedAt
+		m.OpenedAt = &openedAt
+	}
+	return m
+}
+
+type hostRegistry struct {
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+func newHostRegistry() *hostRegistry {
+	return &hostRegistry{hosts: make(map[string]*hostState)}
+}
+
+func (r *hostRegistry) get(host string) *hostState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	h, ok := r.hosts[host]
+	if !ok {
+		h = &hostState{host: host, state: StateClosed, statusCodes: make(map[int]int64)}
+		r.hosts[host] = h
+	}
+	return h
+}
+
+func (r *hostRegistry) snapshot() []HostMetrics {
+	r.mu.Lock()
+	hosts := make([]*hostState, 0, len(r.hosts))
+	for _, h := range r.hosts {
+		hosts = append(hosts, h)
+	}
+	r.mu.Unlock()
+
+	metrics := make([]HostMetrics, 0, len(hosts))
+	for _, h := range hosts {
+		metrics = append(metrics, h.metrics())
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Host < metrics[j].Host })
+	return metrics
+}
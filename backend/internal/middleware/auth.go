@@ -1,23 +1,129 @@
 package middleware
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strings"
 
 	"amz-web-tools/backend/internal/models"
+	"amz-web-tools/backend/internal/permissions"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
+// Errors returned by ParseToken and Authenticate
+var (
+	ErrInvalidToken   = errors.New("invalid or expired token")
+	ErrInvalidClaims  = errors.New("invalid token claims")
+	ErrInvalidUserID  = errors.New("invalid user ID in token")
+	ErrSessionRevoked = errors.New("session revoked or expired")
+)
+
+// Claims are the claims of an access token issued by the auth service
+type Claims struct {
+	UserID      string
+	Role        string
+	Permissions []string
+	// Contas the user can access; "*" means every conta
+	Contas    []string
+	SessionID string
+}
+
+// SessionChecker reports whether the session an access token belongs to is
+// still active; implemented by services.AuthService
+type SessionChecker interface {
+	SessionActive(ctx context.Context, sessionID string) (bool, error)
+}
+
+// ParseToken validates a JWT issued by the auth service and returns its
+// claims. Tokens without a role get "user"; tokens without a session are
+// rejected.
+func ParseToken(tokenString, jwtSecret string) (*Claims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		// Validate signing method
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return []byte(jwtSecret), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	mapClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrInvalidClaims
+	}
+
+	claims := &Claims{}
+	claims.UserID, ok = mapClaims["user_id"].(string)
+	if !ok {
+		return nil, ErrInvalidUserID
+	}
+
+	claims.Role, ok = mapClaims["role"].(string)
+	if !ok {
+		claims.Role = "user"
+	}
+
+	// Permissions of the role and contas of the user when the token was issued
+	claims.Permissions = stringList(mapClaims["permissions"])
+	claims.Contas = stringList(mapClaims["contas"])
+
+	claims.SessionID, ok = mapClaims["sid"].(string)
+	if !ok || claims.SessionID == "" {
+		return nil, ErrInvalidClaims
+	}
+
+	return claims, nil
+}
+
+func stringList(claim interface{}) []string {
+	values := []string{}
+	list, _ := claim.([]interface{})
+	for _, item := range list {
+		if value, ok := item.(string); ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// Authenticate parses the access token and checks that its session has not
+// been revoked
+func Authenticate(ctx context.Context, tokenString, jwtSecret string, sessions SessionChecker) (*Claims, error) {
+	claims, err := ParseToken(tokenString, jwtSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := sessions.SessionActive(ctx, claims.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, ErrSessionRevoked
+	}
+
+	return claims, nil
+}
+
+// AuthMiddleware requires a valid access token of an active session and sets
+// user_id, user_role, user_permissions, user_contas and session_id on the
+// context
+func AuthMiddleware(jwtSecret string, sessions SessionChecker) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		log.Printf("🔐 AuthMiddleware: Verificando autenticação para %s %s", c.Request.Method, c.Request.URL.Path)
 
 		// Get token from Authorization header
 		authHeader := c.GetHeader("Authorization")
-		log.Printf("🔐 AuthMiddleware: Authorization header = '%s'", authHeader)
 
 		if authHeader == "" {
 			log.Printf("❌ AuthMiddleware: Authorization header vazio")
@@ -31,7 +137,6 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 
 		// Check if token starts with "Bearer "
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		log.Printf("🔐 AuthMiddleware: Token extraído = '%s'", tokenString)
 
 		if tokenString == authHeader {
 			log.Printf("❌ AuthMiddleware: Token não tem formato Bearer")
@@ -44,31 +149,25 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 		}
 
 		// Parse and validate token
-		log.Printf("🔐 AuthMiddleware: JWT Secret = '%s'", jwtSecret)
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Validate signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				log.Printf("❌ AuthMiddleware: Método de assinatura inválido")
-				return nil, jwt.ErrSignatureInvalid
-			}
-			return []byte(jwtSecret), nil
-		})
-
+		claims, err := Authenticate(c.Request.Context(), tokenString, jwtSecret, sessions)
 		if err != nil {
-			log.Printf("❌ AuthMiddleware: Erro ao parsear token: %v", err)
-			c.JSON(http.StatusUnauthorized, models.APIResponse{
-				Success: false,
-				Message: "Invalid or expired token",
-			})
-			c.Abort()
-			return
-		}
-
-		if !token.Valid {
-			log.Printf("❌ AuthMiddleware: Token inválido")
-			c.JSON(http.StatusUnauthorized, models.APIResponse{
+			log.Printf("❌ AuthMiddleware: %v", err)
+			status := http.StatusUnauthorized
+			message := "Invalid or expired token"
+			switch {
+			case errors.Is(err, ErrInvalidClaims):
+				message = "Invalid token claims"
+			case errors.Is(err, ErrInvalidUserID):
+				message = "Invalid user ID in token"
+			case errors.Is(err, ErrSessionRevoked):
+				message = "Session revoked or expired"
+			case !errors.Is(err, ErrInvalidToken):
+				status = http.StatusInternalServerError
+				message = "Failed to validate session"
+			}
+			c.JSON(status, models.APIResponse{
 				Success: false,
-				Message: "Invalid or expired token",
+				Message: message,
 			})
 			c.Abort()
 			return
@@ -76,68 +175,40 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 
 		log.Printf("✅ AuthMiddleware: Token válido!")
 
-		// Extract claims
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, models.APIResponse{
-				Success: false,
-				Message: "Invalid token claims",
-			})
-			c.Abort()
-			return
-		}
-
-		// Set user information in context
-		userID, ok := claims["user_id"].(string)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, models.APIResponse{
-				Success: false,
-				Message: "Invalid user ID in token",
-			})
-			c.Abort()
-			return
-		}
-
-		userRole, ok := claims["role"].(string)
-		if !ok {
-			userRole = "user"
-		}
-
-		c.Set("user_id", userID)
-		c.Set("user_role", userRole)
+		c.Set("user_id", claims.UserID)
+		c.Set("user_role", claims.Role)
+		c.Set("session_id", claims.SessionID)
+		c.Set("user_permissions", claims.Permissions)
+		c.Set("user_contas", claims.Contas)
 		c.Next()
 	}
 }
 
-// RoleMiddleware checks if user has required role
-func RoleMiddleware(requiredRole string) gin.HandlerFunc {
+// HasPermission reports whether the authenticated user has a permission
+// covering required
+func HasPermission(c *gin.Context, required string) bool {
+	granted, _ := c.Get("user_permissions")
+	list, _ := granted.([]string)
+	return permissions.Has(list, required)
+}
+
+// RequirePermission rejects users without a permission covering required
+func RequirePermission(required string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userRole, exists := c.Get("user_role")
-		if !exists {
+		if _, exists := c.Get("user_permissions"); !exists {
 			c.JSON(http.StatusUnauthorized, models.APIResponse{
 				Success: false,
-				Message: "User role not found",
+				Message: "User permissions not found",
 			})
 			c.Abort()
 			return
 		}
 
-		role := userRole.(string)
-
-		// Define role hierarchy (admin > operacao > atendimento)
-		roleHierarchy := map[string]int{
-			"atendimento": 1,
-			"operacao":    2,
-			"admin":       3,
-		}
-
-		userLevel, userExists := roleHierarchy[role]
-		requiredLevel, requiredExists := roleHierarchy[requiredRole]
-
-		if !userExists || !requiredExists || userLevel < requiredLevel {
+		if !HasPermission(c, required) {
 			c.JSON(http.StatusForbidden, models.APIResponse{
 				Success: false,
 				Message: "Insufficient permissions",
+				Error:   "missing permission " + required,
 			})
 			c.Abort()
 			return
@@ -147,29 +218,7 @@ func RoleMiddleware(requiredRole string) gin.HandlerFunc {
 	}
 }
 
-// AdminMiddleware checks if user is admin
+// AdminMiddleware requires the admin:access permission
 func AdminMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		userRole, exists := c.Get("user_role")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, models.APIResponse{
-				Success: false,
-				Message: "User role not found",
-			})
-			c.Abort()
-			return
-		}
-
-		role := userRole.(string)
-		if role != "admin" {
-			c.JSON(http.StatusForbidden, models.APIResponse{
-				Success: false,
-				Message: "Admin access required",
-			})
-			c.Abort()
-			return
-		}
-
-		c.Next()
-	}
+	return RequirePermission(permissions.AdminAccess)
 }
@@ -19,13 +19,18 @@ type User struct {
 	UpdatedAt         time.Time `json:"updated_at" db:"updated_at"`
 }
 
-// UserSession represents a user session
+// UserSession represents a user session. Token is the SHA-256 of the
+// session's current refresh token.
 type UserSession struct {
-	ID        string    `json:"id" db:"id"`
-	UserID    string    `json:"user_id" db:"user_id"`
-	Token     string    `json:"token" db:"token"`
-	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ID         string     `json:"id" db:"id"`
+	UserID     string     `json:"user_id" db:"user_id"`
+	Token      string     `json:"-" db:"token"`
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	IPAddress  string     `json:"ip_address,omitempty" db:"ip_address"`
+	UserAgent  string     `json:"user_agent,omitempty" db:"user_agent"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
 }
 
 // LoginRequest represents login request payload
@@ -34,6 +39,17 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required,min=6"`
 }
 
+// RefreshTokenRequest represents token refresh request payload
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// LogoutRequest represents logout request payload. Without a refresh token
+// the session of the bearer token is ended.
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
 // RegisterRequest represents registration request payload
 type RegisterRequest struct {
 	Email      string `json:"email" binding:"required,email"`
@@ -60,7 +76,7 @@ type CreateUserRequest struct {
 	Password   string `json:"password" binding:"required,min=6"`
 	Name       string `json:"name" binding:"required"`
 	Department string `json:"department" binding:"required"`
-	Role       string `json:"role" binding:"required,oneof=admin operacao atendimento"`
+	Role       string `json:"role" binding:"required"`
 }
 
 // UpdateUserRequest represents user update request (Admin only)
@@ -68,7 +84,7 @@ type UpdateUserRequest struct {
 	ID         string `json:"id" binding:"required"`
 	Name       string `json:"name" binding:"required"`
 	Department string `json:"department" binding:"required"`
-	Role       string `json:"role" binding:"required,oneof=admin operacao atendimento"`
+	Role       string `json:"role" binding:"required"`
 }
 
 // ResetPasswordRequest represents password reset request (Admin only)
@@ -77,6 +93,12 @@ type ResetPasswordRequest struct {
 	Password string `json:"password" binding:"required,min=6"`
 }
 
+// UserContasRequest represents the contas a user can access (Admin only).
+// ["*"] gives access to every conta and an empty list to none.
+type UserContasRequest struct {
+	Contas []string `json:"contas" binding:"required"`
+}
+
 // FirstLoginRequest represents first login password change
 type FirstLoginRequest struct {
 	NewPassword string `json:"new_password" binding:"required,min=6"`
@@ -139,6 +161,59 @@ type UpdateDeParaRequest struct {
 	Company string `json:"company" binding:"required"`
 }
 
+// RoutingRule represents an empresa/fornecedor routing rule used by the
+// integration and stock search. rule_type is one of conta, filial, mpn or estoque.
+type RoutingRule struct {
+	ID            string    `json:"id" db:"id"`
+	RuleType      string    `json:"rule_type" db:"rule_type"`
+	MatchValue    string    `json:"match_value" db:"match_value"`
+	SchemaName    string    `json:"schema_name,omitempty" db:"schema_name"`
+	CodEmpresa    string    `json:"cod_empresa,omitempty" db:"cod_empresa"`
+	CodFornecedor string    `json:"cod_fornecedor,omitempty" db:"cod_fornecedor"`
+	ContaNBS      string    `json:"conta_nbs,omitempty" db:"conta_nbs"`
+	Filial        string    `json:"filial,omitempty" db:"filial"`
+	CNPJ          string    `json:"cnpj,omitempty" db:"cnpj"`
+	Priority      int       `json:"priority" db:"priority"`
+	Enabled       bool      `json:"enabled" db:"enabled"`
+	Description   string    `json:"description,omitempty" db:"description"`
+	UpdatedBy     string    `json:"updated_by,omitempty" db:"updated_by"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// RoutingRuleRequest represents request to create or update a routing rule
+type RoutingRuleRequest struct {
+	RuleType      string `json:"rule_type" binding:"required"`
+	MatchValue    string `json:"match_value" binding:"required"`
+	SchemaName    string `json:"schema_name"`
+	CodEmpresa    string `json:"cod_empresa"`
+	CodFornecedor string `json:"cod_fornecedor"`
+	ContaNBS      string `json:"conta_nbs"`
+	Filial        string `json:"filial"`
+	CNPJ          string `json:"cnpj"`
+	Priority      int    `json:"priority"`
+	Enabled       *bool  `json:"enabled"`
+	Description   string `json:"description"`
+}
+
+// Role represents a named set of permissions assigned to users
+type Role struct {
+	Name        string    `json:"name" db:"name"`
+	Description string    `json:"description,omitempty" db:"description"`
+	Permissions []string  `json:"permissions"`
+	UserCount   int       `json:"user_count"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// RoleRequest represents request to create or update a role. The name is
+// only read on creation.
+type RoleRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
 // CarPlateHistory represents a car plate consultation history entry
 type CarPlateHistory struct {
 	ID           string    `json:"id" db:"id"`
@@ -195,6 +270,35 @@ type StockItem struct {
 	EstoqueDisponivel int     `json:"estoque_disponivel" db:"estoque_disponivel"`
 }
 
+// StockItemWithoutCost is a StockItem without valor_reposicao and
+// custo_contabil, returned to users without the stock:view-cost permission
+type StockItemWithoutCost struct {
+	CodEmpresa        int     `json:"cod_empresa"`
+	NomeEmpresa       string  `json:"nome_empresa"`
+	CodFornecedor     string  `json:"cod_fornecedor"`
+	NomeFornecedor    string  `json:"nome_fornecedor"`
+	CodItem           string  `json:"cod_item"`
+	ValorVenda        float64 `json:"valor_venda"`
+	Estoque           int     `json:"estoque"`
+	Reservado         int     `json:"reservado"`
+	EstoqueDisponivel int     `json:"estoque_disponivel"`
+}
+
+// WithoutCost drops the cost columns of the item
+func (i StockItem) WithoutCost() StockItemWithoutCost {
+	return StockItemWithoutCost{
+		CodEmpresa:        i.CodEmpresa,
+		NomeEmpresa:       i.NomeEmpresa,
+		CodFornecedor:     i.CodFornecedor,
+		NomeFornecedor:    i.NomeFornecedor,
+		CodItem:           i.CodItem,
+		ValorVenda:        i.ValorVenda,
+		Estoque:           i.Estoque,
+		Reservado:         i.Reservado,
+		EstoqueDisponivel: i.EstoqueDisponivel,
+	}
+}
+
 // StockSearchRequest represents request to search stock
 type StockSearchRequest struct {
 	SKU string `json:"sku" binding:"required"`
@@ -206,14 +310,22 @@ type StockQueryRequest struct {
 	SKU   string `json:"sku" binding:"required"`
 }
 
-// IntegrationLog represents integration execution log
+// IntegrationLog represents a persisted integration job and the step it reached
 type IntegrationLog struct {
-	ID          string    `json:"id" db:"id"`
-	UserID      string    `json:"user_id" db:"user_id"`
-	ProcessType string    `json:"process_type" db:"process_type"`
-	Status      string    `json:"status" db:"status"`
-	Data        string    `json:"data" db:"data"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	ID           string     `json:"id" db:"id"`
+	UserID       string     `json:"user_id" db:"user_id"`
+	ProcessType  string     `json:"process_type" db:"process_type"`
+	Status       string     `json:"status" db:"status"`
+	Data         string     `json:"-" db:"data"`
+	Conta        string     `json:"conta" db:"conta"`
+	Marketplace  string     `json:"marketplace" db:"marketplace"`
+	NumPedido    string     `json:"num_pedido" db:"num_pedido"`
+	Step         string     `json:"step" db:"step"`
+	Attempts     int        `json:"attempts" db:"attempts"`
+	ErrorMessage string     `json:"error_message,omitempty" db:"error_message"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty" db:"finished_at"`
 }
 
 // ExecuteIntegrationRequest represents integration execution request
@@ -221,6 +333,7 @@ type ExecuteIntegrationRequest struct {
 	Conta       string `json:"conta" binding:"required"`
 	Marketplace string `json:"marketplace" binding:"required"`
 	NumPedido   string `json:"num_pedido" binding:"required"`
+	DryRun      bool   `json:"dry_run"`
 }
 
 // ImportLog represents XML import log
@@ -0,0 +1,241 @@
+// Package nbs implementa o cliente do gateway de e-commerce do NBS
+// (cadastro de clientes, endereços e pedidos).
+package nbs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config define o acesso ao gateway. O usuário de cada conta NBS é
+// UserPrefix + conta (ex.: HYSTALO17).
+type Config struct {
+	BaseURL      string        // ex.: http://10.13.1.19:8080/nbsapi-gateway
+	EcommerceAPI string        // caminho das rotas de e-commerce, ex.: nbs/ecommerce/hystalo/api
+	UserPrefix   string        // ex.: HYSTALO
+	Password     string        // senha comum a todos os usuários
+	Package      string        // pacote informado na geração do token, ex.: HYSTALO
+	TokenTTL     time.Duration // tempo de reutilização de um token
+}
+
+const operationToken = "obter token"
+
+type cachedToken struct {
+	value     string
+	expiresAt time.Time
+}
+
+// Doer executa requisições HTTP; atendido por *http.Client e *httpclient.Client
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Client acessa o gateway do NBS mantendo um token em cache por conta
+type Client struct {
+	cfg        Config
+	httpClient Doer
+
+	mu     sync.Mutex
+	tokens map[string]cachedToken
+}
+
+// NewClient cria o cliente; httpClient pode ser nil para usar um cliente com timeout de 30s
+func NewClient(cfg Config, httpClient Doer) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	if cfg.TokenTTL <= 0 {
+		cfg.TokenTTL = 30 * time.Minute
+	}
+	if cfg.Password == "" {
+		log.Printf("⚠️ NBS_PASSWORD não configurada: o gateway do NBS vai recusar os tokens")
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	cfg.EcommerceAPI = strings.Trim(cfg.EcommerceAPI, "/")
+
+	return &Client{
+		cfg:        cfg,
+		httpClient: httpClient,
+		tokens:     make(map[string]cachedToken),
+	}
+}
+
+// Token retorna o token da conta NBS, gerando um novo quando o cache expira
+func (c *Client) Token(ctx context.Context, contaNBS string) (string, error) {
+	usuario := c.usuario(contaNBS)
+
+	c.mu.Lock()
+	token, ok := c.tokens[usuario]
+	c.mu.Unlock()
+	if ok && time.Now().Before(token.expiresAt) {
+		return token.value, nil
+	}
+
+	params := url.Values{}
+	params.Set("usuario", usuario)
+	params.Set("senha", c.cfg.Password)
+	params.Set("idioma", "PT")
+	params.Set("pacote", c.cfg.Package)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.cfg.BaseURL+"/token?"+params.Encode(), nil)
+	if err != nil {
+		return "", fmt.Errorf("erro ao criar requisição: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("erro ao obter token NBS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var data tokenData
+	if err := c.decode(operationToken, resp, &data); err != nil {
+		return "", err
+	}
+	if data.Token == "" {
+		return "", newAPIError(operationToken, resp.StatusCode, "token não retornado", "")
+	}
+
+	c.mu.Lock()
+	c.tokens[usuario] = cachedToken{value: data.Token, expiresAt: time.Now().Add(c.cfg.TokenTTL)}
+	c.mu.Unlock()
+
+	return data.Token, nil
+}
+
+// RegisterClient cadastra (ou atualiza) um cliente na conta NBS
+func (c *Client) RegisterClient(ctx context.Context, contaNBS string, cliente Cliente) error {
+	log.Printf("👤 Cadastrando cliente %s no NBS (conta %s)...", cliente.CpfCnpj, contaNBS)
+	return c.post(ctx, contaNBS, "cadastrar cliente", "clientes", cliente, nil)
+}
+
+// RegisterAddress cadastra um endereço para um cliente já cadastrado
+func (c *Client) RegisterAddress(ctx context.Context, contaNBS string, endereco Endereco) error {
+	log.Printf("🏠 Cadastrando endereço do cliente %s no NBS (conta %s)...", endereco.CodigoCliente, contaNBS)
+	return c.post(ctx, contaNBS, "cadastrar endereço", "clientes/endereco", endereco, nil)
+}
+
+// SendOrder envia o pedido e retorna o número da pré-nota gerada
+func (c *Client) SendOrder(ctx context.Context, contaNBS string, pedido Pedido) (string, error) {
+	log.Printf("📦 Enviando pedido com %d item(s) para o NBS (conta %s)...", len(pedido.Itens), contaNBS)
+
+	var data pedidoData
+	if err := c.post(ctx, contaNBS, "enviar pedido", "pedidos", pedido, &data); err != nil {
+		return "", err
+	}
+
+	var numeroPedido string
+	switch v := data.CodigoPedido.(type) {
+	case nil:
+	case string:
+		numeroPedido = v
+	case float64:
+		numeroPedido = fmt.Sprintf("%.0f", v)
+	default:
+		numeroPedido = fmt.Sprintf("%v", v)
+	}
+
+	if numeroPedido == "" {
+		return "", newAPIError("enviar pedido", http.StatusOK, "número da pré-nota não retornado", "")
+	}
+
+	return numeroPedido, nil
+}
+
+// post envia payload para uma rota de e-commerce. Um token recusado é
+// descartado do cache e a requisição é repetida uma vez com um token novo.
+func (c *Client) post(ctx context.Context, contaNBS, operation, path string, payload, out interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("erro ao criar JSON para %s: %w", operation, err)
+	}
+
+	for attempt := 1; ; attempt++ {
+		token, err := c.Token(ctx, contaNBS)
+		if err != nil {
+			return err
+		}
+
+		err = c.doPost(ctx, operation, path, token, body, out)
+		if attempt == 1 && errors.Is(err, ErrAuth) {
+			log.Printf("🔑 Token NBS da conta %s recusado, gerando um novo", contaNBS)
+			c.invalidate(contaNBS)
+			continue
+		}
+		return err
+	}
+}
+
+func (c *Client) doPost(ctx context.Context, operation, path, token string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.cfg.BaseURL+"/"+c.cfg.EcommerceAPI+"/"+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("erro ao criar requisição: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("erro ao %s no NBS: %w", operation, err)
+	}
+	defer resp.Body.Close()
+
+	return c.decode(operation, resp, out)
+}
+
+// decode lê o envelope Response; respostas fora de 200 ou com sucesso=false
+// viram *APIError
+func (c *Client) decode(operation string, resp *http.Response, out interface{}) error {
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("erro ao ler resposta do NBS: %w", err)
+	}
+
+	// A resposta do token não é logada para não expor o token
+	if operation != operationToken {
+		log.Printf("🔍 Resposta do NBS ao %s (status %d): %s", operation, resp.StatusCode, string(raw))
+	}
+
+	var envelope Response
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return newAPIError(operation, resp.StatusCode, "", string(raw))
+		}
+		return fmt.Errorf("erro ao decodificar resposta do NBS: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK || (envelope.Sucesso != nil && !*envelope.Sucesso) {
+		return newAPIError(operation, resp.StatusCode, envelope.Mensagem, string(raw))
+	}
+
+	if out != nil && len(envelope.Data) > 0 {
+		if err := json.Unmarshal(envelope.Data, out); err != nil {
+			return fmt.Errorf("erro ao decodificar resposta do NBS: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) invalidate(contaNBS string) {
+	c.mu.Lock()
+	delete(c.tokens, c.usuario(contaNBS))
+	c.mu.Unlock()
+}
+
+// usuario monta o usuário do gateway da conta NBS; a conta de cada filial vem
+// das regras de roteamento
+func (c *Client) usuario(contaNBS string) string {
+	return c.cfg.UserPrefix + contaNBS
+}
@@ -0,0 +1,230 @@
+package nbs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeNBS simula o gateway: /token emite tokens numerados e as rotas de
+// e-commerce respondem com handler
+type fakeNBS struct {
+	*httptest.Server
+	tokens  atomic.Int32
+	handler func(w http.ResponseWriter, r *http.Request)
+}
+
+func newFakeNBS(t *testing.T, handler func(w http.ResponseWriter, r *http.Request)) (*fakeNBS, *Client) {
+	t.Helper()
+
+	f := &fakeNBS{handler: handler}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if r.URL.Query().Get("usuario") != "HYSTALO17" || r.URL.Query().Get("senha") != "secreta" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"sucesso": false, "mensagem": "Usuário inválido"}`))
+				return
+			}
+			n := f.tokens.Add(1)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"sucesso": true,
+				"data":    map[string]string{"token": "token-" + strconv.Itoa(int(n))},
+			})
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, "/ecommerce/api/") {
+			t.Errorf("rota inesperada %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		f.handler(w, r)
+	}))
+	t.Cleanup(f.Close)
+
+	client := NewClient(Config{
+		BaseURL:      f.URL + "/",
+		EcommerceAPI: "/ecommerce/api/",
+		UserPrefix:   "HYSTALO",
+		Password:     "secreta",
+		Package:      "HYSTALO",
+	}, f.Client())
+	return f, client
+}
+
+func respond(status int, body string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+func TestSendOrder(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tt := range []struct {
+		body string
+		want string
+	}{
+		{`{"sucesso": true, "data": {"codigoPedido": 123456}}`, "123456"},
+		{`{"sucesso": true, "data": {"codigoPedido": "PN-77"}}`, "PN-77"},
+	} {
+		_, client := newFakeNBS(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/ecommerce/api/pedidos" || r.Header.Get("Authorization") != "Bearer token-1" {
+				t.Errorf("requisição %s com Authorization %q", r.URL.Path, r.Header.Get("Authorization"))
+			}
+			var pedido Pedido
+			if err := json.NewDecoder(r.Body).Decode(&pedido); err != nil || pedido.CodCliente != "52998224725" || len(pedido.Itens) != 1 {
+				t.Errorf("pedido recebido = %+v, %v", pedido, err)
+			}
+			w.Write([]byte(tt.body))
+		})
+
+		got, err := client.SendOrder(ctx, "17", Pedido{CodCliente: "52998224725", Itens: []PedidoItem{{CodItem: "ABC", Qtde: 1}}})
+		if err != nil || got != tt.want {
+			t.Errorf("SendOrder = (%q, %v), want %q", got, err, tt.want)
+		}
+	}
+}
+
+func TestSendOrderWithoutPreNota(t *testing.T) {
+	_, client := newFakeNBS(t, respond(http.StatusOK, `{"sucesso": true, "data": {}}`))
+
+	var apiErr *APIError
+	if _, err := client.SendOrder(context.Background(), "17", Pedido{}); !errors.As(err, &apiErr) {
+		t.Fatalf("SendOrder sem codigoPedido = %v, want *APIError", err)
+	}
+}
+
+func TestTokenIsCached(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeNBS(t, respond(http.StatusOK, `{"sucesso": true}`))
+
+	for i := 0; i < 3; i++ {
+		if err := client.RegisterClient(ctx, "17", Cliente{CpfCnpj: "52998224725"}); err != nil {
+			t.Fatalf("RegisterClient: %v", err)
+		}
+	}
+	if n := f.tokens.Load(); n != 1 {
+		t.Errorf("%d tokens gerados, want 1", n)
+	}
+}
+
+func TestRejectedTokenIsRenewedOnce(t *testing.T) {
+	ctx := context.Background()
+
+	var calls atomic.Int32
+	f, client := newFakeNBS(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"sucesso": false, "mensagem": "Token expirado"}`))
+			return
+		}
+		w.Write([]byte(`{"sucesso": true}`))
+	})
+
+	if err := client.RegisterAddress(ctx, "17", Endereco{CodigoCliente: "52998224725"}); err != nil {
+		t.Fatalf("RegisterAddress: %v", err)
+	}
+	if calls.Load() != 2 || f.tokens.Load() != 2 {
+		t.Errorf("%d chamadas e %d tokens, want 2 e 2", calls.Load(), f.tokens.Load())
+	}
+
+	// Um token novo também recusado não gera uma terceira tentativa
+	f.handler = respond(http.StatusUnauthorized, `{"sucesso": false, "mensagem": "Token inválido"}`)
+	calls.Store(0)
+	if err := client.RegisterAddress(ctx, "17", Endereco{}); !errors.Is(err, ErrAuth) {
+		t.Errorf("RegisterAddress = %v, want ErrAuth", err)
+	}
+}
+
+func TestBusinessErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   error
+	}{
+		{"cliente duplicado", http.StatusOK, `{"sucesso": false, "mensagem": "Cliente já cadastrado"}`, ErrDuplicateClient},
+		{"campo obrigatório", http.StatusOK, `{"sucesso": false, "mensagem": "Campo CEP obrigatório"}`, ErrValidation},
+		{"bad request", http.StatusBadRequest, `{"mensagem": "JSON inválido"}`, ErrValidation},
+		{"forbidden", http.StatusForbidden, `{"mensagem": "Acesso negado"}`, ErrAuth},
+		{"erro interno", http.StatusInternalServerError, `Internal Server Error`, nil},
+	}
+
+	for _, tt := range tests {
+		_, client := newFakeNBS(t, respond(tt.status, tt.body))
+
+		err := client.RegisterClient(context.Background(), "17", Cliente{})
+
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) {
+			t.Errorf("%s: RegisterClient = %v, want *APIError", tt.name, err)
+			continue
+		}
+		if apiErr.StatusCode != tt.status || apiErr.Operation != "cadastrar cliente" {
+			t.Errorf("%s: APIError = %+v", tt.name, apiErr)
+		}
+		if got := apiErr.Unwrap(); got != tt.want {
+			t.Errorf("%s: categoria = %v, want %v", tt.name, got, tt.want)
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: errors.Is(err, %v) = false", tt.name, tt.want)
+		}
+	}
+}
+
+func TestNewAPIErrorClassification(t *testing.T) {
+	tests := []struct {
+		status  int
+		message string
+		body    string
+		want    error
+	}{
+		{http.StatusUnauthorized, "", "", ErrAuth},
+		{http.StatusOK, "Senha inválida", "", ErrAuth},
+		{http.StatusOK, "", `{"mensagem": "usuario invalido"}`, ErrAuth},
+		{http.StatusOK, "Registro já existe", "", ErrDuplicateClient},
+		{http.StatusConflict, "", "chave duplicada", ErrDuplicateClient},
+		{http.StatusUnprocessableEntity, "", "", ErrValidation},
+		{http.StatusOK, "Nome muito longo", "", ErrValidation},
+		{http.StatusBadGateway, "", "", nil},
+	}
+
+	for _, tt := range tests {
+		if got := newAPIError("enviar pedido", tt.status, tt.message, tt.body).Unwrap(); got != tt.want {
+			t.Errorf("newAPIError(%d, %q, %q) = %v, want %v", tt.status, tt.message, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestTransportErrors(t *testing.T) {
+	ctx := context.Background()
+
+	// Gateway fora do ar
+	f, client := newFakeNBS(t, respond(http.StatusOK, `{"sucesso": true}`))
+	f.Close()
+	var apiErr *APIError
+	if _, err := client.Token(ctx, "17"); err == nil || errors.As(err, &apiErr) {
+		t.Errorf("Token com o gateway fora do ar = %v, want erro de transporte", err)
+	}
+
+	// Resposta 200 que não é JSON
+	_, client = newFakeNBS(t, respond(http.StatusOK, `<html>proxy</html>`))
+	if err := client.RegisterClient(ctx, "17", Cliente{}); err == nil || errors.As(err, &apiErr) {
+		t.Errorf("RegisterClient com resposta inválida = %v, want erro de decodificação", err)
+	}
+
+	// Contexto cancelado
+	_, client = newFakeNBS(t, respond(http.StatusOK, `{"sucesso": true}`))
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := client.SendOrder(canceled, "17", Pedido{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("SendOrder com contexto cancelado = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,66 @@
+package nbs
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Categorias de erro retornadas pelo gateway do NBS. Use errors.Is para testar.
+var (
+	// ErrAuth indica usuário/senha inválidos ou token recusado
+	ErrAuth = errors.New("nbs: falha de autenticação")
+	// ErrValidation indica dados recusados pelo NBS (campos obrigatórios, formato, etc.)
+	ErrValidation = errors.New("nbs: dados inválidos")
+	// ErrDuplicateClient indica um cliente que já está cadastrado
+	ErrDuplicateClient = errors.New("nbs: cliente já cadastrado")
+)
+
+// APIError representa uma resposta de erro do gateway do NBS
+type APIError struct {
+	Operation  string
+	StatusCode int
+	Message    string
+	Body       string
+	kind       error
+}
+
+func (e *APIError) Error() string {
+	message := e.Message
+	if message == "" {
+		message = e.Body
+	}
+	return fmt.Sprintf("erro na API do NBS ao %s (status %d): %s", e.Operation, e.StatusCode, message)
+}
+
+// Unwrap permite errors.Is(err, nbs.ErrAuth) e afins
+func (e *APIError) Unwrap() error {
+	return e.kind
+}
+
+// newAPIError classifica a resposta pelo status HTTP e pela mensagem do NBS
+func newAPIError(operation string, statusCode int, message, body string) *APIError {
+	err := &APIError{
+		Operation:  operation,
+		StatusCode: statusCode,
+		Message:    message,
+		Body:       body,
+	}
+
+	text := strings.ToLower(message + " " + body)
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden ||
+		strings.Contains(text, "token inv") || strings.Contains(text, "token expirado") ||
+		strings.Contains(text, "senha inv") || strings.Contains(text, "usuário inv") || strings.Contains(text, "usuario inv"):
+		err.kind = ErrAuth
+	case strings.Contains(text, "já cadastrado") || strings.Contains(text, "ja cadastrado") ||
+		strings.Contains(text, "já existe") || strings.Contains(text, "ja existe") || strings.Contains(text, "duplicad"):
+		err.kind = ErrDuplicateClient
+	case statusCode == http.StatusBadRequest || statusCode == http.StatusUnprocessableEntity ||
+		(statusCode == http.StatusOK && message != ""):
+		err.kind = ErrValidation
+	}
+
+	return err
+}
@@ -0,0 +1,105 @@
+package nbs
+
+import "encoding/json"
+
+// Cliente representa o cadastro de cliente do e-commerce NBS
+type Cliente struct {
+	CodigoCliente       string `json:"codigoCliente"`
+	CodigoTipoCliente   int    `json:"codigoTipoCliente"`
+	CodigoRamo          string `json:"codigoRamo"`
+	CodigoClasse        int    `json:"codigoClasse"`
+	CodigoClasseTipo    string `json:"codigoClasseTipo"`
+	CodigoEstadoCivil   string `json:"codigoEstadoCivil"`
+	PrefixoCelular      string `json:"prefixoCelular"`
+	TelefoneCelular     string `json:"telefoneCelular"`
+	PrefixoComercial    string `json:"prefixoComercial"`
+	TelefoneComercial   string `json:"telefoneComercial"`
+	PrefixoResidencial  string `json:"prefixoResidencial"`
+	TelefoneResidencial string `json:"telefoneResidencial"`
+	CodigoNacionalidade string `json:"codigoNacionalidade"`
+	CodigoProfissao     string `json:"codigoProfissao"`
+	PaiCliente          string `json:"paiCliente"`
+	MaeCliente          string `json:"maeCliente"`
+	EmailCliente        string `json:"emailCliente"`
+	Tipo                string `json:"tipo"`
+	Nome                string `json:"nome"`
+	Sexo                string `json:"sexo"`
+	Nascimento          string `json:"nascimento"`
+	CpfCnpj             string `json:"cpfCnpj"`
+	RgIe                string `json:"rgIe"`
+	Ssp                 string `json:"ssp"`
+	AtualizaExistente   bool   `json:"atualizaExistente"`
+	ClienteRevendedor   bool   `json:"clienteRevendedor"`
+
+	// Endereço resumido enviado junto com o cliente
+	Rua    string `json:"rua,omitempty"`
+	Bairro string `json:"bairro,omitempty"`
+	UF     string `json:"uf,omitempty"`
+	CEP    string `json:"cep,omitempty"`
+}
+
+// Endereco representa um endereço de cliente
+type Endereco struct {
+	CodigoCliente          string `json:"codigoCliente"`
+	ClienteTipoEndereco    int    `json:"clienteTipoEndereco"`
+	CodCidades             string `json:"codCidades"`
+	CEP                    string `json:"CEP"`
+	Rua                    string `json:"rua"`
+	Complemento            string `json:"complemento"`
+	Bairro                 string `json:"bairro"`
+	UF                     string `json:"uf"`
+	NumeroEndereco         string `json:"numeroEndereco"`
+	NomePropriedade        string `json:"nomePropriedade"`
+	InscricaoEstadual      string `json:"inscricaoEstadual"`
+	Fachada                string `json:"fachada"`
+	Contato                string `json:"contato"`
+	TelefoneContato        string `json:"telefoneContato"`
+	PrefixoTelefoneContato string `json:"prefixoTelefoneContato"`
+}
+
+// Pedido representa um pedido (pré-nota) do e-commerce NBS
+type Pedido struct {
+	CodPedidoWeb      int64        `json:"COD_PEDIDO_WEB"`
+	CodCliente        string       `json:"COD_CLIENTE"`
+	TipoEndereco      int          `json:"TIPO_ENDERECO"`
+	CodTransportadora int          `json:"COD_TRANSPORTADORA"`
+	ValorFreteTotal   float64      `json:"VALOR_FRETE_TOTAL"`
+	CnpjIntermed      string       `json:"CNPJ_INTERMED"`
+	IdentCadIntermed  string       `json:"IDENT_CAD_INTERMED"`
+	Nome              string       `json:"NOME"`
+	Itens             []PedidoItem `json:"Itens"`
+	Pagamentos        []Pagamento  `json:"Pagamentos"`
+}
+
+// PedidoItem representa um item do pedido
+type PedidoItem struct {
+	CodItem       string  `json:"COD_ITEM"`
+	CodFornecedor string  `json:"COD_FORNECEDOR"`
+	PrecoUnitario float64 `json:"PRECO_UNITARIO"`
+	Qtde          int     `json:"QTDE"`
+}
+
+// Pagamento representa um pagamento do pedido
+type Pagamento struct {
+	CodigoBandeira     string `json:"codigoBandeira"`
+	TipoCartao         string `json:"tipoCartao"`
+	DataPagamento      string `json:"dataPagamento"`
+	NumeroCartao       string `json:"numeroCartao"`
+	NumeroAutorizacao  string `json:"numeroAutorizacao"`
+	QuantidadeParcelas int    `json:"quantidadeParcelas"`
+}
+
+// Response é o envelope retornado por todas as rotas do gateway
+type Response struct {
+	Sucesso  *bool           `json:"sucesso"`
+	Mensagem string          `json:"mensagem"`
+	Data     json.RawMessage `json:"data"`
+}
+
+type tokenData struct {
+	Token string `json:"token"`
+}
+
+type pedidoData struct {
+	CodigoPedido interface{} `json:"codigoPedido"` // Pode ser string ou número
+}
@@ -0,0 +1,476 @@
+package notify
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Tipos de canal
+const (
+	ChannelTelegram = "telegram"
+	ChannelEmail    = "email"
+	ChannelWebhook  = "webhook"
+	ChannelSlack    = "slack"
+)
+
+var (
+	ErrChannelNotFound = errors.New("canal de notificação não encontrado")
+	ErrRouteNotFound   = errors.New("rota de notificação não encontrada")
+	ErrInvalidRequest  = errors.New("requisição de notificação inválida")
+)
+
+// maskedSetting substitui os segredos nas respostas da API; enviado de volta
+// numa atualização, mantém o valor gravado
+const maskedSetting = "********"
+
+// encryptedPrefix marca as configurações gravadas criptografadas
+const encryptedPrefix = "enc:"
+
+// channelSettings lista, por tipo de canal, as configurações obrigatórias e
+// as que são segredos
+var channelSettings = map[string]struct {
+	required []string
+	secrets  []string
+}{
+	ChannelTelegram: {required: []string{"bot_token", "chat_id"}, secrets: []string{"bot_token"}},
+	ChannelEmail:    {required: []string{"host", "from", "to"}, secrets: []string{"password"}},
+	ChannelWebhook:  {required: []string{"url"}, secrets: []string{"authorization"}},
+	ChannelSlack:    {required: []string{"webhook_url"}, secrets: []string{"webhook_url"}},
+}
+
+// Channel é um destino de notificações. Os segredos de Settings (token do
+// bot, senha SMTP, URL do webhook do Slack) são mascarados nas respostas.
+type Channel struct {
+	ID        int               `json:"id"`
+	Name      string            `json:"name"`
+	Type      string            `json:"type"`
+	Enabled   bool              `json:"enabled"`
+	Settings  map[string]string `json:"settings"`
+	CreatedBy string            `json:"created_by,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// ChannelRequest cria ou atualiza um canal. Configurações por tipo:
+//   - telegram: bot_token, chat_id
+//   - email: host, port (587), username, password, from, to (separados por vírgula)
+//   - webhook: url, authorization (header Authorization, opcional)
+//   - slack: webhook_url
+type ChannelRequest struct {
+	Name     string            `json:"name" binding:"required"`
+	Type     string            `json:"type" binding:"required"`
+	Enabled  *bool             `json:"enabled"`
+	Settings map[string]string `json:"settings"`
+}
+
+// Route envia a um canal os eventos de uma conta (todas quando vazia) a
+// partir de uma severidade
+type Route struct {
+	ID          int       `json:"id"`
+	ChannelID   int       `json:"channel_id"`
+	ChannelName string    `json:"channel_name"`
+	Conta       string    `json:"conta,omitempty"`
+	MinSeverity Severity  `json:"min_severity"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// RouteRequest cria uma rota
+type RouteRequest struct {
+	ChannelID   int      `json:"channel_id" binding:"required"`
+	Conta       string   `json:"conta"`
+	MinSeverity Severity `json:"min_severity"`
+}
+
+// ListChannels retorna os canais cadastrados, com os segredos mascarados
+func (s *Service) ListChannels(ctx context.Context) ([]Channel, error) {
+	channels, err := s.queryChannels(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for i := range channels {
+		channels[i].mask()
+	}
+	return channels, nil
+}
+
+// GetChannel retorna o canal com os segredos mascarados
+func (s *Service) GetChannel(ctx context.Context, id int) (*Channel, error) {
+	channel, err := s.channel(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	channel.mask()
+	return channel, nil
+}
+
+// CreateChannel cadastra um canal
+func (s *Service) CreateChannel(ctx context.Context, req ChannelRequest, createdBy string) (*Channel, error) {
+	channel := Channel{Name: strings.TrimSpace(req.Name), Type: req.Type, Enabled: true, Settings: req.Settings}
+	if req.Enabled != nil {
+		channel.Enabled = *req.Enabled
+	}
+	if err := channel.validate(); err != nil {
+		return nil, err
+	}
+
+	settings, err := s.encodeSettings(channel.Settings)
+	if err != nil {
+		return nil, err
+	}
+
+	var id int
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO notification_channels (name, type, settings, enabled, created_by)
+		OUTPUT INSERTED.id
+		VALUES (@p1, @p2, @p3, @p4, @p5)`,
+		channel.Name, channel.Type, settings, channel.Enabled, nullString(createdBy)).Scan(&id)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao cadastrar canal de notificação: %w", err)
+	}
+
+	log.Printf("🔔 Canal de notificação %d (%s) cadastrado por %s", id, channel.Type, createdBy)
+	return s.GetChannel(ctx, id)
+}
+
+// UpdateChannel atualiza um canal. Segredos omitidos, vazios ou mascarados
+// mantêm o valor gravado.
+func (s *Service) UpdateChannel(ctx context.Context, id int, req ChannelRequest) (*Channel, error) {
+	current, err := s.channel(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	channel := Channel{ID: id, Name: strings.TrimSpace(req.Name), Type: req.Type, Enabled: current.Enabled, Settings: req.Settings}
+	if req.Enabled != nil {
+		channel.Enabled = *req.Enabled
+	}
+	if channel.Settings == nil {
+		channel.Settings = map[string]string{}
+	}
+	if channel.Type == current.Type {
+		for _, key := range channelSettings[channel.Type].secrets {
+			if value := channel.Settings[key]; value == "" || value == maskedSetting {
+				channel.Settings[key] = current.Settings[key]
+			}
+		}
+	}
+	if err := channel.validate(); err != nil {
+		return nil, err
+	}
+
+	settings, err := s.encodeSettings(channel.Settings)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE notification_channels
+		SET name = @p1, type = @p2, settings = @p3, enabled = @p4, updated_at = GETDATE()
+		WHERE id = @p5`,
+		channel.Name, channel.Type, settings, channel.Enabled, id)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao atualizar canal de notificação: %w", err)
+	}
+
+	return s.GetChannel(ctx, id)
+}
+
+// DeleteChannel remove o canal, suas rotas e sua fila
+func (s *Service) DeleteChannel(ctx context.Context, id int) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM notification_channels WHERE id = @p1`, id)
+	if err != nil {
+		return fmt.Errorf("erro ao remover canal de notificação: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrChannelNotFound
+	}
+	return nil
+}
+
+// TestChannel envia uma notificação de teste diretamente ao canal, sem passar
+// pela fila, e retorna o erro do envio
+func (s *Service) TestChannel(ctx context.Context, id int, user string) error {
+	channel, err := s.channel(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	msg, err := s.render(ctx, EventTest, map[string]interface{}{
+		"canal":   channel.Name,
+		"usuario": user,
+		"data":    time.Now().Format("02/01/2006 15:04:05"),
+	})
+	if err != nil {
+		return err
+	}
+
+	payload, err := eventPayload(Event{Kind: EventTest, Severity: SeverityInfo}, msg)
+	if err != nil {
+		return err
+	}
+	return s.send(ctx, *channel, msg, payload)
+}
+
+// ListRoutes retorna as rotas cadastradas
+func (s *Service) ListRoutes(ctx context.Context) ([]Route, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT r.id, r.channel_id, c.name, COALESCE(r.conta, ''), r.min_severity, r.created_at
+		FROM notification_routes r
+		JOIN notification_channels c ON c.id = r.channel_id
+		ORDER BY r.channel_id, r.conta`)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar rotas de notificação: %w", err)
+	}
+	defer rows.Close()
+
+	routes := []Route{}
+	for rows.Next() {
+		var route Route
+		var minSeverity string
+		if err := rows.Scan(&route.ID, &route.ChannelID, &route.ChannelName, &route.Conta, &minSeverity, &route.CreatedAt); err != nil {
+			return nil, fmt.Errorf("erro ao ler rota de notificação: %w", err)
+		}
+		route.MinSeverity = Severity(minSeverity)
+		routes = append(routes, route)
+	}
+	return routes, rows.Err()
+}
+
+// CreateRoute cadastra uma rota; sem severidade mínima, o canal recebe tudo
+func (s *Service) CreateRoute(ctx context.Context, req RouteRequest) (*Route, error) {
+	if req.MinSeverity == "" {
+		req.MinSeverity = SeverityInfo
+	}
+	if !req.MinSeverity.Valid() {
+		return nil, fmt.Errorf("%w: min_severity deve ser info, warning ou error", ErrInvalidRequest)
+	}
+	if _, err := s.channel(ctx, req.ChannelID); err != nil {
+		return nil, err
+	}
+
+	route := Route{ChannelID: req.ChannelID, Conta: strings.ToLower(strings.TrimSpace(req.Conta)), MinSeverity: req.MinSeverity}
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO notification_routes (channel_id, conta, min_severity)
+		OUTPUT INSERTED.id, INSERTED.created_at
+		VALUES (@p1, @p2, @p3)`,
+		route.ChannelID, nullString(route.Conta), string(route.MinSeverity)).Scan(&route.ID, &route.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao cadastrar rota de notificação: %w", err)
+	}
+	return &route, nil
+}
+
+// DeleteRoute remove uma rota
+func (s *Service) DeleteRoute(ctx context.Context, id int) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM notification_routes WHERE id = @p1`, id)
+	if err != nil {
+		return fmt.Errorf("erro ao remover rota de notificação: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrRouteNotFound
+	}
+	return nil
+}
+
+// seedTelegramChannel cadastra o bot do Telegram configurado no ambiente,
+// com uma rota para todas as contas, quando ainda não há nenhum canal
+func (s *Service) seedTelegramChannel(ctx context.Context) {
+	if s.config.NotifyTelegramToken == "" || s.config.NotifyTelegramChatID == "" {
+		return
+	}
+
+	var count int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM notification_channels`).Scan(&count); err != nil {
+		log.Printf("⚠️ Erro ao verificar canais de notificação: %v", err)
+		return
+	}
+	if count > 0 {
+		return
+	}
+
+	channel, err := s.CreateChannel(ctx, ChannelRequest{
+		Name: "Telegram",
+		Type: ChannelTelegram,
+		Settings: map[string]string{
+			"bot_token": s.config.NotifyTelegramToken,
+			"chat_id":   s.config.NotifyTelegramChatID,
+		},
+	}, "NOTIFY_TELEGRAM_BOT_TOKEN")
+	if err != nil {
+		log.Printf("⚠️ Erro ao cadastrar canal do Telegram: %v", err)
+		return
+	}
+
+	if _, err := s.CreateRoute(ctx, RouteRequest{ChannelID: channel.ID, MinSeverity: SeverityInfo}); err != nil {
+		log.Printf("⚠️ Erro ao cadastrar rota do canal do Telegram: %v", err)
+	}
+}
+
+// channel retorna o canal com os segredos
+func (s *Service) channel(ctx context.Context, id int) (*Channel, error) {
+	channels, err := s.queryChannels(ctx, "WHERE id = @p1", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(channels) == 0 {
+		return nil, ErrChannelNotFound
+	}
+	return &channels[0], nil
+}
+
+func (s *Service) queryChannels(ctx context.Context, where string, args ...interface{}) ([]Channel, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, name, type, settings, enabled, COALESCE(created_by, ''), created_at, updated_at
+		FROM notification_channels `+where+`
+		ORDER BY id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar canais de notificação: %w", err)
+	}
+	defer rows.Close()
+
+	channels := []Channel{}
+	for rows.Next() {
+		var channel Channel
+		var settings string
+		if err := rows.Scan(&channel.ID, &channel.Name, &channel.Type, &settings, &channel.Enabled,
+			&channel.CreatedBy, &channel.CreatedAt, &channel.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("erro ao ler canal de notificação: %w", err)
+		}
+
+		channel.Settings, err = s.decodeSettings(settings)
+		if err != nil {
+			return nil, fmt.Errorf("canal de notificação %d: %w", channel.ID, err)
+		}
+		channels = append(channels, channel)
+	}
+	return channels, rows.Err()
+}
+
+func (c *Channel) validate() error {
+	spec, ok := channelSettings[c.Type]
+	if !ok {
+		return fmt.Errorf("%w: tipo de canal deve ser telegram, email, webhook ou slack", ErrInvalidRequest)
+	}
+	if c.Name == "" {
+		return fmt.Errorf("%w: nome do canal é obrigatório", ErrInvalidRequest)
+	}
+
+	var missing []string
+	for _, key := range spec.required {
+		if strings.TrimSpace(c.Settings[key]) == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: configurações obrigatórias para %s: %s", ErrInvalidRequest, c.Type, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (c *Channel) mask() {
+	masked := make(map[string]string, len(c.Settings))
+	for key, value := range c.Settings {
+		masked[key] = value
+	}
+	for _, key := range channelSettings[c.Type].secrets {
+		if masked[key] != "" {
+			masked[key] = maskedSetting
+		}
+	}
+	c.Settings = masked
+}
+
+// encodeSettings serializa as configurações do canal, criptografadas quando
+// há chave configurada
+func (s *Service) encodeSettings(settings map[string]string) (string, error) {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return "", fmt.Errorf("erro ao serializar configurações do canal: %w", err)
+	}
+	if s.aead == nil {
+		return string(data), nil
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("erro ao gerar nonce: %w", err)
+	}
+	sealed := s.aead.Seal(nonce, nonce, data, nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *Service) decodeSettings(encoded string) (map[string]string, error) {
+	data := []byte(encoded)
+
+	if strings.HasPrefix(encoded, encryptedPrefix) {
+		if s.aead == nil {
+			return nil, fmt.Errorf("configurações criptografadas e CREDENTIALS_ENCRYPTION_KEY não configurada")
+		}
+		sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(encoded, encryptedPrefix))
+		if err != nil || len(sealed) < s.aead.NonceSize() {
+			return nil, fmt.Errorf("configurações criptografadas inválidas")
+		}
+		nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+		data, err = s.aead.Open(nil, nonce, ciphertext, nil)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao descriptografar configurações (CREDENTIALS_ENCRYPTION_KEY alterada?)")
+		}
+	}
+
+	settings := map[string]string{}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("configurações inválidas: %w", err)
+	}
+	return settings, nil
+}
+
+// newAEAD deriva a chave AES-256 da chave configurada, como as credenciais do
+// Mercado Livre
+func newAEAD(key string) (cipher.AEAD, error) {
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// activeRoutes retorna as rotas ativas agrupadas por canal
+func (s *Service) activeRoutes(ctx context.Context) (map[int][]Route, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT r.channel_id, COALESCE(r.conta, ''), r.min_severity
+		FROM notification_routes r
+		JOIN notification_channels c ON c.id = r.channel_id
+		WHERE c.enabled = 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	routes := make(map[int][]Route)
+	for rows.Next() {
+		var route Route
+		var minSeverity string
+		if err := rows.Scan(&route.ChannelID, &route.Conta, &minSeverity); err != nil {
+			return nil, err
+		}
+		route.MinSeverity = Severity(minSeverity)
+		routes[route.ChannelID] = append(routes[route.ChannelID], route)
+	}
+	return routes, rows.Err()
+}
+
+// matches informa se a rota recebe eventos da conta e severidade
+func (r Route) matches(conta string, severity Severity) bool {
+	return (r.Conta == "" || r.Conta == conta) && severity.rank() >= r.MinSeverity.rank()
+}
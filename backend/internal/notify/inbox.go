@@ -0,0 +1,287 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Erros da caixa de entrada
+var (
+	ErrNotificationNotFound = errors.New("notificação não encontrada")
+	ErrSubscriptionNotFound = errors.New("inscrição não encontrada")
+)
+
+// inboxEvents são os tipos de evento entregues na caixa de entrada dos
+// usuários inscritos
+var inboxEvents = map[string]bool{
+	EventIntegrationFailed: true,
+	EventXMLFailed:         true,
+	EventXMLInvoiceError:   true,
+	EventXMLDeferredFailed: true,
+	EventDeParaRollback:    true,
+}
+
+// InboxEvents retorna, em ordem, os tipos de evento que podem ser assinados
+func InboxEvents() []string {
+	return []string{EventIntegrationFailed, EventXMLFailed, EventXMLInvoiceError, EventXMLDeferredFailed, EventDeParaRollback}
+}
+
+// Notification é uma entrada da caixa de entrada de um usuário
+type Notification struct {
+	ID        int64           `json:"id"`
+	Kind      string          `json:"kind"`
+	Severity  Severity        `json:"severity"`
+	Conta     string          `json:"conta,omitempty"`
+	Title     string          `json:"title"`
+	Message   string          `json:"message"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Read      bool            `json:"read"`
+	ReadAt    *time.Time      `json:"read_at,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// InboxFilter filtra e pagina a caixa de entrada
+type InboxFilter struct {
+	UnreadOnly bool
+	Page       int
+	PageSize   int
+}
+
+// Subscription inscreve o usuário nos eventos de uma conta e de um tipo
+// (todos quando vazios) a partir de uma severidade
+type Subscription struct {
+	ID          int       `json:"id"`
+	Conta       string    `json:"conta,omitempty"`
+	Kind        string    `json:"kind,omitempty"`
+	MinSeverity Severity  `json:"min_severity"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// SubscriptionRequest cria uma inscrição
+type SubscriptionRequest struct {
+	Conta       string   `json:"conta"`
+	Kind        string   `json:"kind"`
+	MinSeverity Severity `json:"min_severity"`
+}
+
+// deliverInbox grava o evento na caixa de entrada de cada usuário inscrito e
+// o envia às conexões WebSocket do usuário
+func (s *Service) deliverInbox(ctx context.Context, event Event, msg message) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT CAST(user_id AS NVARCHAR(36)), min_severity
+		FROM notification_subscriptions
+		WHERE (conta IS NULL OR conta = @p1) AND (kind IS NULL OR kind = @p2)`,
+		event.Conta, event.Kind)
+	if err != nil {
+		log.Printf("⚠️ Erro ao buscar inscrições da notificação %s: %v", event.Kind, err)
+		return
+	}
+
+	var users []string
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var userID, minSeverity string
+		if err := rows.Scan(&userID, &minSeverity); err != nil {
+			log.Printf("⚠️ Erro ao ler inscrição de notificação: %v", err)
+			continue
+		}
+		if seen[userID] || event.Severity.rank() < Severity(minSeverity).rank() {
+			continue
+		}
+		seen[userID] = true
+		users = append(users, userID)
+	}
+	rows.Close()
+
+	if len(users) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		log.Printf("⚠️ Erro ao serializar dados da notificação %s: %v", event.Kind, err)
+		data = nil
+	}
+
+	for _, userID := range users {
+		notification := Notification{
+			Kind:     event.Kind,
+			Severity: event.Severity,
+			Conta:    event.Conta,
+			Title:    truncate(msg.Subject, 500),
+			Message:  msg.Body,
+			Data:     data,
+		}
+		err := s.db.QueryRowContext(ctx, `
+			INSERT INTO notifications (user_id, kind, severity, conta, title, message, data)
+			OUTPUT INSERTED.id, INSERTED.created_at
+			VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7)`,
+			userID, notification.Kind, string(notification.Severity), nullString(notification.Conta),
+			notification.Title, notification.Message, nullString(string(data))).
+			Scan(&notification.ID, &notification.CreatedAt)
+		if err != nil {
+			log.Printf("⚠️ Erro ao gravar notificação %s do usuário %s: %v", event.Kind, userID, err)
+			continue
+		}
+
+		if s.hub != nil {
+			push := map[string]interface{}{"type": "notification", "notification": notification}
+			if err := s.hub.SendToUser(userID, push); err != nil {
+				log.Printf("⚠️ Erro ao enviar notificação %d pelo WebSocket: %v", notification.ID, err)
+			}
+		}
+	}
+}
+
+// ListInbox retorna a caixa de entrada do usuário, das mais recentes às mais
+// antigas, com o total filtrado e o número de não lidas
+func (s *Service) ListInbox(ctx context.Context, userID string, filter InboxFilter) ([]Notification, int, int, error) {
+	where := "WHERE user_id = TRY_CONVERT(UNIQUEIDENTIFIER, @p1)"
+	if filter.UnreadOnly {
+		where += " AND read_at IS NULL"
+	}
+
+	var total, unread int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(CASE WHEN read_at IS NULL THEN 1 ELSE 0 END), 0)
+		FROM notifications `+where, userID).Scan(&total, &unread)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("erro ao contar notificações: %w", err)
+	}
+	if filter.UnreadOnly {
+		unread = total
+	}
+
+	page := filter.Page
+	if page < 1 {
+		page = 1
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, kind, severity, COALESCE(conta, ''), title, message, COALESCE(data, ''), read_at, created_at
+		FROM notifications `+where+`
+		ORDER BY created_at DESC, id DESC
+		OFFSET @p2 ROWS FETCH NEXT @p3 ROWS ONLY`,
+		userID, (page-1)*filter.PageSize, filter.PageSize)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("erro ao buscar notificações: %w", err)
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var n Notification
+		var severity, data string
+		var readAt *time.Time
+		if err := rows.Scan(&n.ID, &n.Kind, &severity, &n.Conta, &n.Title, &n.Message, &data, &readAt, &n.CreatedAt); err != nil {
+			return nil, 0, 0, fmt.Errorf("erro ao ler notificação: %w", err)
+		}
+		n.Severity = Severity(severity)
+		if data != "" {
+			n.Data = json.RawMessage(data)
+		}
+		n.ReadAt = readAt
+		n.Read = readAt != nil
+		notifications = append(notifications, n)
+	}
+	return notifications, total, unread, rows.Err()
+}
+
+// MarkRead marca uma notificação do usuário como lida
+func (s *Service) MarkRead(ctx context.Context, userID string, id int64) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE notifications SET read_at = COALESCE(read_at, GETDATE())
+		WHERE id = @p1 AND user_id = TRY_CONVERT(UNIQUEIDENTIFIER, @p2)`, id, userID)
+	if err != nil {
+		return fmt.Errorf("erro ao marcar notificação como lida: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrNotificationNotFound
+	}
+	return nil
+}
+
+// MarkAllRead marca todas as notificações do usuário como lidas e retorna
+// quantas estavam não lidas
+func (s *Service) MarkAllRead(ctx context.Context, userID string) (int, error) {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE notifications SET read_at = GETDATE()
+		WHERE user_id = TRY_CONVERT(UNIQUEIDENTIFIER, @p1) AND read_at IS NULL`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("erro ao marcar notificações como lidas: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return int(rowsAffected), nil
+}
+
+// ListSubscriptions retorna as inscrições do usuário
+func (s *Service) ListSubscriptions(ctx context.Context, userID string) ([]Subscription, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, COALESCE(conta, ''), COALESCE(kind, ''), min_severity, created_at
+		FROM notification_subscriptions
+		WHERE user_id = TRY_CONVERT(UNIQUEIDENTIFIER, @p1)
+		ORDER BY id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar inscrições: %w", err)
+	}
+	defer rows.Close()
+
+	subscriptions := []Subscription{}
+	for rows.Next() {
+		var sub Subscription
+		var minSeverity string
+		if err := rows.Scan(&sub.ID, &sub.Conta, &sub.Kind, &minSeverity, &sub.CreatedAt); err != nil {
+			return nil, fmt.Errorf("erro ao ler inscrição: %w", err)
+		}
+		sub.MinSeverity = Severity(minSeverity)
+		subscriptions = append(subscriptions, sub)
+	}
+	return subscriptions, rows.Err()
+}
+
+// Subscribe inscreve o usuário nos eventos de uma conta, de um tipo e a partir
+// de uma severidade; conta e tipo vazios recebem todos
+func (s *Service) Subscribe(ctx context.Context, userID string, req SubscriptionRequest) (*Subscription, error) {
+	if req.MinSeverity == "" {
+		req.MinSeverity = SeverityInfo
+	}
+	if !req.MinSeverity.Valid() {
+		return nil, fmt.Errorf("%w: min_severity deve ser info, warning ou error", ErrInvalidRequest)
+	}
+	if req.Kind != "" && !inboxEvents[req.Kind] {
+		return nil, fmt.Errorf("%w: kind deve ser um de %s", ErrInvalidRequest, strings.Join(InboxEvents(), ", "))
+	}
+
+	sub := Subscription{
+		Conta:       strings.ToLower(strings.TrimSpace(req.Conta)),
+		Kind:        req.Kind,
+		MinSeverity: req.MinSeverity,
+	}
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO notification_subscriptions (user_id, conta, kind, min_severity)
+		OUTPUT INSERTED.id, INSERTED.created_at
+		VALUES (TRY_CONVERT(UNIQUEIDENTIFIER, @p1), @p2, @p3, @p4)`,
+		userID, nullString(sub.Conta), nullString(sub.Kind), string(sub.MinSeverity)).Scan(&sub.ID, &sub.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao cadastrar inscrição: %w", err)
+	}
+	return &sub, nil
+}
+
+// Unsubscribe remove uma inscrição do usuário
+func (s *Service) Unsubscribe(ctx context.Context, userID string, id int) error {
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM notification_subscriptions
+		WHERE id = @p1 AND user_id = TRY_CONVERT(UNIQUEIDENTIFIER, @p2)`, id, userID)
+	if err != nil {
+		return fmt.Errorf("erro ao remover inscrição: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrSubscriptionNotFound
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+// Package notify envia as notificações do portal (integrações, XML, varreduras)
+// pelos canais cadastrados no banco: Telegram, e-mail (SMTP), webhook genérico
+// e webhooks compatíveis com o Slack.
+//
+// Cada evento é roteado por conta e severidade (notification_routes),
+// renderizado pelo template do seu tipo e gravado na fila notification_outbox,
+// entregue em background com retentativas. Eventos marcados como Digest (falhas
+// de um pedido) não são enviados na hora: entram no resumo diário.
+//
+// As falhas também vão para a caixa de entrada (tabela notifications) dos
+// usuários inscritos na conta, tipo e severidade do evento, e são enviadas às
+// conexões WebSocket desses usuários.
+package notify
+
+import (
+	"context"
+	"crypto/cipher"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"amz-web-tools/backend/internal/config"
+	"amz-web-tools/backend/internal/httpclient"
+	"amz-web-tools/backend/internal/websocket"
+)
+
+// Severity é a gravidade de um evento; as rotas recebem os eventos a partir
+// de uma severidade mínima
+type Severity string
+
+const (
+	SeverityInfo    Severity = "info"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
+func (s Severity) rank() int {
+	switch s {
+	case SeverityWarning:
+		return 2
+	case SeverityError:
+		return 3
+	}
+	return 1
+}
+
+// Valid informa se a severidade é conhecida
+func (s Severity) Valid() bool {
+	return s == SeverityInfo || s == SeverityWarning || s == SeverityError
+}
+
+// Event é uma notificação a enviar. Data alimenta o template do tipo (Kind).
+type Event struct {
+	Kind     string
+	Severity Severity
+	Conta    string
+	Data     map[string]interface{}
+	// Digest agrupa o evento no resumo diário em vez de enviá-lo na hora
+	Digest bool
+}
+
+// eventQueueSize é o número de eventos aguardando roteamento; acima disso
+// Notify grava o evento na própria goroutine
+const eventQueueSize = 1000
+
+// Service roteia os eventos e entrega a fila de notificações
+type Service struct {
+	db     *sql.DB
+	config *config.Config
+	http   *httpclient.Client
+	hub    *websocket.Hub
+	aead   cipher.AEAD
+
+	events chan Event
+	wake   chan struct{}
+
+	// Dia (YYYY-MM-DD) do último resumo enviado; usado só pelo worker
+	lastDigest string
+}
+
+// New cria o serviço. As configurações dos canais são criptografadas com
+// CREDENTIALS_ENCRYPTION_KEY quando ela está definida; as notificações da
+// caixa de entrada são enviadas aos usuários conectados em hub.
+func New(db *sql.DB, cfg *config.Config, client *httpclient.Client, hub *websocket.Hub) *Service {
+	s := &Service{
+		db:     db,
+		config: cfg,
+		http:   client,
+		hub:    hub,
+		events: make(chan Event, eventQueueSize),
+		wake:   make(chan struct{}, 1),
+	}
+
+	if cfg.CredentialsEncryptionKey == "" {
+		log.Printf("⚠️ CREDENTIALS_ENCRYPTION_KEY não configurada: configurações dos canais de notificação gravadas sem criptografia")
+	} else if aead, err := newAEAD(cfg.CredentialsEncryptionKey); err != nil {
+		log.Printf("⚠️ Erro ao inicializar criptografia dos canais de notificação: %v", err)
+	} else {
+		s.aead = aead
+	}
+
+	return s
+}
+
+// Start cadastra o canal do Telegram configurado no ambiente quando não há
+// nenhum canal e inicia, em background, o roteamento dos eventos e a entrega
+// da fila e do resumo diário. Para quando ctx é cancelado.
+func (s *Service) Start(ctx context.Context) {
+	s.seedTelegramChannel(ctx)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-s.events:
+				s.dispatch(ctx, event)
+			}
+		}
+	}()
+
+	go s.runWorker(ctx)
+
+	log.Printf("🔔 Notificações iniciadas (fila a cada %ds, resumo diário às %dh)",
+		s.config.NotifyPollSeconds, s.config.NotifyDigestHour)
+}
+
+// Notify enfileira o evento sem bloquear o chamador; o envio acontece em
+// background
+func (s *Service) Notify(event Event) {
+	if s == nil {
+		return
+	}
+	if !event.Severity.Valid() {
+		event.Severity = SeverityInfo
+	}
+	event.Conta = strings.ToLower(event.Conta)
+
+	select {
+	case s.events <- event:
+	default:
+		log.Printf("⚠️ Fila de eventos de notificação cheia; gravando %s diretamente", event.Kind)
+		s.dispatch(context.Background(), event)
+	}
+}
+
+// dispatch renderiza o evento, o entrega na caixa de entrada dos usuários
+// inscritos e o grava na fila de cada canal roteado, ou no resumo diário
+func (s *Service) dispatch(ctx context.Context, event Event) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+
+	message, err := s.render(ctx, event.Kind, event.Data)
+	if err != nil {
+		log.Printf("⚠️ Erro ao renderizar notificação %s: %v", event.Kind, err)
+		return
+	}
+
+	if inboxEvents[event.Kind] {
+		s.deliverInbox(ctx, event, message)
+	}
+
+	if event.Digest {
+		_, err := s.db.ExecContext(ctx, `
+			INSERT INTO notification_digest_items (kind, severity, conta, summary)
+			VALUES (@p1, @p2, @p3, @p4)`,
+			event.Kind, string(event.Severity), nullString(event.Conta), truncate(message.Subject, 1000))
+		if err != nil {
+			log.Printf("⚠️ Erro ao gravar notificação %s no resumo diário: %v", event.Kind, err)
+		}
+		return
+	}
+
+	routes, err := s.activeRoutes(ctx)
+	if err != nil {
+		log.Printf("⚠️ Erro ao rotear notificação %s: %v", event.Kind, err)
+		return
+	}
+
+	payload, err := eventPayload(event, message)
+	if err != nil {
+		log.Printf("⚠️ Erro ao serializar notificação %s: %v", event.Kind, err)
+		return
+	}
+
+	queued := 0
+	for channelID, channelRoutes := range routes {
+		if !anyRouteMatches(channelRoutes, event.Conta, event.Severity) {
+			continue
+		}
+		if err := s.enqueue(ctx, s.db, channelID, event, message, payload); err != nil {
+			log.Printf("⚠️ Erro ao enfileirar notificação %s para o canal %d: %v", event.Kind, channelID, err)
+			continue
+		}
+		queued++
+	}
+
+	if queued == 0 {
+		log.Printf("🔕 Nenhum canal de notificação para %s (conta %q, %s)", event.Kind, event.Conta, event.Severity)
+		return
+	}
+	s.signal()
+}
+
+// eventPayload é o corpo JSON enviado aos webhooks genéricos
+func eventPayload(event Event, msg message) (string, error) {
+	data, err := json.Marshal(map[string]interface{}{
+		"kind":      event.Kind,
+		"severity":  event.Severity,
+		"conta":     event.Conta,
+		"subject":   msg.Subject,
+		"body":      msg.Body,
+		"data":      event.Data,
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+	return string(data), err
+}
+
+func anyRouteMatches(routes []Route, conta string, severity Severity) bool {
+	for _, route := range routes {
+		if route.matches(conta, severity) {
+			return true
+		}
+	}
+	return false
+}
+
+// signal acorda o worker da fila sem bloquear
+func (s *Service) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+// truncate corta value em até max caracteres
+func truncate(value string, max int) string {
+	runes := []rune(value)
+	if len(runes) <= max {
+		return value
+	}
+	return string(runes[:max-1]) + "…"
+}
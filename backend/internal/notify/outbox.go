@@ -0,0 +1,420 @@
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Status de uma notificação na fila
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+)
+
+const (
+	// outboxBatchSize é o número de notificações entregues por rodada
+	outboxBatchSize = 50
+	// maxRetryBackoff limita a espera entre as tentativas de uma notificação
+	maxRetryBackoff = time.Hour
+	// digestMaxItems é o número de ocorrências listadas no resumo diário
+	digestMaxItems = 100
+	// digestMarkBatch é o número de ocorrências marcadas como enviadas por
+	// UPDATE, abaixo do limite de parâmetros do SQL Server
+	digestMarkBatch = 1000
+	// outboxSendTimeout limita o envio de uma notificação
+	outboxSendTimeout = 60 * time.Second
+	// outboxClaimLease adia as notificações reservadas por uma rodada, para que
+	// outras instâncias não as enviem; cobre a rodada inteira e, se a instância
+	// parar no meio dela, as notificações voltam a vencer depois desse prazo
+	outboxClaimLease = outboxBatchSize * outboxSendTimeout
+)
+
+// OutboxItem é uma notificação da fila
+type OutboxItem struct {
+	ID            int64      `json:"id"`
+	ChannelID     int        `json:"channel_id"`
+	ChannelName   string     `json:"channel_name"`
+	Kind          string     `json:"kind"`
+	Severity      Severity   `json:"severity"`
+	Conta         string     `json:"conta,omitempty"`
+	Subject       string     `json:"subject"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+}
+
+type outboxDelivery struct {
+	id        int64
+	channelID int
+	subject   string
+	body      string
+	payload   string
+	attempts  int
+}
+
+// execer é satisfeito por *sql.DB e *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// enqueue grava a notificação na fila do canal, pronta para envio
+func (s *Service) enqueue(ctx context.Context, db execer, channelID int, event Event, msg message, payload string) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO notification_outbox (channel_id, kind, severity, conta, subject, body, payload, status, next_attempt_at, created_at)
+		VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, GETUTCDATE(), GETUTCDATE())`,
+		channelID, event.Kind, string(event.Severity), nullString(event.Conta),
+		truncate(msg.Subject, 500), msg.Body, payload, OutboxPending)
+	return err
+}
+
+// runWorker entrega a fila a cada NotifyPollSeconds, ou quando um evento é
+// enfileirado, e envia o resumo diário na hora configurada
+func (s *Service) runWorker(ctx context.Context) {
+	interval := time.Duration(s.config.NotifyPollSeconds) * time.Second
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.maybeSendDigest(ctx)
+		case <-s.wake:
+		}
+		s.deliverDue(ctx)
+	}
+}
+
+// deliverDue envia as notificações pendentes cuja próxima tentativa já venceu.
+// As notificações são reservadas atomicamente (READPAST pula as que outra
+// instância está reservando), adiando a próxima tentativa por
+// outboxClaimLease.
+func (s *Service) deliverDue(ctx context.Context) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		WITH due AS (
+			SELECT TOP %d id, channel_id, subject, body, payload, attempts, next_attempt_at
+			FROM notification_outbox WITH (ROWLOCK, UPDLOCK, READPAST)
+			WHERE status = @p1 AND next_attempt_at <= GETUTCDATE()
+			ORDER BY next_attempt_at, id
+		)
+		UPDATE due SET next_attempt_at = DATEADD(SECOND, @p2, GETUTCDATE())
+		OUTPUT INSERTED.id, INSERTED.channel_id, INSERTED.subject, INSERTED.body,
+			COALESCE(INSERTED.payload, ''), INSERTED.attempts`, outboxBatchSize),
+		OutboxPending, int(outboxClaimLease.Seconds()))
+	if err != nil {
+		log.Printf("⚠️ Erro ao buscar fila de notificações: %v", err)
+		return
+	}
+
+	var deliveries []outboxDelivery
+	for rows.Next() {
+		var d outboxDelivery
+		if err := rows.Scan(&d.id, &d.channelID, &d.subject, &d.body, &d.payload, &d.attempts); err != nil {
+			log.Printf("⚠️ Erro ao ler fila de notificações: %v", err)
+			continue
+		}
+		deliveries = append(deliveries, d)
+	}
+	rows.Close()
+
+	channels := make(map[int]*Channel)
+	for _, d := range deliveries {
+		if ctx.Err() != nil {
+			return
+		}
+
+		channel, ok := channels[d.channelID]
+		if !ok {
+			channel, err = s.channel(ctx, d.channelID)
+			if err != nil && err != ErrChannelNotFound {
+				log.Printf("⚠️ Erro ao carregar canal de notificação %d: %v", d.channelID, err)
+				continue
+			}
+			channels[d.channelID] = channel
+		}
+
+		if channel == nil || !channel.Enabled {
+			s.finishDelivery(ctx, d.id, OutboxFailed, d.attempts, "canal desativado")
+			continue
+		}
+
+		sendCtx, cancel := context.WithTimeout(ctx, outboxSendTimeout)
+		err := s.send(sendCtx, *channel, message{Subject: d.subject, Body: d.body}, d.payload)
+		cancel()
+
+		attempts := d.attempts + 1
+		if err == nil {
+			s.finishDelivery(ctx, d.id, OutboxSent, attempts, "")
+			continue
+		}
+
+		if attempts >= s.config.NotifyMaxAttempts {
+			log.Printf("❌ Notificação %d para o canal %q descartada após %d tentativa(s): %v", d.id, channel.Name, attempts, err)
+			s.finishDelivery(ctx, d.id, OutboxFailed, attempts, err.Error())
+			continue
+		}
+
+		retryIn := s.retryBackoff(attempts)
+		log.Printf("⚠️ Erro ao enviar notificação %d para o canal %q (tentativa %d, nova tentativa em %v): %v",
+			d.id, channel.Name, attempts, retryIn, err)
+		_, err = s.db.ExecContext(ctx, `
+			UPDATE notification_outbox
+			SET attempts = @p1, last_error = @p2, next_attempt_at = DATEADD(SECOND, @p3, GETUTCDATE())
+			WHERE id = @p4`,
+			attempts, truncate(err.Error(), 1000), int(retryIn.Seconds()), d.id)
+		if err != nil {
+			log.Printf("⚠️ Erro ao reagendar notificação %d: %v", d.id, err)
+		}
+	}
+}
+
+// retryBackoff é a espera antes da próxima tentativa: NotifyRetryMinutes,
+// dobrada a cada tentativa, até maxRetryBackoff
+func (s *Service) retryBackoff(attempts int) time.Duration {
+	backoff := time.Duration(s.config.NotifyRetryMinutes) * time.Minute
+	for i := 1; i < attempts && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	return backoff
+}
+
+func (s *Service) finishDelivery(ctx context.Context, id int64, status string, attempts int, lastError string) {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE notification_outbox
+		SET status = @p1, attempts = @p2, last_error = @p3,
+			sent_at = CASE WHEN @p1 = 'sent' THEN GETUTCDATE() ELSE sent_at END
+		WHERE id = @p4`,
+		status, attempts, nullString(truncate(lastError, 1000)), id)
+	if err != nil {
+		log.Printf("⚠️ Erro ao atualizar notificação %d: %v", id, err)
+	}
+}
+
+// ListOutbox retorna as notificações mais recentes da fila, opcionalmente
+// filtradas por status
+func (s *Service) ListOutbox(ctx context.Context, status string, limit int) ([]OutboxItem, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT TOP %d o.id, o.channel_id, c.name, o.kind, o.severity, COALESCE(o.conta, ''), o.subject,
+			o.status, o.attempts, COALESCE(o.last_error, ''), o.next_attempt_at, o.created_at, o.sent_at
+		FROM notification_outbox o
+		JOIN notification_channels c ON c.id = o.channel_id
+		WHERE (@p1 = '' OR o.status = @p1)
+		ORDER BY o.id DESC`, limit), status)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar fila de notificações: %w", err)
+	}
+	defer rows.Close()
+
+	items := []OutboxItem{}
+	for rows.Next() {
+		var item OutboxItem
+		var severity string
+		var sentAt sql.NullTime
+		if err := rows.Scan(&item.ID, &item.ChannelID, &item.ChannelName, &item.Kind, &severity, &item.Conta,
+			&item.Subject, &item.Status, &item.Attempts, &item.LastError, &item.NextAttemptAt, &item.CreatedAt, &sentAt); err != nil {
+			return nil, fmt.Errorf("erro ao ler fila de notificações: %w", err)
+		}
+		item.Severity = Severity(severity)
+		if sentAt.Valid {
+			item.SentAt = &sentAt.Time
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// maybeSendDigest envia o resumo diário uma vez por dia, a partir de
+// NotifyDigestHour
+func (s *Service) maybeSendDigest(ctx context.Context) {
+	now := time.Now()
+	today := now.Format("2006-01-02")
+	if now.Hour() < s.config.NotifyDigestHour || s.lastDigest == today {
+		return
+	}
+
+	if _, err := s.SendDigest(ctx); err != nil {
+		log.Printf("⚠️ Erro ao enviar resumo diário de notificações: %v", err)
+		return
+	}
+	s.lastDigest = today
+}
+
+type digestItem struct {
+	id       int64
+	severity Severity
+	conta    string
+	summary  string
+	created  time.Time
+}
+
+// SendDigest agrupa as ocorrências pendentes do resumo e enfileira, para cada
+// canal roteado, um resumo com as ocorrências das suas contas. Retorna o
+// número de ocorrências incluídas.
+func (s *Service) SendDigest(ctx context.Context) (int, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, severity, COALESCE(conta, ''), summary, created_at
+		FROM notification_digest_items
+		WHERE sent_at IS NULL
+		ORDER BY id`)
+	if err != nil {
+		return 0, fmt.Errorf("erro ao buscar ocorrências do resumo diário: %w", err)
+	}
+
+	var items []digestItem
+	for rows.Next() {
+		var item digestItem
+		var severity string
+		if err := rows.Scan(&item.id, &severity, &item.conta, &item.summary, &item.created); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("erro ao ler ocorrências do resumo diário: %w", err)
+		}
+		item.severity = Severity(severity)
+		items = append(items, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("erro ao ler ocorrências do resumo diário: %w", err)
+	}
+	if len(items) == 0 {
+		return 0, nil
+	}
+
+	routes, err := s.activeRoutes(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("erro ao rotear resumo diário: %w", err)
+	}
+
+	type digestDelivery struct {
+		channelID int
+		event     Event
+		msg       message
+		payload   string
+	}
+
+	date := time.Now().Format("02/01/2006")
+	var deliveries []digestDelivery
+	for channelID, channelRoutes := range routes {
+		var channelItems []digestItem
+		for _, item := range items {
+			if anyRouteMatches(channelRoutes, item.conta, item.severity) {
+				channelItems = append(channelItems, item)
+			}
+		}
+		if len(channelItems) == 0 {
+			continue
+		}
+
+		event := Event{Kind: EventDigest, Severity: SeverityError, Data: digestData(date, channelItems)}
+		msg, err := s.render(ctx, event.Kind, event.Data)
+		if err != nil {
+			return 0, err
+		}
+		payload, err := eventPayload(event, msg)
+		if err != nil {
+			return 0, err
+		}
+		deliveries = append(deliveries, digestDelivery{channelID, event, msg, payload})
+	}
+
+	// Os resumos são enfileirados e as ocorrências lidas marcadas na mesma
+	// transação: uma falha no meio não reenvia nem perde ocorrências
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("erro ao iniciar transação do resumo diário: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, d := range deliveries {
+		if err := s.enqueue(ctx, tx, d.channelID, d.event, d.msg, d.payload); err != nil {
+			return 0, fmt.Errorf("erro ao enfileirar resumo diário: %w", err)
+		}
+	}
+	// As ocorrências sem canal também são marcadas, para não acumularem
+	if err := markDigestItems(ctx, tx, items); err != nil {
+		return 0, fmt.Errorf("erro ao marcar ocorrências do resumo diário: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("erro ao gravar resumo diário: %w", err)
+	}
+
+	log.Printf("📰 Resumo diário com %d ocorrência(s) enfileirado para %d canal(is)", len(items), len(deliveries))
+	if len(deliveries) > 0 {
+		s.signal()
+	}
+	return len(items), nil
+}
+
+// markDigestItems marca como enviadas exatamente as ocorrências lidas, em
+// lotes de digestMarkBatch
+func markDigestItems(ctx context.Context, db execer, items []digestItem) error {
+	for start := 0; start < len(items); start += digestMarkBatch {
+		end := start + digestMarkBatch
+		if end > len(items) {
+			end = len(items)
+		}
+
+		placeholders := make([]string, 0, end-start)
+		args := make([]interface{}, 0, end-start)
+		for _, item := range items[start:end] {
+			args = append(args, item.id)
+			placeholders = append(placeholders, fmt.Sprintf("@p%d", len(args)))
+		}
+
+		_, err := db.ExecContext(ctx, fmt.Sprintf(`
+			UPDATE notification_digest_items SET sent_at = GETUTCDATE()
+			WHERE sent_at IS NULL AND id IN (%s)`, strings.Join(placeholders, ", ")), args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// digestData agrupa as ocorrências por conta para o template do resumo,
+// listando até digestMaxItems
+func digestData(date string, items []digestItem) map[string]interface{} {
+	omitted := 0
+	if len(items) > digestMaxItems {
+		omitted = len(items) - digestMaxItems
+	}
+
+	var contas []map[string]interface{}
+	byConta := make(map[string]map[string]interface{})
+	for i, item := range items {
+		group, ok := byConta[item.conta]
+		if !ok {
+			group = map[string]interface{}{"conta": item.conta, "total": 0, "items": []map[string]interface{}{}}
+			byConta[item.conta] = group
+			contas = append(contas, group)
+		}
+		group["total"] = group["total"].(int) + 1
+		if i < digestMaxItems {
+			group["items"] = append(group["items"].([]map[string]interface{}), map[string]interface{}{
+				"hora":    item.created.Format("15:04"),
+				"summary": item.summary,
+			})
+		}
+	}
+
+	return map[string]interface{}{
+		"data":     date,
+		"total":    len(items),
+		"contas":   contas,
+		"omitidos": omitted,
+	}
+}
@@ -0,0 +1,167 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// telegramMaxLength é o tamanho máximo de uma mensagem do Telegram
+const telegramMaxLength = 4096
+
+// send entrega a notificação pelo adaptador do tipo do canal
+func (s *Service) send(ctx context.Context, channel Channel, msg message, payload string) error {
+	settings := channel.Settings
+
+	switch channel.Type {
+	case ChannelTelegram:
+		url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", settings["bot_token"])
+		return s.postJSON(ctx, url, "", map[string]string{
+			"chat_id": settings["chat_id"],
+			"text":    truncate(msg.Body, telegramMaxLength),
+		})
+	case ChannelSlack:
+		return s.postJSON(ctx, settings["webhook_url"], "", map[string]string{"text": msg.Body})
+	case ChannelWebhook:
+		return s.post(ctx, settings["url"], settings["authorization"], []byte(payload))
+	case ChannelEmail:
+		return sendEmail(ctx, settings, msg)
+	}
+	return fmt.Errorf("tipo de canal desconhecido: %s", channel.Type)
+}
+
+func (s *Service) postJSON(ctx context.Context, url, authorization string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("erro ao serializar notificação: %w", err)
+	}
+	return s.post(ctx, url, authorization, data)
+}
+
+func (s *Service) post(ctx context.Context, url, authorization string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("URL inválida: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 500))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
+// sendEmail envia a notificação por SMTP. A porta 465 usa TLS implícito; nas
+// demais o STARTTLS é usado quando o servidor oferece.
+func sendEmail(ctx context.Context, settings map[string]string, msg message) error {
+	port := settings["port"]
+	if port == "" {
+		port = "587"
+	}
+	host := settings["host"]
+	addr := net.JoinHostPort(host, port)
+
+	var to []string
+	for _, address := range strings.Split(settings["to"], ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			to = append(to, address)
+		}
+	}
+	if len(to) == 0 {
+		return fmt.Errorf("nenhum destinatário configurado")
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(30 * time.Second)
+	}
+	dialer := &net.Dialer{Deadline: deadline}
+
+	var conn net.Conn
+	var err error
+	if port == "465" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("erro ao conectar ao SMTP %s: %w", addr, err)
+	}
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("erro ao iniciar sessão SMTP: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && port != "465" {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return fmt.Errorf("erro no STARTTLS: %w", err)
+		}
+	}
+	if settings["username"] != "" {
+		if err := client.Auth(smtp.PlainAuth("", settings["username"], settings["password"], host)); err != nil {
+			return fmt.Errorf("erro na autenticação SMTP: %w", err)
+		}
+	}
+
+	if err := client.Mail(settings["from"]); err != nil {
+		return fmt.Errorf("remetente recusado: %w", err)
+	}
+	for _, address := range to {
+		if err := client.Rcpt(address); err != nil {
+			return fmt.Errorf("destinatário %s recusado: %w", address, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("erro ao enviar e-mail: %w", err)
+	}
+	if _, err := w.Write(emailMessage(settings["from"], to, msg)); err != nil {
+		return fmt.Errorf("erro ao enviar e-mail: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("erro ao enviar e-mail: %w", err)
+	}
+	return client.Quit()
+}
+
+func emailMessage(from string, to []string, msg message) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	qp.Write([]byte(strings.ReplaceAll(msg.Body, "\n", "\r\n")))
+	qp.Close()
+
+	return buf.Bytes()
+}
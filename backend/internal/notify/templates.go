@@ -0,0 +1,291 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Tipos de evento. Cada tipo tem um template padrão, que pode ser
+// substituído em notification_templates.
+const (
+	EventIntegrationCompleted = "integration.completed"
+	EventIntegrationFailed    = "integration.failed"
+	EventXMLProcessed         = "xml.processed"
+	EventXMLFailed            = "xml.failed"
+	EventXMLInvoiceError      = "xml.invoice_error"
+	EventXMLDeferredFailed    = "xml.deferred_failed"
+	EventXMLSweepCompleted    = "xml.sweep_completed"
+	EventDeParaRollback       = "depara.rollback"
+	EventTest                 = "test"
+	EventDigest               = "digest"
+)
+
+// ErrUnknownTemplate indica um tipo de evento sem template padrão
+var ErrUnknownTemplate = errors.New("tipo de notificação desconhecido")
+
+// Template é o assunto e o corpo (text/template) de um tipo de evento. O
+// assunto é a linha usada no resumo diário e no assunto do e-mail.
+type Template struct {
+	Kind      string     `json:"kind"`
+	Subject   string     `json:"subject"`
+	Body      string     `json:"body"`
+	Custom    bool       `json:"custom"`
+	UpdatedBy string     `json:"updated_by,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// TemplateRequest substitui o template padrão de um tipo de evento
+type TemplateRequest struct {
+	Subject string `json:"subject" binding:"required"`
+	Body    string `json:"body" binding:"required"`
+}
+
+// message é uma notificação renderizada
+type message struct {
+	Subject string
+	Body    string
+}
+
+var templateFuncs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"join": func(values []string, sep string) string {
+		return strings.Join(values, sep)
+	},
+}
+
+var defaultTemplates = map[string]Template{
+	EventIntegrationCompleted: {
+		Subject: `✅ {{upper .conta}} - Pedido {{.num_pedido}} integrado (pré-nota {{.prenotas}})`,
+		Body: `🎉 NOVO PEDIDO INTEGRADO COM SUCESSO! 🎉
+
+📋 INFORMAÇÕES DO PEDIDO:
+• Conta: {{upper .conta}}
+• Número: {{.num_pedido}}
+• Tipo de Envio: {{.tipo_envio}}
+• Cliente: {{.cliente}}
+• Endereço: {{.endereco}}
+• Itens: {{.itens}}
+• Valor Total: R$ {{printf "%.2f" .valor_total}}
+
+✅ PROCESSAMENTO:
+• Cliente cadastrado no NBS
+• Endereço registrado no NBS
+• Pedido processado com sucesso
+
+📄 PRÉ-NOTA(S) GERADA(S): {{.prenotas}}`,
+	},
+	EventIntegrationFailed: {
+		Subject: `❌ {{upper .conta}} - Pedido {{.num_pedido}} falhou na etapa {{.etapa}}: {{.erro}}`,
+		Body: `❌ {{upper .conta}} (MeLi) - INTEGRAÇÃO FALHOU! ❌
+
+📊 Resumo:
+• Etapa: {{.etapa}}
+• Erro: {{.erro}}
+
+❌ Pedido {{.num_pedido}} falhou na integração!`,
+	},
+	EventXMLProcessed: {
+		Subject: `📊 {{upper .conta}} (MeLi) - Processamento XML: {{.success_count}} sucesso(s), {{.error_count}} erro(s)`,
+		Body: `📊 {{upper .conta}} (MeLi) - Processamento XML Concluído
+
+📈 Resumo:
+• Total processado: {{.total}}
+• Sucessos: {{.success_count}}
+• Erros: {{.error_count}}
+
+📋 Detalhes:
+{{join .details "\n"}}`,
+	},
+	EventXMLFailed: {
+		Subject: `❌ Erro ao buscar pedidos de {{.num_pedido}}: {{.erro}}`,
+		Body:    `❌ Erro ao buscar pedidos de {{.num_pedido}}: {{.erro}}`,
+	},
+	EventXMLInvoiceError: {
+		Subject: `❌ {{upper .conta}} (MeLi) - Pedido {{.num_pedido}}{{if .nota_fiscal}}, NF: {{.nota_fiscal}}{{end}} - {{.erro}}`,
+		Body:    `❌ {{upper .conta}} (MeLi) - Pedido {{.num_pedido}}{{if .nota_fiscal}}, NF: {{.nota_fiscal}}{{end}} - {{.erro}}`,
+	},
+	EventXMLDeferredFailed: {
+		Subject: `❌ {{upper .conta}} (MeLi) - Pedido {{.num_pedido}} - Envio agendado do XML falhou após {{.tentativas}} tentativa(s)`,
+		Body:    `❌ {{upper .conta}} (MeLi) - Pedido {{.num_pedido}} - Envio agendado do XML falhou após {{.tentativas}} tentativa(s): {{.erro}}`,
+	},
+	EventXMLSweepCompleted: {
+		Subject: `📊 Varredura de XML: {{.uploaded}} enviado(s), {{.errors}} erro(s) de {{.total}} pendente(s)`,
+		Body: `📊 Varredura de XML Concluída
+
+📈 Resumo:
+• Pendentes: {{.total}}
+• XML enviados: {{.uploaded}}
+• Aguardando ML: {{.waiting}}
+• Sem XML: {{.no_xml}}
+• Erros: {{.errors}}{{if .error_message}}
+
+⚠️ {{.error_message}}{{end}}{{if .erros}}
+
+📋 Erros:
+{{join .erros "\n"}}{{end}}`,
+	},
+	EventDeParaRollback: {
+		Subject: `↩️ {{if .conta}}{{upper .conta}} - {{end}}Rollback no DePara: {{.operacao}} do registro {{.registro}} desfeito por {{.usuario}}`,
+		Body: `↩️ ROLLBACK NO DEPARA
+
+• Tabela: {{.tabela}}
+• Registro: {{.registro}}
+• Operação desfeita: {{.operacao}}
+• Usuário: {{.usuario}}
+• Auditoria: {{.auditoria}}`,
+	},
+	EventTest: {
+		Subject: `🔔 Teste de notificação`,
+		Body:    `🔔 Teste do canal "{{.canal}}" enviado por {{.usuario}} em {{.data}}`,
+	},
+	EventDigest: {
+		Subject: `📰 Resumo diário de falhas - {{.data}}: {{.total}} ocorrência(s)`,
+		Body: `📰 Resumo diário de falhas - {{.data}}
+
+{{.total}} ocorrência(s)
+{{range .contas}}
+🏢 {{if .conta}}{{upper .conta}}{{else}}Sem conta{{end}} ({{.total}})
+{{range .items}}• {{.hora}} {{.summary}}
+{{end}}{{end}}{{if .omitidos}}
+... e mais {{.omitidos}} ocorrência(s){{end}}`,
+	},
+}
+
+// render monta a notificação do tipo com o template personalizado, se houver,
+// ou o padrão. Um template personalizado inválido cai no padrão.
+func (s *Service) render(ctx context.Context, kind string, data map[string]interface{}) (message, error) {
+	if custom, err := s.customTemplate(ctx, kind); err != nil {
+		log.Printf("⚠️ Erro ao buscar template de notificação %s: %v", kind, err)
+	} else if custom != nil {
+		msg, err := execute(*custom, data)
+		if err == nil {
+			return msg, nil
+		}
+		log.Printf("⚠️ Template personalizado de %s inválido, usando o padrão: %v", kind, err)
+	}
+
+	tmpl, ok := defaultTemplates[kind]
+	if !ok {
+		return message{}, fmt.Errorf("%w: %s", ErrUnknownTemplate, kind)
+	}
+	tmpl.Kind = kind
+	return execute(tmpl, data)
+}
+
+func execute(tmpl Template, data map[string]interface{}) (message, error) {
+	subject, err := executeText(tmpl.Kind+".subject", tmpl.Subject, data)
+	if err != nil {
+		return message{}, err
+	}
+	body, err := executeText(tmpl.Kind+".body", tmpl.Body, data)
+	if err != nil {
+		return message{}, err
+	}
+	return message{Subject: strings.TrimSpace(subject), Body: strings.TrimSpace(body)}, nil
+}
+
+func executeText(name, text string, data map[string]interface{}) (string, error) {
+	t, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (s *Service) customTemplate(ctx context.Context, kind string) (*Template, error) {
+	var tmpl Template
+	var updatedBy sql.NullString
+	var updatedAt time.Time
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT kind, subject, body, updated_by, updated_at
+		FROM notification_templates WHERE kind = @p1`, kind).
+		Scan(&tmpl.Kind, &tmpl.Subject, &tmpl.Body, &updatedBy, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl.Custom = true
+	tmpl.UpdatedBy = updatedBy.String
+	tmpl.UpdatedAt = &updatedAt
+	return &tmpl, nil
+}
+
+// ListTemplates retorna o template em uso de cada tipo de evento
+func (s *Service) ListTemplates(ctx context.Context) ([]Template, error) {
+	kinds := make([]string, 0, len(defaultTemplates))
+	for kind := range defaultTemplates {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	templates := make([]Template, 0, len(kinds))
+	for _, kind := range kinds {
+		custom, err := s.customTemplate(ctx, kind)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao buscar templates de notificação: %w", err)
+		}
+		if custom != nil {
+			templates = append(templates, *custom)
+			continue
+		}
+
+		tmpl := defaultTemplates[kind]
+		tmpl.Kind = kind
+		templates = append(templates, tmpl)
+	}
+	return templates, nil
+}
+
+// SaveTemplate substitui o template padrão do tipo de evento
+func (s *Service) SaveTemplate(ctx context.Context, kind string, req TemplateRequest, updatedBy string) (*Template, error) {
+	if _, ok := defaultTemplates[kind]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTemplate, kind)
+	}
+	for name, text := range map[string]string{"subject": req.Subject, "body": req.Body} {
+		if _, err := template.New(name).Funcs(templateFuncs).Parse(text); err != nil {
+			return nil, fmt.Errorf("%w: template %s inválido: %v", ErrInvalidRequest, name, err)
+		}
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		MERGE notification_templates AS target
+		USING (SELECT @p1 AS kind) AS source ON target.kind = source.kind
+		WHEN MATCHED THEN
+			UPDATE SET subject = @p2, body = @p3, updated_by = @p4, updated_at = GETDATE()
+		WHEN NOT MATCHED THEN
+			INSERT (kind, subject, body, updated_by) VALUES (@p1, @p2, @p3, @p4);`,
+		kind, req.Subject, req.Body, nullString(updatedBy))
+	if err != nil {
+		return nil, fmt.Errorf("erro ao gravar template de notificação: %w", err)
+	}
+
+	return s.customTemplate(ctx, kind)
+}
+
+// DeleteTemplate remove o template personalizado, voltando ao padrão
+func (s *Service) DeleteTemplate(ctx context.Context, kind string) error {
+	if _, ok := defaultTemplates[kind]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownTemplate, kind)
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM notification_templates WHERE kind = @p1`, kind); err != nil {
+		return fmt.Errorf("erro ao remover template de notificação: %w", err)
+	}
+	return nil
+}
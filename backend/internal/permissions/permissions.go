@@ -0,0 +1,140 @@
+// Package permissions define as permissões do portal e como elas são
+// comparadas.
+//
+// Uma permissão é uma sequência de segmentos separados por ":", como
+// "integration:execute" ou "depara:write:psa". Os papéis (roles) são
+// conjuntos de permissões cadastrados pelos administradores; uma permissão
+// concedida pode usar "*" como curinga de um segmento ou, no final, de todos
+// os segmentos restantes ("depara:write:*", "integration:*", "*").
+package permissions
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Permissões verificadas pelas rotas
+const (
+	All = "*"
+
+	AdminAccess = "admin:access"
+
+	IntegrationExecute = "integration:execute"
+	IntegrationView    = "integration:view"
+
+	XMLProcess = "xml:process"
+	XMLView    = "xml:view"
+
+	DeParaView = "depara:view"
+	// DeParaWrite é seguida da conta da tabela: depara:write:psa
+	DeParaWrite = "depara:write"
+
+	AuditView     = "audit:view"
+	AuditRollback = "audit:rollback"
+
+	StockView     = "stock:view"
+	StockViewCost = "stock:view-cost"
+
+	CarPlateView = "car-plate:view"
+
+	DashboardView = "dashboard:view"
+)
+
+// Definition descreve uma permissão do catálogo
+type Definition struct {
+	Permission  string `json:"permission"`
+	Description string `json:"description"`
+}
+
+// Catalog lista as permissões conhecidas, na ordem exibida aos administradores
+func Catalog() []Definition {
+	return []Definition{
+		{All, "Todas as permissões"},
+		{AdminAccess, "Rotas administrativas: usuários, papéis, poller, varredura de XML, credenciais, roteamento e notificações"},
+		{IntegrationExecute, "Executar e retomar integrações de pedidos"},
+		{IntegrationView, "Consultar o status das integrações"},
+		{XMLProcess, "Processar e importar XML de notas fiscais"},
+		{XMLView, "Consultar execuções e logs do integrador de XML"},
+		{DeParaView, "Consultar as tabelas DePara"},
+		{DeParaWrite + ":{conta}", "Criar, alterar e excluir produtos na tabela DePara da conta (ex.: depara:write:psa)"},
+		{AuditView, "Consultar o log de auditoria"},
+		{AuditRollback, "Desfazer alterações auditadas"},
+		{StockView, "Consultar estoque"},
+		{StockViewCost, "Ver o custo contábil e o valor de reposição no estoque"},
+		{CarPlateView, "Consultar placas"},
+		{DashboardView, "Ver o dashboard"},
+	}
+}
+
+// DeParaWriteFor retorna a permissão de escrita na tabela DePara da conta
+func DeParaWriteFor(conta string) string {
+	return DeParaWrite + ":" + strings.ToLower(conta)
+}
+
+// Match informa se a permissão concedida cobre a exigida
+func Match(granted, required string) bool {
+	grantedParts := strings.Split(granted, ":")
+	requiredParts := strings.Split(required, ":")
+
+	for i, part := range grantedParts {
+		if part == "*" && i == len(grantedParts)-1 {
+			return len(requiredParts) > i
+		}
+		if i >= len(requiredParts) || (part != "*" && part != requiredParts[i]) {
+			return false
+		}
+	}
+	return len(grantedParts) == len(requiredParts)
+}
+
+// Has informa se alguma das permissões concedidas cobre a exigida
+func Has(granted []string, required string) bool {
+	for _, permission := range granted {
+		if Match(permission, required) {
+			return true
+		}
+	}
+	return false
+}
+
+// Normalize valida e normaliza (minúsculas, sem duplicadas) as permissões de
+// um papel. Só são aceitas permissões do catálogo, as de escrita DePara de uma
+// conta e curingas que cubram alguma delas.
+func Normalize(list []string) ([]string, error) {
+	normalized := make([]string, 0, len(list))
+	seen := make(map[string]bool)
+	for _, permission := range list {
+		permission = strings.ToLower(strings.TrimSpace(permission))
+		if permission == "" || seen[permission] {
+			continue
+		}
+		if !known(permission) {
+			return nil, fmt.Errorf("permissão desconhecida: %s", permission)
+		}
+		seen[permission] = true
+		normalized = append(normalized, permission)
+	}
+	return normalized, nil
+}
+
+func known(permission string) bool {
+	parts := strings.Split(permission, ":")
+	for _, part := range parts {
+		if part == "" {
+			return false
+		}
+	}
+
+	// depara:write:<conta>
+	if len(parts) == 3 && parts[0]+":"+parts[1] == DeParaWrite {
+		return true
+	}
+
+	for _, definition := range Catalog() {
+		candidate := strings.Replace(definition.Permission, "{conta}", "x", 1)
+		if Match(permission, candidate) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package permissions
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		granted, required string
+		want              bool
+	}{
+		{"*", "integration:execute", true},
+		{"*", "depara:write:psa", true},
+		{"integration:execute", "integration:execute", true},
+		{"integration:execute", "integration:view", false},
+		{"integration:*", "integration:view", true},
+		{"integration:*", "integration", false},
+		{"integration:*", "xml:view", false},
+		{"depara:*", "depara:write:psa", true},
+		{"depara:write:*", "depara:write:psa", true},
+		{"depara:write:*", "depara:write", false},
+		{"depara:write", "depara:write:psa", false},
+		{"depara:write:psa", "depara:write:lcs", false},
+		{"depara:*:psa", "depara:write:psa", true},
+		{"depara:*:psa", "depara:write:lcs", false},
+		{"*:view", "stock:view", true},
+		{"*:view", "stock:view-cost", false},
+		{"stock:view", "stock:view-cost", false},
+	}
+
+	for _, tt := range tests {
+		if got := Match(tt.granted, tt.required); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.granted, tt.required, got, tt.want)
+		}
+	}
+}
+
+func TestHas(t *testing.T) {
+	granted := []string{"integration:view", "depara:write:psa"}
+
+	if !Has(granted, DeParaWriteFor("PSA")) {
+		t.Error("Has: depara:write:psa deveria ser concedida")
+	}
+	if Has(granted, IntegrationExecute) {
+		t.Error("Has: integration:execute não foi concedida")
+	}
+	if Has(nil, DashboardView) {
+		t.Error("Has: lista vazia não concede nada")
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	got, err := Normalize([]string{" Integration:View ", "integration:view", "", "XML:*", "depara:write:PSA", "*"})
+	if err != nil {
+		t.Fatalf("Normalize: %v", err)
+	}
+	want := []string{"integration:view", "xml:*", "depara:write:psa", "*"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Normalize = %q, want %q", got, want)
+	}
+
+	if got, err := Normalize(nil); err != nil || len(got) != 0 {
+		t.Errorf("Normalize(nil) = %q, %v", got, err)
+	}
+
+	for _, permission := range []string{
+		"stock:delete",       // fora do catálogo
+		"billing:*",          // curinga que não cobre nenhuma permissão
+		"depara:write:",      // escrita DePara sem conta
+		"integration::view",  // segmento vazio
+		"depara:write:psa:x", // segmentos a mais
+	} {
+		if _, err := Normalize([]string{permission}); err == nil {
+			t.Errorf("Normalize(%q): esperava erro", permission)
+		}
+	}
+}
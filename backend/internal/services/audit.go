@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -9,22 +10,29 @@ import (
 
 	"amz-web-tools/backend/internal/config"
 	"amz-web-tools/backend/internal/models"
+	"amz-web-tools/backend/internal/notify"
 )
 
 type AuditService struct {
-	db     *sql.DB
-	config *config.Config
+	db       *sql.DB
+	config   *config.Config
+	notifier *notify.Service
 }
 
-func NewAuditService(db *sql.DB, cfg *config.Config) *AuditService {
+// NewAuditService creates the audit service; rollbacks are announced through notifier
+func NewAuditService(db *sql.DB, cfg *config.Config, notifier *notify.Service) *AuditService {
 	return &AuditService{
-		db:     db,
-		config: cfg,
+		db:       db,
+		config:   cfg,
+		notifier: notifier,
 	}
 }
 
 // LogOperation logs a CRUD operation to the audit table
-func (s *AuditService) LogOperation(req models.AuditLogRequest, userID, userEmail, userName string) error {
+func (s *AuditService) LogOperation(ctx context.Context, req models.AuditLogRequest, userID, userEmail, userName string) error {
+	ctx, cancel := withDeadline(ctx, s.config.DBQueryTimeoutSeconds)
+	defer cancel()
+
 	// Convert maps to JSON strings
 	oldValuesJSON, err := json.Marshal(req.OldValues)
 	if err != nil {
@@ -65,7 +73,7 @@ func (s *AuditService) LogOperation(req models.AuditLogRequest, userID, userEmai
 		 old_values, new_values, changed_fields, ip_address, user_agent, rollback_data)
 		VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, @p9, @p10, @p11, @p12)`
 
-	_, err = s.db.Exec(query,
+	_, err = s.db.ExecContext(ctx, query,
 		req.TableName,
 		req.RecordID,
 		strings.ToUpper(req.Operation),
@@ -90,7 +98,15 @@ func (s *AuditService) LogOperation(req models.AuditLogRequest, userID, userEmai
 }
 
 // GetAuditLogs retrieves audit logs for a specific table and record
-func (s *AuditService) GetAuditLogs(tableName, recordID string, limit int) ([]models.AuditLog, error) {
+func (s *AuditService) GetAuditLogs(ctx context.Context, scope Scope, tableName, recordID string, limit int) ([]models.AuditLog, error) {
+	// Users limited to contas only see the history of their DePara tables
+	if err := scope.check(deParaConta(tableName)); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withDeadline(ctx, s.config.DBQueryTimeoutSeconds)
+	defer cancel()
+
 	query := `
 		SELECT TOP (@p1) id, table_name, record_id, operation, user_id, user_email, user_name,
 		       old_values, new_values, changed_fields, ip_address, user_agent, created_at, rollback_data
@@ -98,7 +114,7 @@ func (s *AuditService) GetAuditLogs(tableName, recordID string, limit int) ([]mo
 		WHERE table_name = @p2 AND record_id = @p3
 		ORDER BY created_at DESC`
 
-	rows, err := s.db.Query(query, limit, tableName, recordID)
+	rows, err := s.db.QueryContext(ctx, query, limit, tableName, recordID)
 	if err != nil {
 		return nil, err
 	}
@@ -120,7 +136,10 @@ func (s *AuditService) GetAuditLogs(tableName, recordID string, limit int) ([]mo
 }
 
 // GetAuditLogsByUser retrieves audit logs for a specific user
-func (s *AuditService) GetAuditLogsByUser(userID string, limit int) ([]models.AuditLog, error) {
+func (s *AuditService) GetAuditLogsByUser(ctx context.Context, userID string, limit int) ([]models.AuditLog, error) {
+	ctx, cancel := withDeadline(ctx, s.config.DBQueryTimeoutSeconds)
+	defer cancel()
+
 	query := `
 		SELECT TOP (@p1) id, table_name, record_id, operation, user_id, user_email, user_name,
 		       old_values, new_values, changed_fields, ip_address, user_agent, created_at, rollback_data
@@ -128,7 +147,7 @@ func (s *AuditService) GetAuditLogsByUser(userID string, limit int) ([]models.Au
 		WHERE user_id = @p2
 		ORDER BY created_at DESC`
 
-	rows, err := s.db.Query(query, limit, userID)
+	rows, err := s.db.QueryContext(ctx, query, limit, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -149,8 +168,24 @@ func (s *AuditService) GetAuditLogsByUser(userID string, limit int) ([]models.Au
 	return logs, nil
 }
 
+// AuditLogTable returns the table changed by an audit log entry
+func (s *AuditService) AuditLogTable(ctx context.Context, auditLogID string) (string, error) {
+	ctx, cancel := withDeadline(ctx, s.config.DBQueryTimeoutSeconds)
+	defer cancel()
+
+	var tableName string
+	err := s.db.QueryRowContext(ctx, `SELECT table_name FROM portal.dbo.audit_logs WHERE id = @p1`, auditLogID).Scan(&tableName)
+	if err != nil {
+		return "", err
+	}
+	return tableName, nil
+}
+
 // ExecuteRollback executes a rollback operation based on audit log
-func (s *AuditService) ExecuteRollback(auditLogID string, userID, userEmail, userName string) error {
+func (s *AuditService) ExecuteRollback(ctx context.Context, auditLogID string, userID, userEmail, userName string) error {
+	ctx, cancel := withDeadline(ctx, s.config.DBQueryTimeoutSeconds)
+	defer cancel()
+
 	// Get the audit log
 	query := `
 		SELECT table_name, record_id, operation, rollback_data
@@ -158,7 +193,7 @@ func (s *AuditService) ExecuteRollback(auditLogID string, userID, userEmail, use
 		WHERE id = @p1`
 
 	var tableName, recordID, operation, rollbackData string
-	err := s.db.QueryRow(query, auditLogID).Scan(&tableName, &recordID, &operation, &rollbackData)
+	err := s.db.QueryRowContext(ctx, query, auditLogID).Scan(&tableName, &recordID, &operation, &rollbackData)
 	if err != nil {
 		return fmt.Errorf("failed to get audit log: %w", err)
 	}
@@ -174,7 +209,7 @@ func (s *AuditService) ExecuteRollback(auditLogID string, userID, userEmail, use
 	case "INSERT":
 		// Rollback INSERT = DELETE
 		deleteQuery := fmt.Sprintf("DELETE FROM %s WHERE id = @p1", tableName)
-		_, err = s.db.Exec(deleteQuery, recordID)
+		_, err = s.db.ExecContext(ctx, deleteQuery, recordID)
 		if err != nil {
 			return fmt.Errorf("failed to execute rollback DELETE: %w", err)
 		}
@@ -204,7 +239,7 @@ func (s *AuditService) ExecuteRollback(auditLogID string, userID, userEmail, use
 				tableName, strings.Join(setParts, ", "), argIndex)
 			args = append(args, recordID)
 
-			_, err = s.db.Exec(updateQuery, args...)
+			_, err = s.db.ExecContext(ctx, updateQuery, args...)
 			if err != nil {
 				return fmt.Errorf("failed to execute rollback UPDATE: %w", err)
 			}
@@ -232,7 +267,7 @@ func (s *AuditService) ExecuteRollback(auditLogID string, userID, userEmail, use
 			insertQuery := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
 				tableName, strings.Join(fields, ", "), strings.Join(placeholders, ", "))
 
-			_, err = s.db.Exec(insertQuery, args...)
+			_, err = s.db.ExecContext(ctx, insertQuery, args...)
 			if err != nil {
 				return fmt.Errorf("failed to execute rollback INSERT: %w", err)
 			}
@@ -250,7 +285,42 @@ func (s *AuditService) ExecuteRollback(auditLogID string, userID, userEmail, use
 		},
 	}
 
-	return s.LogOperation(rollbackReq, userID, userEmail, userName)
+	if err := s.LogOperation(ctx, rollbackReq, userID, userEmail, userName); err != nil {
+		return err
+	}
+
+	user := userName
+	if user == "" {
+		user = userEmail
+	}
+	conta := deParaConta(tableName)
+	s.notifier.Notify(notify.Event{
+		Kind:     notify.EventDeParaRollback,
+		Severity: notify.SeverityWarning,
+		Conta:    conta,
+		Data: map[string]interface{}{
+			"conta":     conta,
+			"tabela":    tableName,
+			"registro":  recordID,
+			"operacao":  operation,
+			"usuario":   user,
+			"auditoria": auditLogID,
+		},
+	})
+	return nil
+}
+
+// deParaConta extracts the conta from a DePara table name
+// (integration.<empresa>_<conta>.<marketplace>_base); empty for other tables
+func deParaConta(tableName string) string {
+	parts := strings.Split(tableName, ".")
+	if len(parts) != 3 || parts[0] != "integration" {
+		return ""
+	}
+	if i := strings.LastIndex(parts[1], "_"); i >= 0 {
+		return strings.ToLower(parts[1][i+1:])
+	}
+	return ""
 }
 
 
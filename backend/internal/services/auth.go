@@ -1,22 +1,35 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"sync"
 	"time"
 
+	"amz-web-tools/backend/internal/config"
 	"amz-web-tools/backend/internal/models"
 
-	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthService struct {
-	db *sql.DB
+	db     *sql.DB
+	config *config.Config
+	roles  *RoleService
+
+	// Cache of SessionActive lookups, keyed by session ID
+	sessionsMu sync.Mutex
+	sessions   map[string]sessionCacheEntry
 }
 
-func NewAuthService(db *sql.DB) *AuthService {
-	return &AuthService{db: db}
+func NewAuthService(db *sql.DB, cfg *config.Config, roles *RoleService) *AuthService {
+	return &AuthService{
+		db:       db,
+		config:   cfg,
+		roles:    roles,
+		sessions: make(map[string]sessionCacheEntry),
+	}
 }
 
 // HashPassword hashes a password using bcrypt
@@ -31,19 +44,6 @@ func (s *AuthService) CheckPassword(password, hash string) bool {
 	return err == nil
 }
 
-// GenerateJWT generates a JWT token for a user
-func (s *AuthService) GenerateJWT(userID, role, secret string, expireHours int) (string, error) {
-	claims := jwt.MapClaims{
-		"user_id": userID,
-		"role":    role,
-		"exp":     time.Now().Add(time.Hour * time.Duration(expireHours)).Unix(),
-		"iat":     time.Now().Unix(),
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
-}
-
 // RegisterUser registers a new user
 func (s *AuthService) RegisterUser(req *models.RegisterRequest) (*models.User, error) {
 	// Check if user already exists
@@ -66,10 +66,10 @@ func (s *AuthService) RegisterUser(req *models.RegisterRequest) (*models.User, e
 	query := `
 		INSERT INTO users (email, password_hash, name, department, role)
 		OUTPUT INSERTED.id, INSERTED.email, INSERTED.name, INSERTED.department, INSERTED.role, INSERTED.created_at, INSERTED.updated_at
-		VALUES (@p1, @p2, @p3, @p4, 'user')`
+		VALUES (@p1, @p2, @p3, @p4, @p5)`
 
 	var user models.User
-	err = s.db.QueryRow(query, req.Email, hashedPassword, req.Name, req.Department).Scan(
+	err = s.db.QueryRow(query, req.Email, hashedPassword, req.Name, req.Department, s.config.DefaultUserRole).Scan(
 		&user.ID, &user.Email, &user.Name, &user.Department, &user.Role, &user.CreatedAt, &user.UpdatedAt,
 	)
 
@@ -181,6 +181,9 @@ func (s *AuthService) CreateUser(req *models.CreateUserRequest) (*models.User, e
 	if count > 0 {
 		return nil, fmt.Errorf("user already exists")
 	}
+	if err := s.checkRole(req.Role); err != nil {
+		return nil, err
+	}
 
 	// Hash password
 	hashedPassword, err := s.HashPassword(req.Password)
@@ -234,8 +237,13 @@ func (s *AuthService) GetAllUsers() ([]models.User, error) {
 	return users, nil
 }
 
-// UpdateUser updates a user (Admin only)
+// UpdateUser updates a user (Admin only). A new role takes effect on the
+// user's next token refresh.
 func (s *AuthService) UpdateUser(req *models.UpdateUserRequest) (*models.User, error) {
+	if err := s.checkRole(req.Role); err != nil {
+		return nil, err
+	}
+
 	query := `
 		UPDATE users 
 		SET name = @p1, department = @p2, role = @p3, updated_at = GETDATE()
@@ -291,6 +299,18 @@ func (s *AuthService) ChangePasswordFirstLogin(userID, newPassword string) error
 	return err
 }
 
+// checkRole rejects roles that are not registered
+func (s *AuthService) checkRole(role string) error {
+	exists, err := s.roles.Exists(context.Background(), role)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrRoleNotFound, role)
+	}
+	return nil
+}
+
 // DeleteUser deletes a user (Admin only)
 func (s *AuthService) DeleteUser(userID string) error {
 	_, err := s.db.Exec("DELETE FROM users WHERE id = @p1", userID)
@@ -1,12 +1,12 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"strings"
 	"time"
 
@@ -14,21 +14,24 @@ import (
 	_ "github.com/sijms/go-ora/v2" // Oracle driver
 
 	"amz-web-tools/backend/internal/config"
+	"amz-web-tools/backend/internal/httpclient"
 	"amz-web-tools/backend/internal/models"
 )
 
 type CarPlateService struct {
 	db     *sql.DB
 	Config *config.Config
+	client *httpclient.Client
 }
 
 // PlateAPIResponse represents the raw JSON response from the API
 type PlateAPIResponse map[string]interface{}
 
-func NewCarPlateService(db *sql.DB, cfg *config.Config) *CarPlateService {
+func NewCarPlateService(db *sql.DB, cfg *config.Config, client *httpclient.Client) *CarPlateService {
 	return &CarPlateService{
 		db:     db,
 		Config: cfg,
+		client: client,
 	}
 }
 
@@ -44,36 +47,39 @@ type PlateResult struct {
 }
 
 // GetCarPlate retrieves car plate information with caching
-func (s *CarPlateService) GetCarPlate(plate string, userID string) (*PlateResult, error) {
+func (s *CarPlateService) GetCarPlate(ctx context.Context, plate string, userID string) (*PlateResult, error) {
+	ctx, cancel := withDeadline(ctx, s.Config.PlateLookupTimeoutSeconds)
+	defer cancel()
+
 	// Normalize plate (remove spaces, convert to uppercase)
 	plate = strings.ToUpper(strings.ReplaceAll(plate, " ", ""))
 
 	log.Printf("Searching for plate: %s", plate)
 
 	// First, check cache
-	cachedData, err := s.getFromCache(plate)
+	cachedData, err := s.getFromCache(ctx, plate)
 	if err == nil && cachedData != nil {
 		log.Printf("✅ Plate %s found in cache", plate)
 		// Save to history even if from cache
-		s.saveToHistory(plate, cachedData, "success", "", userID)
+		s.saveToHistory(ctx, plate, cachedData, "success", "", userID)
 		return &PlateResult{Data: cachedData, Source: "cache"}, nil
 	}
 
 	log.Printf("❌ Plate %s not in cache, fetching from API", plate)
 
 	// If not in cache, fetch from API
-	apiData, err := s.fetchFromAPI(plate)
+	apiData, err := s.fetchFromAPI(ctx, plate)
 	if err != nil {
 		log.Printf("❌ Failed to fetch plate %s from API: %v", plate, err)
 		// Save error to history
-		s.saveToHistory(plate, nil, "error", err.Error(), userID)
+		s.saveToHistory(ctx, plate, nil, "error", err.Error(), userID)
 		return nil, fmt.Errorf("failed to fetch from API: %w", err)
 	}
 
 	log.Printf("✅ Successfully fetched plate %s from API", plate)
 
 	// Cache the result
-	if err := s.saveToCache(plate, apiData); err != nil {
+	if err := s.saveToCache(ctx, plate, apiData); err != nil {
 		log.Printf("⚠️ Warning: Failed to cache plate %s: %v", plate, err)
 		// Don't return error, just log warning
 	} else {
@@ -81,19 +87,19 @@ func (s *CarPlateService) GetCarPlate(plate string, userID string) (*PlateResult
 	}
 
 	// Save to history
-	s.saveToHistory(plate, apiData, "success", "", userID)
+	s.saveToHistory(ctx, plate, apiData, "success", "", userID)
 
 	return &PlateResult{Data: apiData, Source: "api"}, nil
 }
 
 // getFromCache retrieves plate data from database cache
-func (s *CarPlateService) getFromCache(plate string) (*PlateAPIResponse, error) {
+func (s *CarPlateService) getFromCache(ctx context.Context, plate string) (*PlateAPIResponse, error) {
 	query := `
 		SELECT data FROM plate_cache 
 		WHERE plate = @p1 AND expires_at > GETDATE()`
 
 	var dataJSON string
-	err := s.db.QueryRow(query, sql.Named("p1", plate)).Scan(&dataJSON)
+	err := s.db.QueryRowContext(ctx, query, sql.Named("p1", plate)).Scan(&dataJSON)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // Not found in cache
@@ -110,19 +116,14 @@ func (s *CarPlateService) getFromCache(plate string) (*PlateAPIResponse, error)
 }
 
 // fetchFromAPI fetches plate data from external API
-func (s *CarPlateService) fetchFromAPI(plate string) (*PlateAPIResponse, error) {
+func (s *CarPlateService) fetchFromAPI(ctx context.Context, plate string) (*PlateAPIResponse, error) {
 	// Replace PLACA placeholder in URL
 	apiURL := strings.Replace(s.Config.PlateAPIURL, "PLACA", plate, 1)
 
 	log.Printf("🌐 Fetching from API: %s", apiURL)
 
-	// Create HTTP client with timeout
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-	}
-
-	// Make HTTP request
-	resp, err := client.Get(apiURL)
+	// Make HTTP request (retries and circuit breaker come from the shared client)
+	resp, err := s.client.Get(ctx, apiURL)
 	if err != nil {
 		log.Printf("❌ HTTP request failed: %v", err)
 		return nil, fmt.Errorf("HTTP request failed: %w", err)
@@ -159,7 +160,7 @@ func (s *CarPlateService) fetchFromAPI(plate string) (*PlateAPIResponse, error)
 }
 
 // saveToCache saves plate data to database cache
-func (s *CarPlateService) saveToCache(plate string, data *PlateAPIResponse) error {
+func (s *CarPlateService) saveToCache(ctx context.Context, plate string, data *PlateAPIResponse) error {
 	// Convert to JSON
 	dataJSON, err := json.Marshal(data)
 	if err != nil {
@@ -178,7 +179,7 @@ func (s *CarPlateService) saveToCache(plate string, data *PlateAPIResponse) erro
 		WHEN NOT MATCHED THEN
 			INSERT (plate, data, expires_at) VALUES (source.plate, source.data, source.expires_at);`
 
-	_, err = s.db.Exec(query, plate, string(dataJSON), expiresAt)
+	_, err = s.db.ExecContext(ctx, query, plate, string(dataJSON), expiresAt)
 	if err != nil {
 		return fmt.Errorf("failed to save to cache: %w", err)
 	}
@@ -187,7 +188,7 @@ func (s *CarPlateService) saveToCache(plate string, data *PlateAPIResponse) erro
 }
 
 // saveToHistory saves consultation to history table
-func (s *CarPlateService) saveToHistory(plate string, data *PlateAPIResponse, status, errorMessage, userID string) {
+func (s *CarPlateService) saveToHistory(ctx context.Context, plate string, data *PlateAPIResponse, status, errorMessage, userID string) {
 	var responseData string
 	if data != nil {
 		jsonData, err := json.Marshal(data)
@@ -200,7 +201,7 @@ func (s *CarPlateService) saveToHistory(plate string, data *PlateAPIResponse, st
 		INSERT INTO car_plate_history (plate, response_data, status, error_message, user_id)
 		VALUES (@p1, @p2, @p3, @p4, @p5)`
 
-	_, err := s.db.Exec(query, plate, responseData, status, errorMessage, userID)
+	_, err := s.db.ExecContext(ctx, query, plate, responseData, status, errorMessage, userID)
 	if err != nil {
 		log.Printf("⚠️ Warning: Failed to save plate %s to history: %v", plate, err)
 	} else {
@@ -209,14 +210,17 @@ func (s *CarPlateService) saveToHistory(plate string, data *PlateAPIResponse, st
 }
 
 // GetPlateHistory retrieves search history for a user
-func (s *CarPlateService) GetPlateHistory(userID string, limit int) ([]models.CarPlateHistory, error) {
+func (s *CarPlateService) GetPlateHistory(ctx context.Context, userID string, limit int) ([]models.CarPlateHistory, error) {
+	ctx, cancel := withDeadline(ctx, s.Config.DBQueryTimeoutSeconds)
+	defer cancel()
+
 	query := `
 		SELECT TOP (@p1) id, plate, response_data, status, error_message, created_at, user_id
 		FROM car_plate_history 
 		WHERE user_id = @p2 OR user_id IS NULL
 		ORDER BY created_at DESC`
 
-	rows, err := s.db.Query(query, sql.Named("p1", limit), sql.Named("p2", userID))
+	rows, err := s.db.QueryContext(ctx, query, sql.Named("p1", limit), sql.Named("p2", userID))
 	if err != nil {
 		return nil, err
 	}
@@ -236,10 +240,13 @@ func (s *CarPlateService) GetPlateHistory(userID string, limit int) ([]models.Ca
 }
 
 // CleanExpiredCache removes expired cache entries
-func (s *CarPlateService) CleanExpiredCache() error {
+func (s *CarPlateService) CleanExpiredCache(ctx context.Context) error {
+	ctx, cancel := withDeadline(ctx, s.Config.DBQueryTimeoutSeconds)
+	defer cancel()
+
 	query := `DELETE FROM plate_cache WHERE expires_at < GETDATE()`
 
-	result, err := s.db.Exec(query)
+	result, err := s.db.ExecContext(ctx, query)
 	if err != nil {
 		return err
 	}
@@ -259,12 +266,15 @@ type DashboardStats struct {
 }
 
 // GetDashboardStats retrieves dashboard statistics
-func (s *CarPlateService) GetDashboardStats() (*DashboardStats, error) {
+func (s *CarPlateService) GetDashboardStats(ctx context.Context) (*DashboardStats, error) {
+	ctx, cancel := withDeadline(ctx, s.Config.DBQueryTimeoutSeconds)
+	defer cancel()
+
 	stats := &DashboardStats{}
 
 	// 1. Consultas de Placa - contagem da tabela plate_cache no SQL Server
 	query1 := `SELECT COUNT(*) FROM portal.dbo.plate_cache`
-	err := s.db.QueryRow(query1).Scan(&stats.CarPlateQueries)
+	err := s.db.QueryRowContext(ctx, query1).Scan(&stats.CarPlateQueries)
 	if err != nil {
 		log.Printf("Error getting car plate queries count: %v", err)
 		stats.CarPlateQueries = 0
@@ -285,7 +295,7 @@ func (s *CarPlateService) GetDashboardStats() (*DashboardStats, error) {
 	for _, table := range deparaTables {
 		query := fmt.Sprintf(`SELECT COUNT(*) FROM %s`, table)
 		var count int
-		err := s.db.QueryRow(query).Scan(&count)
+		err := s.db.QueryRowContext(ctx, query).Scan(&count)
 		if err != nil {
 			log.Printf("⚠️ Error getting count from %s: %v", table, err)
 			// Continue with other tables even if one fails
@@ -301,14 +311,14 @@ func (s *CarPlateService) GetDashboardStats() (*DashboardStats, error) {
 	// 3. Importações XML - contagem da tabela codako_bi.integrator.fato_statusvenda no PostgreSQL
 	// Usando a consulta correta fornecida pelo usuário
 	log.Printf("🔍 Calling GetXMLImportsCount...")
-	xmlCount := s.GetXMLImportsCount()
+	xmlCount := s.GetXMLImportsCount(ctx)
 	log.Printf("🔍 XML imports result: %d", xmlCount)
 
 	// Se PostgreSQL falhar, usar fallback do SQL Server
 	if xmlCount == 0 {
 		log.Printf("🔄 PostgreSQL failed, using SQL Server fallback for XML imports")
 		query3 := `SELECT COUNT(*) FROM xml_integrator_logs`
-		err = s.db.QueryRow(query3).Scan(&stats.XMLImports)
+		err = s.db.QueryRowContext(ctx, query3).Scan(&stats.XMLImports)
 		if err != nil {
 			log.Printf("Error getting XML imports fallback count: %v", err)
 			stats.XMLImports = 0
@@ -322,14 +332,17 @@ func (s *CarPlateService) GetDashboardStats() (*DashboardStats, error) {
 	// 4. Itens em Estoque - contagem da tabela nbs.CRANI_PECAS_ITENS no Oracle
 	// Usando a consulta correta fornecida pelo usuário
 	log.Printf("🔍 Calling GetStockItemsCount...")
-	stats.StockItems = s.GetStockItemsCount()
+	stats.StockItems = s.GetStockItemsCount(ctx)
 	log.Printf("🔍 Stock items result: %d", stats.StockItems)
 
 	return stats, nil
 }
 
 // GetXMLImportsCount retrieves XML imports count from PostgreSQL
-func (s *CarPlateService) GetXMLImportsCount() int {
+func (s *CarPlateService) GetXMLImportsCount(ctx context.Context) int {
+	ctx, cancel := withDeadline(ctx, s.Config.DBQueryTimeoutSeconds)
+	defer cancel()
+
 	log.Printf("🔍 Starting XML imports count query...")
 
 	// Verificar se as configurações PostgreSQL estão disponíveis
@@ -352,7 +365,7 @@ func (s *CarPlateService) GetXMLImportsCount() int {
 	defer pgDB.Close()
 
 	// Testar conexão com timeout
-	if err := pgDB.Ping(); err != nil {
+	if err := pgDB.PingContext(ctx); err != nil {
 		log.Printf("❌ Error pinging PostgreSQL: %v", err)
 		return 0 // Usar fallback para SQL Server
 	}
@@ -364,7 +377,7 @@ func (s *CarPlateService) GetXMLImportsCount() int {
 	log.Printf("🔍 Executing query: %s", query)
 
 	var count int
-	err = pgDB.QueryRow(query).Scan(&count)
+	err = pgDB.QueryRowContext(ctx, query).Scan(&count)
 	if err != nil {
 		log.Printf("❌ Error getting XML imports count from PostgreSQL: %v", err)
 		return 0 // Usar fallback para SQL Server
@@ -375,7 +388,10 @@ func (s *CarPlateService) GetXMLImportsCount() int {
 }
 
 // GetStockItemsCount retrieves stock items count from Oracle
-func (s *CarPlateService) GetStockItemsCount() int {
+func (s *CarPlateService) GetStockItemsCount(ctx context.Context) int {
+	ctx, cancel := withDeadline(ctx, s.Config.StockQueryTimeoutSeconds)
+	defer cancel()
+
 	log.Printf("🔍 Starting stock items count query...")
 
 	// Verificar se as configurações Oracle estão disponíveis
@@ -398,7 +414,7 @@ func (s *CarPlateService) GetStockItemsCount() int {
 	defer oracleDB.Close()
 
 	// Testar conexão
-	if err := oracleDB.Ping(); err != nil {
+	if err := oracleDB.PingContext(ctx); err != nil {
 		log.Printf("❌ Error pinging Oracle: %v", err)
 		return 888
 	}
@@ -410,7 +426,7 @@ func (s *CarPlateService) GetStockItemsCount() int {
 	log.Printf("🔍 Executing query: %s", query)
 
 	var count int
-	err = oracleDB.QueryRow(query).Scan(&count)
+	err = oracleDB.QueryRowContext(ctx, query).Scan(&count)
 	if err != nil {
 		log.Printf("❌ Error getting stock items count from Oracle: %v", err)
 		return 888
@@ -0,0 +1,16 @@
+package services
+
+import (
+	"context"
+	"time"
+)
+
+// withDeadline limita a operação ao deadline configurado, em segundos, sem
+// perder o cancelamento do contexto do chamador (requisição encerrada ou
+// servidor desligando). seconds <= 0 desativa o deadline.
+func withDeadline(ctx context.Context, seconds int) (context.Context, context.CancelFunc) {
+	if seconds <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(seconds)*time.Second)
+}
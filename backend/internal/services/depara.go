@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -8,23 +9,30 @@ import (
 	"strings"
 	"time"
 
+	"amz-web-tools/backend/internal/config"
 	"amz-web-tools/backend/internal/models"
 )
 
 type DeParaService struct {
 	db           *sql.DB
 	auditService *AuditService
+	config       *config.Config
 }
 
-func NewDeParaService(db *sql.DB, auditService *AuditService) *DeParaService {
+func NewDeParaService(db *sql.DB, auditService *AuditService, cfg *config.Config) *DeParaService {
 	return &DeParaService{
 		db:           db,
 		auditService: auditService,
+		config:       cfg,
 	}
 }
 
-// GetAvailableTables returns list of available integration tables
-func (s *DeParaService) GetAvailableTables() ([]models.IntegrationTable, error) {
+// GetAvailableTables returns list of available integration tables within the
+// scope
+func (s *DeParaService) GetAvailableTables(ctx context.Context, scope Scope) ([]models.IntegrationTable, error) {
+	ctx, cancel := withDeadline(ctx, s.config.DBQueryTimeoutSeconds)
+	defer cancel()
+
 	log.Printf("🔍 Starting GetAvailableTables...")
 
 	// Since INFORMATION_SCHEMA doesn't show these tables properly, we'll use the verified list
@@ -42,12 +50,16 @@ func (s *DeParaService) GetAvailableTables() ([]models.IntegrationTable, error)
 	id := 1
 
 	for _, tableName := range verifiedTables {
+		if !scope.Allows(deParaConta(tableName)) {
+			continue
+		}
+
 		log.Printf("🔍 Checking table: %s", tableName)
 
 		// Check if table exists by trying to query it
 		query := fmt.Sprintf("SELECT COUNT(*) FROM %s", tableName)
 		var count int
-		err := s.db.QueryRow(query).Scan(&count)
+		err := s.db.QueryRowContext(ctx, query).Scan(&count)
 		if err != nil {
 			log.Printf("⚠️ Table %s not accessible: %v", tableName, err)
 			continue
@@ -92,7 +104,7 @@ func (s *DeParaService) GetAvailableTables() ([]models.IntegrationTable, error)
 }
 
 // GetTableOptions returns available options for dynamic table selection
-func (s *DeParaService) GetTableOptions() (map[string][]string, error) {
+func (s *DeParaService) GetTableOptions(ctx context.Context) (map[string][]string, error) {
 	// Based on the 6 verified tables, return the available options
 	options := map[string][]string{
 		"empresa":     {"amazonas"},
@@ -105,7 +117,7 @@ func (s *DeParaService) GetTableOptions() (map[string][]string, error) {
 }
 
 // hasColumn checks if a column exists in the specified table
-func (s *DeParaService) hasColumn(tableName, columnName string) (bool, error) {
+func (s *DeParaService) hasColumn(ctx context.Context, tableName, columnName string) (bool, error) {
 	// Extract table name from full table name (e.g., "integration.amazonas_psa.mercadolivre_base" -> "amazonas_psa.mercadolivre_base")
 	parts := strings.Split(tableName, ".")
 	if len(parts) < 2 {
@@ -123,7 +135,7 @@ func (s *DeParaService) hasColumn(tableName, columnName string) (bool, error) {
 		AND COLUMN_NAME = @p2`
 
 	var count int
-	err := s.db.QueryRow(query, tablePart, columnName).Scan(&count)
+	err := s.db.QueryRowContext(ctx, query, tablePart, columnName).Scan(&count)
 	if err != nil {
 		return false, err
 	}
@@ -132,7 +144,14 @@ func (s *DeParaService) hasColumn(tableName, columnName string) (bool, error) {
 }
 
 // SearchProducts searches products based on criteria with pagination
-func (s *DeParaService) SearchProducts(tableName, query, searchBy string, page, pageSize int) ([]models.DeParaProduct, int, error) {
+func (s *DeParaService) SearchProducts(ctx context.Context, scope Scope, tableName, query, searchBy string, page, pageSize int) ([]models.DeParaProduct, int, error) {
+	if err := scope.check(s.TableConta(tableName)); err != nil {
+		return nil, 0, err
+	}
+
+	ctx, cancel := withDeadline(ctx, s.config.DBQueryTimeoutSeconds)
+	defer cancel()
+
 	var whereClause string
 	var args []interface{}
 
@@ -171,7 +190,7 @@ func (s *DeParaService) SearchProducts(tableName, query, searchBy string, page,
 	// Count total results
 	countQuery := fmt.Sprintf(`SELECT COUNT(*) FROM %s %s`, actualTableName, whereClause)
 	var totalCount int
-	err := s.db.QueryRow(countQuery, args...).Scan(&totalCount)
+	err := s.db.QueryRowContext(ctx, countQuery, args...).Scan(&totalCount)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to count products: %w", err)
 	}
@@ -180,7 +199,7 @@ func (s *DeParaService) SearchProducts(tableName, query, searchBy string, page,
 	offset := (page - 1) * pageSize
 
 	// Check if permalink column exists in this table
-	hasPermalink, err := s.hasColumn(actualTableName, "permalink")
+	hasPermalink, err := s.hasColumn(ctx, actualTableName, "permalink")
 	if err != nil {
 		log.Printf("⚠️ Warning: Could not check for permalink column: %v", err)
 		hasPermalink = false
@@ -205,7 +224,7 @@ func (s *DeParaService) SearchProducts(tableName, query, searchBy string, page,
 
 	log.Printf("🔍 Searching in %s with query: %s", actualTableName, querySQL)
 
-	rows, err := s.db.Query(querySQL, args...)
+	rows, err := s.db.QueryContext(ctx, querySQL, args...)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to search products: %w", err)
 	}
@@ -277,7 +296,14 @@ func (s *DeParaService) SearchProducts(tableName, query, searchBy string, page,
 }
 
 // GetProductByID gets a single product by ID
-func (s *DeParaService) GetProductByID(tableName, id string) (*models.DeParaProduct, error) {
+func (s *DeParaService) GetProductByID(ctx context.Context, scope Scope, tableName, id string) (*models.DeParaProduct, error) {
+	if err := scope.check(s.TableConta(tableName)); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withDeadline(ctx, s.config.DBQueryTimeoutSeconds)
+	defer cancel()
+
 	actualTableName := s.buildTableName(tableName)
 	query := fmt.Sprintf(`
 		SELECT id, mlbu, type, sku, company, permalink, 
@@ -289,7 +315,7 @@ func (s *DeParaService) GetProductByID(tableName, id string) (*models.DeParaProd
 	var product models.DeParaProduct
 	var picturesJSON string
 
-	err := s.db.QueryRow(query, id).Scan(
+	err := s.db.QueryRowContext(ctx, query, id).Scan(
 		&product.ID, &product.MLBU, &product.Type, &product.SKU, &product.Company,
 		&product.Permalink, &product.ShipCostSlow, &product.ShipCostStandard,
 		&product.ShipCostNextday, &picturesJSON, &product.UpdatedAt, &product.CreatedAt,
@@ -322,7 +348,14 @@ func (s *DeParaService) GetProductByID(tableName, id string) (*models.DeParaProd
 }
 
 // CreateProduct creates a new product
-func (s *DeParaService) CreateProduct(req models.CreateDeParaRequest, userID, userEmail, userName, ipAddress, userAgent string) error {
+func (s *DeParaService) CreateProduct(ctx context.Context, scope Scope, req models.CreateDeParaRequest, userID, userEmail, userName, ipAddress, userAgent string) error {
+	if err := scope.check(s.TableConta(req.TableName)); err != nil {
+		return err
+	}
+
+	ctx, cancel := withDeadline(ctx, s.config.DBQueryTimeoutSeconds)
+	defer cancel()
+
 	actualTableName := s.buildTableName(req.TableName)
 	query := fmt.Sprintf(`
 		INSERT INTO %s (id, mlbu, type, sku, company, permalink, pictures)
@@ -339,7 +372,7 @@ func (s *DeParaService) CreateProduct(req models.CreateDeParaRequest, userID, us
 	permalink := fmt.Sprintf("https://produto.mercadolivre.com.br/%s", req.ID)
 	picturesJSON := "[]" // Empty array for new products
 
-	_, err := s.db.Exec(query, req.ID, req.MLBU, req.Type, req.SKU, req.Company, permalink, picturesJSON)
+	_, err := s.db.ExecContext(ctx, query, req.ID, req.MLBU, req.Type, req.SKU, req.Company, permalink, picturesJSON)
 	if err != nil {
 		return fmt.Errorf("failed to create product: %w", err)
 	}
@@ -363,7 +396,8 @@ func (s *DeParaService) CreateProduct(req models.CreateDeParaRequest, userID, us
 		UserAgent:     userAgent,
 	}
 
-	if err := s.auditService.LogOperation(auditReq, userID, userEmail, userName); err != nil {
+	// The change is already committed: the audit log must not be lost if the client disconnects
+	if err := s.auditService.LogOperation(context.WithoutCancel(ctx), auditReq, userID, userEmail, userName); err != nil {
 		log.Printf("⚠️ Warning: Failed to log audit for CREATE: %v", err)
 	}
 
@@ -372,11 +406,18 @@ func (s *DeParaService) CreateProduct(req models.CreateDeParaRequest, userID, us
 }
 
 // UpdateProduct updates an existing product
-func (s *DeParaService) UpdateProduct(tableName, id string, req models.UpdateDeParaRequest, userID, userEmail, userName, ipAddress, userAgent string) error {
+func (s *DeParaService) UpdateProduct(ctx context.Context, scope Scope, tableName, id string, req models.UpdateDeParaRequest, userID, userEmail, userName, ipAddress, userAgent string) error {
+	if err := scope.check(s.TableConta(tableName)); err != nil {
+		return err
+	}
+
+	ctx, cancel := withDeadline(ctx, s.config.DBQueryTimeoutSeconds)
+	defer cancel()
+
 	actualTableName := s.buildTableName(tableName)
 
 	// Get old values before update
-	oldProduct, err := s.GetProductByID(tableName, id)
+	oldProduct, err := s.GetProductByID(ctx, scope, tableName, id)
 	if err != nil {
 		return fmt.Errorf("failed to get product for audit: %w", err)
 	}
@@ -386,7 +427,7 @@ func (s *DeParaService) UpdateProduct(tableName, id string, req models.UpdateDeP
 		SET sku = @p1, company = @p2, updated_at = GETDATE()
 		WHERE id = @p3`, actualTableName)
 
-	result, err := s.db.Exec(query, req.SKU, req.Company, id)
+	result, err := s.db.ExecContext(ctx, query, req.SKU, req.Company, id)
 	if err != nil {
 		return fmt.Errorf("failed to update product: %w", err)
 	}
@@ -424,7 +465,7 @@ func (s *DeParaService) UpdateProduct(tableName, id string, req models.UpdateDeP
 			UserAgent:     userAgent,
 		}
 
-		if err := s.auditService.LogOperation(auditReq, userID, userEmail, userName); err != nil {
+		if err := s.auditService.LogOperation(context.WithoutCancel(ctx), auditReq, userID, userEmail, userName); err != nil {
 			log.Printf("⚠️ Warning: Failed to log audit for UPDATE: %v", err)
 		}
 	}
@@ -434,18 +475,25 @@ func (s *DeParaService) UpdateProduct(tableName, id string, req models.UpdateDeP
 }
 
 // DeleteProduct deletes a product
-func (s *DeParaService) DeleteProduct(tableName, id string, userID, userEmail, userName, ipAddress, userAgent string) error {
+func (s *DeParaService) DeleteProduct(ctx context.Context, scope Scope, tableName, id string, userID, userEmail, userName, ipAddress, userAgent string) error {
+	if err := scope.check(s.TableConta(tableName)); err != nil {
+		return err
+	}
+
+	ctx, cancel := withDeadline(ctx, s.config.DBQueryTimeoutSeconds)
+	defer cancel()
+
 	actualTableName := s.buildTableName(tableName)
 
 	// Get old values before delete
-	oldProduct, err := s.GetProductByID(tableName, id)
+	oldProduct, err := s.GetProductByID(ctx, scope, tableName, id)
 	if err != nil {
 		return fmt.Errorf("failed to get product for audit: %w", err)
 	}
 
 	query := fmt.Sprintf(`DELETE FROM %s WHERE id = @p1`, actualTableName)
 
-	result, err := s.db.Exec(query, id)
+	result, err := s.db.ExecContext(ctx, query, id)
 	if err != nil {
 		return fmt.Errorf("failed to delete product: %w", err)
 	}
@@ -474,7 +522,7 @@ func (s *DeParaService) DeleteProduct(tableName, id string, userID, userEmail, u
 		UserAgent:     userAgent,
 	}
 
-	if err := s.auditService.LogOperation(auditReq, userID, userEmail, userName); err != nil {
+	if err := s.auditService.LogOperation(context.WithoutCancel(ctx), auditReq, userID, userEmail, userName); err != nil {
 		log.Printf("⚠️ Warning: Failed to log audit for DELETE: %v", err)
 	}
 
@@ -482,6 +530,13 @@ func (s *DeParaService) DeleteProduct(tableName, id string, userID, userEmail, u
 	return nil
 }
 
+// TableConta returns the conta of a DePara table (psa for
+// integration.amazonas_psa.mercadolivre_base), or "" when the name is not a
+// DePara table
+func (s *DeParaService) TableConta(tableName string) string {
+	return deParaConta(s.buildTableName(tableName))
+}
+
 // buildTableName constructs table name from empresa, conta, marketplace
 func (s *DeParaService) buildTableName(tableConfig string) string {
 	// If tableConfig is already a full table name, return it
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"amz-web-tools/backend/internal/httpclient"
+)
+
+const ibgeMunicipiosURL = "https://servicodados.ibge.gov.br/api/v1/localidades/estados/%s/municipios"
+
+// IBGECityResolver resolve o código IBGE de um município (usado em codCidades
+// no NBS). A lista de municípios de cada UF é baixada uma única vez, fora do
+// lock; consultas concorrentes da mesma UF podem baixá-la em paralelo.
+type IBGECityResolver struct {
+	client *httpclient.Client
+
+	mu     sync.Mutex
+	cities map[string]map[string]string // UF -> nome normalizado -> código IBGE
+}
+
+func NewIBGECityResolver(client *httpclient.Client) *IBGECityResolver {
+	return &IBGECityResolver{
+		client: client.WithTimeout(15 * time.Second),
+		cities: make(map[string]map[string]string),
+	}
+}
+
+// CityCode retorna o código IBGE do município na UF informada
+func (r *IBGECityResolver) CityCode(ctx context.Context, uf, city string) (string, error) {
+	uf = strings.ToUpper(strings.TrimSpace(uf))
+
+	cities, err := r.citiesOf(ctx, uf)
+	if err != nil {
+		return "", err
+	}
+
+	code, ok := cities[normalizeName(city)]
+	if !ok {
+		return "", fmt.Errorf("município %s não encontrado no IBGE para a UF %s", city, uf)
+	}
+	return code, nil
+}
+
+func (r *IBGECityResolver) citiesOf(ctx context.Context, uf string) (map[string]string, error) {
+	r.mu.Lock()
+	cities, ok := r.cities[uf]
+	r.mu.Unlock()
+	if ok {
+		return cities, nil
+	}
+
+	cities, err := r.fetchCities(ctx, uf)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.cities[uf] = cities
+	r.mu.Unlock()
+
+	return cities, nil
+}
+
+// fetchCities baixa a lista de municípios da UF no IBGE
+func (r *IBGECityResolver) fetchCities(ctx context.Context, uf string) (map[string]string, error) {
+	log.Printf("🗺️ Carregando municípios do IBGE para a UF %s...", uf)
+
+	resp, err := r.client.Get(ctx, fmt.Sprintf(ibgeMunicipiosURL, uf))
+	if err != nil {
+		return nil, fmt.Errorf("erro ao consultar municípios do IBGE: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("erro ao consultar municípios do IBGE: status %d", resp.StatusCode)
+	}
+
+	var municipios []struct {
+		ID   int64  `json:"id"`
+		Nome string `json:"nome"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&municipios); err != nil {
+		return nil, fmt.Errorf("erro ao decodificar municípios do IBGE: %w", err)
+	}
+	if len(municipios) == 0 {
+		return nil, fmt.Errorf("nenhum município retornado pelo IBGE para a UF %s", uf)
+	}
+
+	cities := make(map[string]string, len(municipios))
+	for _, municipio := range municipios {
+		cities[normalizeName(municipio.Nome)] = fmt.Sprintf("%d", municipio.ID)
+	}
+
+	return cities, nil
+}
+
+var accentReplacer = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ã", "a", "ä", "a",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ó", "o", "ò", "o", "ô", "o", "õ", "o", "ö", "o",
+	"ú", "u", "ù", "u", "û", "u", "ü", "u",
+	"ç", "c", "ñ", "n",
+	"'", "", "-", " ",
+)
+
+// normalizeName deixa um nome de cidade ou estado comparável: minúsculo, sem
+// acentos, hífens ou espaços repetidos
+func normalizeName(name string) string {
+	name = accentReplacer.Replace(strings.ToLower(strings.TrimSpace(name)))
+	return strings.Join(strings.Fields(name), " ")
+}
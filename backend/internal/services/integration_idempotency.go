@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"amz-web-tools/backend/internal/models"
+)
+
+var (
+	// ErrIdempotencyKeyReused indica uma idempotency key já usada para outro pedido
+	ErrIdempotencyKeyReused = errors.New("idempotency key já usada para outro pedido")
+	// ErrOrderLocked indica um pedido sendo processado por outro job
+	ErrOrderLocked = errors.New("pedido em processamento por outro job")
+)
+
+// jobByIdempotencyKey retorna o job criado por uma requisição anterior com a
+// mesma key, ou nil se a key é nova
+func (s *IntegrationService) jobByIdempotencyKey(ctx context.Context, req IntegrationRequest) (*models.IntegrationLog, error) {
+	query := `
+		SELECT CAST(job_id AS NVARCHAR(36)), COALESCE(marketplace, ''), COALESCE(num_pedido, '')
+		FROM integration_idempotency_keys
+		WHERE idempotency_key = @p1`
+
+	var jobID, marketplace, numPedido string
+	err := s.sqlDB.QueryRowContext(ctx, query, req.IdempotencyKey).Scan(&jobID, &marketplace, &numPedido)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar idempotency key: %w", err)
+	}
+
+	if numPedido != req.NumPedido || !strings.EqualFold(marketplace, req.Marketplace) {
+		return nil, fmt.Errorf("%w (pedido %s)", ErrIdempotencyKeyReused, numPedido)
+	}
+
+	log.Printf("🔁 Idempotency key repetida para o pedido %s, retornando o job %s", numPedido, jobID)
+	return s.GetIntegrationJob(ctx, jobID)
+}
+
+func (s *IntegrationService) saveIdempotencyKey(ctx context.Context, tx *sql.Tx, req IntegrationRequest, jobID string) error {
+	query := `
+		INSERT INTO integration_idempotency_keys (idempotency_key, job_id, conta, marketplace, num_pedido)
+		VALUES (@p1, @p2, @p3, @p4, @p5)`
+
+	_, err := tx.ExecContext(ctx, query, req.IdempotencyKey, jobID, strings.ToLower(req.Conta), req.Marketplace, req.NumPedido)
+	return err
+}
+
+// activeJobForOrder retorna o job pendente ou em execução do pedido, se houver
+func (s *IntegrationService) activeJobForOrder(ctx context.Context, marketplace, numPedido string) (*models.IntegrationLog, error) {
+	query := `
+		SELECT TOP 1 CAST(id AS NVARCHAR(36))
+		FROM integration_logs
+		WHERE process_type = @p1 AND marketplace = @p2 AND num_pedido = @p3 AND status IN (@p4, @p5)
+		ORDER BY created_at DESC`
+
+	var jobID string
+	err := s.sqlDB.QueryRowContext(ctx, query, integrationProcessType, marketplace, numPedido,
+		IntegrationJobPending, IntegrationJobRunning).Scan(&jobID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao verificar jobs do pedido: %w", err)
+	}
+
+	return s.GetIntegrationJob(ctx, jobID)
+}
+
+// lockOrder obtém o advisory lock do Postgres do pedido. O lock é de sessão,
+// então fica preso a uma conexão dedicada até a função retornada ser chamada;
+// se outro job já tem o lock, retorna ErrOrderLocked sem esperar. O lock é
+// liberado mesmo que ctx já tenha sido cancelado.
+func (s *IntegrationService) lockOrder(ctx context.Context, marketplace, numPedido string) (func(), error) {
+	if s.pgDB == nil {
+		// A validação falha sem o PostgreSQL, então não há o que proteger
+		return func() {}, nil
+	}
+
+	conn, err := s.pgDB.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao obter conexão para o lock do pedido: %w", err)
+	}
+
+	key := fmt.Sprintf("integration:%s:%s", strings.ToLower(marketplace), numPedido)
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock(hashtext($1))`, key).Scan(&locked); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("erro ao obter lock do pedido: %w", err)
+	}
+	if !locked {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %s", ErrOrderLocked, numPedido)
+	}
+
+	return func() {
+		if _, err := conn.ExecContext(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock(hashtext($1))`, key); err != nil {
+			log.Printf("⚠️ Erro ao liberar lock do pedido %s: %v", numPedido, err)
+		}
+		conn.Close()
+	}, nil
+}
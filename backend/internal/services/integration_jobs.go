@@ -0,0 +1,1110 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"amz-web-tools/backend/internal/models"
+	"amz-web-tools/backend/internal/notify"
+	"amz-web-tools/backend/internal/websocket"
+
+	"github.com/lib/pq"
+)
+
+// Etapas do job de integração, na ordem em que são executadas.
+// A etapa gravada em integration_logs.step é sempre a próxima a executar,
+// então um job que falhou é retomado exatamente da etapa que falhou.
+const (
+	integrationStepValidacao     = "validacao"
+	integrationStepPedidoML      = "pedido_ml"
+	integrationStepEmpresa       = "empresa_fornecedor"
+	integrationStepCliente       = "cliente"
+	integrationStepEndereco      = "endereco"
+	integrationStepStatusInicial = "status_inicial"
+	integrationStepPedidoNBS     = "pedido_nbs"
+	integrationStepStatusFinal   = "status_final"
+	integrationStepConcluido     = "concluido"
+)
+
+var integrationSteps = []string{
+	integrationStepValidacao,
+	integrationStepPedidoML,
+	integrationStepEmpresa,
+	integrationStepCliente,
+	integrationStepEndereco,
+	integrationStepStatusInicial,
+	integrationStepPedidoNBS,
+	integrationStepStatusFinal,
+}
+
+// Status de um job de integração
+const (
+	IntegrationJobPending   = "pending"
+	IntegrationJobRunning   = "running"
+	IntegrationJobFailed    = "failed"
+	IntegrationJobCompleted = "completed"
+)
+
+const integrationProcessType = "integration"
+
+// integrationJobState é o checkpoint persistido na coluna data do job.
+// Guarda apenas o que foi produzido pelas etapas já concluídas; tokens, pedido
+// e comprador são obtidos novamente do marketplace a cada execução.
+type integrationJobState struct {
+	SellerID             string                 `json:"seller_id,omitempty"`
+	ShippingID           string                 `json:"shipping_id,omitempty"`
+	PedidoReservado      bool                   `json:"pedido_reservado,omitempty"` // status inicial inserido na validação
+	Pedidos              []integrationJobPedido `json:"pedidos,omitempty"`
+	ClientesCadastrados  []string               `json:"clientes_cadastrados,omitempty"`  // contas NBS com o cliente cadastrado
+	EnderecosCadastrados []string               `json:"enderecos_cadastrados,omitempty"` // contas NBS com o endereço cadastrado
+	CodCliente           string                 `json:"cod_cliente,omitempty"`
+	NomeCliente          string                 `json:"nome_cliente,omitempty"`
+	EnderecoCliente      string                 `json:"endereco_cliente,omitempty"`
+	Logs                 []IntegrationLogEntry  `json:"logs"`
+}
+
+// integrationJobPedido é um pedido do NBS: os itens do pedido do marketplace
+// que pertencem à mesma empresa/fornecedor
+type integrationJobPedido struct {
+	CodEmpresa    string               `json:"cod_empresa"`
+	CodFornecedor string               `json:"cod_fornecedor"`
+	ContaNBS      string               `json:"conta_nbs,omitempty"`
+	Itens         []integrationJobItem `json:"itens"`
+	NumeroPrenota string               `json:"numero_prenota,omitempty"`
+}
+
+type integrationJobItem struct {
+	ItemID    string  `json:"item_id"`
+	SKU       string  `json:"sku"`
+	UnitPrice float64 `json:"unit_price"`
+	Quantity  int     `json:"quantity"`
+}
+
+// Total retorna o valor dos itens do pedido
+func (p integrationJobPedido) Total() float64 {
+	var total float64
+	for _, item := range p.Itens {
+		total += item.UnitPrice * float64(item.Quantity)
+	}
+	return total
+}
+
+// contaNBS retorna a conta do gateway do NBS que recebe o pedido; jobs
+// anteriores às regras de roteamento usam a própria empresa
+func (p integrationJobPedido) contaNBS() string {
+	if p.ContaNBS != "" {
+		return p.ContaNBS
+	}
+	return p.CodEmpresa
+}
+
+// contasNBS retorna as contas do NBS dos pedidos, sem repetição
+func (st *integrationJobState) contasNBS() []string {
+	var contas []string
+	for _, pedido := range st.Pedidos {
+		if !containsString(contas, pedido.contaNBS()) {
+			contas = append(contas, pedido.contaNBS())
+		}
+	}
+	return contas
+}
+
+// prenotas retorna as pré-notas já geradas
+func (st *integrationJobState) prenotas() []string {
+	var prenotas []string
+	for _, pedido := range st.Pedidos {
+		if pedido.NumeroPrenota != "" {
+			prenotas = append(prenotas, pedido.NumeroPrenota)
+		}
+	}
+	return prenotas
+}
+
+// integrationJobRun mantém o estado de uma execução do job em memória
+type integrationJobRun struct {
+	ctx         context.Context // cancelado no deadline do job ou no desligamento do servidor
+	job         *models.IntegrationLog
+	state       integrationJobState
+	marketplace Marketplace
+	order       *MarketplaceOrder
+	nbsBuyer    *nbsBuyerData
+	hub         *websocket.Hub // recebe cada entrada de log; nil na simulação
+}
+
+// IntegrationJobStatus representa o status de um job para a API
+type IntegrationJobStatus struct {
+	*models.IntegrationLog
+	Progress int                    `json:"progress"`
+	Prenota  string                 `json:"prenota,omitempty"`
+	Pedidos  []integrationJobPedido `json:"pedidos,omitempty"`
+	Logs     []IntegrationLogEntry  `json:"logs"`
+}
+
+func (r *integrationJobRun) log(level, step, message string) {
+	entry := IntegrationLogEntry{
+		Timestamp: time.Now().Format("2006-01-02 15:04:05"),
+		Level:     level,
+		Step:      step,
+		Message:   message,
+	}
+	r.state.Logs = append(r.state.Logs, entry)
+	r.publish(entry)
+}
+
+// publish envia as entradas de log ao hub, no tópico do job, para que o
+// progresso apareça em tempo real
+func (r *integrationJobRun) publish(entries ...IntegrationLogEntry) {
+	if r.hub == nil || r.job == nil || r.job.ID == "" {
+		return
+	}
+
+	for _, entry := range entries {
+		timestamp := entry.Timestamp
+		if t, err := time.ParseInLocation("2006-01-02 15:04:05", entry.Timestamp, time.Local); err == nil {
+			timestamp = t.Format(time.RFC3339)
+		}
+
+		r.hub.BroadcastLog(websocket.LogMessage{
+			Type:      "log",
+			Timestamp: timestamp,
+			Level:     entry.Level,
+			Step:      entry.Step,
+			Message:   entry.Message,
+			ProcessID: r.job.ID,
+			NumPedido: r.job.NumPedido,
+			Conta:     r.job.Conta,
+		})
+	}
+}
+
+func (r *integrationJobRun) response() *IntegrationResponse {
+	if r.job.Status == IntegrationJobCompleted {
+		results := make([]map[string]interface{}, 0, len(r.state.Pedidos))
+		for _, pedido := range r.state.Pedidos {
+			results = append(results, map[string]interface{}{
+				"pedido":         r.job.NumPedido,
+				"numero_pedido":  pedido.NumeroPrenota,
+				"prenota":        pedido.NumeroPrenota,
+				"cod_empresa":    pedido.CodEmpresa,
+				"cod_fornecedor": pedido.CodFornecedor,
+				"itens":          len(pedido.Itens),
+				"status":         "sucesso",
+			})
+		}
+
+		return &IntegrationResponse{
+			JobID:          r.job.ID,
+			TotalProcessed: len(r.state.Pedidos),
+			SuccessCount:   len(r.state.Pedidos),
+			ErrorCount:     0,
+			Results:        results,
+			Logs:           r.state.Logs,
+		}
+	}
+
+	return &IntegrationResponse{
+		JobID:          r.job.ID,
+		TotalProcessed: 0,
+		SuccessCount:   0,
+		ErrorCount:     1,
+		Results:        []map[string]interface{}{},
+		Logs:           r.state.Logs,
+	}
+}
+
+// IntegrationStart é o job retornado por StartIntegration; Replayed indica que
+// a requisição repetiu uma anterior e nenhum job novo foi criado
+type IntegrationStart struct {
+	*models.IntegrationLog
+	Replayed bool `json:"replayed"`
+}
+
+// StartIntegration cria um job de integração e o executa em background. ctx
+// vale só para a criação do job: a execução continua depois que a requisição
+// termina e é cancelada apenas no desligamento do servidor.
+func (s *IntegrationService) StartIntegration(ctx context.Context, req IntegrationRequest) (*IntegrationStart, error) {
+	job, replayed, err := s.createJob(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if replayed {
+		return &IntegrationStart{IntegrationLog: job, Replayed: true}, nil
+	}
+
+	go func() {
+		if _, err := s.runJob(s.baseCtx, job.ID); err != nil {
+			log.Printf("❌ Erro ao executar job de integração %s: %v", job.ID, err)
+		}
+	}()
+
+	return &IntegrationStart{IntegrationLog: job}, nil
+}
+
+// ResumeIntegration retoma um job que falhou a partir da etapa em que parou
+func (s *IntegrationService) ResumeIntegration(ctx context.Context, scope Scope, jobID string) (*models.IntegrationLog, error) {
+	job, err := s.GetIntegrationJob(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if err := scope.check(job.Conta); err != nil {
+		return nil, err
+	}
+
+	switch job.Status {
+	case IntegrationJobCompleted:
+		return nil, fmt.Errorf("job %s já foi concluído", jobID)
+	case IntegrationJobRunning:
+		return nil, fmt.Errorf("job %s já está em execução", jobID)
+	}
+
+	// O claim é feito aqui, e não na execução em background, para que o job
+	// retornado já esteja em execução
+	claimCtx, cancel := withDeadline(ctx, s.config.DBQueryTimeoutSeconds)
+	defer cancel()
+
+	claimed, err := s.claimJob(claimCtx, job.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, fmt.Errorf("job %s já está em execução ou foi concluído", jobID)
+	}
+
+	job, err = s.GetIntegrationJob(claimCtx, job.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		if _, err := s.executeJob(s.baseCtx, job.ID); err != nil {
+			log.Printf("❌ Erro ao retomar job de integração %s: %v", job.ID, err)
+		}
+	}()
+
+	return job, nil
+}
+
+// GetIntegrationJob busca um job de integração pelo ID
+func (s *IntegrationService) GetIntegrationJob(ctx context.Context, jobID string) (*models.IntegrationLog, error) {
+	ctx, cancel := withDeadline(ctx, s.config.DBQueryTimeoutSeconds)
+	defer cancel()
+
+	query := `
+		SELECT CAST(id AS NVARCHAR(36)), COALESCE(CAST(user_id AS NVARCHAR(36)), ''), process_type, status,
+		       COALESCE(data, ''), COALESCE(conta, ''), COALESCE(marketplace, ''), COALESCE(num_pedido, ''),
+		       COALESCE(step, ''), COALESCE(attempts, 0), COALESCE(error_message, ''),
+		       created_at, COALESCE(updated_at, created_at), finished_at
+		FROM integration_logs
+		WHERE id = @p1 AND process_type = @p2`
+
+	var job models.IntegrationLog
+	var finishedAt sql.NullTime
+	err := s.sqlDB.QueryRowContext(ctx, query, jobID, integrationProcessType).Scan(
+		&job.ID, &job.UserID, &job.ProcessType, &job.Status,
+		&job.Data, &job.Conta, &job.Marketplace, &job.NumPedido,
+		&job.Step, &job.Attempts, &job.ErrorMessage,
+		&job.CreatedAt, &job.UpdatedAt, &finishedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("job de integração %s não encontrado", jobID)
+		}
+		return nil, fmt.Errorf("erro ao buscar job de integração: %w", err)
+	}
+
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+
+	return &job, nil
+}
+
+// GetIntegrationStatus retorna o status, progresso e logs de um job
+func (s *IntegrationService) GetIntegrationStatus(ctx context.Context, scope Scope, jobID string) (*IntegrationJobStatus, error) {
+	job, err := s.GetIntegrationJob(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if err := scope.check(job.Conta); err != nil {
+		return nil, err
+	}
+
+	state, err := decodeJobState(job.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	progress := 100
+	if job.Status != IntegrationJobCompleted {
+		progress = integrationStepIndex(job.Step) * 100 / len(integrationSteps)
+	}
+
+	return &IntegrationJobStatus{
+		IntegrationLog: job,
+		Progress:       progress,
+		Prenota:        strings.Join(state.prenotas(), ", "),
+		Pedidos:        state.Pedidos,
+		Logs:           state.Logs,
+	}, nil
+}
+
+// integrationJobRecoveryInterval é o intervalo entre as buscas por jobs
+// interrompidos
+const integrationJobRecoveryInterval = 5 * time.Minute
+
+// RecoverInterruptedJobs marca como falhos, para que possam ser retomados, os
+// jobs que estão em execução sem progresso há mais que o deadline de
+// integração: a instância que os executava parou. Jobs ainda dentro do
+// deadline, de outras instâncias, não são tocados. A busca se repete em
+// background até ctx ser cancelado.
+func (s *IntegrationService) RecoverInterruptedJobs(ctx context.Context) {
+	s.failStaleJobs(ctx)
+
+	go func() {
+		ticker := time.NewTicker(integrationJobRecoveryInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.failStaleJobs(ctx)
+			}
+		}
+	}()
+}
+
+func (s *IntegrationService) failStaleJobs(ctx context.Context) {
+	ctx, cancel := withDeadline(ctx, s.config.DBQueryTimeoutSeconds)
+	defer cancel()
+
+	// saveJob atualiza updated_at a cada etapa e runJob nunca passa do
+	// deadline; sem deadline configurado, uma hora sem progresso
+	staleSeconds := 3600
+	if s.config.IntegrationTimeoutSeconds > 0 {
+		staleSeconds = s.config.IntegrationTimeoutSeconds + 60
+	}
+
+	query := `
+		UPDATE integration_logs
+		SET status = @p1, error_message = @p2, updated_at = GETDATE()
+		WHERE process_type = @p3 AND status = @p4
+		  AND COALESCE(updated_at, created_at) < DATEADD(SECOND, -@p5, GETDATE())`
+
+	result, err := s.sqlDB.ExecContext(ctx, query, IntegrationJobFailed, "Execução interrompida: o servidor que executava o job parou",
+		integrationProcessType, IntegrationJobRunning, staleSeconds)
+	if err != nil {
+		log.Printf("⚠️ Erro ao recuperar jobs de integração interrompidos: %v", err)
+		return
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
+		log.Printf("⚠️ %d job(s) de integração interrompido(s) marcados como falhos", rowsAffected)
+	}
+}
+
+// createJob cria o job do pedido. Uma requisição repetida (mesma idempotency
+// key) ou um pedido que já tem um job pendente/em execução retorna o job
+// existente com replayed = true, sem criar outro.
+func (s *IntegrationService) createJob(ctx context.Context, req IntegrationRequest) (job *models.IntegrationLog, replayed bool, err error) {
+	ctx, cancel := withDeadline(ctx, s.config.DBQueryTimeoutSeconds)
+	defer cancel()
+
+	if _, err := s.marketplaces.Get(req.Marketplace); err != nil {
+		return nil, false, err
+	}
+	if err := req.Scope.check(req.Conta); err != nil {
+		return nil, false, err
+	}
+
+	if req.IdempotencyKey != "" {
+		job, err := s.jobByIdempotencyKey(ctx, req)
+		if err != nil || job != nil {
+			return job, job != nil, err
+		}
+	}
+
+	active, err := s.activeJobForOrder(ctx, req.Marketplace, req.NumPedido)
+	if err != nil {
+		return nil, false, err
+	}
+	if active != nil {
+		log.Printf("ℹ️ Pedido %s já possui o job de integração %s (%s)", req.NumPedido, active.ID, active.Status)
+		return active, true, nil
+	}
+
+	run := &integrationJobRun{}
+
+	run.log("info", "🎉", fmt.Sprintf("%s (MeLi) - NOVO PEDIDO RECEBIDO! 🎉", strings.ToUpper(req.Conta)))
+	run.log("info", "🛒", fmt.Sprintf("%s | %s", req.NumPedido, req.Marketplace))
+
+	data, err := json.Marshal(run.state)
+	if err != nil {
+		return nil, false, fmt.Errorf("erro ao serializar estado do job: %w", err)
+	}
+
+	tx, err := s.sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("erro ao criar job de integração: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO integration_logs (user_id, process_type, status, data, conta, marketplace, num_pedido, step, attempts)
+		OUTPUT CAST(INSERTED.id AS NVARCHAR(36))
+		VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, 0)`
+
+	var jobID string
+	err = tx.QueryRowContext(ctx, query,
+		sql.NullString{String: req.UserID, Valid: req.UserID != ""},
+		integrationProcessType,
+		IntegrationJobPending,
+		string(data),
+		strings.ToLower(req.Conta),
+		req.Marketplace,
+		req.NumPedido,
+		integrationSteps[0],
+	).Scan(&jobID)
+	if err != nil {
+		return nil, false, fmt.Errorf("erro ao criar job de integração: %w", err)
+	}
+
+	if req.IdempotencyKey != "" {
+		if err := s.saveIdempotencyKey(ctx, tx, req, jobID); err != nil {
+			// Outra requisição com a mesma key gravou primeiro: o job dela é o resultado
+			tx.Rollback()
+			if job, lookupErr := s.jobByIdempotencyKey(ctx, req); lookupErr != nil || job != nil {
+				return job, job != nil, lookupErr
+			}
+			return nil, false, fmt.Errorf("erro ao gravar idempotency key: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, fmt.Errorf("erro ao criar job de integração: %w", err)
+	}
+
+	log.Printf("📝 Job de integração %s criado para o pedido %s", jobID, req.NumPedido)
+	job, err = s.GetIntegrationJob(ctx, jobID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// As entradas iniciais foram registradas antes de o job ter ID
+	run.job = job
+	run.hub = s.wsHub
+	run.publish(run.state.Logs...)
+
+	return job, false, nil
+}
+
+// claimJob marca o job como em execução; retorna false se outro processo já o executa
+func (s *IntegrationService) claimJob(ctx context.Context, jobID string) (bool, error) {
+	query := `
+		UPDATE integration_logs
+		SET status = @p1, attempts = COALESCE(attempts, 0) + 1, error_message = NULL, finished_at = NULL, updated_at = GETDATE()
+		WHERE id = @p2 AND status NOT IN (@p3, @p4)`
+
+	result, err := s.sqlDB.ExecContext(ctx, query, IntegrationJobRunning, jobID, IntegrationJobRunning, IntegrationJobCompleted)
+	if err != nil {
+		return false, fmt.Errorf("erro ao iniciar job de integração: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// saveJob grava o estado do job. A gravação não é cancelada junto com o job,
+// para que a falha por deadline ou desligamento fique registrada e o job
+// possa ser retomado da etapa em que parou.
+func (s *IntegrationService) saveJob(run *integrationJobRun) {
+	ctx, cancel := withDeadline(context.WithoutCancel(run.ctx), s.config.DBQueryTimeoutSeconds)
+	defer cancel()
+
+	data, err := json.Marshal(run.state)
+	if err != nil {
+		log.Printf("⚠️ Erro ao serializar estado do job %s: %v", run.job.ID, err)
+		return
+	}
+
+	finishedAt := sql.NullTime{}
+	if run.job.Status == IntegrationJobCompleted || run.job.Status == IntegrationJobFailed {
+		finishedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+
+	query := `
+		UPDATE integration_logs
+		SET status = @p1, step = @p2, data = @p3, error_message = @p4, finished_at = @p5, updated_at = GETDATE()
+		WHERE id = @p6`
+
+	_, err = s.sqlDB.ExecContext(ctx, query,
+		run.job.Status,
+		run.job.Step,
+		string(data),
+		sql.NullString{String: run.job.ErrorMessage, Valid: run.job.ErrorMessage != ""},
+		finishedAt,
+		run.job.ID,
+	)
+	if err != nil {
+		log.Printf("⚠️ Erro ao salvar job de integração %s: %v", run.job.ID, err)
+	}
+}
+
+// runJob marca o job como em execução e o executa a partir da etapa gravada
+// até concluir ou falhar, limitado ao deadline de integração
+func (s *IntegrationService) runJob(ctx context.Context, jobID string) (*IntegrationResponse, error) {
+	claimCtx, cancel := withDeadline(ctx, s.config.DBQueryTimeoutSeconds)
+	defer cancel()
+
+	claimed, err := s.claimJob(claimCtx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, fmt.Errorf("job %s já está em execução ou foi concluído", jobID)
+	}
+
+	return s.executeJob(ctx, jobID)
+}
+
+// executeJob executa um job já marcado como em execução por claimJob
+func (s *IntegrationService) executeJob(ctx context.Context, jobID string) (*IntegrationResponse, error) {
+	ctx, cancel := withDeadline(ctx, s.config.IntegrationTimeoutSeconds)
+	defer cancel()
+
+	job, err := s.GetIntegrationJob(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := decodeJobState(job.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	run := &integrationJobRun{ctx: ctx, job: job, state: *state, hub: s.wsHub}
+
+	run.marketplace, err = s.marketplaces.Get(job.Marketplace)
+	if err != nil {
+		s.failJob(run, err)
+		return run.response(), nil
+	}
+
+	unlock, err := s.lockOrder(ctx, job.Marketplace, job.NumPedido)
+	if err != nil {
+		s.failJob(run, err)
+		return run.response(), nil
+	}
+	defer unlock()
+
+	start := integrationStepIndex(job.Step)
+
+	// Jobs gravados antes da divisão por fornecedor não têm os pedidos do NBS;
+	// as etapas a partir de empresa/fornecedor são refeitas
+	empresaStep := integrationStepIndex(integrationStepEmpresa)
+	if len(run.state.Pedidos) == 0 && start > empresaStep {
+		start = empresaStep
+	}
+
+	if job.Attempts > 1 {
+		run.log("info", "🔁", fmt.Sprintf("Retomando integração a partir da etapa '%s' (tentativa %d)", job.Step, job.Attempts))
+	}
+
+	for i := start; i < len(integrationSteps); i++ {
+		step := integrationSteps[i]
+		run.job.Step = step
+
+		if err := ctx.Err(); err != nil {
+			s.failJob(run, fmt.Errorf("integração interrompida antes da etapa %s: %w", step, err))
+			return run.response(), nil
+		}
+
+		if err := s.executeStep(run, step); err != nil {
+			s.failJob(run, err)
+			return run.response(), nil
+		}
+
+		if i+1 < len(integrationSteps) {
+			run.job.Step = integrationSteps[i+1]
+		} else {
+			run.job.Step = integrationStepConcluido
+		}
+		s.saveJob(run)
+	}
+
+	s.completeJob(run)
+	return run.response(), nil
+}
+
+func (s *IntegrationService) executeStep(run *integrationJobRun, step string) error {
+	switch step {
+	case integrationStepValidacao:
+		return s.stepValidacao(run)
+	case integrationStepPedidoML:
+		return s.ensureOrder(run)
+	case integrationStepEmpresa:
+		return s.stepEmpresaFornecedor(run)
+	case integrationStepCliente:
+		return s.stepCliente(run)
+	case integrationStepEndereco:
+		return s.stepEndereco(run)
+	case integrationStepStatusInicial:
+		return s.stepStatusInicial(run)
+	case integrationStepPedidoNBS:
+		return s.stepPedidoNBS(run)
+	case integrationStepStatusFinal:
+		return s.stepStatusFinal(run)
+	}
+	return fmt.Errorf("etapa desconhecida: %s", step)
+}
+
+func (s *IntegrationService) failJob(run *integrationJobRun, err error) {
+	run.log("error", "❌", err.Error())
+	run.job.Status = IntegrationJobFailed
+	run.job.ErrorMessage = err.Error()
+	s.saveJob(run)
+
+	log.Printf("❌ Job de integração %s falhou na etapa %s: %v", run.job.ID, run.job.Step, err)
+
+	// Falhas entram no resumo diário em vez de uma mensagem por pedido
+	s.notifier.Notify(notify.Event{
+		Kind:     notify.EventIntegrationFailed,
+		Severity: notify.SeverityError,
+		Conta:    run.job.Conta,
+		Digest:   true,
+		Data: map[string]interface{}{
+			"conta":      run.job.Conta,
+			"num_pedido": run.job.NumPedido,
+			"etapa":      run.job.Step,
+			"erro":       err.Error(),
+		},
+	})
+}
+
+func (s *IntegrationService) completeJob(run *integrationJobRun) {
+	prenotas := strings.Join(run.state.prenotas(), ", ")
+	run.log("success", "🎯", fmt.Sprintf("Integração concluída com sucesso! Pedido %s integrado como pré-nota(s) %s",
+		run.job.NumPedido, prenotas))
+	run.job.Status = IntegrationJobCompleted
+	run.job.Step = integrationStepConcluido
+	run.job.ErrorMessage = ""
+	s.saveJob(run)
+
+	var valorTotal float64
+	var numeroItens int
+	if run.order != nil {
+		numeroItens = len(run.order.Items)
+		valorTotal = run.order.Total()
+	}
+
+	s.notifier.Notify(notify.Event{
+		Kind:     notify.EventIntegrationCompleted,
+		Severity: notify.SeverityInfo,
+		Conta:    run.job.Conta,
+		Data: map[string]interface{}{
+			"conta":       run.job.Conta,
+			"num_pedido":  run.job.NumPedido,
+			"tipo_envio":  "Envio Flex",
+			"cliente":     run.state.NomeCliente,
+			"endereco":    run.state.EnderecoCliente,
+			"itens":       numeroItens,
+			"valor_total": valorTotal,
+			"prenotas":    prenotas,
+		},
+	})
+}
+
+// stepValidacao recusa pedidos já processados e insere a linha de status
+// inicial logo no início, ainda com o lock do pedido, para que requisições
+// concorrentes ou repetidas encontrem o pedido reservado
+func (s *IntegrationService) stepValidacao(run *integrationJobRun) error {
+	if run.state.PedidoReservado {
+		return nil
+	}
+
+	run.log("info", "🔍", "Verificando se o pedido já foi processado...")
+
+	if s.pgDB == nil {
+		return fmt.Errorf("PostgreSQL não disponível")
+	}
+
+	processed, err := s.orderAlreadyProcessed(run.ctx, run.job.NumPedido)
+	if err != nil {
+		return fmt.Errorf("erro ao verificar pedido no PostgreSQL: %w", err)
+	}
+	if processed {
+		run.log("warning", "⚠️", fmt.Sprintf("Pedido %s já foi processado anteriormente", run.job.NumPedido))
+		return fmt.Errorf("pedido %s já foi processado anteriormente", run.job.NumPedido)
+	}
+
+	if err := s.ensureOrder(run); err != nil {
+		return err
+	}
+
+	err = s.insertInitialStatus(run.ctx, run.marketplace.DisplayName(), run.job.NumPedido, strings.ToUpper(run.job.Conta),
+		run.state.SellerID, run.state.ShippingID)
+	if err != nil {
+		return fmt.Errorf("erro ao inserir status inicial: %w", err)
+	}
+
+	run.state.PedidoReservado = true
+	s.saveJob(run)
+
+	run.log("success", "📝", "Status inicial inserido, pedido reservado para este job")
+	return nil
+}
+
+func (s *IntegrationService) ensureOrder(run *integrationJobRun) error {
+	if run.order != nil {
+		return nil
+	}
+
+	run.log("info", "📋", fmt.Sprintf("Obtendo dados do pedido no %s...", run.marketplace.DisplayName()))
+
+	order, err := run.marketplace.FetchOrder(run.ctx, run.job.Conta, run.job.NumPedido)
+	if err != nil {
+		return fmt.Errorf("erro ao obter dados do pedido: %w", err)
+	}
+
+	run.order = order
+	run.state.SellerID = order.SellerID
+	run.state.ShippingID = order.ShipmentID
+
+	run.log("success", "✅", fmt.Sprintf("Pedido obtido: %s | Itens: %d", run.job.NumPedido, len(order.Items)))
+	run.log("info", "🚚", "Tipo de Envio: Envio Flex")
+	return nil
+}
+
+func (s *IntegrationService) stepEmpresaFornecedor(run *integrationJobRun) error {
+	if err := s.ensureOrder(run); err != nil {
+		return err
+	}
+
+	run.log("info", "🏢", "Determinando empresa e fornecedor de cada item...")
+
+	if len(run.order.Items) == 0 {
+		return fmt.Errorf("pedido %s não possui itens", run.job.NumPedido)
+	}
+
+	pedidos, err := s.resolveOrderItems(run.ctx, run.marketplace, run.job.Conta, run.order)
+	if err != nil {
+		return fmt.Errorf("erro ao determinar empresa/fornecedor: %w", err)
+	}
+
+	run.state.Pedidos = pedidos
+	run.state.ClientesCadastrados = nil
+	run.state.EnderecosCadastrados = nil
+
+	for _, pedido := range pedidos {
+		run.log("success", "✅", fmt.Sprintf("Empresa: %s, Fornecedor: %s, Conta: %s | %d item(s)",
+			pedido.CodEmpresa, pedido.CodFornecedor, strings.ToUpper(run.job.Conta), len(pedido.Itens)))
+	}
+	if len(pedidos) > 1 {
+		run.log("info", "✂️", fmt.Sprintf("Pedido dividido em %d pedidos no NBS", len(pedidos)))
+	}
+	return nil
+}
+
+func (s *IntegrationService) ensureBuyer(run *integrationJobRun) error {
+	if run.nbsBuyer != nil {
+		return nil
+	}
+	if run.state.ShippingID == "" {
+		if err := s.ensureOrder(run); err != nil {
+			return err
+		}
+	}
+
+	run.log("info", "👤", fmt.Sprintf("Coletando dados do cliente do %s...", run.marketplace.DisplayName()))
+
+	buyer, err := run.marketplace.FetchBuyer(run.ctx, run.job.Conta, run.job.NumPedido)
+	if err != nil {
+		return fmt.Errorf("erro ao obter dados do cliente: %w", err)
+	}
+
+	// O envio completa o endereço e o telefone quando o faturamento não os informa
+	var shipment *MarketplaceShipment
+	if run.state.ShippingID != "" {
+		shipment, err = run.marketplace.FetchShipment(run.ctx, run.job.Conta, run.state.ShippingID)
+		if err != nil {
+			run.log("warning", "⚠️", fmt.Sprintf("Não foi possível obter o envio %s: %v", run.state.ShippingID, err))
+			shipment = nil
+		}
+	}
+
+	nbsBuyer, err := s.mapBuyerToNBS(run.ctx, buyer, shipment)
+	if err != nil {
+		var validation *BuyerValidationError
+		if errors.As(err, &validation) {
+			for _, field := range validation.Fields {
+				run.log("error", "❌", fmt.Sprintf("Campo %s: %s", field.Field, field.Message))
+			}
+		}
+		return err
+	}
+	run.nbsBuyer = nbsBuyer
+	for _, warning := range buyerWarnings(nbsBuyer) {
+		run.log("warning", "⚠️", warning)
+	}
+
+	cliente := nbsBuyer.Cliente
+	run.state.NomeCliente = cliente.Nome
+	run.state.CodCliente = cliente.CodigoCliente
+	run.state.EnderecoCliente = fmt.Sprintf("%s, %s, %s - %s/%s", cliente.Rua, nbsBuyer.Endereco.NumeroEndereco,
+		cliente.Bairro, nbsBuyer.Cidade, cliente.UF)
+
+	run.log("success", "✅", fmt.Sprintf("Cliente identificado: %s | Doc: %s", run.state.NomeCliente, run.state.CodCliente))
+	return nil
+}
+
+// stepCliente cadastra o cliente em cada conta NBS do pedido
+func (s *IntegrationService) stepCliente(run *integrationJobRun) error {
+	if err := s.ensureBuyer(run); err != nil {
+		return err
+	}
+
+	for _, contaNBS := range run.state.contasNBS() {
+		if containsString(run.state.ClientesCadastrados, contaNBS) {
+			continue
+		}
+
+		run.log("info", "👤", fmt.Sprintf("Cadastrando cliente no NBS (conta %s)...", contaNBS))
+
+		if err := s.registerClient(run.ctx, contaNBS, run.nbsBuyer.Cliente); err != nil {
+			return fmt.Errorf("erro ao cadastrar cliente na conta NBS %s: %w", contaNBS, err)
+		}
+
+		run.state.ClientesCadastrados = append(run.state.ClientesCadastrados, contaNBS)
+		s.saveJob(run)
+	}
+
+	run.log("success", "✅", fmt.Sprintf("Cliente %s cadastrado no NBS com sucesso", run.state.NomeCliente))
+	return nil
+}
+
+// stepEndereco cadastra o endereço do cliente em cada conta NBS do pedido
+func (s *IntegrationService) stepEndereco(run *integrationJobRun) error {
+	if err := s.ensureBuyer(run); err != nil {
+		return err
+	}
+
+	run.log("info", "🏠", fmt.Sprintf("Endereço identificado: %s", run.state.EnderecoCliente))
+
+	for _, contaNBS := range run.state.contasNBS() {
+		if containsString(run.state.EnderecosCadastrados, contaNBS) {
+			continue
+		}
+
+		run.log("info", "🏠", fmt.Sprintf("Cadastrando endereço no NBS (conta %s)...", contaNBS))
+
+		if err := s.registerAddress(run.ctx, contaNBS, run.nbsBuyer.Endereco); err != nil {
+			return fmt.Errorf("erro ao cadastrar endereço na conta NBS %s: %w", contaNBS, err)
+		}
+
+		run.state.EnderecosCadastrados = append(run.state.EnderecosCadastrados, contaNBS)
+		s.saveJob(run)
+	}
+
+	run.log("success", "✅", "Endereço cadastrado no NBS com sucesso")
+	return nil
+}
+
+// stepStatusInicial completa o fato_StatusVenda com uma linha por pedido do NBS;
+// a primeira já foi inserida na validação
+func (s *IntegrationService) stepStatusInicial(run *integrationJobRun) error {
+	if run.state.SellerID == "" {
+		if err := s.ensureOrder(run); err != nil {
+			return err
+		}
+	}
+
+	run.log("info", "📝", "Inserindo status inicial do pedido...")
+
+	if s.pgDB == nil {
+		return fmt.Errorf("PostgreSQL não disponível")
+	}
+
+	// Linhas inseridas pela validação e por uma execução anterior que falhou no meio da etapa
+	var existentes int
+	err := s.pgDB.QueryRowContext(run.ctx, `SELECT COUNT(*) FROM integrator.fato_StatusVenda WHERE num_pedido = $1 AND id_conta = $2`,
+		run.job.NumPedido, run.state.SellerID).Scan(&existentes)
+	if err != nil {
+		return fmt.Errorf("erro ao verificar status inicial: %w", err)
+	}
+
+	for i := existentes; i < len(run.state.Pedidos); i++ {
+		err := s.insertInitialStatus(run.ctx, run.marketplace.DisplayName(), run.job.NumPedido, strings.ToUpper(run.job.Conta),
+			run.state.SellerID, run.state.ShippingID)
+		if err != nil {
+			return fmt.Errorf("erro ao inserir status inicial: %w", err)
+		}
+	}
+
+	run.log("success", "📝", "Status inicial inserido com sucesso")
+	return nil
+}
+
+// stepPedidoNBS envia cada pedido do NBS que ainda não tem pré-nota
+func (s *IntegrationService) stepPedidoNBS(run *integrationJobRun) error {
+	if err := s.ensureOrder(run); err != nil {
+		return err
+	}
+	if run.state.CodCliente == "" {
+		if err := s.ensureBuyer(run); err != nil {
+			return err
+		}
+	}
+
+	for i := range run.state.Pedidos {
+		pedido := &run.state.Pedidos[i]
+		if pedido.NumeroPrenota != "" {
+			continue
+		}
+
+		run.log("info", "📦", fmt.Sprintf("Pedido NBS %d/%d - Empresa %s, Fornecedor %s: %d item(s)",
+			i+1, len(run.state.Pedidos), pedido.CodEmpresa, pedido.CodFornecedor, len(pedido.Itens)))
+		for _, item := range pedido.Itens {
+			run.log("info", "📦", fmt.Sprintf("Item: MLB %s | SKU %s | Preço: R$ %.2f | Qtd: %d",
+				item.ItemID, item.SKU, item.UnitPrice, item.Quantity))
+		}
+
+		run.log("info", "⚙️", "Enviando pedido para NBS...")
+
+		numeroPedido, err := s.sendNBSOrder(run.ctx, run.marketplace, run.order, *pedido, run.state.CodCliente)
+		if err != nil {
+			return fmt.Errorf("erro ao enviar pedido da empresa %s: %w", pedido.CodEmpresa, err)
+		}
+
+		pedido.NumeroPrenota = numeroPedido
+		s.saveJob(run)
+		run.log("success", "✅", fmt.Sprintf("Pedido processado com sucesso! Número da pré-nota: %s", numeroPedido))
+	}
+
+	return nil
+}
+
+func (s *IntegrationService) stepStatusFinal(run *integrationJobRun) error {
+	if run.state.SellerID == "" {
+		if err := s.ensureOrder(run); err != nil {
+			return err
+		}
+	}
+
+	run.log("info", "📊", "Atualizando status final do pedido...")
+
+	if s.pgDB == nil {
+		return fmt.Errorf("PostgreSQL não disponível")
+	}
+
+	for _, numeroPrenota := range run.state.prenotas() {
+		if err := s.updateFinalStatus(run.ctx, run.job.NumPedido, numeroPrenota, run.state.SellerID); err != nil {
+			return fmt.Errorf("erro ao atualizar status final: %w", err)
+		}
+	}
+
+	run.log("success", "📊", "Status final atualizado com sucesso")
+	return nil
+}
+
+// filterNewOrders remove os pedidos que já estão no fato_StatusVenda ou que já
+// possuem um job de integração (jobs com falha são retomados manualmente)
+func (s *IntegrationService) filterNewOrders(ctx context.Context, numPedidos []string) ([]string, error) {
+	if len(numPedidos) == 0 {
+		return []string{}, nil
+	}
+	if s.pgDB == nil {
+		return nil, fmt.Errorf("PostgreSQL não disponível")
+	}
+
+	known := make(map[string]bool)
+
+	rows, err := s.pgDB.QueryContext(ctx, `SELECT num_pedido FROM integrator.fato_StatusVenda WHERE num_pedido = ANY($1)`, pq.Array(numPedidos))
+	if err != nil {
+		return nil, fmt.Errorf("erro ao verificar pedidos no PostgreSQL: %w", err)
+	}
+	for rows.Next() {
+		var numPedido string
+		if err := rows.Scan(&numPedido); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		known[numPedido] = true
+	}
+	rows.Close()
+
+	placeholders := make([]string, len(numPedidos))
+	args := make([]interface{}, len(numPedidos)+1)
+	args[0] = integrationProcessType
+	for i, numPedido := range numPedidos {
+		placeholders[i] = fmt.Sprintf("@p%d", i+2)
+		args[i+1] = numPedido
+	}
+
+	query := fmt.Sprintf(`SELECT DISTINCT num_pedido FROM integration_logs WHERE process_type = @p1 AND num_pedido IN (%s)`,
+		strings.Join(placeholders, ", "))
+	jobRows, err := s.sqlDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao verificar jobs de integração: %w", err)
+	}
+	defer jobRows.Close()
+
+	for jobRows.Next() {
+		var numPedido string
+		if err := jobRows.Scan(&numPedido); err != nil {
+			return nil, err
+		}
+		known[numPedido] = true
+	}
+
+	pending := []string{}
+	for _, numPedido := range numPedidos {
+		if !known[numPedido] {
+			pending = append(pending, numPedido)
+		}
+	}
+
+	return pending, nil
+}
+
+func decodeJobState(data string) (*integrationJobState, error) {
+	state := &integrationJobState{}
+	if data == "" {
+		return state, nil
+	}
+	if err := json.Unmarshal([]byte(data), state); err != nil {
+		return nil, fmt.Errorf("erro ao ler estado do job: %w", err)
+	}
+	return state, nil
+}
+
+func integrationStepIndex(step string) int {
+	for i, s := range integrationSteps {
+		if s == step {
+			return i
+		}
+	}
+	if step == integrationStepConcluido {
+		return len(integrationSteps)
+	}
+	return 0
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
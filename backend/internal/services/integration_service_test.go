@@ -0,0 +1,21 @@
+package services
+
+import "testing"
+
+func TestCodPedidoWeb(t *testing.T) {
+	valid := map[string]int64{
+		"2000012345678901": 345678901,
+		"200001234":        34,
+	}
+	for orderID, want := range valid {
+		if got, err := codPedidoWeb(orderID); err != nil || got != want {
+			t.Errorf("codPedidoWeb(%q) = (%d, %v), want (%d, nil)", orderID, got, err, want)
+		}
+	}
+
+	for _, orderID := range []string{"", "2000012", "20000120000000", "2000012abc"} {
+		if _, err := codPedidoWeb(orderID); err == nil {
+			t.Errorf("codPedidoWeb(%q): esperava erro", orderID)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"amz-web-tools/backend/internal/models"
+	"amz-web-tools/backend/internal/nbs"
+)
+
+// IntegrationSimulation é o resultado de uma integração em modo dry-run: os
+// payloads que seriam enviados ao NBS e os problemas encontrados
+type IntegrationSimulation struct {
+	DryRun            bool                         `json:"dry_run"`
+	Conta             string                       `json:"conta"`
+	Marketplace       string                       `json:"marketplace"`
+	NumPedido         string                       `json:"num_pedido"`
+	NBSClientRequest  *nbs.Cliente                 `json:"nbs_client_request,omitempty"`
+	NBSAddressRequest *nbs.Endereco                `json:"nbs_address_request,omitempty"`
+	NBSOrderRequests  []IntegrationSimulatedPedido `json:"nbs_order_requests"`
+	Warnings          []string                     `json:"warnings"`
+	Logs              []IntegrationLogEntry        `json:"logs"`
+}
+
+// IntegrationSimulatedPedido é um pedido do NBS que seria enviado, com a rota
+// usada para montá-lo
+type IntegrationSimulatedPedido struct {
+	CodEmpresa    string     `json:"cod_empresa"`
+	CodFornecedor string     `json:"cod_fornecedor"`
+	ContaNBS      string     `json:"conta_nbs"`
+	Total         float64    `json:"total"`
+	Request       nbs.Pedido `json:"request"`
+}
+
+// SimulateIntegration executa as leituras reais do marketplace, do DePara e das
+// regras de roteamento sem cadastrar nada no NBS nem gravar no fato_StatusVenda.
+// Os erros que fariam a integração falhar viram avisos, para que todos apareçam
+// de uma vez.
+func (s *IntegrationService) SimulateIntegration(ctx context.Context, req IntegrationRequest) (*IntegrationSimulation, error) {
+	ctx, cancel := withDeadline(ctx, s.config.IntegrationTimeoutSeconds)
+	defer cancel()
+
+	marketplace, err := s.marketplaces.Get(req.Marketplace)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Scope.check(req.Conta); err != nil {
+		return nil, err
+	}
+
+	run := &integrationJobRun{
+		ctx: ctx,
+		job: &models.IntegrationLog{
+			Conta:       strings.ToLower(req.Conta),
+			Marketplace: req.Marketplace,
+			NumPedido:   req.NumPedido,
+		},
+		marketplace: marketplace,
+	}
+	sim := &IntegrationSimulation{
+		DryRun:           true,
+		Conta:            run.job.Conta,
+		Marketplace:      req.Marketplace,
+		NumPedido:        req.NumPedido,
+		NBSOrderRequests: []IntegrationSimulatedPedido{},
+		Warnings:         []string{},
+	}
+
+	run.log("info", "🧪", fmt.Sprintf("Simulação (dry-run) do pedido %s | %s", req.NumPedido, strings.ToUpper(req.Conta)))
+
+	if processed, err := s.orderAlreadyProcessed(ctx, req.NumPedido); err != nil {
+		sim.warn(run, fmt.Sprintf("Não foi possível verificar se o pedido já foi processado: %v", err))
+	} else if processed {
+		sim.warn(run, fmt.Sprintf("Pedido %s já foi processado anteriormente", req.NumPedido))
+	}
+
+	// Sem o pedido não há o que simular
+	if err := s.ensureOrder(run); err != nil {
+		return nil, err
+	}
+
+	if err := s.stepEmpresaFornecedor(run); err != nil {
+		sim.warn(run, err.Error())
+	}
+
+	if err := s.ensureBuyer(run); err != nil {
+		var validation *BuyerValidationError
+		if errors.As(err, &validation) {
+			for _, field := range validation.Fields {
+				sim.Warnings = append(sim.Warnings, fmt.Sprintf("Campo %s: %s", field.Field, field.Message))
+			}
+		} else {
+			sim.warn(run, err.Error())
+		}
+	} else {
+		sim.NBSClientRequest = &run.nbsBuyer.Cliente
+		sim.NBSAddressRequest = &run.nbsBuyer.Endereco
+		sim.Warnings = append(sim.Warnings, buyerWarnings(run.nbsBuyer)...)
+	}
+
+	for _, pedido := range run.state.Pedidos {
+		if pedido.Total() <= 0 {
+			sim.warn(run, fmt.Sprintf("Pedido da empresa %s com valor total zerado", pedido.CodEmpresa))
+		}
+
+		request, err := s.buildNBSOrder(marketplace, run.order, pedido, run.state.CodCliente)
+		if err != nil {
+			sim.warn(run, err.Error())
+		}
+
+		sim.NBSOrderRequests = append(sim.NBSOrderRequests, IntegrationSimulatedPedido{
+			CodEmpresa:    pedido.CodEmpresa,
+			CodFornecedor: pedido.CodFornecedor,
+			ContaNBS:      pedido.contaNBS(),
+			Total:         pedido.Total(),
+			Request:       request,
+		})
+	}
+
+	run.log("info", "🧪", fmt.Sprintf("Simulação concluída: %d pedido(s) no NBS, %d aviso(s)",
+		len(sim.NBSOrderRequests), len(sim.Warnings)))
+	sim.Logs = run.state.Logs
+
+	return sim, nil
+}
+
+func (sim *IntegrationSimulation) warn(run *integrationJobRun, message string) {
+	sim.Warnings = append(sim.Warnings, message)
+	run.log("warning", "⚠️", message)
+}
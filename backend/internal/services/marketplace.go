@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrUnsupportedMarketplace indica um marketplace sem adapter registrado
+var ErrUnsupportedMarketplace = errors.New("marketplace não suportado")
+
+// Marketplace abstrai as chamadas feitas a um marketplace durante a integração
+// de pedidos e o envio de notas fiscais. Cada implementação resolve as próprias
+// credenciais a partir da conta.
+type Marketplace interface {
+	// Name é o identificador usado em IntegrationRequest.Marketplace (ex.: "mercadolivre")
+	Name() string
+	// DisplayName é o nome gravado no NBS e no integrator (ex.: "Mercado Livre")
+	DisplayName() string
+	// IntermediaryCNPJ é o CNPJ do intermediador informado no pedido do NBS
+	IntermediaryCNPJ() string
+
+	FetchOrder(ctx context.Context, conta, orderID string) (*MarketplaceOrder, error)
+	FetchBuyer(ctx context.Context, conta, orderID string) (*MarketplaceBuyer, error)
+	FetchShipment(ctx context.Context, conta, shipmentID string) (*MarketplaceShipment, error)
+	FetchItem(ctx context.Context, conta, itemID string) (*MarketplaceItem, error)
+	SearchPaidOrders(ctx context.Context, conta string, since time.Time) ([]string, error)
+	UploadInvoice(ctx context.Context, conta, shipmentID, xmlNota string) error
+}
+
+// MarketplaceOrder representa um pedido independente do marketplace
+type MarketplaceOrder struct {
+	ID          string                 `json:"id"`
+	PackID      string                 `json:"pack_id,omitempty"`
+	Status      string                 `json:"status"`
+	SellerID    string                 `json:"seller_id"`
+	DateCreated string                 `json:"date_created"`
+	ShipmentID  string                 `json:"shipment_id"`
+	Items       []MarketplaceOrderItem `json:"items"`
+}
+
+// MarketplaceOrderItem representa um item de um pedido
+type MarketplaceOrderItem struct {
+	ItemID    string  `json:"item_id"`
+	Title     string  `json:"title"`
+	UnitPrice float64 `json:"unit_price"`
+	Quantity  int     `json:"quantity"`
+}
+
+// MarketplaceBuyer representa os dados de faturamento do comprador
+type MarketplaceBuyer struct {
+	DocType           string             `json:"doc_type"`
+	DocNumber         string             `json:"doc_number"`
+	Name              string             `json:"name"`
+	StateRegistration string             `json:"state_registration,omitempty"`
+	Phone             string             `json:"phone,omitempty"`
+	Address           MarketplaceAddress `json:"address"`
+}
+
+// MarketplaceAddress representa um endereço de faturamento ou entrega
+type MarketplaceAddress struct {
+	Street       string `json:"street"`
+	Number       string `json:"number"`
+	Complement   string `json:"complement,omitempty"`
+	Neighborhood string `json:"neighborhood"`
+	City         string `json:"city"`
+	State        string `json:"state"` // UF, nome do estado ou ID do marketplace (ex.: BR-SP)
+	ZipCode      string `json:"zip_code"`
+}
+
+// MarketplaceShipment representa o status de um envio
+type MarketplaceShipment struct {
+	ID            string             `json:"id"`
+	Status        string             `json:"status"`
+	Substatus     string             `json:"substatus"`
+	LogisticType  string             `json:"logistic_type"`
+	BufferingDate string             `json:"buffering_date,omitempty"`
+	ReceiverName  string             `json:"receiver_name,omitempty"`
+	ReceiverPhone string             `json:"receiver_phone,omitempty"`
+	Address       MarketplaceAddress `json:"address"`
+}
+
+// MarketplaceItem representa um anúncio do marketplace
+type MarketplaceItem struct {
+	ID         string `json:"id"`
+	Title      string `json:"title"`
+	PartNumber string `json:"part_number"`
+}
+
+// NumPedido retorna o número usado para integrar o pedido: pedidos de um
+// mesmo carrinho são integrados pelo pack
+func (o *MarketplaceOrder) NumPedido() string {
+	if o.PackID != "" {
+		return o.PackID
+	}
+	return o.ID
+}
+
+// Total retorna o valor total dos itens do pedido
+func (o *MarketplaceOrder) Total() float64 {
+	var total float64
+	for _, item := range o.Items {
+		total += item.UnitPrice * float64(item.Quantity)
+	}
+	return total
+}
+
+// MarketplaceRegistry resolve o adapter a partir do nome do marketplace
+type MarketplaceRegistry struct {
+	adapters map[string]Marketplace
+}
+
+func NewMarketplaceRegistry(adapters ...Marketplace) *MarketplaceRegistry {
+	r := &MarketplaceRegistry{adapters: make(map[string]Marketplace)}
+	for _, adapter := range adapters {
+		r.adapters[normalizeMarketplaceName(adapter.Name())] = adapter
+	}
+	return r
+}
+
+// Get retorna o adapter do marketplace informado
+func (r *MarketplaceRegistry) Get(name string) (Marketplace, error) {
+	adapter, ok := r.adapters[normalizeMarketplaceName(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedMarketplace, name)
+	}
+	return adapter, nil
+}
+
+// normalizeMarketplaceName aceita variações como "Mercado Livre" e "mercado_livre"
+func normalizeMarketplaceName(name string) string {
+	return strings.NewReplacer(" ", "", "_", "", "-", "").Replace(strings.ToLower(strings.TrimSpace(name)))
+}
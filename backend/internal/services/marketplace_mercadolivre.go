@@ -0,0 +1,563 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"amz-web-tools/backend/internal/httpclient"
+)
+
+const mercadoLivreAPIURL = "https://api.mercadolibre.com"
+
+type MLOrder struct {
+	ID          interface{} `json:"id"`
+	Status      string      `json:"status"`
+	PackID      interface{} `json:"pack_id"`
+	DateCreated string      `json:"date_created"`
+	Seller      struct {
+		ID interface{} `json:"id"`
+	} `json:"seller"`
+	OrderItems []struct {
+		Item struct {
+			ID    interface{} `json:"id"`
+			Title string      `json:"title"`
+		} `json:"item"`
+		UnitPrice float64 `json:"unit_price"`
+		Quantity  int     `json:"quantity"`
+	} `json:"order_items"`
+	Shipping struct {
+		ID interface{} `json:"id"`
+	} `json:"shipping"`
+}
+
+type MLItem struct {
+	ID         string `json:"id"`
+	Title      string `json:"title"`
+	Attributes []struct {
+		ID        string `json:"id"`
+		Name      string `json:"name"`
+		ValueName string `json:"value_name"`
+	} `json:"attributes"`
+}
+
+type MLBillingInfo struct {
+	BillingInfo struct {
+		DocType   string `json:"doc_type"`
+		DocNumber string `json:"doc_number"`
+		// additional_info pode vir como lista de {name, value} ou como objeto
+		AdditionalInfo interface{} `json:"additional_info"`
+	} `json:"billing_info"`
+}
+
+type MLShipment struct {
+	ID           interface{} `json:"id"`
+	Status       string      `json:"status"`
+	Substatus    string      `json:"substatus"`
+	LogisticType string      `json:"logistic_type"`
+	LeadTime     struct {
+		Buffering struct {
+			Date string `json:"date"`
+		} `json:"buffering"`
+	} `json:"lead_time"`
+	Destination struct {
+		ReceiverName    string `json:"receiver_name"`
+		ReceiverPhone   string `json:"receiver_phone"`
+		ShippingAddress struct {
+			City struct {
+				Name string `json:"name"`
+			} `json:"city"`
+			State struct {
+				ID string `json:"id"`
+			} `json:"state"`
+			ZipCode      string `json:"zip_code"`
+			StreetName   string `json:"street_name"`
+			StreetNumber string `json:"street_number"`
+			Neighborhood struct {
+				Name string `json:"name"`
+			} `json:"neighborhood"`
+			Comment string `json:"comment"`
+		} `json:"shipping_address"`
+	} `json:"destination"`
+}
+
+type MLPack struct {
+	Orders []struct {
+		ID interface{} `json:"id"`
+	} `json:"orders"`
+}
+
+type mlOrderSearchResponse struct {
+	Results []struct {
+		ID     int64  `json:"id"`
+		Status string `json:"status"`
+		PackID *int64 `json:"pack_id"`
+	} `json:"results"`
+	Paging struct {
+		Total  int `json:"total"`
+		Offset int `json:"offset"`
+		Limit  int `json:"limit"`
+	} `json:"paging"`
+}
+
+// Paginação de /orders/search: o Mercado Livre devolve até 50 pedidos por
+// página e não aceita offset acima de 10000
+const (
+	mlOrderSearchPageSize  = 50
+	mlOrderSearchMaxOffset = 10000
+)
+
+// MercadoLivreMarketplace implementa Marketplace para a API do Mercado Livre
+type MercadoLivreMarketplace struct {
+	client *httpclient.Client
+	tokens *MLTokenManager
+}
+
+func NewMercadoLivreMarketplace(tokens *MLTokenManager, client *httpclient.Client) *MercadoLivreMarketplace {
+	return &MercadoLivreMarketplace{
+		client: client,
+		tokens: tokens,
+	}
+}
+
+func (m *MercadoLivreMarketplace) Name() string {
+	return "mercadolivre"
+}
+
+func (m *MercadoLivreMarketplace) DisplayName() string {
+	return "Mercado Livre"
+}
+
+func (m *MercadoLivreMarketplace) IntermediaryCNPJ() string {
+	return "03361252000134"
+}
+
+// SellerID retorna o ID de vendedor da conta
+func (m *MercadoLivreMarketplace) SellerID(ctx context.Context, conta string) (string, error) {
+	_, sellerID, err := m.token(ctx, conta)
+	return sellerID, err
+}
+
+// token retorna o token de acesso e o seller ID da conta. Contas com credenciais
+// OAuth cadastradas usam o MLTokenManager; as demais continuam usando o token
+// publicado no S3 até serem migradas.
+func (m *MercadoLivreMarketplace) token(ctx context.Context, conta string) (string, string, error) {
+	if m.tokens != nil {
+		tokenML, sellerID, err := m.tokens.Token(ctx, conta)
+		if err == nil {
+			return tokenML, sellerID, nil
+		}
+		if !errors.Is(err, ErrMLCredentialsNotFound) {
+			return "", "", err
+		}
+	}
+
+	return m.legacyToken(ctx, conta)
+}
+
+// legacyToken obtém o token de acesso da conta publicado no S3; o ID do vendedor
+// é o último segmento do token
+func (m *MercadoLivreMarketplace) legacyToken(ctx context.Context, conta string) (string, string, error) {
+	conta = strings.ToLower(conta)
+	log.Printf("🔑 Obtendo token ML do S3 para conta: %s (credenciais OAuth não cadastradas)", conta)
+
+	tkConta := fmt.Sprintf("tk%s", conta)
+	if conta == "ford" {
+		url := fmt.Sprintf("https://imgs-amz.s3.us-east-1.amazonaws.com/tk/%s.txt", tkConta)
+		resp, err := m.client.Get(ctx, url)
+		if err != nil {
+			return "", "", fmt.Errorf("erro ao obter token fords: %w", err)
+		}
+		defer resp.Body.Close()
+
+		var content struct {
+			Data string `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&content); err != nil {
+			return "", "", fmt.Errorf("erro ao decodificar token fords: %w", err)
+		}
+
+		tokenML := content.Data
+		tokenUser := strings.Split(tokenML, "-")[len(strings.Split(tokenML, "-"))-1]
+		return tokenML, tokenUser, nil
+	}
+
+	// Substituir "principal" por "amz" se necessário
+	tkConta = strings.Replace(tkConta, "principal", "amz", 1)
+	urlToken := fmt.Sprintf("https://imgs-amz.s3.us-east-1.amazonaws.com/tk/%s.html", tkConta)
+
+	resp, err := m.client.Get(ctx, urlToken)
+	if err != nil {
+		return "", "", fmt.Errorf("erro ao obter token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", fmt.Errorf("erro ao ler conteúdo: %w", err)
+	}
+
+	contentStr := string(content)
+	tokenIndex := strings.Index(contentStr, "y>") + 2
+	if tokenIndex < 2 {
+		return "", "", fmt.Errorf("formato de token inválido")
+	}
+
+	tokenMid := contentStr[tokenIndex:]
+	tokenIndexEnd := strings.Index(tokenMid, "</")
+	if tokenIndexEnd == -1 {
+		return "", "", fmt.Errorf("formato de token inválido")
+	}
+
+	tokenML := "APP_USR-" + tokenMid[:tokenIndexEnd]
+	tokenUser := strings.Split(tokenML, "-")[len(strings.Split(tokenML, "-"))-1]
+
+	log.Printf("✅ Token ML obtido para conta %s", conta)
+	return tokenML, tokenUser, nil
+}
+
+// get faz um GET autenticado na API do Mercado Livre e decodifica a resposta em out.
+// Retorna o status HTTP para que o chamador possa tratar 404.
+func (m *MercadoLivreMarketplace) get(ctx context.Context, path, tokenML string, headers map[string]string, out interface{}) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", mercadoLivreAPIURL+path, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenML))
+	req.Header.Set("Accept", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, fmt.Errorf("GET %s retornou status %d: %s", path, resp.StatusCode, string(body))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return resp.StatusCode, fmt.Errorf("erro ao decodificar resposta de %s: %w", path, err)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// firstOrderOfPack retorna o primeiro pedido de um pack (carrinho)
+func (m *MercadoLivreMarketplace) firstOrderOfPack(ctx context.Context, packID, tokenML string) (string, error) {
+	var pack MLPack
+	if _, err := m.get(ctx, "/packs/"+packID, tokenML, nil, &pack); err != nil {
+		return "", err
+	}
+	if len(pack.Orders) == 0 {
+		return "", fmt.Errorf("nenhum pedido encontrado no pack")
+	}
+
+	firstOrderID := formatMLID(pack.Orders[0].ID)
+	log.Printf("🔍 Pack encontrado! Primeiro pedido: %s", firstOrderID)
+	return firstOrderID, nil
+}
+
+// FetchOrder busca o pedido em /orders e, se não encontrado, trata o ID como pack
+func (m *MercadoLivreMarketplace) FetchOrder(ctx context.Context, conta, orderID string) (*MarketplaceOrder, error) {
+	tokenML, sellerID, err := m.token(ctx, conta)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao obter token ML: %w", err)
+	}
+
+	var order MLOrder
+	status, err := m.get(ctx, "/orders/"+orderID, tokenML, nil, &order)
+	if err != nil && status == http.StatusNotFound {
+		log.Printf("🔍 Pedido %s não encontrado em /orders, tentando /packs...", orderID)
+
+		firstOrderID, packErr := m.firstOrderOfPack(ctx, orderID, tokenML)
+		if packErr != nil {
+			log.Printf("❌ Pedido %s não encontrado nem em /orders nem em /packs", orderID)
+			return nil, fmt.Errorf("erro ao obter pedido ML: %w", packErr)
+		}
+
+		order = MLOrder{}
+		_, err = m.get(ctx, "/orders/"+firstOrderID, tokenML, nil, &order)
+		if err == nil {
+			log.Printf("✅ Pedido obtido via pack: %s", firstOrderID)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao obter pedido ML: %w", err)
+	}
+
+	result := &MarketplaceOrder{
+		ID:          formatMLID(order.ID),
+		PackID:      formatMLID(order.PackID),
+		Status:      order.Status,
+		SellerID:    formatMLID(order.Seller.ID),
+		DateCreated: order.DateCreated,
+		ShipmentID:  formatMLID(order.Shipping.ID),
+	}
+	if result.SellerID == "" {
+		result.SellerID = sellerID
+	}
+
+	for _, item := range order.OrderItems {
+		result.Items = append(result.Items, MarketplaceOrderItem{
+			ItemID:    formatMLID(item.Item.ID),
+			Title:     item.Item.Title,
+			UnitPrice: item.UnitPrice,
+			Quantity:  item.Quantity,
+		})
+	}
+
+	return result, nil
+}
+
+// FetchBuyer busca os dados de faturamento do pedido (ou do primeiro pedido do pack)
+func (m *MercadoLivreMarketplace) FetchBuyer(ctx context.Context, conta, orderID string) (*MarketplaceBuyer, error) {
+	log.Printf("🔍 Coletando dados REAIS do cliente do Mercado Livre...")
+
+	tokenML, _, err := m.token(ctx, conta)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao obter token ML: %w", err)
+	}
+
+	var billingInfo MLBillingInfo
+	if _, err := m.get(ctx, "/orders/"+orderID+"/billing_info", tokenML, nil, &billingInfo); err == nil {
+		log.Printf("✅ Dados do cliente obtidos via /orders/billing_info")
+	} else {
+		log.Printf("🔍 Tentando obter dados via pack...")
+
+		firstOrderID, err := m.firstOrderOfPack(ctx, orderID, tokenML)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao obter pack: %w", err)
+		}
+
+		billingInfo = MLBillingInfo{}
+		if _, err := m.get(ctx, "/orders/"+firstOrderID+"/billing_info", tokenML, nil, &billingInfo); err != nil {
+			return nil, fmt.Errorf("erro ao obter billing_info do pack: %w", err)
+		}
+		log.Printf("✅ Dados do cliente obtidos via pack")
+	}
+
+	info := mlAdditionalInfo(billingInfo.BillingInfo.AdditionalInfo)
+
+	// STATE_CODE traz a UF; quando ausente, usa o nome do estado
+	state := info["STATE_CODE"]
+	if state == "" {
+		state = info["STATE_NAME"]
+	}
+
+	buyer := &MarketplaceBuyer{
+		DocType:           billingInfo.BillingInfo.DocType,
+		DocNumber:         billingInfo.BillingInfo.DocNumber,
+		StateRegistration: info["STATE_REGISTRATION"],
+		Address: MarketplaceAddress{
+			Street:       info["STREET_NAME"],
+			Number:       info["STREET_NUMBER"],
+			Complement:   info["COMMENT"],
+			Neighborhood: info["NEIGHBORHOOD"],
+			City:         info["CITY_NAME"],
+			State:        state,
+			ZipCode:      info["ZIP_CODE"],
+		},
+	}
+
+	if buyer.DocType == "CPF" {
+		if firstName := info["FIRST_NAME"]; firstName != "" {
+			buyer.Name = strings.ToUpper(strings.TrimSpace(firstName + " " + info["LAST_NAME"]))
+		}
+	} else if businessName := info["BUSINESS_NAME"]; businessName != "" {
+		buyer.Name = strings.ToUpper(businessName)
+	}
+	if buyer.Name == "" {
+		buyer.Name = "CLIENTE ML"
+	}
+
+	log.Printf("🔍 Cliente coletado: Nome=%s, Tipo=%s, Doc=%s", buyer.Name, buyer.DocType, buyer.DocNumber)
+	return buyer, nil
+}
+
+// mlAdditionalInfo normaliza o additional_info do billing_info, que pode vir
+// como lista de {name, value} ou como objeto
+func mlAdditionalInfo(raw interface{}) map[string]string {
+	info := make(map[string]string)
+
+	switch v := raw.(type) {
+	case []interface{}:
+		for _, entry := range v {
+			if entryMap, ok := entry.(map[string]interface{}); ok {
+				name, _ := entryMap["name"].(string)
+				value, _ := entryMap["value"].(string)
+				if name != "" {
+					info[name] = value
+				}
+			}
+		}
+	case map[string]interface{}:
+		for name, value := range v {
+			if str, ok := value.(string); ok {
+				info[name] = str
+			}
+		}
+	}
+
+	return info
+}
+
+// FetchShipment busca o status e o destino de um envio
+func (m *MercadoLivreMarketplace) FetchShipment(ctx context.Context, conta, shipmentID string) (*MarketplaceShipment, error) {
+	log.Printf("🚚 Verificando status REAL do envio ML: %s", shipmentID)
+
+	tokenML, _, err := m.token(ctx, conta)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao obter token ML: %w", err)
+	}
+
+	var shipment MLShipment
+	if _, err := m.get(ctx, "/shipments/"+shipmentID, tokenML, map[string]string{"x-format-new": "true"}, &shipment); err != nil {
+		return nil, err
+	}
+
+	log.Printf("📊 Status ML - Status: %s, Substatus: %s", shipment.Status, shipment.Substatus)
+
+	address := shipment.Destination.ShippingAddress
+	return &MarketplaceShipment{
+		ID:            shipmentID,
+		Status:        shipment.Status,
+		Substatus:     shipment.Substatus,
+		LogisticType:  shipment.LogisticType,
+		BufferingDate: shipment.LeadTime.Buffering.Date,
+		ReceiverName:  shipment.Destination.ReceiverName,
+		ReceiverPhone: shipment.Destination.ReceiverPhone,
+		Address: MarketplaceAddress{
+			Street:       address.StreetName,
+			Number:       address.StreetNumber,
+			Complement:   address.Comment,
+			Neighborhood: address.Neighborhood.Name,
+			City:         address.City.Name,
+			State:        address.State.ID,
+			ZipCode:      address.ZipCode,
+		},
+	}, nil
+}
+
+// FetchItem busca o anúncio e o número da peça (atributo MPN)
+func (m *MercadoLivreMarketplace) FetchItem(ctx context.Context, conta, itemID string) (*MarketplaceItem, error) {
+	resp, err := m.client.Get(ctx, fmt.Sprintf("%s/items/%s", mercadoLivreAPIURL, itemID))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var item MLItem
+	if err := json.NewDecoder(resp.Body).Decode(&item); err != nil {
+		return nil, err
+	}
+
+	result := &MarketplaceItem{ID: item.ID, Title: item.Title}
+	for _, attr := range item.Attributes {
+		if attr.Name == "MPN" || attr.ID == "MPN" {
+			result.PartNumber = attr.ValueName
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// SearchPaidOrders retorna os pedidos pagos da conta desde a data informada,
+// percorrendo todas as páginas da busca; pedidos de um mesmo carrinho são
+// agrupados pelo pack_id
+func (m *MercadoLivreMarketplace) SearchPaidOrders(ctx context.Context, conta string, since time.Time) ([]string, error) {
+	tokenML, sellerID, err := m.token(ctx, conta)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao obter token ML: %w", err)
+	}
+
+	params := url.Values{}
+	params.Set("seller", sellerID)
+	params.Set("order.status", "paid")
+	params.Set("order.date_created.from", since.Format("2006-01-02T15:04:05.000-07:00"))
+	params.Set("sort", "date_desc")
+	params.Set("limit", strconv.Itoa(mlOrderSearchPageSize))
+
+	seen := make(map[string]bool)
+	var numPedidos []string
+	for offset := 0; ; offset += mlOrderSearchPageSize {
+		if offset > mlOrderSearchMaxOffset {
+			log.Printf("⚠️ Busca de pedidos da conta %s truncada no offset %d", strings.ToUpper(conta), mlOrderSearchMaxOffset)
+			break
+		}
+		params.Set("offset", strconv.Itoa(offset))
+
+		var search mlOrderSearchResponse
+		if _, err := m.get(ctx, "/orders/search?"+params.Encode(), tokenML, nil, &search); err != nil {
+			return nil, fmt.Errorf("erro ao buscar pedidos no Mercado Livre (offset %d): %w", offset, err)
+		}
+
+		for _, order := range search.Results {
+			numPedido := strconv.FormatInt(order.ID, 10)
+			if order.PackID != nil {
+				numPedido = strconv.FormatInt(*order.PackID, 10)
+			}
+			if !seen[numPedido] {
+				seen[numPedido] = true
+				numPedidos = append(numPedidos, numPedido)
+			}
+		}
+
+		if len(search.Results) == 0 || offset+len(search.Results) >= search.Paging.Total {
+			break
+		}
+	}
+
+	return numPedidos, nil
+}
+
+// UploadInvoice envia o XML da nota fiscal para o envio. O Mercado Livre
+// responde 406 quando aceita o XML com Accept: application/xml.
+func (m *MercadoLivreMarketplace) UploadInvoice(ctx context.Context, conta, shipmentID, xmlNota string) error {
+	log.Printf("📤 Enviando XML REAL para ML - Envio: %s", shipmentID)
+
+	tokenML, _, err := m.token(ctx, conta)
+	if err != nil {
+		return fmt.Errorf("erro ao obter token ML: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/shipments/%s/invoice_data?siteId=MLB", mercadoLivreAPIURL, shipmentID)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(xmlNota))
+	if err != nil {
+		return fmt.Errorf("erro ao criar request: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenML))
+	req.Header.Set("Content-Type", "application/xml")
+	req.Header.Set("Accept", "application/xml")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("erro ao fazer request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, _ := io.ReadAll(resp.Body)
+	log.Printf("📤 Resposta ML - Status: %d, Body: %s", resp.StatusCode, string(responseBody))
+
+	if resp.StatusCode == http.StatusNotAcceptable {
+		log.Println("✅ XML enviado com sucesso!")
+		return nil
+	}
+
+	return fmt.Errorf("erro ao enviar XML - Status: %d, Body: %s", resp.StatusCode, string(responseBody))
+}
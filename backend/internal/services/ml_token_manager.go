@@ -0,0 +1,479 @@
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"amz-web-tools/backend/internal/config"
+	"amz-web-tools/backend/internal/httpclient"
+)
+
+// mlTokenRefreshMargin antecipa a renovação do token para evitar que ele
+// expire durante uma integração
+const mlTokenRefreshMargin = 10 * time.Minute
+
+// ErrMLCredentialsNotFound indica uma conta sem credenciais OAuth cadastradas
+var ErrMLCredentialsNotFound = errors.New("credenciais do Mercado Livre não cadastradas")
+
+// MLCredentials representa as credenciais OAuth de uma conta. Os segredos
+// nunca são serializados nas respostas da API.
+type MLCredentials struct {
+	Conta         string     `json:"conta"`
+	ClientID      string     `json:"client_id"`
+	ClientSecret  string     `json:"-"`
+	RefreshToken  string     `json:"-"`
+	SellerID      string     `json:"seller_id,omitempty"`
+	LastRefreshAt *time.Time `json:"last_refresh_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	UpdatedBy     string     `json:"updated_by,omitempty"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// MLCredentialsRequest representa o cadastro ou a rotação das credenciais de uma conta.
+// Na rotação, client_id e client_secret vazios mantêm os valores atuais.
+type MLCredentialsRequest struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// MLCredentialsStatus representa uma conta e o estado do token em cache
+type MLCredentialsStatus struct {
+	Conta          string         `json:"conta"`
+	Configured     bool           `json:"configured"`
+	Credentials    *MLCredentials `json:"credentials,omitempty"`
+	TokenExpiresAt *time.Time     `json:"token_expires_at,omitempty"`
+}
+
+type mlAccessToken struct {
+	AccessToken string
+	SellerID    string
+	ExpiresAt   time.Time
+}
+
+type mlOAuthResponse struct {
+	AccessToken  string      `json:"access_token"`
+	TokenType    string      `json:"token_type"`
+	ExpiresIn    int         `json:"expires_in"`
+	UserID       interface{} `json:"user_id"`
+	RefreshToken string      `json:"refresh_token"`
+	Error        string      `json:"error"`
+	Message      string      `json:"message"`
+}
+
+// MLTokenManager guarda as credenciais OAuth de cada conta criptografadas no
+// banco e mantém em memória os tokens de acesso, renovando-os antes de expirar
+type MLTokenManager struct {
+	sqlDB  *sql.DB
+	client *httpclient.Client
+	aead   cipher.AEAD
+
+	mu     sync.Mutex
+	tokens map[string]mlAccessToken
+	locks  map[string]*sync.Mutex
+}
+
+func NewMLTokenManager(sqlDB *sql.DB, cfg *config.Config, client *httpclient.Client) *MLTokenManager {
+	m := &MLTokenManager{
+		sqlDB:  sqlDB,
+		client: client,
+		tokens: make(map[string]mlAccessToken),
+		locks:  make(map[string]*sync.Mutex),
+	}
+
+	if cfg.CredentialsEncryptionKey == "" {
+		log.Printf("⚠️ CREDENTIALS_ENCRYPTION_KEY não configurada: credenciais do Mercado Livre desabilitadas")
+		return m
+	}
+
+	// A chave configurada pode ter qualquer tamanho; o SHA-256 gera os 32 bytes do AES-256
+	key := sha256.Sum256([]byte(cfg.CredentialsEncryptionKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		log.Printf("⚠️ Erro ao inicializar criptografia de credenciais: %v", err)
+		return m
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		log.Printf("⚠️ Erro ao inicializar criptografia de credenciais: %v", err)
+		return m
+	}
+	m.aead = aead
+
+	return m
+}
+
+// Token retorna um token de acesso válido e o seller ID da conta, renovando-o
+// pelo fluxo de refresh token quando necessário
+func (m *MLTokenManager) Token(ctx context.Context, conta string) (string, string, error) {
+	conta = strings.ToLower(conta)
+
+	if token, ok := m.cachedToken(conta); ok {
+		return token.AccessToken, token.SellerID, nil
+	}
+
+	// Serializa a renovação por conta: o refresh token do Mercado Livre é de uso único
+	lock := m.contaLock(conta)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if token, ok := m.cachedToken(conta); ok {
+		return token.AccessToken, token.SellerID, nil
+	}
+
+	token, err := m.refresh(ctx, conta)
+	if err != nil {
+		return "", "", err
+	}
+	return token.AccessToken, token.SellerID, nil
+}
+
+// Refresh força a renovação do token de acesso da conta
+func (m *MLTokenManager) Refresh(ctx context.Context, conta string) (*MLCredentialsStatus, error) {
+	conta = strings.ToLower(conta)
+
+	lock := m.contaLock(conta)
+	lock.Lock()
+	_, err := m.refresh(ctx, conta)
+	lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return m.status(conta)
+}
+
+// GetCredentials retorna o estado das credenciais de todas as contas conhecidas
+func (m *MLTokenManager) GetCredentials() ([]MLCredentialsStatus, error) {
+	query := `
+		SELECT conta, client_id, seller_id, last_refresh_at, last_error, updated_by, updated_at
+		FROM ml_credentials`
+
+	rows, err := m.sqlDB.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ML credentials: %w", err)
+	}
+	defer rows.Close()
+
+	stored := make(map[string]*MLCredentials)
+	for rows.Next() {
+		var creds MLCredentials
+		var sellerID, lastError, updatedBy sql.NullString
+		var lastRefreshAt sql.NullTime
+		if err := rows.Scan(&creds.Conta, &creds.ClientID, &sellerID, &lastRefreshAt, &lastError, &updatedBy, &creds.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan ML credentials: %w", err)
+		}
+		creds.SellerID = sellerID.String
+		creds.LastError = lastError.String
+		creds.UpdatedBy = updatedBy.String
+		if lastRefreshAt.Valid {
+			creds.LastRefreshAt = &lastRefreshAt.Time
+		}
+		stored[creds.Conta] = &creds
+	}
+
+	statuses := make([]MLCredentialsStatus, 0, len(integrationContas))
+	for _, conta := range integrationContas {
+		statuses = append(statuses, m.statusFor(conta, stored[conta]))
+	}
+
+	return statuses, nil
+}
+
+// SaveCredentials cadastra ou rotaciona as credenciais de uma conta. As novas
+// credenciais são validadas com uma renovação de token antes de serem gravadas.
+func (m *MLTokenManager) SaveCredentials(ctx context.Context, conta string, req MLCredentialsRequest, updatedBy string) (*MLCredentialsStatus, error) {
+	conta = strings.ToLower(conta)
+	if !isIntegrationConta(conta) {
+		return nil, fmt.Errorf("conta inválida: %s", conta)
+	}
+	if m.aead == nil {
+		return nil, fmt.Errorf("CREDENTIALS_ENCRYPTION_KEY não configurada")
+	}
+
+	lock := m.contaLock(conta)
+	lock.Lock()
+	defer lock.Unlock()
+
+	creds := &MLCredentials{
+		Conta:        conta,
+		ClientID:     strings.TrimSpace(req.ClientID),
+		ClientSecret: strings.TrimSpace(req.ClientSecret),
+		RefreshToken: strings.TrimSpace(req.RefreshToken),
+	}
+
+	if creds.ClientID == "" || creds.ClientSecret == "" {
+		current, err := m.loadCredentials(conta)
+		if err != nil {
+			return nil, fmt.Errorf("client_id e client_secret são obrigatórios no primeiro cadastro: %w", err)
+		}
+		if creds.ClientID == "" {
+			creds.ClientID = current.ClientID
+		}
+		if creds.ClientSecret == "" {
+			creds.ClientSecret = current.ClientSecret
+		}
+	}
+
+	token, err := m.requestToken(ctx, creds)
+	if err != nil {
+		return nil, fmt.Errorf("credenciais recusadas pelo Mercado Livre: %w", err)
+	}
+
+	clientSecret, err := m.encrypt(creds.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := m.encrypt(creds.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		MERGE ml_credentials AS target
+		USING (SELECT @p1 AS conta) AS source ON target.conta = source.conta
+		WHEN MATCHED THEN UPDATE SET client_id = @p2, client_secret = @p3, refresh_token = @p4, seller_id = @p5,
+			last_refresh_at = GETDATE(), last_error = NULL, updated_by = @p6, updated_at = GETDATE()
+		WHEN NOT MATCHED THEN INSERT (conta, client_id, client_secret, refresh_token, seller_id, last_refresh_at, updated_by)
+			VALUES (@p1, @p2, @p3, @p4, @p5, GETDATE(), @p6);`
+
+	if _, err := m.sqlDB.Exec(query, conta, creds.ClientID, clientSecret, refreshToken, token.SellerID, updatedBy); err != nil {
+		return nil, fmt.Errorf("failed to save ML credentials: %w", err)
+	}
+
+	m.mu.Lock()
+	m.tokens[conta] = *token
+	m.mu.Unlock()
+
+	log.Printf("🔑 Credenciais ML da conta %s atualizadas por %s", strings.ToUpper(conta), updatedBy)
+	return m.status(conta)
+}
+
+// DeleteCredentials remove as credenciais de uma conta e descarta o token em cache
+func (m *MLTokenManager) DeleteCredentials(conta string) error {
+	conta = strings.ToLower(conta)
+
+	result, err := m.sqlDB.Exec(`DELETE FROM ml_credentials WHERE conta = @p1`, conta)
+	if err != nil {
+		return fmt.Errorf("failed to delete ML credentials: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrMLCredentialsNotFound
+	}
+
+	m.mu.Lock()
+	delete(m.tokens, conta)
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *MLTokenManager) cachedToken(conta string) (mlAccessToken, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, ok := m.tokens[conta]
+	if !ok || time.Until(token.ExpiresAt) < mlTokenRefreshMargin {
+		return mlAccessToken{}, false
+	}
+	return token, true
+}
+
+func (m *MLTokenManager) contaLock(conta string) *sync.Mutex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lock, ok := m.locks[conta]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.locks[conta] = lock
+	}
+	return lock
+}
+
+// refresh renova o token da conta e grava o novo refresh token; deve ser
+// chamado com o lock da conta
+func (m *MLTokenManager) refresh(ctx context.Context, conta string) (*mlAccessToken, error) {
+	creds, err := m.loadCredentials(conta)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("🔄 Renovando token ML da conta %s", strings.ToUpper(conta))
+
+	token, err := m.requestToken(ctx, creds)
+	if err != nil {
+		m.recordError(conta, err)
+		return nil, fmt.Errorf("erro ao renovar token ML da conta %s: %w", conta, err)
+	}
+
+	refreshToken, err := m.encrypt(creds.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE ml_credentials
+		SET refresh_token = @p1, seller_id = @p2, last_refresh_at = GETDATE(), last_error = NULL
+		WHERE conta = @p3`
+
+	if _, err := m.sqlDB.ExecContext(ctx, query, refreshToken, token.SellerID, conta); err != nil {
+		// O refresh token antigo já foi invalidado pelo Mercado Livre; o token de
+		// acesso continua válido em memória até a próxima renovação
+		log.Printf("❌ Erro ao gravar refresh token da conta %s: %v", conta, err)
+	}
+
+	m.mu.Lock()
+	m.tokens[conta] = *token
+	m.mu.Unlock()
+
+	log.Printf("✅ Token ML da conta %s renovado (expira em %s)", strings.ToUpper(conta), token.ExpiresAt.Format("15:04:05"))
+	return token, nil
+}
+
+// requestToken troca o refresh token por um novo token de acesso. O Mercado
+// Livre rotaciona o refresh token a cada uso; o novo valor é gravado em creds.
+func (m *MLTokenManager) requestToken(ctx context.Context, creds *MLCredentials) (*mlAccessToken, error) {
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("client_id", creds.ClientID)
+	form.Set("client_secret", creds.ClientSecret)
+	form.Set("refresh_token", creds.RefreshToken)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", mercadoLivreAPIURL+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var oauth mlOAuthResponse
+	if err := json.Unmarshal(body, &oauth); err != nil {
+		return nil, fmt.Errorf("resposta inválida do OAuth (HTTP %d): %s", resp.StatusCode, string(body))
+	}
+	if resp.StatusCode != http.StatusOK || oauth.AccessToken == "" {
+		return nil, fmt.Errorf("HTTP %d: %s %s", resp.StatusCode, oauth.Error, oauth.Message)
+	}
+
+	if oauth.RefreshToken != "" {
+		creds.RefreshToken = oauth.RefreshToken
+	}
+
+	return &mlAccessToken{
+		AccessToken: oauth.AccessToken,
+		SellerID:    formatMLID(oauth.UserID),
+		ExpiresAt:   time.Now().Add(time.Duration(oauth.ExpiresIn) * time.Second),
+	}, nil
+}
+
+func (m *MLTokenManager) loadCredentials(conta string) (*MLCredentials, error) {
+	if m.aead == nil {
+		return nil, ErrMLCredentialsNotFound
+	}
+
+	creds := &MLCredentials{Conta: conta}
+	var clientSecret, refreshToken string
+
+	query := `SELECT client_id, client_secret, refresh_token FROM ml_credentials WHERE conta = @p1`
+	err := m.sqlDB.QueryRow(query, conta).Scan(&creds.ClientID, &clientSecret, &refreshToken)
+	if err == sql.ErrNoRows {
+		return nil, ErrMLCredentialsNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ML credentials: %w", err)
+	}
+
+	if creds.ClientSecret, err = m.decrypt(clientSecret); err != nil {
+		return nil, err
+	}
+	if creds.RefreshToken, err = m.decrypt(refreshToken); err != nil {
+		return nil, err
+	}
+
+	return creds, nil
+}
+
+func (m *MLTokenManager) recordError(conta string, refreshErr error) {
+	_, err := m.sqlDB.Exec(`UPDATE ml_credentials SET last_error = @p1 WHERE conta = @p2`, refreshErr.Error(), conta)
+	if err != nil {
+		log.Printf("⚠️ Erro ao registrar falha de renovação da conta %s: %v", conta, err)
+	}
+}
+
+func (m *MLTokenManager) status(conta string) (*MLCredentialsStatus, error) {
+	statuses, err := m.GetCredentials()
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range statuses {
+		if status.Conta == conta {
+			return &status, nil
+		}
+	}
+	return nil, fmt.Errorf("conta inválida: %s", conta)
+}
+
+func (m *MLTokenManager) statusFor(conta string, creds *MLCredentials) MLCredentialsStatus {
+	status := MLCredentialsStatus{Conta: conta, Configured: creds != nil, Credentials: creds}
+
+	m.mu.Lock()
+	if token, ok := m.tokens[conta]; ok {
+		expiresAt := token.ExpiresAt
+		status.TokenExpiresAt = &expiresAt
+	}
+	m.mu.Unlock()
+
+	return status
+}
+
+// encrypt criptografa um segredo com AES-GCM; o nonce é gravado antes do texto cifrado
+func (m *MLTokenManager) encrypt(plain string) (string, error) {
+	nonce := make([]byte, m.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	sealed := m.aead.Seal(nonce, nonce, []byte(plain), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (m *MLTokenManager) decrypt(encoded string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < m.aead.NonceSize() {
+		return "", fmt.Errorf("credencial criptografada inválida")
+	}
+
+	nonce, ciphertext := sealed[:m.aead.NonceSize()], sealed[m.aead.NonceSize():]
+	plain, err := m.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("falha ao descriptografar credencial (CREDENTIALS_ENCRYPTION_KEY alterada?)")
+	}
+
+	return string(plain), nil
+}
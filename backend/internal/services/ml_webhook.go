@@ -0,0 +1,371 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"amz-web-tools/backend/internal/config"
+
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// Tópicos de notificação do Mercado Livre tratados pelo webhook
+const (
+	MLTopicOrders    = "orders_v2"
+	MLTopicShipments = "shipments"
+)
+
+// Status de um evento de webhook
+const (
+	webhookEventProcessing = "processing"
+	webhookEventProcessed  = "processed"
+	webhookEventIgnored    = "ignored"
+	webhookEventFailed     = "failed"
+)
+
+// Eventos que ficam em processing por mais de webhookEventStaleAfter (servidor
+// reiniciado antes de concluí-los) voltam para a fila; a verificação ocorre a
+// cada webhookRequeueInterval e uma notificação nova do recurso também os
+// reprocessa
+const (
+	webhookEventStaleAfter = time.Hour
+	webhookRequeueInterval = 5 * time.Minute
+)
+
+// mlSellersRefreshInterval limita a frequência com que os tokens das contas
+// são consultados para descobrir o seller ID de um user_id desconhecido
+const mlSellersRefreshInterval = 10 * time.Minute
+
+// ErrWebhookUnauthorized indica uma notificação de aplicação ou usuário desconhecido
+var ErrWebhookUnauthorized = errors.New("notificação não autorizada")
+
+// MLNotification representa uma notificação enviada pelo Mercado Livre
+type MLNotification struct {
+	ID            string `json:"_id"`
+	Resource      string `json:"resource" binding:"required"`
+	UserID        int64  `json:"user_id" binding:"required"`
+	Topic         string `json:"topic" binding:"required"`
+	ApplicationID int64  `json:"application_id"`
+	Attempts      int    `json:"attempts"`
+	Sent          string `json:"sent"`
+	Received      string `json:"received"`
+}
+
+type mlWebhookEvent struct {
+	Topic    string
+	Resource string
+	Conta    string
+}
+
+// MLWebhookService recebe as notificações do Mercado Livre e despacha o processamento
+type MLWebhookService struct {
+	sqlDB         *sql.DB
+	integration   *IntegrationService
+	xmlIntegrator *XMLIntegratorService
+	mercadoLivre  *MercadoLivreMarketplace
+	applicationID string
+	queue         chan mlWebhookEvent
+
+	mu              sync.Mutex
+	sellers         map[string]string // seller ID -> conta
+	sellersLoadedAt time.Time
+}
+
+func NewMLWebhookService(sqlDB *sql.DB, integration *IntegrationService, xmlIntegrator *XMLIntegratorService, mercadoLivre *MercadoLivreMarketplace, cfg *config.Config) *MLWebhookService {
+	s := &MLWebhookService{
+		sqlDB:         sqlDB,
+		integration:   integration,
+		xmlIntegrator: xmlIntegrator,
+		mercadoLivre:  mercadoLivre,
+		applicationID: cfg.MLApplicationID,
+		queue:         make(chan mlWebhookEvent, 500),
+		sellers:       make(map[string]string),
+	}
+	if s.applicationID == "" {
+		log.Printf("⚠️ ML_APPLICATION_ID não configurado: as notificações do Mercado Livre serão recusadas")
+	}
+
+	return s
+}
+
+// Start inicia o worker das notificações; ctx é o contexto do servidor, que
+// interrompe o processamento no desligamento. Um único worker processa os
+// eventos em ordem, evitando integrações simultâneas do mesmo pedido e
+// sobrecarga do NBS.
+func (s *MLWebhookService) Start(ctx context.Context) {
+	go s.worker(ctx)
+}
+
+// Receive valida e registra a notificação; o processamento ocorre em background.
+// Notificações repetidas para um recurso já processado são descartadas.
+func (s *MLWebhookService) Receive(ctx context.Context, n MLNotification) error {
+	if n.Topic != MLTopicOrders && n.Topic != MLTopicShipments {
+		log.Printf("🔕 Notificação ML ignorada (tópico %s): %s", n.Topic, n.Resource)
+		return nil
+	}
+
+	// Sem ML_APPLICATION_ID configurado nenhuma notificação é aceita
+	if s.applicationID == "" || strconv.FormatInt(n.ApplicationID, 10) != s.applicationID {
+		return fmt.Errorf("%w: application_id %d", ErrWebhookUnauthorized, n.ApplicationID)
+	}
+
+	userID := strconv.FormatInt(n.UserID, 10)
+	conta, ok := s.resolveConta(ctx, userID)
+	if !ok {
+		return fmt.Errorf("%w: user_id %s não pertence a nenhuma conta", ErrWebhookUnauthorized, userID)
+	}
+
+	claimed, err := s.claimEvent(n.Topic, n.Resource, userID, conta)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		log.Printf("🔁 Notificação ML duplicada descartada: %s %s", n.Topic, n.Resource)
+		return nil
+	}
+
+	event := mlWebhookEvent{Topic: n.Topic, Resource: n.Resource, Conta: conta}
+	select {
+	case s.queue <- event:
+		log.Printf("📨 Notificação ML recebida: %s %s (conta %s)", n.Topic, n.Resource, strings.ToUpper(conta))
+	default:
+		s.finishEvent(event, webhookEventFailed, "fila de notificações cheia")
+	}
+
+	return nil
+}
+
+// resolveConta identifica a conta pelo seller ID do token de cada conta. Os
+// seller IDs são consultados fora do lock, para que as demais notificações não
+// esperem pelas chamadas ao Mercado Livre.
+func (s *MLWebhookService) resolveConta(ctx context.Context, userID string) (string, bool) {
+	s.mu.Lock()
+	conta, ok := s.sellers[userID]
+	refresh := !ok && time.Since(s.sellersLoadedAt) >= mlSellersRefreshInterval
+	if !refresh {
+		s.mu.Unlock()
+		return conta, ok
+	}
+	// Marca a renovação antes de soltar o lock: só uma requisição consulta as contas
+	s.sellersLoadedAt = time.Now()
+	sellers := make(map[string]string, len(s.sellers))
+	for sellerID, conta := range s.sellers {
+		sellers[sellerID] = conta
+	}
+	s.mu.Unlock()
+
+	for _, conta := range integrationContas {
+		sellerID, err := s.mercadoLivre.SellerID(ctx, conta)
+		if err != nil {
+			log.Printf("⚠️ Erro ao obter seller ID da conta %s: %v", conta, err)
+			continue
+		}
+		sellers[sellerID] = conta
+	}
+
+	s.mu.Lock()
+	s.sellers = sellers
+	s.mu.Unlock()
+
+	conta, ok = sellers[userID]
+	return conta, ok
+}
+
+// claimEvent registra o evento; eventos ignorados, com falha ou presos em
+// processing podem ser reprocessados
+func (s *MLWebhookService) claimEvent(topic, resource, userID, conta string) (bool, error) {
+	query := `
+		UPDATE ml_webhook_events
+		SET status = @p1, attempts = COALESCE(attempts, 0) + 1, ml_user_id = @p2, conta = @p3,
+		    error_message = NULL, received_at = GETDATE(), processed_at = NULL
+		WHERE topic = @p4 AND resource = @p5
+		  AND (status IN (@p6, @p7) OR (status = @p1 AND received_at < DATEADD(MINUTE, -@p8, GETDATE())))`
+
+	result, err := s.sqlDB.Exec(query, webhookEventProcessing, userID, conta, topic, resource,
+		webhookEventIgnored, webhookEventFailed, int(webhookEventStaleAfter.Minutes()))
+	if err != nil {
+		return false, fmt.Errorf("failed to claim webhook event: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
+		return true, nil
+	}
+
+	_, err = s.sqlDB.Exec(`
+		INSERT INTO ml_webhook_events (topic, resource, ml_user_id, conta, status)
+		VALUES (@p1, @p2, @p3, @p4, @p5)`,
+		topic, resource, userID, conta, webhookEventProcessing)
+	if err != nil {
+		var sqlErr mssql.Error
+		if errors.As(err, &sqlErr) && (sqlErr.Number == 2627 || sqlErr.Number == 2601) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to save webhook event: %w", err)
+	}
+
+	return true, nil
+}
+
+func (s *MLWebhookService) finishEvent(event mlWebhookEvent, status, message string) {
+	query := `
+		UPDATE ml_webhook_events
+		SET status = @p1, error_message = @p2, processed_at = GETDATE()
+		WHERE topic = @p3 AND resource = @p4`
+
+	_, err := s.sqlDB.Exec(query, status, sql.NullString{String: message, Valid: message != ""}, event.Topic, event.Resource)
+	if err != nil {
+		log.Printf("⚠️ Erro ao atualizar evento de webhook %s: %v", event.Resource, err)
+	}
+}
+
+// worker processa os eventos depois que a requisição do webhook já terminou
+// e recoloca na fila os eventos presos em processing
+func (s *MLWebhookService) worker(ctx context.Context) {
+	ticker := time.NewTicker(webhookRequeueInterval)
+	defer ticker.Stop()
+
+	s.requeueStaleEvents(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.requeueStaleEvents(ctx)
+		case event := <-s.queue:
+			var status, message string
+			switch event.Topic {
+			case MLTopicOrders:
+				status, message = s.processOrder(ctx, event)
+			case MLTopicShipments:
+				status, message = s.processShipment(ctx, event)
+			}
+
+			if status == webhookEventFailed {
+				log.Printf("❌ Notificação ML %s falhou: %s", event.Resource, message)
+			}
+			s.finishEvent(event, status, message)
+		}
+	}
+}
+
+// requeueStaleEvents reivindica os eventos em processing há mais de
+// webhookEventStaleAfter, até o espaço livre da fila, e os coloca na fila
+func (s *MLWebhookService) requeueStaleEvents(ctx context.Context) {
+	free := cap(s.queue) - len(s.queue)
+	if free <= 0 {
+		return
+	}
+
+	rows, err := s.sqlDB.QueryContext(ctx, `
+		UPDATE TOP (@p1) ml_webhook_events
+		SET attempts = COALESCE(attempts, 0) + 1, received_at = GETDATE()
+		OUTPUT INSERTED.topic, INSERTED.resource, COALESCE(INSERTED.conta, '')
+		WHERE status = @p2 AND received_at < DATEADD(MINUTE, -@p3, GETDATE())`,
+		free, webhookEventProcessing, int(webhookEventStaleAfter.Minutes()))
+	if err != nil {
+		log.Printf("⚠️ Erro ao buscar eventos de webhook pendentes: %v", err)
+		return
+	}
+
+	var events []mlWebhookEvent
+	for rows.Next() {
+		var event mlWebhookEvent
+		if err := rows.Scan(&event.Topic, &event.Resource, &event.Conta); err != nil {
+			log.Printf("⚠️ Erro ao ler evento de webhook pendente: %v", err)
+			continue
+		}
+		events = append(events, event)
+	}
+	rows.Close()
+
+	for _, event := range events {
+		select {
+		case s.queue <- event:
+			log.Printf("🔁 Notificação ML pendente recolocada na fila: %s %s", event.Topic, event.Resource)
+		default:
+			s.finishEvent(event, webhookEventFailed, "fila de notificações cheia")
+		}
+	}
+}
+
+func (s *MLWebhookService) processOrder(ctx context.Context, event mlWebhookEvent) (string, string) {
+	orderID := strings.TrimPrefix(event.Resource, "/orders/")
+
+	order, err := s.mercadoLivre.FetchOrder(ctx, event.Conta, orderID)
+	if err != nil {
+		return webhookEventFailed, err.Error()
+	}
+
+	if order.Status != "paid" {
+		return webhookEventIgnored, fmt.Sprintf("pedido com status %s", order.Status)
+	}
+
+	// Pedidos de um carrinho são integrados pelo pack_id
+	numPedido := order.NumPedido()
+
+	pending, err := s.integration.filterNewOrders(ctx, []string{numPedido})
+	if err != nil {
+		return webhookEventFailed, err.Error()
+	}
+	if len(pending) == 0 {
+		return webhookEventProcessed, fmt.Sprintf("pedido %s já integrado", numPedido)
+	}
+
+	result, err := s.integration.ProcessIntegration(ctx, IntegrationRequest{
+		Conta:       event.Conta,
+		Marketplace: s.mercadoLivre.Name(),
+		NumPedido:   numPedido,
+	})
+	if err != nil {
+		return webhookEventFailed, err.Error()
+	}
+	if result.ErrorCount > 0 {
+		return webhookEventFailed, fmt.Sprintf("integração do pedido %s falhou (job %s)", numPedido, result.JobID)
+	}
+
+	return webhookEventProcessed, ""
+}
+
+func (s *MLWebhookService) processShipment(ctx context.Context, event mlWebhookEvent) (string, string) {
+	numEnvio := strings.TrimPrefix(event.Resource, "/shipments/")
+
+	shipment, err := s.mercadoLivre.FetchShipment(ctx, event.Conta, numEnvio)
+	if err != nil {
+		return webhookEventFailed, fmt.Sprintf("erro ao obter envio: %v", err)
+	}
+
+	if shipment.Substatus != "invoice_pending" {
+		return webhookEventIgnored, fmt.Sprintf("envio com status %s/%s", shipment.Status, shipment.Substatus)
+	}
+
+	numPedido, err := s.xmlIntegrator.FindPedidoByEnvio(ctx, numEnvio)
+	if err != nil {
+		return webhookEventFailed, err.Error()
+	}
+
+	if _, err := s.xmlIntegrator.ProcessXMLIntegration(ctx, Scope{}, numPedido, ""); err != nil {
+		return webhookEventFailed, err.Error()
+	}
+
+	return webhookEventProcessed, ""
+}
+
+// formatMLID converte um ID numérico do JSON do Mercado Livre em string sem notação científica
+func formatMLID(id interface{}) string {
+	switch v := id.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return v
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -0,0 +1,326 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"amz-web-tools/backend/internal/nbs"
+)
+
+// nbsUFs mapeia os nomes dos estados para a UF; os IDs do Mercado Livre
+// (BR-SP) e as próprias UFs também são aceitos
+var nbsUFs = map[string]string{
+	"acre": "AC", "alagoas": "AL", "amapa": "AP", "amazonas": "AM", "bahia": "BA",
+	"ceara": "CE", "distrito federal": "DF", "espirito santo": "ES", "goias": "GO",
+	"maranhao": "MA", "mato grosso": "MT", "mato grosso do sul": "MS", "minas gerais": "MG",
+	"para": "PA", "paraiba": "PB", "parana": "PR", "pernambuco": "PE", "piaui": "PI",
+	"rio de janeiro": "RJ", "rio grande do norte": "RN", "rio grande do sul": "RS",
+	"rondonia": "RO", "roraima": "RR", "santa catarina": "SC", "sao paulo": "SP",
+	"sergipe": "SE", "tocantins": "TO",
+}
+
+// FieldError representa um campo inválido do cadastro do NBS
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// BuyerValidationError lista todos os campos do comprador que impedem o
+// cadastro no NBS
+type BuyerValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *BuyerValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, fmt.Sprintf("%s: %s", field.Field, field.Message))
+	}
+	return "dados do comprador inválidos - " + strings.Join(messages, "; ")
+}
+
+func (e *BuyerValidationError) add(field, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+// nbsBuyerData é o cadastro do comprador já convertido para o NBS
+type nbsBuyerData struct {
+	Cliente  nbs.Cliente
+	Endereco nbs.Endereco
+	Cidade   string
+}
+
+// mapBuyerToNBS monta o cliente e o endereço do NBS a partir dos dados de
+// faturamento do comprador, completando com o destinatário do envio. Todos os
+// campos são validados antes de retornar; sexo, nascimento e e-mail, que os
+// marketplaces não informam, são enviados em branco.
+func (s *IntegrationService) mapBuyerToNBS(ctx context.Context, buyer *MarketplaceBuyer, shipment *MarketplaceShipment) (*nbsBuyerData, error) {
+	validation := &BuyerValidationError{}
+
+	// Documento
+	docNumber, docErr := normalizeDocument(buyer.DocType, buyer.DocNumber)
+	if docErr != "" {
+		validation.add("cpfCnpj", docErr)
+	}
+	tipoCliente := "J"
+	if len(docNumber) == 11 {
+		tipoCliente = "F"
+	}
+
+	nome := strings.ToUpper(strings.TrimSpace(buyer.Name))
+	if nome == "" && shipment != nil {
+		nome = strings.ToUpper(strings.TrimSpace(shipment.ReceiverName))
+	}
+	if nome == "" {
+		validation.add("nome", "nome do comprador não informado")
+	}
+
+	// Endereço: faturamento, ou o endereço de entrega se o faturamento estiver incompleto
+	address := buyer.Address
+	if (address.Street == "" || address.ZipCode == "" || address.City == "") && shipment != nil {
+		address = shipment.Address
+	}
+
+	cep := onlyDigits(address.ZipCode)
+	if len(cep) != 8 {
+		validation.add("CEP", fmt.Sprintf("CEP inválido: '%s'", address.ZipCode))
+	}
+
+	rua := strings.ToUpper(strings.TrimSpace(address.Street))
+	if rua == "" {
+		validation.add("rua", "logradouro não informado")
+	}
+
+	numero := strings.TrimSpace(address.Number)
+	if numero == "" {
+		numero = "S/N"
+	}
+
+	bairro := strings.ToUpper(strings.TrimSpace(address.Neighborhood))
+	if bairro == "" {
+		bairro = "CENTRO"
+	}
+
+	uf, ok := ufFromState(address.State)
+	if !ok {
+		validation.add("uf", fmt.Sprintf("estado inválido: '%s'", address.State))
+	}
+
+	var codCidade string
+	if address.City == "" {
+		validation.add("codCidades", "município não informado")
+	} else if ok {
+		code, err := s.ibge.CityCode(ctx, uf, address.City)
+		if err != nil {
+			validation.add("codCidades", err.Error())
+		}
+		codCidade = code
+	}
+
+	// Telefone
+	phone := buyer.Phone
+	if phone == "" && shipment != nil {
+		phone = shipment.ReceiverPhone
+	}
+	var prefixo, telefone string
+	if phone == "" {
+		validation.add("telefoneCelular", "telefone do comprador não informado")
+	} else if p, t, err := splitPhone(phone); err != nil {
+		validation.add("telefoneCelular", err.Error())
+	} else {
+		prefixo, telefone = p, t
+	}
+
+	if len(validation.Fields) > 0 {
+		return nil, validation
+	}
+
+	rgIe := "0"
+	inscricaoEstadual := "ISENTO"
+	if ie := onlyDigits(buyer.StateRegistration); tipoCliente == "J" && ie != "" {
+		rgIe = ie
+		inscricaoEstadual = ie
+	}
+
+	contato := nome
+	if shipment != nil && shipment.ReceiverName != "" {
+		contato = strings.ToUpper(shipment.ReceiverName)
+	}
+
+	complemento := strings.ToUpper(strings.TrimSpace(address.Complement))
+	if complemento == "" {
+		complemento = "N/A"
+	}
+
+	return &nbsBuyerData{
+		Cliente: nbs.Cliente{
+			CodigoCliente:       docNumber,
+			CodigoTipoCliente:   1,
+			CodigoRamo:          "V",
+			CodigoClasse:        53,
+			CodigoClasseTipo:    "24",
+			CodigoEstadoCivil:   "1",
+			PrefixoCelular:      prefixo,
+			TelefoneCelular:     telefone,
+			PrefixoComercial:    prefixo,
+			TelefoneComercial:   telefone,
+			PrefixoResidencial:  prefixo,
+			TelefoneResidencial: telefone,
+			CodigoNacionalidade: "36",
+			CodigoProfissao:     "102",
+			Tipo:                tipoCliente,
+			Nome:                truncate(nome, 60),
+			CpfCnpj:             docNumber,
+			RgIe:                rgIe,
+			Ssp:                 uf,
+			AtualizaExistente:   true,
+			ClienteRevendedor:   false,
+			Rua:                 rua,
+			Bairro:              bairro,
+			UF:                  uf,
+			CEP:                 cep,
+		},
+		Endereco: nbs.Endereco{
+			CodigoCliente:          docNumber,
+			ClienteTipoEndereco:    4,
+			CodCidades:             codCidade,
+			CEP:                    cep,
+			Rua:                    rua,
+			Complemento:            truncate(complemento, 60),
+			Bairro:                 bairro,
+			UF:                     uf,
+			NumeroEndereco:         numero,
+			NomePropriedade:        "Internet",
+			InscricaoEstadual:      inscricaoEstadual,
+			Fachada:                "Internet",
+			Contato:                truncate(contato, 40),
+			TelefoneContato:        telefone,
+			PrefixoTelefoneContato: prefixo,
+		},
+		Cidade: strings.ToUpper(strings.TrimSpace(address.City)),
+	}, nil
+}
+
+// buyerWarnings aponta os valores padrão que o mapeamento usou no lugar de
+// dados do comprador
+func buyerWarnings(buyer *nbsBuyerData) []string {
+	var warnings []string
+	if buyer.Endereco.NumeroEndereco == "S/N" {
+		warnings = append(warnings, "Número do endereço não informado, usando S/N")
+	}
+	if buyer.Cliente.Tipo == "J" && buyer.Endereco.InscricaoEstadual == "ISENTO" {
+		warnings = append(warnings, "Inscrição estadual do comprador PJ não informada, usando ISENTO")
+	}
+	return warnings
+}
+
+// normalizeDocument remove a pontuação do CPF/CNPJ e valida os dígitos
+// verificadores; retorna a mensagem de erro em vez de error para compor a validação
+func normalizeDocument(docType, docNumber string) (string, string) {
+	doc := onlyDigits(docNumber)
+
+	switch {
+	case doc == "":
+		return "", "documento não informado"
+	case len(doc) == 11:
+		if docType != "" && !strings.EqualFold(docType, "CPF") {
+			return doc, fmt.Sprintf("documento do tipo %s com 11 dígitos", docType)
+		}
+		if !validCPF(doc) {
+			return doc, fmt.Sprintf("CPF inválido: %s", doc)
+		}
+	case len(doc) == 14:
+		if docType != "" && !strings.EqualFold(docType, "CNPJ") {
+			return doc, fmt.Sprintf("documento do tipo %s com 14 dígitos", docType)
+		}
+		if !validCNPJ(doc) {
+			return doc, fmt.Sprintf("CNPJ inválido: %s", doc)
+		}
+	default:
+		return doc, fmt.Sprintf("documento com %d dígitos: %s", len(doc), doc)
+	}
+
+	return doc, ""
+}
+
+func validCPF(cpf string) bool {
+	if allSameDigit(cpf) {
+		return false
+	}
+	return checkDigit(cpf[:9], []int{10, 9, 8, 7, 6, 5, 4, 3, 2}) == int(cpf[9]-'0') &&
+		checkDigit(cpf[:10], []int{11, 10, 9, 8, 7, 6, 5, 4, 3, 2}) == int(cpf[10]-'0')
+}
+
+func validCNPJ(cnpj string) bool {
+	if allSameDigit(cnpj) {
+		return false
+	}
+	return checkDigit(cnpj[:12], []int{5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2}) == int(cnpj[12]-'0') &&
+		checkDigit(cnpj[:13], []int{6, 5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2}) == int(cnpj[13]-'0')
+}
+
+func checkDigit(digits string, weights []int) int {
+	sum := 0
+	for i, weight := range weights {
+		sum += int(digits[i]-'0') * weight
+	}
+	if rest := sum % 11; rest >= 2 {
+		return 11 - rest
+	}
+	return 0
+}
+
+func allSameDigit(doc string) bool {
+	return strings.Count(doc, doc[:1]) == len(doc)
+}
+
+// splitPhone separa DDD e número de um telefone brasileiro, removendo o
+// código do país e o zero de discagem
+func splitPhone(phone string) (string, string, error) {
+	digits := onlyDigits(phone)
+	if len(digits) >= 12 && strings.HasPrefix(digits, "55") {
+		digits = digits[2:]
+	}
+	digits = strings.TrimLeft(digits, "0")
+
+	if len(digits) != 10 && len(digits) != 11 {
+		return "", "", fmt.Errorf("telefone inválido: '%s'", phone)
+	}
+	return digits[:2], digits[2:], nil
+}
+
+// ufFromState converte "BR-SP", "SP" ou "São Paulo" em "SP"
+func ufFromState(state string) (string, bool) {
+	state = strings.TrimSpace(state)
+	code := strings.ToUpper(strings.TrimPrefix(strings.ToUpper(state), "BR-"))
+	if len(code) == 2 {
+		for _, uf := range nbsUFs {
+			if uf == code {
+				return uf, true
+			}
+		}
+		return "", false
+	}
+
+	uf, ok := nbsUFs[normalizeName(state)]
+	return uf, ok
+}
+
+func onlyDigits(value string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, value)
+}
+
+func truncate(value string, max int) string {
+	runes := []rune(value)
+	if len(runes) <= max {
+		return value
+	}
+	return string(runes[:max])
+}
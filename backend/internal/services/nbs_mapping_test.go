@@ -0,0 +1,87 @@
+package services
+
+import "testing"
+
+func TestNormalizeDocument(t *testing.T) {
+	tests := []struct {
+		docType, doc string
+		want         string
+		valid        bool
+	}{
+		{"CPF", "529.982.247-25", "52998224725", true},
+		{"", "52998224725", "52998224725", true},
+		{"CPF", "52998224735", "52998224735", false}, // primeiro dígito errado
+		{"CPF", "52998224724", "52998224724", false}, // segundo dígito errado
+		{"CPF", "111.111.111-11", "11111111111", false},
+		{"CNPJ", "52998224725", "52998224725", false},
+		{"CNPJ", "11.222.333/0001-81", "11222333000181", true},
+		{"cnpj", "11222333000181", "11222333000181", true},
+		{"CNPJ", "11222333000191", "11222333000191", false}, // primeiro dígito errado
+		{"CNPJ", "11222333000180", "11222333000180", false}, // segundo dígito errado
+		{"CNPJ", "00000000000000", "00000000000000", false},
+		{"CPF", "11222333000181", "11222333000181", false},
+		{"CPF", "", "", false},
+		{"", "1234567890", "1234567890", false},
+	}
+
+	for _, tt := range tests {
+		got, problem := normalizeDocument(tt.docType, tt.doc)
+		if got != tt.want {
+			t.Errorf("normalizeDocument(%q, %q) = %q, want %q", tt.docType, tt.doc, got, tt.want)
+		}
+		if (problem == "") != tt.valid {
+			t.Errorf("normalizeDocument(%q, %q): problem = %q, want valid = %v", tt.docType, tt.doc, problem, tt.valid)
+		}
+	}
+}
+
+func TestSplitPhone(t *testing.T) {
+	tests := []struct {
+		phone          string
+		prefix, number string
+	}{
+		{"(11) 98765-4321", "11", "987654321"},
+		{"11 3456-7890", "11", "34567890"},
+		{"+55 21 98765-4321", "21", "987654321"},
+		{"5521987654321", "21", "987654321"},
+		{"021 3456-7890", "21", "34567890"},
+		{"0 21 98765-4321", "21", "987654321"},
+	}
+	for _, tt := range tests {
+		prefix, number, err := splitPhone(tt.phone)
+		if err != nil || prefix != tt.prefix || number != tt.number {
+			t.Errorf("splitPhone(%q) = (%q, %q, %v), want (%q, %q, nil)", tt.phone, prefix, number, err, tt.prefix, tt.number)
+		}
+	}
+
+	for _, phone := range []string{"98765-4321", "123", ""} {
+		if _, _, err := splitPhone(phone); err == nil {
+			t.Errorf("splitPhone(%q): esperava erro", phone)
+		}
+	}
+}
+
+func TestUfFromState(t *testing.T) {
+	valid := map[string]string{
+		"BR-SP":               "SP",
+		"br-rj":               "RJ",
+		"MG":                  "MG",
+		" df ":                "DF",
+		"São Paulo":           "SP",
+		"PARANÁ":              "PR",
+		"Rio Grande do Sul":   "RS",
+		"Mato  Grosso do Sul": "MS",
+		"Espírito Santo":      "ES",
+	}
+	for state, want := range valid {
+		if got, ok := ufFromState(state); !ok || got != want {
+			t.Errorf("ufFromState(%q) = (%q, %v), want (%q, true)", state, got, ok, want)
+		}
+	}
+
+	for _, state := range []string{"BR-XX", "XX", "Atlântida", ""} {
+		if got, ok := ufFromState(state); ok {
+			t.Errorf("ufFromState(%q) = %q, esperava estado inválido", state, got)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package services
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidNFe indica um XML de NF-e que não passou nas validações e não
+// deve ser enviado ao marketplace
+var ErrInvalidNFe = errors.New("NF-e inválida")
+
+// nfeTotalTolerance é a diferença aceita entre o total da nota e o do pedido
+const nfeTotalTolerance = 0.01
+
+// NFeSummary é o resumo de uma NF-e extraído do XML autorizado (nfeProc)
+type NFeSummary struct {
+	Chave         string   `json:"chave"`
+	Numero        string   `json:"numero"`
+	Serie         string   `json:"serie"`
+	DataEmissao   string   `json:"data_emissao"`
+	EmitenteCNPJ  string   `json:"emitente_cnpj"`
+	EmitenteNome  string   `json:"emitente_nome"`
+	Destinatario  string   `json:"destinatario"`
+	Itens         int      `json:"itens"`
+	Quantidade    float64  `json:"quantidade"`
+	ValorProdutos float64  `json:"valor_produtos"`
+	ValorTotal    float64  `json:"valor_total"`
+	Protocolo     string   `json:"protocolo,omitempty"`
+	StatusSEFAZ   string   `json:"status_sefaz,omitempty"`
+	MotivoSEFAZ   string   `json:"motivo_sefaz,omitempty"`
+	Warnings      []string `json:"warnings,omitempty"`
+}
+
+// NFeExpectations são os valores que a nota de um pedido deve ter
+type NFeExpectations struct {
+	CNPJ          string  // CNPJ da empresa; vazio pula a verificação
+	OrderTotal    float64 // soma de preço x quantidade dos itens do pedido
+	OrderQuantity int     // soma das quantidades dos itens do pedido
+	Partial       bool    // o pedido foi dividido em mais de uma pré-nota
+}
+
+type nfeProcXML struct {
+	XMLName xml.Name
+	NFe     nfeXML      `xml:"NFe"`
+	ProtNFe *nfeProtXML `xml:"protNFe"`
+}
+
+type nfeXML struct {
+	InfNFe struct {
+		ID  string `xml:"Id,attr"`
+		Ide struct {
+			NNF   string `xml:"nNF"`
+			Serie string `xml:"serie"`
+			DhEmi string `xml:"dhEmi"`
+		} `xml:"ide"`
+		Emit struct {
+			CNPJ  string `xml:"CNPJ"`
+			XNome string `xml:"xNome"`
+		} `xml:"emit"`
+		Dest struct {
+			CNPJ string `xml:"CNPJ"`
+			CPF  string `xml:"CPF"`
+		} `xml:"dest"`
+		Det []struct {
+			Prod struct {
+				QCom  string `xml:"qCom"`
+				VProd string `xml:"vProd"`
+			} `xml:"prod"`
+		} `xml:"det"`
+		Total struct {
+			ICMSTot struct {
+				VProd string `xml:"vProd"`
+				VNF   string `xml:"vNF"`
+			} `xml:"ICMSTot"`
+		} `xml:"total"`
+	} `xml:"infNFe"`
+}
+
+type nfeProtXML struct {
+	InfProt struct {
+		ChNFe   string `xml:"chNFe"`
+		NProt   string `xml:"nProt"`
+		CStat   string `xml:"cStat"`
+		XMotivo string `xml:"xMotivo"`
+	} `xml:"infProt"`
+}
+
+// ParseNFe lê o XML de uma NF-e (nfeProc ou NFe sem protocolo). Retorna
+// ErrInvalidNFe se o XML estiver malformado ou não for uma NF-e.
+func ParseNFe(data string) (*NFeSummary, error) {
+	// O Unmarshal para no fim do elemento raiz; a leitura completa garante
+	// que o documento inteiro está bem formado
+	decoder := xml.NewDecoder(strings.NewReader(data))
+	for {
+		if _, err := decoder.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: XML malformado: %v", ErrInvalidNFe, err)
+		}
+	}
+
+	var proc nfeProcXML
+	if err := xml.Unmarshal([]byte(data), &proc); err != nil {
+		return nil, fmt.Errorf("%w: XML malformado: %v", ErrInvalidNFe, err)
+	}
+
+	var nfe nfeXML
+	switch proc.XMLName.Local {
+	case "nfeProc":
+		nfe = proc.NFe
+	case "NFe":
+		if err := xml.Unmarshal([]byte(data), &nfe); err != nil {
+			return nil, fmt.Errorf("%w: XML malformado: %v", ErrInvalidNFe, err)
+		}
+		proc.ProtNFe = nil
+	default:
+		return nil, fmt.Errorf("%w: elemento raiz <%s> não é uma NF-e", ErrInvalidNFe, proc.XMLName.Local)
+	}
+
+	inf := nfe.InfNFe
+	if inf.ID == "" {
+		return nil, fmt.Errorf("%w: infNFe não encontrado", ErrInvalidNFe)
+	}
+
+	summary := &NFeSummary{
+		Chave:        strings.TrimPrefix(inf.ID, "NFe"),
+		Numero:       inf.Ide.NNF,
+		Serie:        inf.Ide.Serie,
+		DataEmissao:  inf.Ide.DhEmi,
+		EmitenteCNPJ: onlyDigits(inf.Emit.CNPJ),
+		EmitenteNome: inf.Emit.XNome,
+		Destinatario: onlyDigits(inf.Dest.CNPJ + inf.Dest.CPF),
+		Itens:        len(inf.Det),
+	}
+
+	var problems []string
+	for i, det := range inf.Det {
+		quantidade, err := parseNFeDecimal(det.Prod.QCom)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("qCom do item %d inválido: %q", i+1, det.Prod.QCom))
+		}
+		summary.Quantidade += quantidade
+	}
+
+	var err error
+	if summary.ValorProdutos, err = parseNFeDecimal(inf.Total.ICMSTot.VProd); err != nil {
+		problems = append(problems, fmt.Sprintf("vProd total inválido: %q", inf.Total.ICMSTot.VProd))
+	}
+	if summary.ValorTotal, err = parseNFeDecimal(inf.Total.ICMSTot.VNF); err != nil {
+		problems = append(problems, fmt.Sprintf("vNF inválido: %q", inf.Total.ICMSTot.VNF))
+	}
+
+	if proc.ProtNFe != nil {
+		summary.Protocolo = proc.ProtNFe.InfProt.NProt
+		summary.StatusSEFAZ = proc.ProtNFe.InfProt.CStat
+		summary.MotivoSEFAZ = proc.ProtNFe.InfProt.XMotivo
+		if ch := proc.ProtNFe.InfProt.ChNFe; ch != "" && ch != summary.Chave {
+			problems = append(problems, fmt.Sprintf("chave do protocolo (%s) diferente da chave da nota (%s)", ch, summary.Chave))
+		}
+	}
+
+	if len(problems) > 0 {
+		return summary, fmt.Errorf("%w: %s", ErrInvalidNFe, strings.Join(problems, "; "))
+	}
+	return summary, nil
+}
+
+// Validate confere chave de acesso, emitente, totais e autorização da nota.
+// Todas as verificações que falharem são listadas no erro, que embrulha
+// ErrInvalidNFe.
+func (n *NFeSummary) Validate(exp NFeExpectations) error {
+	var problems []string
+
+	if err := validateNFeChave(n.Chave); err != nil {
+		problems = append(problems, err.Error())
+	} else if chaveCNPJ := n.Chave[6:20]; chaveCNPJ != n.EmitenteCNPJ {
+		problems = append(problems, fmt.Sprintf("CNPJ da chave de acesso (%s) diferente do emitente (%s)", chaveCNPJ, n.EmitenteCNPJ))
+	}
+
+	if exp.CNPJ != "" && n.EmitenteCNPJ != exp.CNPJ {
+		problems = append(problems, fmt.Sprintf("CNPJ do emitente (%s) diferente do CNPJ da empresa (%s)", n.EmitenteCNPJ, exp.CNPJ))
+	}
+
+	quantidade := int(math.Round(n.Quantidade))
+	if exp.Partial {
+		// A nota cobre parte do pedido: não pode ultrapassá-lo
+		if n.ValorProdutos > exp.OrderTotal+nfeTotalTolerance {
+			problems = append(problems, fmt.Sprintf("valor dos produtos (%.2f) maior que o total do pedido (%.2f)", n.ValorProdutos, exp.OrderTotal))
+		}
+		if quantidade > exp.OrderQuantity {
+			problems = append(problems, fmt.Sprintf("quantidade de itens da nota (%d) maior que a do pedido (%d)", quantidade, exp.OrderQuantity))
+		}
+	} else {
+		if math.Abs(n.ValorProdutos-exp.OrderTotal) > nfeTotalTolerance {
+			problems = append(problems, fmt.Sprintf("valor dos produtos (%.2f) diferente do total do pedido (%.2f)", n.ValorProdutos, exp.OrderTotal))
+		}
+		if quantidade != exp.OrderQuantity {
+			problems = append(problems, fmt.Sprintf("quantidade de itens da nota (%d) diferente da do pedido (%d)", quantidade, exp.OrderQuantity))
+		}
+	}
+
+	switch {
+	case n.Protocolo == "" && n.StatusSEFAZ == "":
+		problems = append(problems, "protocolo de autorização (protNFe) ausente")
+	case n.StatusSEFAZ != "100" && n.StatusSEFAZ != "150":
+		problems = append(problems, fmt.Sprintf("nota não autorizada pela SEFAZ (cStat %s: %s)", n.StatusSEFAZ, n.MotivoSEFAZ))
+	case n.Protocolo == "":
+		problems = append(problems, "número do protocolo de autorização (nProt) ausente")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidNFe, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validateNFeChave confere o formato e o dígito verificador (módulo 11) da
+// chave de acesso
+func validateNFeChave(chave string) error {
+	if len(chave) != 44 || onlyDigits(chave) != chave {
+		return fmt.Errorf("chave de acesso com formato inválido: %q", chave)
+	}
+
+	// Pesos de 2 a 9 da direita para a esquerda, sobre os 43 primeiros dígitos
+	weights := make([]int, 43)
+	for i := range weights {
+		weights[42-i] = 2 + i%8
+	}
+
+	if dv := checkDigit(chave[:43], weights); dv != int(chave[43]-'0') {
+		return fmt.Errorf("dígito verificador da chave de acesso inválido: esperado %d, encontrado %c", dv, chave[43])
+	}
+	return nil
+}
+
+func parseNFeDecimal(value string) (float64, error) {
+	return strconv.ParseFloat(strings.TrimSpace(value), 64)
+}
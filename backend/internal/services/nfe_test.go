@@ -0,0 +1,138 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Chave de acesso válida de uma nota emitida pelo CNPJ 11222333000181
+const testNFeChave = "35240111222333000181550010000001231123456780"
+
+// testNFeXML monta um nfeProc autorizado com dois itens (3 unidades, R$ 150,00)
+func testNFeXML(chave, cStat string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<nfeProc xmlns="http://www.portalfiscal.inf.br/nfe" versao="4.00">
+  <NFe>
+    <infNFe Id="NFe%s" versao="4.00">
+      <ide><nNF>123</nNF><serie>1</serie><dhEmi>2024-01-15T10:00:00-03:00</dhEmi></ide>
+      <emit><CNPJ>11222333000181</CNPJ><xNome>EMPRESA TESTE LTDA</xNome></emit>
+      <dest><CPF>52998224725</CPF></dest>
+      <det nItem="1"><prod><qCom>2.0000</qCom><vProd>100.00</vProd></prod></det>
+      <det nItem="2"><prod><qCom>1.0000</qCom><vProd>50.00</vProd></prod></det>
+      <total><ICMSTot><vProd>150.00</vProd><vNF>165.00</vNF></ICMSTot></total>
+    </infNFe>
+  </NFe>
+  <protNFe><infProt><chNFe>%s</chNFe><nProt>135240000000001</nProt><cStat>%s</cStat><xMotivo>Autorizado o uso da NF-e</xMotivo></infProt></protNFe>
+</nfeProc>`, chave, chave, cStat)
+}
+
+func TestValidateNFeChave(t *testing.T) {
+	if err := validateNFeChave(testNFeChave); err != nil {
+		t.Fatalf("validateNFeChave(%q) = %v", testNFeChave, err)
+	}
+
+	invalid := map[string]string{
+		testNFeChave[:43] + "1":                        "dígito verificador",
+		"35240111222333000181550010000001241123456780": "dígito verificador",
+		testNFeChave[:43]:                              "formato inválido",
+		testNFeChave + "0":                             "formato inválido",
+		"NFe" + testNFeChave[3:]:                       "formato inválido",
+		"":                                             "formato inválido",
+	}
+	for chave, want := range invalid {
+		err := validateNFeChave(chave)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("validateNFeChave(%q) = %v, want erro com %q", chave, err, want)
+		}
+	}
+}
+
+func TestParseNFe(t *testing.T) {
+	summary, err := ParseNFe(testNFeXML(testNFeChave, "100"))
+	if err != nil {
+		t.Fatalf("ParseNFe: %v", err)
+	}
+
+	if summary.Chave != testNFeChave || summary.EmitenteCNPJ != "11222333000181" || summary.Destinatario != "52998224725" {
+		t.Errorf("chave/emitente/destinatário = %s/%s/%s", summary.Chave, summary.EmitenteCNPJ, summary.Destinatario)
+	}
+	if summary.Itens != 2 || summary.Quantidade != 3 || summary.ValorProdutos != 150 || summary.ValorTotal != 165 {
+		t.Errorf("itens/quantidade/vProd/vNF = %d/%v/%v/%v", summary.Itens, summary.Quantidade, summary.ValorProdutos, summary.ValorTotal)
+	}
+	if summary.Protocolo != "135240000000001" || summary.StatusSEFAZ != "100" {
+		t.Errorf("protocolo/cStat = %s/%s", summary.Protocolo, summary.StatusSEFAZ)
+	}
+
+	for name, data := range map[string]string{
+		"malformado":       "<nfeProc><NFe>",
+		"outro documento":  "<cteProc></cteProc>",
+		"chave divergente": strings.Replace(testNFeXML(testNFeChave, "100"), "<chNFe>"+testNFeChave, "<chNFe>"+testNFeChave[:43]+"1", 1),
+		"sem infNFe":       "<NFe></NFe>",
+	} {
+		if _, err := ParseNFe(data); !errors.Is(err, ErrInvalidNFe) {
+			t.Errorf("%s: ParseNFe = %v, want ErrInvalidNFe", name, err)
+		}
+	}
+}
+
+func TestNFeSummaryValidate(t *testing.T) {
+	summary, err := ParseNFe(testNFeXML(testNFeChave, "100"))
+	if err != nil {
+		t.Fatalf("ParseNFe: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		exp     NFeExpectations
+		wantErr string
+	}{
+		{"pedido inteiro", NFeExpectations{CNPJ: "11222333000181", OrderTotal: 150, OrderQuantity: 3}, ""},
+		{"diferença dentro da tolerância", NFeExpectations{OrderTotal: 150.005, OrderQuantity: 3}, ""},
+		{"total diferente", NFeExpectations{OrderTotal: 200, OrderQuantity: 3}, "diferente do total do pedido"},
+		{"quantidade diferente", NFeExpectations{OrderTotal: 150, OrderQuantity: 5}, "quantidade de itens da nota (3) diferente"},
+		{"emitente de outra empresa", NFeExpectations{CNPJ: "11444777000161", OrderTotal: 150, OrderQuantity: 3}, "diferente do CNPJ da empresa"},
+
+		// Pedido dividido em várias pré-notas: a nota cobre só parte dele
+		{"dividido: parte do pedido", NFeExpectations{OrderTotal: 400, OrderQuantity: 8, Partial: true}, ""},
+		{"dividido: pedido inteiro", NFeExpectations{OrderTotal: 150, OrderQuantity: 3, Partial: true}, ""},
+		{"dividido: valor acima do pedido", NFeExpectations{OrderTotal: 100, OrderQuantity: 8, Partial: true}, "maior que o total do pedido"},
+		{"dividido: quantidade acima do pedido", NFeExpectations{OrderTotal: 400, OrderQuantity: 2, Partial: true}, "quantidade de itens da nota (3) maior"},
+	}
+
+	for _, tt := range tests {
+		err := summary.Validate(tt.exp)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: Validate = %v", tt.name, err)
+		case tt.wantErr != "" && (!errors.Is(err, ErrInvalidNFe) || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: Validate = %v, want ErrInvalidNFe com %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNFeSummaryValidateAuthorization(t *testing.T) {
+	exp := NFeExpectations{OrderTotal: 150, OrderQuantity: 3}
+
+	denied, err := ParseNFe(testNFeXML(testNFeChave, "110"))
+	if err != nil {
+		t.Fatalf("ParseNFe: %v", err)
+	}
+	if err := denied.Validate(exp); err == nil || !strings.Contains(err.Error(), "cStat 110") {
+		t.Errorf("nota denegada: Validate = %v", err)
+	}
+
+	unsigned := *denied
+	unsigned.Protocolo, unsigned.StatusSEFAZ = "", ""
+	if err := unsigned.Validate(exp); err == nil || !strings.Contains(err.Error(), "protNFe") {
+		t.Errorf("nota sem protocolo: Validate = %v", err)
+	}
+
+	otherIssuer := *denied
+	otherIssuer.StatusSEFAZ = "100"
+	otherIssuer.EmitenteCNPJ = "11444777000161"
+	if err := otherIssuer.Validate(exp); err == nil || !strings.Contains(err.Error(), "CNPJ da chave de acesso") {
+		t.Errorf("emitente diferente da chave: Validate = %v", err)
+	}
+}
@@ -0,0 +1,274 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"amz-web-tools/backend/internal/config"
+)
+
+// orderPollerLookback define até quantas horas atrás o poller busca pedidos pagos
+const orderPollerLookback = 48 * time.Hour
+
+// OrderPollerService busca periodicamente pedidos pagos no Mercado Livre
+// e os envia para o IntegrationService
+type OrderPollerService struct {
+	sqlDB       *sql.DB
+	pgDB        *sql.DB
+	integration *IntegrationService
+	marketplace Marketplace
+	enabled     bool
+	interval    time.Duration
+	dryRun      bool
+	mu          sync.Mutex
+}
+
+// OrderPollerConta representa a configuração e o último resultado do poller para uma conta
+type OrderPollerConta struct {
+	Conta     string     `json:"conta"`
+	Enabled   bool       `json:"enabled"`
+	LastRunAt *time.Time `json:"last_run_at,omitempty"`
+	LastFound int        `json:"last_found"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// OrderPollResult representa o resultado de uma busca de pedidos para uma
+// conta. Os pedidos enfileirados viram jobs de integração em background; Jobs
+// relaciona cada pedido ao ID do seu job.
+type OrderPollResult struct {
+	Conta    string            `json:"conta"`
+	DryRun   bool              `json:"dry_run"`
+	Found    int               `json:"found"`
+	Pending  []string          `json:"pending"`
+	Enqueued []string          `json:"enqueued"`
+	Jobs     map[string]string `json:"jobs"`
+	Error    string            `json:"error,omitempty"`
+}
+
+func NewOrderPollerService(sqlDB, pgDB *sql.DB, integration *IntegrationService, marketplace Marketplace, cfg *config.Config) *OrderPollerService {
+	interval := time.Duration(cfg.OrderPollerIntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = 10 * time.Minute
+	}
+
+	return &OrderPollerService{
+		sqlDB:       sqlDB,
+		pgDB:        pgDB,
+		integration: integration,
+		marketplace: marketplace,
+		enabled:     cfg.OrderPollerEnabled,
+		interval:    interval,
+		dryRun:      cfg.OrderPollerDryRun,
+	}
+}
+
+// Start inicia o agendamento em background, se habilitado na configuração; o
+// agendamento para quando ctx (o contexto do servidor) é cancelado
+func (s *OrderPollerService) Start(ctx context.Context) {
+	if !s.enabled {
+		log.Printf("⏸️ Poller de pedidos do Mercado Livre desabilitado")
+		return
+	}
+
+	log.Printf("⏰ Poller de pedidos do Mercado Livre iniciado (intervalo: %s, dry-run: %t)", s.interval, s.dryRun)
+
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := s.runEnabled(ctx); err != nil {
+					log.Printf("⚠️ Poller de pedidos: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// GetContas retorna a configuração do poller para todas as contas conhecidas
+func (s *OrderPollerService) GetContas() ([]OrderPollerConta, error) {
+	query := `SELECT conta, enabled, last_run_at, COALESCE(last_found, 0), COALESCE(last_error, '') FROM order_poller_contas`
+
+	rows, err := s.sqlDB.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order poller contas: %w", err)
+	}
+	defer rows.Close()
+
+	stored := make(map[string]OrderPollerConta)
+	for rows.Next() {
+		var conta OrderPollerConta
+		var lastRunAt sql.NullTime
+		if err := rows.Scan(&conta.Conta, &conta.Enabled, &lastRunAt, &conta.LastFound, &conta.LastError); err != nil {
+			return nil, fmt.Errorf("failed to scan order poller conta: %w", err)
+		}
+		if lastRunAt.Valid {
+			conta.LastRunAt = &lastRunAt.Time
+		}
+		stored[conta.Conta] = conta
+	}
+
+	contas := make([]OrderPollerConta, 0, len(integrationContas))
+	for _, name := range integrationContas {
+		if conta, ok := stored[name]; ok {
+			contas = append(contas, conta)
+		} else {
+			contas = append(contas, OrderPollerConta{Conta: name})
+		}
+	}
+
+	return contas, nil
+}
+
+// SetContaEnabled habilita ou desabilita o poller para uma conta
+func (s *OrderPollerService) SetContaEnabled(conta string, enabled bool) error {
+	conta = strings.ToLower(conta)
+	if !isIntegrationConta(conta) {
+		return fmt.Errorf("conta inválida: %s", conta)
+	}
+
+	query := `
+		MERGE order_poller_contas AS target
+		USING (SELECT @p1 AS conta) AS source ON target.conta = source.conta
+		WHEN MATCHED THEN UPDATE SET enabled = @p2, updated_at = GETDATE()
+		WHEN NOT MATCHED THEN INSERT (conta, enabled) VALUES (@p1, @p2);`
+
+	if _, err := s.sqlDB.Exec(query, conta, enabled); err != nil {
+		return fmt.Errorf("failed to update order poller conta: %w", err)
+	}
+
+	return nil
+}
+
+// RunNow executa uma busca imediata; se conta for vazia, busca em todas as contas habilitadas
+func (s *OrderPollerService) RunNow(ctx context.Context, conta string, dryRun bool) ([]OrderPollResult, error) {
+	if conta == "" {
+		contas, err := s.enabledContas()
+		if err != nil {
+			return nil, err
+		}
+		return s.run(ctx, contas, dryRun)
+	}
+
+	conta = strings.ToLower(conta)
+	if !isIntegrationConta(conta) {
+		return nil, fmt.Errorf("conta inválida: %s", conta)
+	}
+
+	return s.run(ctx, []string{conta}, dryRun)
+}
+
+func (s *OrderPollerService) runEnabled(ctx context.Context) ([]OrderPollResult, error) {
+	contas, err := s.enabledContas()
+	if err != nil {
+		return nil, err
+	}
+	return s.run(ctx, contas, s.dryRun)
+}
+
+func (s *OrderPollerService) run(ctx context.Context, contas []string, dryRun bool) ([]OrderPollResult, error) {
+	if !s.mu.TryLock() {
+		return nil, fmt.Errorf("já existe uma busca de pedidos em andamento")
+	}
+	defer s.mu.Unlock()
+
+	results := make([]OrderPollResult, 0, len(contas))
+	for _, conta := range contas {
+		result := s.pollConta(ctx, conta, dryRun)
+		s.recordRun(result)
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (s *OrderPollerService) enabledContas() ([]string, error) {
+	contas, err := s.GetContas()
+	if err != nil {
+		return nil, err
+	}
+
+	var enabled []string
+	for _, conta := range contas {
+		if conta.Enabled {
+			enabled = append(enabled, conta.Conta)
+		}
+	}
+	return enabled, nil
+}
+
+func (s *OrderPollerService) pollConta(ctx context.Context, conta string, dryRun bool) OrderPollResult {
+	result := OrderPollResult{Conta: conta, DryRun: dryRun, Pending: []string{}, Enqueued: []string{}, Jobs: map[string]string{}}
+
+	log.Printf("🔍 Buscando pedidos pagos da conta %s no Mercado Livre...", strings.ToUpper(conta))
+
+	numPedidos, err := s.marketplace.SearchPaidOrders(ctx, conta, time.Now().Add(-orderPollerLookback))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Found = len(numPedidos)
+
+	pending, err := s.integration.filterNewOrders(ctx, numPedidos)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Pending = pending
+
+	log.Printf("📦 Conta %s: %d pedido(s) pago(s), %d a integrar", strings.ToUpper(conta), result.Found, len(pending))
+
+	if dryRun {
+		return result
+	}
+
+	// Os jobs rodam no contexto do IntegrationService: cancelar ctx (requisição
+	// do admin encerrada) não interrompe integrações já iniciadas
+	for _, numPedido := range pending {
+		req := IntegrationRequest{
+			Conta:       conta,
+			Marketplace: s.marketplace.Name(),
+			NumPedido:   numPedido,
+		}
+		job, err := s.integration.StartIntegration(ctx, req)
+		if err != nil {
+			log.Printf("❌ Erro ao iniciar integração do pedido %s da conta %s: %v", numPedido, conta, err)
+			continue
+		}
+		result.Enqueued = append(result.Enqueued, numPedido)
+		result.Jobs[numPedido] = job.ID
+	}
+
+	return result
+}
+
+func (s *OrderPollerService) recordRun(result OrderPollResult) {
+	query := `
+		MERGE order_poller_contas AS target
+		USING (SELECT @p1 AS conta) AS source ON target.conta = source.conta
+		WHEN MATCHED THEN UPDATE SET last_run_at = GETDATE(), last_found = @p2, last_error = @p3
+		WHEN NOT MATCHED THEN INSERT (conta, enabled, last_run_at, last_found, last_error) VALUES (@p1, 0, GETDATE(), @p2, @p3);`
+
+	lastError := sql.NullString{String: result.Error, Valid: result.Error != ""}
+	if _, err := s.sqlDB.Exec(query, result.Conta, len(result.Pending), lastError); err != nil {
+		log.Printf("⚠️ Erro ao registrar execução do poller da conta %s: %v", result.Conta, err)
+	}
+}
+
+func isIntegrationConta(conta string) bool {
+	for _, c := range integrationContas {
+		if c == conta {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,309 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"amz-web-tools/backend/internal/models"
+	"amz-web-tools/backend/internal/permissions"
+)
+
+// AdminRole é o papel com todas as permissões; não pode ser alterado nem
+// excluído
+const AdminRole = "admin"
+
+var (
+	// ErrRoleNotFound indica um papel inexistente
+	ErrRoleNotFound = errors.New("papel não encontrado")
+	// ErrRoleExists indica um papel já cadastrado
+	ErrRoleExists = errors.New("papel já cadastrado")
+	// ErrRoleInUse indica um papel atribuído a usuários
+	ErrRoleInUse = errors.New("papel atribuído a usuários")
+	// ErrRoleProtected indica uma alteração no papel admin
+	ErrRoleProtected = errors.New("o papel admin não pode ser alterado")
+	// ErrInvalidRole indica um papel com nome ou permissões inválidos
+	ErrInvalidRole = errors.New("papel inválido")
+)
+
+var roleNamePattern = regexp.MustCompile(`^[a-z0-9_-]{2,50}$`)
+
+// defaultRoles são os papéis criados na primeira execução, equivalentes à
+// antiga hierarquia admin > operacao > atendimento
+var defaultRoles = []models.Role{
+	{
+		Name:        AdminRole,
+		Description: "Administrador: todas as permissões",
+		Permissions: []string{permissions.All},
+	},
+	{
+		Name:        "operacao",
+		Description: "Operação: integrações, XML, DePara de todas as contas e rollback de auditoria",
+		Permissions: []string{
+			"integration:*", "xml:*", permissions.DeParaView, permissions.DeParaWrite + ":*",
+			"audit:*", "stock:*", permissions.CarPlateView, permissions.DashboardView,
+		},
+	},
+	{
+		Name:        "atendimento",
+		Description: "Atendimento: consultas",
+		Permissions: []string{
+			permissions.IntegrationView, permissions.XMLView, permissions.DeParaView, permissions.AuditView,
+			permissions.StockView, permissions.CarPlateView, permissions.DashboardView,
+		},
+	},
+}
+
+// RoleService gerencia os papéis (conjuntos nomeados de permissões)
+type RoleService struct {
+	db *sql.DB
+}
+
+// NewRoleService cria o serviço, cadastra os papéis padrão que não existirem
+// e move para defaultRole os usuários com papéis inexistentes
+func NewRoleService(db *sql.DB, defaultRole string) *RoleService {
+	s := &RoleService{db: db}
+
+	if err := s.seedDefaults(defaultRole); err != nil {
+		log.Printf("⚠️ Erro ao popular papéis padrão: %v", err)
+	}
+
+	return s
+}
+
+// Permissions retorna as permissões do papel; papéis inexistentes não têm
+// nenhuma
+func (s *RoleService) Permissions(ctx context.Context, role string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT permission FROM role_permissions WHERE role_name = @p1 ORDER BY permission`, role)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar permissões do papel %s: %w", role, err)
+	}
+	defer rows.Close()
+
+	list := []string{}
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, fmt.Errorf("erro ao ler permissão: %w", err)
+		}
+		list = append(list, permission)
+	}
+	return list, rows.Err()
+}
+
+// Exists informa se o papel está cadastrado
+func (s *RoleService) Exists(ctx context.Context, role string) (bool, error) {
+	var count int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM roles WHERE name = @p1`, role).Scan(&count); err != nil {
+		return false, fmt.Errorf("erro ao buscar papel %s: %w", role, err)
+	}
+	return count > 0, nil
+}
+
+// ListRoles retorna os papéis com suas permissões e o número de usuários
+func (s *RoleService) ListRoles(ctx context.Context) ([]models.Role, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT r.name, COALESCE(r.description, ''), r.created_at, r.updated_at,
+			(SELECT COUNT(*) FROM users u WHERE u.role = r.name)
+		FROM roles r
+		ORDER BY r.name`)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar papéis: %w", err)
+	}
+
+	roles := []models.Role{}
+	for rows.Next() {
+		var role models.Role
+		if err := rows.Scan(&role.Name, &role.Description, &role.CreatedAt, &role.UpdatedAt, &role.UserCount); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("erro ao ler papel: %w", err)
+		}
+		roles = append(roles, role)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro ao buscar papéis: %w", err)
+	}
+
+	for i := range roles {
+		if roles[i].Permissions, err = s.Permissions(ctx, roles[i].Name); err != nil {
+			return nil, err
+		}
+	}
+	return roles, nil
+}
+
+// GetRole retorna um papel
+func (s *RoleService) GetRole(ctx context.Context, name string) (*models.Role, error) {
+	var role models.Role
+	err := s.db.QueryRowContext(ctx, `
+		SELECT r.name, COALESCE(r.description, ''), r.created_at, r.updated_at,
+			(SELECT COUNT(*) FROM users u WHERE u.role = r.name)
+		FROM roles r WHERE r.name = @p1`, name).
+		Scan(&role.Name, &role.Description, &role.CreatedAt, &role.UpdatedAt, &role.UserCount)
+	if err == sql.ErrNoRows {
+		return nil, ErrRoleNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar papel %s: %w", name, err)
+	}
+
+	if role.Permissions, err = s.Permissions(ctx, role.Name); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// CreateRole cadastra um papel
+func (s *RoleService) CreateRole(ctx context.Context, req models.RoleRequest) (*models.Role, error) {
+	name := strings.ToLower(strings.TrimSpace(req.Name))
+	if !roleNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("%w: o nome deve ter de 2 a 50 letras minúsculas, números, _ ou -", ErrInvalidRole)
+	}
+	list, err := permissions.Normalize(req.Permissions)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRole, err)
+	}
+
+	exists, err := s.Exists(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrRoleExists
+	}
+
+	if err := s.save(ctx, name, req.Description, list, true); err != nil {
+		return nil, err
+	}
+	log.Printf("🔑 Papel %s criado com %d permissões", name, len(list))
+	return s.GetRole(ctx, name)
+}
+
+// UpdateRole substitui a descrição e as permissões de um papel. Os usuários
+// recebem as novas permissões na próxima renovação do token de acesso.
+func (s *RoleService) UpdateRole(ctx context.Context, name string, req models.RoleRequest) (*models.Role, error) {
+	if name == AdminRole {
+		return nil, ErrRoleProtected
+	}
+	list, err := permissions.Normalize(req.Permissions)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRole, err)
+	}
+
+	exists, err := s.Exists(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrRoleNotFound
+	}
+
+	if err := s.save(ctx, name, req.Description, list, false); err != nil {
+		return nil, err
+	}
+	log.Printf("🔑 Papel %s atualizado com %d permissões", name, len(list))
+	return s.GetRole(ctx, name)
+}
+
+// DeleteRole exclui um papel sem usuários
+func (s *RoleService) DeleteRole(ctx context.Context, name string) error {
+	if name == AdminRole {
+		return ErrRoleProtected
+	}
+
+	var users int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE role = @p1`, name).Scan(&users); err != nil {
+		return fmt.Errorf("erro ao verificar usuários do papel %s: %w", name, err)
+	}
+	if users > 0 {
+		return fmt.Errorf("%w: %d usuário(s)", ErrRoleInUse, users)
+	}
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM roles WHERE name = @p1`, name)
+	if err != nil {
+		return fmt.Errorf("erro ao excluir papel %s: %w", name, err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrRoleNotFound
+	}
+	log.Printf("🔑 Papel %s excluído", name)
+	return nil
+}
+
+// save grava o papel e substitui suas permissões numa transação
+func (s *RoleService) save(ctx context.Context, name, description string, list []string, create bool) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("erro ao iniciar transação: %w", err)
+	}
+	defer tx.Rollback()
+
+	if create {
+		_, err = tx.ExecContext(ctx, `INSERT INTO roles (name, description) VALUES (@p1, @p2)`,
+			name, nullIfEmpty(strings.TrimSpace(description)))
+	} else {
+		_, err = tx.ExecContext(ctx, `UPDATE roles SET description = @p1, updated_at = GETDATE() WHERE name = @p2`,
+			nullIfEmpty(strings.TrimSpace(description)), name)
+	}
+	if err != nil {
+		return fmt.Errorf("erro ao gravar papel %s: %w", name, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM role_permissions WHERE role_name = @p1`, name); err != nil {
+		return fmt.Errorf("erro ao gravar permissões do papel %s: %w", name, err)
+	}
+	for _, permission := range list {
+		_, err := tx.ExecContext(ctx, `INSERT INTO role_permissions (role_name, permission) VALUES (@p1, @p2)`,
+			name, permission)
+		if err != nil {
+			return fmt.Errorf("erro ao gravar permissões do papel %s: %w", name, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *RoleService) seedDefaults(defaultRole string) error {
+	ctx := context.Background()
+
+	for _, role := range defaultRoles {
+		exists, err := s.Exists(ctx, role.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		log.Printf("🔑 Criando papel padrão %s", role.Name)
+		if err := s.save(ctx, role.Name, role.Description, role.Permissions, true); err != nil {
+			return err
+		}
+	}
+
+	exists, err := s.Exists(ctx, defaultRole)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: papel padrão %q não cadastrado", ErrRoleNotFound, defaultRole)
+	}
+
+	// Usuários de papéis que não existem (como o antigo 'user' do registro)
+	// ficariam sem nenhuma permissão
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE users SET role = @p1, updated_at = GETDATE()
+		WHERE role IS NULL OR role NOT IN (SELECT name FROM roles)`, defaultRole)
+	if err != nil {
+		return fmt.Errorf("erro ao migrar usuários sem papel: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
+		log.Printf("🔑 %d usuário(s) com papel inexistente movidos para %s", rowsAffected, defaultRole)
+	}
+	return nil
+}
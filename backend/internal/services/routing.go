@@ -0,0 +1,565 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"amz-web-tools/backend/internal/config"
+	"amz-web-tools/backend/internal/models"
+)
+
+// Tipos de regra de roteamento
+const (
+	RoutingRuleConta   = "conta"   // conta do marketplace -> schema do DePara
+	RoutingRuleFilial  = "filial"  // filial do DePara -> empresa, fornecedor e conta do NBS
+	RoutingRuleMPN     = "mpn"     // número da peça contendo match_value -> filial
+	RoutingRuleEstoque = "estoque" // empresa incluída na consulta de estoque
+	RoutingRuleEmpresa = "empresa" // empresa -> CNPJ do emitente das notas fiscais
+)
+
+// Filiais especiais usadas quando o DePara não informa a filial
+const (
+	routingFilialOutros    = "OUTROS"
+	routingDefaultSchema   = "principal"
+	routingRulesTable      = "routing_rules"
+	routingDefaultInterval = time.Minute
+)
+
+var (
+	// ErrRouteNotFound indica uma filial sem regra de roteamento
+	ErrRouteNotFound = errors.New("filial sem regra de roteamento")
+	// ErrRoutingRuleNotFound indica um ID de regra inexistente
+	ErrRoutingRuleNotFound = errors.New("regra de roteamento não encontrada")
+)
+
+// routingSchemaPattern restringe schema_name a um identificador SQL simples,
+// já que o schema é interpolado nas consultas ao DePara
+var routingSchemaPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Route é o destino de um item no NBS
+type Route struct {
+	Filial        string `json:"filial"`
+	CodEmpresa    string `json:"cod_empresa"`
+	CodFornecedor string `json:"cod_fornecedor"`
+	ContaNBS      string `json:"conta_nbs"`
+	Schema        string `json:"schema"`
+}
+
+// defaultRoutingRules são as regras usadas antes do roteamento ir para o banco;
+// populam a tabela vazia e servem de fallback se ela não puder ser lida
+var defaultRoutingRules = []models.RoutingRule{
+	{RuleType: RoutingRuleConta, MatchValue: "principal", SchemaName: "principal"},
+	{RuleType: RoutingRuleConta, MatchValue: "oficial", SchemaName: "oficial"},
+	{RuleType: RoutingRuleConta, MatchValue: "renault", SchemaName: "renault"},
+	{RuleType: RoutingRuleConta, MatchValue: "psa", SchemaName: "psa"},
+	{RuleType: RoutingRuleConta, MatchValue: "ford", SchemaName: "ford"},
+	{RuleType: RoutingRuleConta, MatchValue: "jeep", SchemaName: "jeep"},
+
+	{RuleType: RoutingRuleFilial, MatchValue: "17", CodEmpresa: "17", CodFornecedor: "7", ContaNBS: "17", SchemaName: "principal"},
+	{RuleType: RoutingRuleFilial, MatchValue: "144", CodEmpresa: "144", CodFornecedor: "13", ContaNBS: "144", SchemaName: "psa"},
+	{RuleType: RoutingRuleFilial, MatchValue: "44", CodEmpresa: "144", CodFornecedor: "13", ContaNBS: "144", SchemaName: "psa"},
+	{RuleType: RoutingRuleFilial, MatchValue: "12", CodEmpresa: "17", CodFornecedor: "12", ContaNBS: "17", SchemaName: "principal"},
+	{RuleType: RoutingRuleFilial, MatchValue: "40", CodEmpresa: "40", CodFornecedor: "1", ContaNBS: "40", SchemaName: "ford"},
+	{RuleType: RoutingRuleFilial, MatchValue: "34", CodEmpresa: "34", CodFornecedor: "9", ContaNBS: "34", SchemaName: "jeep"},
+	{RuleType: RoutingRuleFilial, MatchValue: "41", CodEmpresa: "41", CodFornecedor: "11", ContaNBS: "41", SchemaName: "renault"},
+	{RuleType: RoutingRuleFilial, MatchValue: "47", CodEmpresa: "47", CodFornecedor: "17", ContaNBS: "47", SchemaName: "renault"},
+	{RuleType: RoutingRuleFilial, MatchValue: "140", CodEmpresa: "140", CodFornecedor: "1", ContaNBS: "140", SchemaName: "ford"},
+	{RuleType: RoutingRuleFilial, MatchValue: "LUCIOS", CodEmpresa: "LUCIOS", CodFornecedor: "8", ContaNBS: "17", SchemaName: "principal"},
+	{RuleType: RoutingRuleFilial, MatchValue: routingFilialOutros, CodEmpresa: "17", CodFornecedor: "8", ContaNBS: "17", SchemaName: "principal"},
+
+	{RuleType: RoutingRuleMPN, MatchValue: "LC", Filial: "LUCIOS", Description: "Peças Lucios"},
+
+	{RuleType: RoutingRuleEstoque, MatchValue: "1"},
+	{RuleType: RoutingRuleEstoque, MatchValue: "3"},
+	{RuleType: RoutingRuleEstoque, MatchValue: "17"},
+	{RuleType: RoutingRuleEstoque, MatchValue: "31"},
+	{RuleType: RoutingRuleEstoque, MatchValue: "34"},
+	{RuleType: RoutingRuleEstoque, MatchValue: "35"},
+	{RuleType: RoutingRuleEstoque, MatchValue: "40"},
+	{RuleType: RoutingRuleEstoque, MatchValue: "41"},
+	{RuleType: RoutingRuleEstoque, MatchValue: "43"},
+	{RuleType: RoutingRuleEstoque, MatchValue: "144"},
+	{RuleType: RoutingRuleEstoque, MatchValue: "45"},
+	{RuleType: RoutingRuleEstoque, MatchValue: "47"},
+	{RuleType: RoutingRuleEstoque, MatchValue: "48"},
+	{RuleType: RoutingRuleEstoque, MatchValue: "140"},
+}
+
+// RoutingService mantém em memória as regras de roteamento de empresa/fornecedor,
+// recarregando-as do banco periodicamente e a cada alteração feita pela API
+type RoutingService struct {
+	db    *sql.DB
+	audit *AuditService
+
+	mu       sync.RWMutex
+	rules    []models.RoutingRule // apenas regras habilitadas, por prioridade
+	loadedAt time.Time
+}
+
+// NewRoutingService carrega as regras de roteamento e as recarrega
+// periodicamente até ctx ser cancelado
+func NewRoutingService(ctx context.Context, db *sql.DB, audit *AuditService, cfg *config.Config) *RoutingService {
+	s := &RoutingService{
+		db:    db,
+		audit: audit,
+		rules: enabledRoutingRules(defaultRoutingRules),
+	}
+
+	if err := s.seedDefaults(); err != nil {
+		log.Printf("⚠️ Erro ao popular regras de roteamento: %v", err)
+	}
+	if err := s.Reload(); err != nil {
+		log.Printf("⚠️ Erro ao carregar regras de roteamento, usando regras padrão: %v", err)
+	}
+
+	interval := time.Duration(cfg.RoutingRulesReloadSeconds) * time.Second
+	if interval <= 0 {
+		interval = routingDefaultInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.Reload(); err != nil {
+					log.Printf("⚠️ Erro ao recarregar regras de roteamento: %v", err)
+				}
+			}
+		}
+	}()
+
+	return s
+}
+
+// Reload recarrega as regras do banco
+func (s *RoutingService) Reload() error {
+	rules, err := s.ListRules(context.Background(), "")
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.rules = enabledRoutingRules(rules)
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+
+	return nil
+}
+
+// quoteSchema delimita o schema para interpolação em consultas do SQL Server
+func quoteSchema(schema string) string {
+	return "[" + strings.ReplaceAll(schema, "]", "]]") + "]"
+}
+
+// SchemaForConta retorna o schema do DePara da conta
+func (s *RoutingService) SchemaForConta(conta string) string {
+	if rule, ok := s.find(RoutingRuleConta, strings.ToLower(conta)); ok && rule.SchemaName != "" {
+		return rule.SchemaName
+	}
+	return routingDefaultSchema
+}
+
+// ResolveFilial determina o destino de um item a partir da filial do DePara e
+// do número da peça. Regras de MPN têm precedência sobre a filial; sem filial,
+// o item vai para a filial OUTROS.
+func (s *RoutingService) ResolveFilial(filial, partNumber string) (*Route, error) {
+	filial = strings.TrimSpace(filial)
+
+	s.mu.RLock()
+	for _, rule := range s.rules {
+		if rule.RuleType == RoutingRuleMPN && rule.MatchValue != "" && strings.Contains(partNumber, rule.MatchValue) {
+			filial = rule.Filial
+			break
+		}
+	}
+	s.mu.RUnlock()
+
+	if filial == "" {
+		filial = routingFilialOutros
+	}
+
+	rule, ok := s.find(RoutingRuleFilial, filial)
+	if !ok {
+		return nil, fmt.Errorf("%w: filial %s", ErrRouteNotFound, filial)
+	}
+
+	route := &Route{
+		Filial:        filial,
+		CodEmpresa:    rule.CodEmpresa,
+		CodFornecedor: rule.CodFornecedor,
+		ContaNBS:      rule.ContaNBS,
+		Schema:        rule.SchemaName,
+	}
+	if route.ContaNBS == "" {
+		route.ContaNBS = route.CodEmpresa
+	}
+	if route.Schema == "" {
+		route.Schema = routingDefaultSchema
+	}
+	return route, nil
+}
+
+// StockEmpresas retorna as empresas incluídas na consulta de estoque
+func (s *RoutingService) StockEmpresas() []int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var empresas []int
+	for _, rule := range s.rules {
+		if rule.RuleType != RoutingRuleEstoque {
+			continue
+		}
+		if codEmpresa, err := strconv.Atoi(rule.MatchValue); err == nil {
+			empresas = append(empresas, codEmpresa)
+		}
+	}
+	return empresas
+}
+
+// ContaEmpresas retorna as empresas das filiais roteadas para o schema das
+// contas, usadas para limitar a consulta de estoque ao escopo do usuário
+func (s *RoutingService) ContaEmpresas(contas []string) map[int]bool {
+	schemas := make(map[string]bool, len(contas))
+	for _, conta := range contas {
+		schemas[s.SchemaForConta(conta)] = true
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	empresas := make(map[int]bool)
+	for _, rule := range s.rules {
+		if rule.RuleType != RoutingRuleFilial || !schemas[rule.SchemaName] {
+			continue
+		}
+		if codEmpresa, err := strconv.Atoi(rule.CodEmpresa); err == nil {
+			empresas[codEmpresa] = true
+		}
+	}
+	return empresas
+}
+
+// CNPJForEmpresa retorna o CNPJ cadastrado para a empresa, se houver
+func (s *RoutingService) CNPJForEmpresa(codEmpresa string) (string, bool) {
+	rule, ok := s.find(RoutingRuleEmpresa, strings.TrimSpace(codEmpresa))
+	if !ok || rule.CNPJ == "" {
+		return "", false
+	}
+	return rule.CNPJ, true
+}
+
+// ListRules lista as regras do banco, inclusive as desabilitadas
+func (s *RoutingService) ListRules(ctx context.Context, ruleType string) ([]models.RoutingRule, error) {
+	query := `
+		SELECT CAST(id AS NVARCHAR(36)), rule_type, match_value, COALESCE(schema_name, ''), COALESCE(cod_empresa, ''),
+		       COALESCE(cod_fornecedor, ''), COALESCE(conta_nbs, ''), COALESCE(filial, ''), COALESCE(cnpj, ''), COALESCE(priority, 0),
+		       enabled, COALESCE(description, ''), COALESCE(updated_by, ''), created_at, updated_at
+		FROM routing_rules
+		WHERE @p1 = '' OR rule_type = @p1
+		ORDER BY rule_type, priority DESC, match_value`
+
+	rows, err := s.db.QueryContext(ctx, query, ruleType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get routing rules: %w", err)
+	}
+	defer rows.Close()
+
+	rules := []models.RoutingRule{}
+	for rows.Next() {
+		var rule models.RoutingRule
+		err := rows.Scan(&rule.ID, &rule.RuleType, &rule.MatchValue, &rule.SchemaName, &rule.CodEmpresa,
+			&rule.CodFornecedor, &rule.ContaNBS, &rule.Filial, &rule.CNPJ, &rule.Priority,
+			&rule.Enabled, &rule.Description, &rule.UpdatedBy, &rule.CreatedAt, &rule.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan routing rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+// GetRule busca uma regra pelo ID
+func (s *RoutingService) GetRule(ctx context.Context, id string) (*models.RoutingRule, error) {
+	rules, err := s.ListRules(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if strings.EqualFold(rule.ID, id) {
+			return &rule, nil
+		}
+	}
+	return nil, ErrRoutingRuleNotFound
+}
+
+// CreateRule cria uma regra e registra a operação na auditoria
+func (s *RoutingService) CreateRule(ctx context.Context, req models.RoutingRuleRequest, userID, userEmail, userName, ipAddress, userAgent string) (*models.RoutingRule, error) {
+	rule := routingRuleFromRequest(req)
+	if err := validateRoutingRule(rule); err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO routing_rules (rule_type, match_value, schema_name, cod_empresa, cod_fornecedor, conta_nbs,
+		                           filial, cnpj, priority, enabled, description, updated_by)
+		OUTPUT CAST(INSERTED.id AS NVARCHAR(36))
+		VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, @p9, @p10, @p11, @p12)`
+
+	err := s.db.QueryRowContext(ctx, query, rule.RuleType, rule.MatchValue, rule.SchemaName, rule.CodEmpresa, rule.CodFornecedor,
+		rule.ContaNBS, rule.Filial, rule.CNPJ, rule.Priority, rule.Enabled, rule.Description, userEmail).Scan(&rule.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create routing rule: %w", err)
+	}
+
+	auditReq := models.AuditLogRequest{
+		TableName:     routingRulesTable,
+		RecordID:      rule.ID,
+		Operation:     "INSERT",
+		NewValues:     routingRuleValues(rule),
+		ChangedFields: routingRuleFields,
+		IPAddress:     ipAddress,
+		UserAgent:     userAgent,
+	}
+	if err := s.audit.LogOperation(context.WithoutCancel(ctx), auditReq, userID, userEmail, userName); err != nil {
+		log.Printf("⚠️ Warning: Failed to log audit for routing rule CREATE: %v", err)
+	}
+
+	s.reloadAfterChange()
+	return s.GetRule(ctx, rule.ID)
+}
+
+// UpdateRule atualiza uma regra e registra os campos alterados na auditoria
+func (s *RoutingService) UpdateRule(ctx context.Context, id string, req models.RoutingRuleRequest, userID, userEmail, userName, ipAddress, userAgent string) (*models.RoutingRule, error) {
+	oldRule, err := s.GetRule(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := routingRuleFromRequest(req)
+	rule.ID = oldRule.ID
+	if err := validateRoutingRule(rule); err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE routing_rules
+		SET rule_type = @p1, match_value = @p2, schema_name = @p3, cod_empresa = @p4, cod_fornecedor = @p5,
+		    conta_nbs = @p6, filial = @p7, cnpj = @p8, priority = @p9, enabled = @p10, description = @p11,
+		    updated_by = @p12, updated_at = GETDATE()
+		WHERE id = @p13`
+
+	_, err = s.db.ExecContext(ctx, query, rule.RuleType, rule.MatchValue, rule.SchemaName, rule.CodEmpresa, rule.CodFornecedor,
+		rule.ContaNBS, rule.Filial, rule.CNPJ, rule.Priority, rule.Enabled, rule.Description, userEmail, rule.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update routing rule: %w", err)
+	}
+
+	oldValues := routingRuleValues(*oldRule)
+	newValues := routingRuleValues(rule)
+	changedFields := []string{}
+	changedOld := map[string]interface{}{}
+	changedNew := map[string]interface{}{}
+	for _, field := range routingRuleFields {
+		if oldValues[field] != newValues[field] {
+			changedFields = append(changedFields, field)
+			changedOld[field] = oldValues[field]
+			changedNew[field] = newValues[field]
+		}
+	}
+
+	if len(changedFields) > 0 {
+		auditReq := models.AuditLogRequest{
+			TableName:     routingRulesTable,
+			RecordID:      rule.ID,
+			Operation:     "UPDATE",
+			OldValues:     changedOld,
+			NewValues:     changedNew,
+			ChangedFields: changedFields,
+			IPAddress:     ipAddress,
+			UserAgent:     userAgent,
+		}
+		if err := s.audit.LogOperation(context.WithoutCancel(ctx), auditReq, userID, userEmail, userName); err != nil {
+			log.Printf("⚠️ Warning: Failed to log audit for routing rule UPDATE: %v", err)
+		}
+	}
+
+	s.reloadAfterChange()
+	return s.GetRule(ctx, rule.ID)
+}
+
+// DeleteRule remove uma regra e registra os valores antigos na auditoria
+func (s *RoutingService) DeleteRule(ctx context.Context, id string, userID, userEmail, userName, ipAddress, userAgent string) error {
+	oldRule, err := s.GetRule(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM routing_rules WHERE id = @p1`, oldRule.ID); err != nil {
+		return fmt.Errorf("failed to delete routing rule: %w", err)
+	}
+
+	oldValues := routingRuleValues(*oldRule)
+	oldValues["id"] = oldRule.ID
+
+	auditReq := models.AuditLogRequest{
+		TableName:     routingRulesTable,
+		RecordID:      oldRule.ID,
+		Operation:     "DELETE",
+		OldValues:     oldValues,
+		ChangedFields: routingRuleFields,
+		IPAddress:     ipAddress,
+		UserAgent:     userAgent,
+	}
+	if err := s.audit.LogOperation(context.WithoutCancel(ctx), auditReq, userID, userEmail, userName); err != nil {
+		log.Printf("⚠️ Warning: Failed to log audit for routing rule DELETE: %v", err)
+	}
+
+	s.reloadAfterChange()
+	return nil
+}
+
+func (s *RoutingService) reloadAfterChange() {
+	if err := s.Reload(); err != nil {
+		log.Printf("⚠️ Erro ao recarregar regras de roteamento: %v", err)
+	}
+}
+
+func (s *RoutingService) find(ruleType, matchValue string) (models.RoutingRule, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, rule := range s.rules {
+		if rule.RuleType == ruleType && strings.EqualFold(rule.MatchValue, matchValue) {
+			return rule, true
+		}
+	}
+	return models.RoutingRule{}, false
+}
+
+// seedDefaults popula a tabela com as regras padrão quando ela está vazia
+func (s *RoutingService) seedDefaults() error {
+	var count int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM routing_rules`).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	log.Printf("🧭 Populando %d regras de roteamento padrão", len(defaultRoutingRules))
+
+	query := `
+		INSERT INTO routing_rules (rule_type, match_value, schema_name, cod_empresa, cod_fornecedor, conta_nbs,
+		                           filial, priority, enabled, description, updated_by)
+		VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, 1, @p9, 'system')`
+
+	for _, rule := range defaultRoutingRules {
+		_, err := s.db.Exec(query, rule.RuleType, rule.MatchValue, rule.SchemaName, rule.CodEmpresa,
+			rule.CodFornecedor, rule.ContaNBS, rule.Filial, rule.Priority, rule.Description)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var routingRuleFields = []string{"rule_type", "match_value", "schema_name", "cod_empresa", "cod_fornecedor",
+	"conta_nbs", "filial", "cnpj", "priority", "enabled", "description"}
+
+// routingRuleValues retorna a regra indexada pelos nomes das colunas, como
+// esperado pelo rollback da auditoria
+func routingRuleValues(rule models.RoutingRule) map[string]interface{} {
+	return map[string]interface{}{
+		"rule_type":      rule.RuleType,
+		"match_value":    rule.MatchValue,
+		"schema_name":    rule.SchemaName,
+		"cod_empresa":    rule.CodEmpresa,
+		"cod_fornecedor": rule.CodFornecedor,
+		"conta_nbs":      rule.ContaNBS,
+		"filial":         rule.Filial,
+		"cnpj":           rule.CNPJ,
+		"priority":       rule.Priority,
+		"enabled":        rule.Enabled,
+		"description":    rule.Description,
+	}
+}
+
+func routingRuleFromRequest(req models.RoutingRuleRequest) models.RoutingRule {
+	rule := models.RoutingRule{
+		RuleType:      strings.ToLower(strings.TrimSpace(req.RuleType)),
+		MatchValue:    strings.TrimSpace(req.MatchValue),
+		SchemaName:    strings.TrimSpace(req.SchemaName),
+		CodEmpresa:    strings.TrimSpace(req.CodEmpresa),
+		CodFornecedor: strings.TrimSpace(req.CodFornecedor),
+		ContaNBS:      strings.TrimSpace(req.ContaNBS),
+		Filial:        strings.TrimSpace(req.Filial),
+		CNPJ:          onlyDigits(req.CNPJ),
+		Priority:      req.Priority,
+		Enabled:       req.Enabled == nil || *req.Enabled,
+		Description:   strings.TrimSpace(req.Description),
+	}
+	if rule.RuleType == RoutingRuleConta {
+		rule.MatchValue = strings.ToLower(rule.MatchValue)
+	}
+	return rule
+}
+
+func validateRoutingRule(rule models.RoutingRule) error {
+	if rule.SchemaName != "" && !routingSchemaPattern.MatchString(rule.SchemaName) {
+		return fmt.Errorf("schema_name inválido: %s (use apenas letras, números e _)", rule.SchemaName)
+	}
+
+	switch rule.RuleType {
+	case RoutingRuleConta:
+		if rule.SchemaName == "" {
+			return fmt.Errorf("schema_name é obrigatório para regras de conta")
+		}
+	case RoutingRuleFilial:
+		if rule.CodEmpresa == "" || rule.CodFornecedor == "" {
+			return fmt.Errorf("cod_empresa e cod_fornecedor são obrigatórios para regras de filial")
+		}
+	case RoutingRuleMPN:
+		if rule.Filial == "" {
+			return fmt.Errorf("filial é obrigatória para regras de MPN")
+		}
+	case RoutingRuleEstoque:
+		if _, err := strconv.Atoi(rule.MatchValue); err != nil {
+			return fmt.Errorf("match_value deve ser o código numérico da empresa para regras de estoque")
+		}
+	case RoutingRuleEmpresa:
+		if len(rule.CNPJ) != 14 || !validCNPJ(rule.CNPJ) {
+			return fmt.Errorf("cnpj inválido para regras de empresa")
+		}
+	default:
+		return fmt.Errorf("rule_type inválido: %s (use conta, filial, mpn, estoque ou empresa)", rule.RuleType)
+	}
+	return nil
+}
+
+// enabledRoutingRules filtra as regras habilitadas, ordenadas por prioridade
+func enabledRoutingRules(rules []models.RoutingRule) []models.RoutingRule {
+	enabled := make([]models.RoutingRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Enabled || rule.ID == "" {
+			enabled = append(enabled, rule)
+		}
+	}
+	sort.SliceStable(enabled, func(i, j int) bool {
+		return enabled[i].Priority > enabled[j].Priority
+	})
+	return enabled
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrOutOfScope indica um dado de uma conta fora do escopo do usuário
+var ErrOutOfScope = errors.New("conta fora do escopo do usuário")
+
+// AllContas é a conta atribuída aos usuários que acessam todas as contas
+const AllContas = "*"
+
+// Scope são as contas cujos dados um usuário pode ver e alterar. O escopo
+// zero (Contas nil) não restringe nada: é o dos administradores, dos usuários
+// com AllContas e das rotinas internas (poller, webhooks, varreduras).
+type Scope struct {
+	Contas []string
+}
+
+// ScopeFor monta o escopo de um usuário a partir das contas atribuídas a ele.
+// Sem contas atribuídas o usuário não acessa nenhuma.
+func ScopeFor(contas []string) Scope {
+	normalized := make([]string, 0, len(contas))
+	for _, conta := range contas {
+		conta = strings.ToLower(strings.TrimSpace(conta))
+		if conta == AllContas {
+			return Scope{}
+		}
+		normalized = append(normalized, conta)
+	}
+	return Scope{Contas: normalized}
+}
+
+// Restricted informa se o escopo limita as contas
+func (s Scope) Restricted() bool {
+	return s.Contas != nil
+}
+
+// Allows informa se a conta está no escopo
+func (s Scope) Allows(conta string) bool {
+	if !s.Restricted() {
+		return true
+	}
+	conta = strings.ToLower(strings.TrimSpace(conta))
+	for _, allowed := range s.Contas {
+		if allowed == conta {
+			return true
+		}
+	}
+	return false
+}
+
+// sqlCondition retorna a condição "column IN (...)" do escopo, acrescentando
+// as contas em args; vazia quando o escopo não restringe nada
+func (s Scope) sqlCondition(column string, args *[]interface{}) string {
+	if !s.Restricted() {
+		return ""
+	}
+	if len(s.Contas) == 0 {
+		return "1 = 0"
+	}
+	placeholders := make([]string, len(s.Contas))
+	for i, conta := range s.Contas {
+		*args = append(*args, conta)
+		placeholders[i] = fmt.Sprintf("@p%d", len(*args))
+	}
+	return column + " IN (" + strings.Join(placeholders, ", ") + ")"
+}
+
+// check retorna ErrOutOfScope quando a conta não está no escopo
+func (s Scope) check(conta string) error {
+	if s.Allows(conta) {
+		return nil
+	}
+	if conta == "" {
+		return ErrOutOfScope
+	}
+	return fmt.Errorf("%w: %s", ErrOutOfScope, conta)
+}
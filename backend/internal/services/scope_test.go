@@ -0,0 +1,53 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScopeFor(t *testing.T) {
+	// Sem contas atribuídas o usuário não acessa nenhuma
+	none := ScopeFor(nil)
+	if !none.Restricted() || none.Allows("psa") {
+		t.Errorf("ScopeFor(nil) = %#v, deveria negar todas as contas", none)
+	}
+
+	if ScopeFor([]string{"psa", AllContas}).Restricted() {
+		t.Error("ScopeFor com AllContas não deveria restringir")
+	}
+
+	scope := ScopeFor([]string{" PSA ", "lcs"})
+	if !scope.Allows("psa") || !scope.Allows("LCS") || scope.Allows("outra") {
+		t.Errorf("ScopeFor = %v: Allows incorreto", scope.Contas)
+	}
+}
+
+func TestScopeSQLCondition(t *testing.T) {
+	tests := []struct {
+		name     string
+		scope    Scope
+		args     []interface{}
+		want     string
+		wantArgs []interface{}
+	}{
+		{"sem restrição", Scope{}, nil, "", nil},
+		{"todas as contas", ScopeFor([]string{AllContas}), nil, "", nil},
+		{"nenhuma conta atribuída", ScopeFor(nil), nil, "1 = 0", nil},
+		{"uma conta", ScopeFor([]string{"psa"}), nil, "conta IN (@p1)", []interface{}{"psa"}},
+		{"várias contas", ScopeFor([]string{"psa", "lcs"}), nil, "conta IN (@p1, @p2)", []interface{}{"psa", "lcs"}},
+		{
+			"depois de outros argumentos", ScopeFor([]string{"psa", "lcs"}), []interface{}{"integration", 10},
+			"conta IN (@p3, @p4)", []interface{}{"integration", 10, "psa", "lcs"},
+		},
+	}
+
+	for _, tt := range tests {
+		args := tt.args
+		if got := tt.scope.sqlCondition("conta", &args); got != tt.want {
+			t.Errorf("%s: sqlCondition = %q, want %q", tt.name, got, tt.want)
+		}
+		if !reflect.DeepEqual(args, tt.wantArgs) {
+			t.Errorf("%s: args = %v, want %v", tt.name, args, tt.wantArgs)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"amz-web-tools/backend/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Errors returned by the session methods
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token already used; session revoked")
+)
+
+// sessionCacheTTL is how long AuthMiddleware trusts a session lookup before
+// checking user_sessions again. Revocations made by this process clear the
+// cache immediately.
+const sessionCacheTTL = 30 * time.Second
+
+// TokenPair is returned on login and refresh
+type TokenPair struct {
+	AccessToken      string    `json:"token"`
+	RefreshToken     string    `json:"refresh_token"`
+	ExpiresIn        int       `json:"expires_in"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+	Permissions      []string  `json:"permissions"`
+	Contas           []string  `json:"contas"`
+	SessionID        string    `json:"-"`
+}
+
+type sessionCacheEntry struct {
+	active    bool
+	checkedAt time.Time
+}
+
+// CreateSession opens a session for the user and returns its first token
+// pair. Expired and long revoked sessions of the user are removed.
+func (s *AuthService) CreateSession(ctx context.Context, user *models.User, ipAddress, userAgent string) (*TokenPair, error) {
+	_, err := s.db.ExecContext(ctx, `
+		DELETE FROM user_sessions
+		WHERE user_id = TRY_CONVERT(UNIQUEIDENTIFIER, @p1)
+		AND (expires_at <= GETDATE() OR revoked_at < DATEADD(DAY, -1, GETDATE()))`, user.ID)
+	if err != nil {
+		log.Printf("⚠️ Erro ao limpar sessões expiradas do usuário %s: %v", user.ID, err)
+	}
+
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	var sessionID string
+	var expiresAt time.Time
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO user_sessions (user_id, token, expires_at, last_used_at, ip_address, user_agent)
+		OUTPUT CAST(INSERTED.id AS NVARCHAR(36)), INSERTED.expires_at
+		VALUES (TRY_CONVERT(UNIQUEIDENTIFIER, @p1), @p2, DATEADD(HOUR, @p3, GETDATE()), GETDATE(), @p4, @p5)`,
+		user.ID, hashRefreshToken(refreshToken), s.config.JWTRefreshTokenHours,
+		truncate(ipAddress, 45), truncate(userAgent, 500)).Scan(&sessionID, &expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return s.issueTokens(ctx, user, sessionID, refreshToken, expiresAt)
+}
+
+// RefreshSession rotates the refresh token of a session and issues a new
+// access token with the user's current role and permissions. The session keeps its original
+// expiry. Presenting a refresh token that was already rotated revokes the
+// session, since it means the token was copied.
+func (s *AuthService) RefreshSession(ctx context.Context, refreshToken, ipAddress, userAgent string) (*TokenPair, error) {
+	if refreshToken == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+	tokenHash := hashRefreshToken(refreshToken)
+
+	newToken, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	var sessionID, userID string
+	var expiresAt time.Time
+	err = s.db.QueryRowContext(ctx, `
+		UPDATE user_sessions
+		SET previous_token = token, token = @p1, last_used_at = GETDATE(),
+			ip_address = COALESCE(@p2, ip_address), user_agent = COALESCE(@p3, user_agent)
+		OUTPUT CAST(INSERTED.id AS NVARCHAR(36)), CAST(INSERTED.user_id AS NVARCHAR(36)), INSERTED.expires_at
+		WHERE token = @p4 AND revoked_at IS NULL AND expires_at > GETDATE()`,
+		hashRefreshToken(newToken), nullIfEmpty(truncate(ipAddress, 45)),
+		nullIfEmpty(truncate(userAgent, 500)), tokenHash).Scan(&sessionID, &userID, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, s.rejectRefreshToken(ctx, tokenHash)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh session: %w", err)
+	}
+
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			s.RevokeSession(ctx, sessionID)
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, fmt.Errorf("failed to load session user: %w", err)
+	}
+
+	return s.issueTokens(ctx, user, sessionID, newToken, expiresAt)
+}
+
+// rejectRefreshToken revokes the session when the token is the one it had
+// before the last rotation
+func (s *AuthService) rejectRefreshToken(ctx context.Context, tokenHash string) error {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE user_sessions SET revoked_at = GETDATE()
+		OUTPUT CAST(INSERTED.id AS NVARCHAR(36)), CAST(INSERTED.user_id AS NVARCHAR(36))
+		WHERE previous_token = @p1 AND revoked_at IS NULL`, tokenHash)
+	if err != nil {
+		return fmt.Errorf("failed to check refresh token: %w", err)
+	}
+	defer rows.Close()
+
+	reused := false
+	for rows.Next() {
+		var sessionID, userID string
+		if err := rows.Scan(&sessionID, &userID); err != nil {
+			return fmt.Errorf("failed to check refresh token: %w", err)
+		}
+		log.Printf("🚨 Refresh token reutilizado: sessão %s do usuário %s revogada", sessionID, userID)
+		s.forgetSession(sessionID)
+		reused = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to check refresh token: %w", err)
+	}
+
+	if reused {
+		return ErrRefreshTokenReused
+	}
+	return ErrInvalidRefreshToken
+}
+
+// RevokeSession ends a session; access tokens already issued for it stop
+// being accepted
+func (s *AuthService) RevokeSession(ctx context.Context, sessionID string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE user_sessions SET revoked_at = GETDATE()
+		WHERE id = TRY_CONVERT(UNIQUEIDENTIFIER, @p1) AND revoked_at IS NULL`, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	s.forgetSession(sessionID)
+	return nil
+}
+
+// RevokeSessionByRefreshToken ends the session the refresh token belongs to.
+// Unknown tokens are ignored so logout always succeeds.
+func (s *AuthService) RevokeSessionByRefreshToken(ctx context.Context, refreshToken string) error {
+	var sessionID string
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE user_sessions SET revoked_at = GETDATE()
+		OUTPUT CAST(INSERTED.id AS NVARCHAR(36))
+		WHERE token = @p1 AND revoked_at IS NULL`, hashRefreshToken(refreshToken)).Scan(&sessionID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	s.forgetSession(sessionID)
+	return nil
+}
+
+// RevokeUserSessions ends every active session of the user and returns how
+// many were revoked
+func (s *AuthService) RevokeUserSessions(ctx context.Context, userID string) (int, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE user_sessions SET revoked_at = GETDATE()
+		OUTPUT CAST(INSERTED.id AS NVARCHAR(36))
+		WHERE user_id = TRY_CONVERT(UNIQUEIDENTIFIER, @p1) AND revoked_at IS NULL`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke user sessions: %w", err)
+	}
+	defer rows.Close()
+
+	revoked := 0
+	for rows.Next() {
+		var sessionID string
+		if err := rows.Scan(&sessionID); err != nil {
+			return revoked, fmt.Errorf("failed to revoke user sessions: %w", err)
+		}
+		s.forgetSession(sessionID)
+		revoked++
+	}
+	return revoked, rows.Err()
+}
+
+// SessionActive reports whether the session exists, is not revoked and has
+// not expired. Results are cached for sessionCacheTTL.
+func (s *AuthService) SessionActive(ctx context.Context, sessionID string) (bool, error) {
+	key := strings.ToUpper(sessionID)
+
+	s.sessionsMu.Lock()
+	entry, ok := s.sessions[key]
+	s.sessionsMu.Unlock()
+	if ok && time.Since(entry.checkedAt) < sessionCacheTTL {
+		return entry.active, nil
+	}
+
+	var count int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM user_sessions
+		WHERE id = TRY_CONVERT(UNIQUEIDENTIFIER, @p1) AND revoked_at IS NULL AND expires_at > GETDATE()`,
+		sessionID).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check session: %w", err)
+	}
+
+	s.sessionsMu.Lock()
+	now := time.Now()
+	for id, cached := range s.sessions {
+		if now.Sub(cached.checkedAt) >= sessionCacheTTL {
+			delete(s.sessions, id)
+		}
+	}
+	s.sessions[key] = sessionCacheEntry{active: count > 0, checkedAt: now}
+	s.sessionsMu.Unlock()
+
+	return count > 0, nil
+}
+
+func (s *AuthService) forgetSession(sessionID string) {
+	s.sessionsMu.Lock()
+	delete(s.sessions, strings.ToUpper(sessionID))
+	s.sessionsMu.Unlock()
+}
+
+// issueTokens signs the access token of the session, embedding the
+// permissions of the user's role and the contas the user can access
+func (s *AuthService) issueTokens(ctx context.Context, user *models.User, sessionID, refreshToken string, refreshExpiresAt time.Time) (*TokenPair, error) {
+	rolePermissions, err := s.roles.Permissions(ctx, user.Role)
+	if err != nil {
+		return nil, err
+	}
+	contas, err := s.UserContas(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	lifetime := time.Duration(s.config.JWTAccessTokenMinutes) * time.Minute
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"user_id":     user.ID,
+		"role":        user.Role,
+		"permissions": rolePermissions,
+		"contas":      contas,
+		"sid":         sessionID,
+		"exp":         now.Add(lifetime).Unix(),
+		"iat":         now.Unix(),
+	}
+
+	accessToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(s.config.JWTSecret))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign access token: %w", err)
+	}
+
+	return &TokenPair{
+		AccessToken:      accessToken,
+		RefreshToken:     refreshToken,
+		ExpiresIn:        int(lifetime.Seconds()),
+		RefreshExpiresAt: refreshExpiresAt,
+		Permissions:      rolePermissions,
+		Contas:           contas,
+		SessionID:        sessionID,
+	}, nil
+}
+
+// newRefreshToken returns a random opaque refresh token
+func newRefreshToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashRefreshToken is what user_sessions stores, so a leaked table does not
+// leak usable tokens
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func nullIfEmpty(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
@@ -1,9 +1,11 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 
 	"amz-web-tools/backend/internal/config"
@@ -15,9 +17,10 @@ import (
 type StockService struct {
 	oracleDB *sql.DB
 	config   *config.Config
+	routing  *RoutingService
 }
 
-func NewStockService(cfg *config.Config) (*StockService, error) {
+func NewStockService(cfg *config.Config, routing *RoutingService) (*StockService, error) {
 	log.Printf("🔧 Starting StockService initialization...")
 
 	// Check if Oracle configuration is available
@@ -29,6 +32,7 @@ func NewStockService(cfg *config.Config) (*StockService, error) {
 		return &StockService{
 			oracleDB: nil,
 			config:   cfg,
+			routing:  routing,
 		}, nil
 	}
 
@@ -53,6 +57,7 @@ func NewStockService(cfg *config.Config) (*StockService, error) {
 		return &StockService{
 			oracleDB: nil,
 			config:   cfg,
+			routing:  routing,
 		}, nil
 	}
 
@@ -65,6 +70,7 @@ func NewStockService(cfg *config.Config) (*StockService, error) {
 		return &StockService{
 			oracleDB: nil,
 			config:   cfg,
+			routing:  routing,
 		}, nil
 	}
 
@@ -73,14 +79,15 @@ func NewStockService(cfg *config.Config) (*StockService, error) {
 	service := &StockService{
 		oracleDB: oracleDB,
 		config:   cfg,
+		routing:  routing,
 	}
 
 	log.Printf("🔧 StockService created with Oracle connection: %v", service.oracleDB != nil)
 	return service, nil
 }
 
-// SearchStock searches for stock by SKU
-func (s *StockService) SearchStock(sku string) ([]models.StockItem, error) {
+// SearchStock searches for stock by SKU in the companies within the scope
+func (s *StockService) SearchStock(ctx context.Context, scope Scope, sku string) ([]models.StockItem, error) {
 	// Clean and format SKU (remove 'LC' prefix and convert to uppercase)
 	cleanSKU := strings.ToUpper(strings.Replace(sku, "LC", "", -1))
 
@@ -93,7 +100,32 @@ func (s *StockService) SearchStock(sku string) ([]models.StockItem, error) {
 		return []models.StockItem{}, nil
 	}
 
-	query := `
+	// Companies come from the "estoque" routing rules
+	empresas := s.routing.StockEmpresas()
+	if len(empresas) == 0 {
+		log.Printf("⚠️ No stock routing rules enabled, returning empty results")
+		return []models.StockItem{}, nil
+	}
+	if scope.Restricted() {
+		allowed := s.routing.ContaEmpresas(scope.Contas)
+		scoped := empresas[:0]
+		for _, codEmpresa := range empresas {
+			if allowed[codEmpresa] {
+				scoped = append(scoped, codEmpresa)
+			}
+		}
+		if len(scoped) == 0 {
+			log.Printf("⚠️ No stock companies for contas %v, returning empty results", scope.Contas)
+			return []models.StockItem{}, nil
+		}
+		empresas = scoped
+	}
+	codEmpresas := make([]string, len(empresas))
+	for i, codEmpresa := range empresas {
+		codEmpresas[i] = strconv.Itoa(codEmpresa)
+	}
+
+	query := fmt.Sprintf(`
 		SELECT DISTINCT
 			e.cod_empresa,
 			em.nome nom_empresa,
@@ -112,10 +144,13 @@ func (s *StockService) SearchStock(sku string) ([]models.StockItem, error) {
 		LEFT JOIN nbs.EMPRESAS em
 		ON e.COD_EMPRESA = em.COD_EMPRESA 
 		WHERE
-			e.cod_empresa IN (1,3,17,31,34,35,40,41,43,144,45,47,48,140)
-			AND e.cod_item = :1`
+			e.cod_empresa IN (%s)
+			AND e.cod_item = :1`, strings.Join(codEmpresas, ","))
+
+	ctx, cancel := withDeadline(ctx, s.config.StockQueryTimeoutSeconds)
+	defer cancel()
 
-	rows, err := s.oracleDB.Query(query, cleanSKU)
+	rows, err := s.oracleDB.QueryContext(ctx, query, cleanSKU)
 	if err != nil {
 		log.Printf("❌ Error querying Oracle: %v", err)
 		return nil, fmt.Errorf("failed to query stock: %w", err)
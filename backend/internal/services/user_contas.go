@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidConta is returned when assigning a conta that is not integrated
+var ErrInvalidConta = errors.New("invalid conta")
+
+// UserContas returns the contas the user can access; AllContas means every
+// conta and an empty list none
+func (s *AuthService) UserContas(ctx context.Context, userID string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT conta FROM user_contas
+		WHERE user_id = TRY_CONVERT(UNIQUEIDENTIFIER, @p1)
+		ORDER BY conta`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user contas: %w", err)
+	}
+	defer rows.Close()
+
+	contas := []string{}
+	for rows.Next() {
+		var conta string
+		if err := rows.Scan(&conta); err != nil {
+			return nil, fmt.Errorf("failed to read user conta: %w", err)
+		}
+		contas = append(contas, conta)
+	}
+	return contas, rows.Err()
+}
+
+// SetUserContas replaces the contas the user can access. AllContas grants
+// every conta and an empty list none. Tokens already issued keep the old
+// contas until the user's sessions are revoked or refreshed.
+func (s *AuthService) SetUserContas(ctx context.Context, userID string, contas []string) ([]string, error) {
+	normalized := []string{}
+	seen := make(map[string]bool)
+	for _, conta := range contas {
+		conta = strings.ToLower(strings.TrimSpace(conta))
+		if conta == "" || seen[conta] {
+			continue
+		}
+		if conta == AllContas {
+			normalized = []string{AllContas}
+			break
+		}
+		if !isIntegrationConta(conta) {
+			return nil, fmt.Errorf("%w: %s (use %s or %s)", ErrInvalidConta, conta, strings.Join(integrationContas, ", "), AllContas)
+		}
+		seen[conta] = true
+		normalized = append(normalized, conta)
+	}
+	sort.Strings(normalized)
+
+	if _, err := s.GetUserByID(userID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_contas WHERE user_id = TRY_CONVERT(UNIQUEIDENTIFIER, @p1)`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update user contas: %w", err)
+	}
+	for _, conta := range normalized {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO user_contas (user_id, conta) VALUES (TRY_CONVERT(UNIQUEIDENTIFIER, @p1), @p2)`, userID, conta)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update user contas: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to update user contas: %w", err)
+	}
+	return normalized, nil
+}
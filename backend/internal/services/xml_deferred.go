@@ -0,0 +1,308 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"amz-web-tools/backend/internal/notify"
+)
+
+// Status de um envio agendado em xml_deferred_uploads
+const (
+	XMLDeferredScheduled = "scheduled" // aguardando a data de buffering
+	XMLDeferredUploaded  = "uploaded"  // XML enviado ao Mercado Livre
+	XMLDeferredFailed    = "failed"    // tentativas esgotadas
+	XMLDeferredCancelled = "cancelled" // o envio não aguarda mais a nota fiscal
+)
+
+// xmlDeferredBatchSize limita os envios processados por execução do worker
+const xmlDeferredBatchSize = 100
+
+// XMLDeferredUpload é um envio "buffered" do Mercado Livre cujo XML será
+// enviado quando a data de buffering chegar. As datas são gravadas em UTC.
+type XMLDeferredUpload struct {
+	ID            string     `json:"id"`
+	NumEnvio      string     `json:"num_envio"`
+	NumPedido     string     `json:"num_pedido"`
+	NumPrenota    string     `json:"num_prenota"`
+	Conta         string     `json:"conta"`
+	BufferingDate time.Time  `json:"buffering_date"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	MLStatus      string     `json:"ml_status,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ProcessedAt   *time.Time `json:"processed_at,omitempty"`
+}
+
+// deferUpload agenda o envio do XML de um pedido "buffered" para a data de
+// buffering informada pelo Mercado Livre. Um envio já agendado tem a data
+// atualizada; envios já concluídos não são alterados.
+func (s *XMLIntegratorService) deferUpload(ctx context.Context, pedido PedidoInfo, conta, bufferingDate string) error {
+	if s.sqlDB == nil {
+		return fmt.Errorf("banco do portal não disponível")
+	}
+	if pedido.NumEnvio == "" {
+		return fmt.Errorf("pedido %s sem envio", pedido.NumPedido)
+	}
+
+	date, err := time.Parse(time.RFC3339, bufferingDate)
+	if err != nil {
+		return fmt.Errorf("data de buffering inválida %q: %w", bufferingDate, err)
+	}
+	date = date.UTC()
+
+	query := `
+		MERGE xml_deferred_uploads AS target
+		USING (SELECT @p1 AS num_envio) AS source
+		ON target.num_envio = source.num_envio
+		WHEN MATCHED AND target.status <> @p7 THEN UPDATE SET num_pedido = @p2, num_prenota = @p3, conta = @p4,
+			buffering_date = @p5, next_attempt_at = @p5, status = @p6, attempts = 0, last_error = NULL, updated_at = GETDATE()
+		WHEN NOT MATCHED THEN INSERT (num_envio, num_pedido, num_prenota, conta, buffering_date, next_attempt_at, status)
+			VALUES (@p1, @p2, @p3, @p4, @p5, @p5, @p6);`
+
+	_, err = s.sqlDB.ExecContext(ctx, query, pedido.NumEnvio, pedido.NumPedido, pedido.NumPrenota, conta,
+		date, XMLDeferredScheduled, XMLDeferredUploaded)
+	if err != nil {
+		return fmt.Errorf("erro ao agendar envio do XML: %w", err)
+	}
+
+	log.Printf("📅 %s (MeLi) - Pedido %s - Envio do XML agendado para %s",
+		strings.ToUpper(conta), pedido.NumPedido, date.Local().Format("02/01/2006 15:04"))
+	return nil
+}
+
+// StartDeferredWorker processa periodicamente os envios agendados cuja data
+// chegou; o worker para quando ctx é cancelado
+func (s *XMLIntegratorService) StartDeferredWorker(ctx context.Context) {
+	if s.sqlDB == nil {
+		return
+	}
+
+	interval := time.Duration(s.config.XMLDeferredIntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+
+	log.Printf("⏰ Envios de XML agendados: verificação a cada %s", interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.processDeferredUploads(ctx)
+			}
+		}
+	}()
+}
+
+// processDeferredUploads envia os XMLs agendados cuja data já chegou
+func (s *XMLIntegratorService) processDeferredUploads(ctx context.Context) {
+	if !s.deferredMu.TryLock() {
+		return
+	}
+	defer s.deferredMu.Unlock()
+
+	uploads, err := s.queryDeferredUploads(ctx, `
+		SELECT TOP (@p1) CAST(id AS NVARCHAR(36)), num_envio, num_pedido, num_prenota, conta, buffering_date, next_attempt_at,
+		       status, COALESCE(attempts, 0), COALESCE(ml_status, ''), COALESCE(last_error, ''), created_at, processed_at
+		FROM xml_deferred_uploads
+		WHERE status = @p2 AND next_attempt_at <= GETUTCDATE()
+		ORDER BY next_attempt_at`, xmlDeferredBatchSize, XMLDeferredScheduled)
+	if err != nil {
+		log.Printf("⚠️ Erro ao buscar envios de XML agendados: %v", err)
+		return
+	}
+
+	if len(uploads) > 0 {
+		log.Printf("📅 %d envio(s) de XML agendado(s) para processar", len(uploads))
+	}
+
+	for _, upload := range uploads {
+		if ctx.Err() != nil {
+			return
+		}
+		s.processDeferredUpload(ctx, upload)
+	}
+}
+
+// processDeferredUpload verifica o envio no Mercado Livre e envia o XML se a
+// nota fiscal já é aguardada. Um envio ainda "buffered" é reagendado para a
+// nova data de buffering; falhas são repetidas até XML_DEFERRED_MAX_ATTEMPTS.
+func (s *XMLIntegratorService) processDeferredUpload(ctx context.Context, upload XMLDeferredUpload) {
+	ctx, cancel := withDeadline(ctx, s.config.XMLIntegrationTimeoutSeconds)
+	defer cancel()
+
+	shipment, err := s.marketplace.FetchShipment(ctx, upload.Conta, upload.NumEnvio)
+	if err != nil {
+		s.retryDeferredUpload(ctx, upload, "", fmt.Sprintf("erro ao verificar status ML: %v", err))
+		return
+	}
+	mlStatus := shipment.Status + "/" + shipment.Substatus
+
+	switch {
+	case shipment.Status == "pending" && shipment.Substatus == "buffered":
+		pedido := PedidoInfo{NumPedido: upload.NumPedido, NumPrenota: upload.NumPrenota, NumEnvio: upload.NumEnvio}
+		if date, err := time.Parse(time.RFC3339, shipment.BufferingDate); err == nil && date.After(time.Now()) {
+			if err := s.deferUpload(ctx, pedido, upload.Conta, shipment.BufferingDate); err != nil {
+				log.Printf("⚠️ Erro ao reagendar envio do XML do pedido %s: %v", upload.NumPedido, err)
+			}
+			return
+		}
+		s.retryDeferredUpload(ctx, upload, mlStatus, "envio ainda aguardando a data de buffering")
+		return
+
+	case shipment.Status != "ready_to_ship" || shipment.Substatus != "invoice_pending":
+		log.Printf("⏭️ %s (MeLi) - Pedido %s - Envio agendado cancelado, status ML: %s",
+			strings.ToUpper(upload.Conta), upload.NumPedido, mlStatus)
+		s.finishDeferredUpload(ctx, upload, XMLDeferredCancelled, mlStatus, "envio não aguarda mais a nota fiscal")
+		return
+	}
+
+	xmlData, err := s.getXMLDataFromOracle(ctx, upload.NumPrenota)
+	if err != nil {
+		s.retryDeferredUpload(ctx, upload, mlStatus, fmt.Sprintf("erro ao buscar XML: %v", err))
+		return
+	}
+	if xmlData == nil || xmlData.XMLNota == "" {
+		s.retryDeferredUpload(ctx, upload, mlStatus, "pré-nota ainda sem XML")
+		return
+	}
+
+	pedido := PedidoInfo{NumPedido: upload.NumPedido, NumPrenota: upload.NumPrenota, NumEnvio: upload.NumEnvio}
+	if _, err := s.validateInvoice(ctx, upload.Conta, pedido, xmlData); err != nil {
+		s.retryDeferredUpload(ctx, upload, mlStatus, fmt.Sprintf("envio bloqueado: %v", err))
+		return
+	}
+
+	if err := s.marketplace.UploadInvoice(ctx, upload.Conta, upload.NumEnvio, xmlData.XMLNota); err != nil {
+		s.retryDeferredUpload(ctx, upload, mlStatus, fmt.Sprintf("falha ao enviar XML: %v", err))
+		return
+	}
+
+	// O XML já foi aceito pelo ML: as gravações não são canceladas junto com o worker
+	ctx = context.WithoutCancel(ctx)
+
+	log.Printf("✅ %s (MeLi) - Pedido %s, NF: %s - XML agendado enviado com sucesso!",
+		strings.ToUpper(upload.Conta), upload.NumPedido, xmlData.Controle)
+
+	if err := s.updatePostgresStatus(ctx, upload.NumPrenota, xmlData.Controle, xmlData.XMLNota, "3"); err != nil {
+		log.Printf("⚠️ Erro ao atualizar status do pedido %s: %v", upload.NumPedido, err)
+	}
+	s.finishDeferredUpload(ctx, upload, XMLDeferredUploaded, mlStatus, "")
+}
+
+// retryDeferredUpload registra a falha e reagenda a tentativa para daqui a
+// XML_DEFERRED_RETRY_MINUTES, ou marca o envio como falho após a última tentativa
+func (s *XMLIntegratorService) retryDeferredUpload(ctx context.Context, upload XMLDeferredUpload, mlStatus, reason string) {
+	attempts := upload.Attempts + 1
+	if attempts >= s.config.XMLDeferredMaxAttempts {
+		log.Printf("❌ %s (MeLi) - Pedido %s - Envio agendado do XML falhou após %d tentativa(s): %s",
+			strings.ToUpper(upload.Conta), upload.NumPedido, attempts, reason)
+		s.notifier.Notify(notify.Event{
+			Kind:     notify.EventXMLDeferredFailed,
+			Severity: notify.SeverityError,
+			Conta:    upload.Conta,
+			Digest:   true,
+			Data: map[string]interface{}{
+				"conta":      upload.Conta,
+				"num_pedido": upload.NumPedido,
+				"tentativas": attempts,
+				"erro":       reason,
+			},
+		})
+
+		upload.Attempts = attempts
+		s.finishDeferredUpload(ctx, upload, XMLDeferredFailed, mlStatus, reason)
+		return
+	}
+
+	log.Printf("⏳ %s (MeLi) - Pedido %s - Envio agendado do XML adiado (tentativa %d/%d): %s",
+		strings.ToUpper(upload.Conta), upload.NumPedido, attempts, s.config.XMLDeferredMaxAttempts, reason)
+
+	query := `
+		UPDATE xml_deferred_uploads
+		SET attempts = @p1, ml_status = @p2, last_error = @p3,
+		    next_attempt_at = DATEADD(MINUTE, @p4, GETUTCDATE()), updated_at = GETDATE()
+		WHERE id = @p5`
+
+	_, err := s.sqlDB.ExecContext(context.WithoutCancel(ctx), query, attempts,
+		sql.NullString{String: mlStatus, Valid: mlStatus != ""}, reason, s.config.XMLDeferredRetryMinutes, upload.ID)
+	if err != nil {
+		log.Printf("⚠️ Erro ao reagendar envio do XML do pedido %s: %v", upload.NumPedido, err)
+	}
+}
+
+func (s *XMLIntegratorService) finishDeferredUpload(ctx context.Context, upload XMLDeferredUpload, status, mlStatus, reason string) {
+	query := `
+		UPDATE xml_deferred_uploads
+		SET status = @p1, attempts = @p2, ml_status = @p3, last_error = @p4, processed_at = GETDATE(), updated_at = GETDATE()
+		WHERE id = @p5`
+
+	_, err := s.sqlDB.ExecContext(context.WithoutCancel(ctx), query, status, upload.Attempts,
+		sql.NullString{String: mlStatus, Valid: mlStatus != ""}, sql.NullString{String: reason, Valid: reason != ""}, upload.ID)
+	if err != nil {
+		log.Printf("⚠️ Erro ao atualizar envio agendado do pedido %s: %v", upload.NumPedido, err)
+	}
+}
+
+// GetScheduledUploads lista os envios de XML agendados com o status informado
+// (scheduled por padrão), dos mais próximos aos mais distantes
+func (s *XMLIntegratorService) GetScheduledUploads(ctx context.Context, scope Scope, status string, limit int) ([]XMLDeferredUpload, error) {
+	if s.sqlDB == nil {
+		return nil, fmt.Errorf("banco do portal não disponível")
+	}
+	if status == "" {
+		status = XMLDeferredScheduled
+	}
+
+	ctx, cancel := withDeadline(ctx, s.config.DBQueryTimeoutSeconds)
+	defer cancel()
+
+	args := []interface{}{limit, status}
+	where := "status = @p2"
+	if condition := scope.sqlCondition("conta", &args); condition != "" {
+		where += " AND " + condition
+	}
+
+	return s.queryDeferredUploads(ctx, `
+		SELECT TOP (@p1) CAST(id AS NVARCHAR(36)), num_envio, num_pedido, num_prenota, conta, buffering_date, next_attempt_at,
+		       status, COALESCE(attempts, 0), COALESCE(ml_status, ''), COALESCE(last_error, ''), created_at, processed_at
+		FROM xml_deferred_uploads
+		WHERE `+where+`
+		ORDER BY next_attempt_at`, args...)
+}
+
+func (s *XMLIntegratorService) queryDeferredUploads(ctx context.Context, query string, args ...interface{}) ([]XMLDeferredUpload, error) {
+	rows, err := s.sqlDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar envios de XML agendados: %w", err)
+	}
+	defer rows.Close()
+
+	uploads := []XMLDeferredUpload{}
+	for rows.Next() {
+		var u XMLDeferredUpload
+		var processedAt sql.NullTime
+		if err := rows.Scan(&u.ID, &u.NumEnvio, &u.NumPedido, &u.NumPrenota, &u.Conta, &u.BufferingDate, &u.NextAttemptAt,
+			&u.Status, &u.Attempts, &u.MLStatus, &u.LastError, &u.CreatedAt, &processedAt); err != nil {
+			return nil, fmt.Errorf("erro ao ler envio de XML agendado: %w", err)
+		}
+		if processedAt.Valid {
+			u.ProcessedAt = &processedAt.Time
+		}
+		uploads = append(uploads, u)
+	}
+
+	return uploads, rows.Err()
+}
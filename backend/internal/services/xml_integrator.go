@@ -1,18 +1,18 @@
 package services
 
 import (
-	"bytes"
+	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"amz-web-tools/backend/internal/config"
+	"amz-web-tools/backend/internal/httpclient"
 	"amz-web-tools/backend/internal/models"
+	"amz-web-tools/backend/internal/notify"
 	"amz-web-tools/backend/internal/websocket"
 
 	_ "github.com/lib/pq"          // PostgreSQL driver
@@ -20,11 +20,16 @@ import (
 )
 
 type XMLIntegratorService struct {
-	oracleDB *sql.DB
-	pgDB     *sql.DB
-	config   *config.Config
-	wsHub    *websocket.Hub
-	logs     map[string][]map[string]interface{} // Armazenar logs por process_id
+	sqlDB       *sql.DB // xml_deferred_uploads, xml_integrator_runs e xml_integrator_logs
+	oracleDB    *sql.DB
+	pgDB        *sql.DB
+	config      *config.Config
+	wsHub       *websocket.Hub
+	marketplace Marketplace // integrator.fato_statusvenda só contém pedidos do Mercado Livre
+	routing     *RoutingService
+	http        *httpclient.Client
+	notifier    *notify.Service
+	deferredMu  sync.Mutex // impede execuções simultâneas dos envios agendados
 }
 
 type PedidoInfo struct {
@@ -44,24 +49,10 @@ type OracleXMLData struct {
 	PedidoExterno string `json:"pedido_externo"`
 	XMLNota       string `json:"xml_nota"`
 	Status        string `json:"status"`
+	CodEmpresa    string `json:"cod_empresa"`
 }
 
-type MLResponse struct {
-	Status    string `json:"status"`
-	Substatus string `json:"substatus"`
-	LeadTime  struct {
-		Buffering struct {
-			Date string `json:"date"`
-		} `json:"buffering"`
-	} `json:"lead_time"`
-}
-
-type TelegramMessage struct {
-	ChatID string `json:"chat_id"`
-	Text   string `json:"text"`
-}
-
-func NewXMLIntegratorService(cfg *config.Config, wsHub *websocket.Hub) (*XMLIntegratorService, error) {
+func NewXMLIntegratorService(cfg *config.Config, sqlDB *sql.DB, wsHub *websocket.Hub, marketplace Marketplace, routing *RoutingService, client *httpclient.Client, notifier *notify.Service) (*XMLIntegratorService, error) {
 	log.Println("🔧 Iniciando XMLIntegratorService...")
 
 	var oracleDB *sql.DB
@@ -110,84 +101,40 @@ func NewXMLIntegratorService(cfg *config.Config, wsHub *websocket.Hub) (*XMLInte
 		log.Println("⚠️ Configuração PostgreSQL não disponível")
 	}
 
-	return &XMLIntegratorService{
-		oracleDB: oracleDB,
-		pgDB:     pgDB,
-		config:   cfg,
-		wsHub:    wsHub,
-		logs:     make(map[string][]map[string]interface{}),
-	}, nil
-}
-
-func (s *XMLIntegratorService) getMLToken(nomEmpresa string) (string, error) {
-	log.Printf("🔑 Obtendo token ML REAL para empresa: %s", nomEmpresa)
-
-	// Converter nome da empresa para formato da conta
-	empresaLower := strings.ToLower(nomEmpresa)
-	// Aplicar replace: principal -> amz
-	empresaLower = strings.Replace(empresaLower, "principal", "amz", -1)
-	tkConta := fmt.Sprintf("tk%s", empresaLower)
-	urlToken := fmt.Sprintf("https://imgs-amz.s3.us-east-1.amazonaws.com/tk/%s.html", tkConta)
-
-	log.Printf("🌐 URL Token: %s", urlToken)
-
-	resp, err := http.Get(urlToken)
-	if err != nil {
-		return "", fmt.Errorf("erro ao buscar token: %w", err)
+	s := &XMLIntegratorService{
+		sqlDB:       sqlDB,
+		oracleDB:    oracleDB,
+		pgDB:        pgDB,
+		config:      cfg,
+		wsHub:       wsHub,
+		marketplace: marketplace,
+		routing:     routing,
+		http:        client,
+		notifier:    notifier,
 	}
-	defer resp.Body.Close()
-
-	content, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("erro ao ler resposta: %w", err)
-	}
-
-	contentStr := string(content)
-	tokenIndex := strings.Index(contentStr, "y>") + 2
-	if tokenIndex < 2 {
-		return "", fmt.Errorf("formato de token inválido")
-	}
-
-	tokenMid := contentStr[tokenIndex:]
-	tokenIndexEnd := strings.Index(tokenMid, "</")
-	if tokenIndexEnd == -1 {
-		return "", fmt.Errorf("formato de token inválido")
-	}
-
-	tokenML := "APP_USR-" + tokenMid[:tokenIndexEnd]
-	log.Printf("✅ Token obtido: %s...", tokenML[:30])
+	s.recoverInterruptedRuns()
 
-	return tokenML, nil
+	return s, nil
 }
 
-func (s *XMLIntegratorService) sendTelegramMessage(message string) {
-	tokenTelegram := "7055914019:AAEJL0zVCZsaGv-RLKVf4VGcFY4-haShUx4"
-	chatID := "-4282027613"
-
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", tokenTelegram)
-
-	msg := TelegramMessage{
-		ChatID: chatID,
-		Text:   message,
-	}
-
-	jsonData, err := json.Marshal(msg)
-	if err != nil {
-		log.Printf("❌ Erro ao serializar mensagem Telegram: %v", err)
-		return
-	}
-
-	resp, err := http.Post(url, "application/json", strings.NewReader(string(jsonData)))
-	if err != nil {
-		log.Printf("❌ Erro ao enviar Telegram: %v", err)
-		return
-	}
-	defer resp.Body.Close()
-
-	log.Printf("📱 Mensagem Telegram enviada: %s", message)
+// notifyInvoiceError registra a falha de uma pré-nota no resumo diário de
+// notificações
+func (s *XMLIntegratorService) notifyInvoiceError(conta, numPedido, notaFiscal, erro string) {
+	s.notifier.Notify(notify.Event{
+		Kind:     notify.EventXMLInvoiceError,
+		Severity: notify.SeverityError,
+		Conta:    conta,
+		Digest:   true,
+		Data: map[string]interface{}{
+			"conta":       conta,
+			"num_pedido":  numPedido,
+			"nota_fiscal": notaFiscal,
+			"erro":        erro,
+		},
+	})
 }
 
-func (s *XMLIntegratorService) getPedidosFromPostgres(numPedido string) ([]PedidoInfo, error) {
+func (s *XMLIntegratorService) getPedidosFromPostgres(ctx context.Context, numPedido string) ([]PedidoInfo, error) {
 	log.Printf("🔍 Buscando pedido real no PostgreSQL: %s", numPedido)
 
 	query := `
@@ -203,7 +150,7 @@ func (s *XMLIntegratorService) getPedidosFromPostgres(numPedido string) ([]Pedid
 		WHERE num_pedido = $1
 	`
 
-	rows, err := s.pgDB.Query(query, numPedido)
+	rows, err := s.pgDB.QueryContext(ctx, query, numPedido)
 	if err != nil {
 		log.Printf("❌ Erro na consulta PostgreSQL: %v", err)
 		return nil, fmt.Errorf("erro ao consultar PostgreSQL: %v", err)
@@ -240,62 +187,68 @@ func (s *XMLIntegratorService) getPedidosFromPostgres(numPedido string) ([]Pedid
 	return pedidos, nil
 }
 
-// checkMercadoLivreStatus verifica o status do pedido no Mercado Livre
-func (s *XMLIntegratorService) checkMercadoLivreStatus(numEnvio, token string) (map[string]interface{}, error) {
-	if numEnvio == "" {
-		return nil, fmt.Errorf("num_envio não pode estar vazio")
+// getPendingInvoicesFromPostgres lista as pré-notas ainda sem XML enviado ao
+// Mercado Livre (flg_statuspedido 1 ou 2)
+func (s *XMLIntegratorService) getPendingInvoicesFromPostgres(ctx context.Context) ([]PedidoInfo, error) {
+	if s.pgDB == nil {
+		return nil, fmt.Errorf("PostgreSQL não disponível")
 	}
 
-	url := fmt.Sprintf("https://api.mercadolibre.com/shipments/%s", numEnvio)
-	headers := map[string]string{
-		"Authorization": fmt.Sprintf("Bearer %s", token),
-		"x-format-new":  "true",
-	}
+	query := `
+		SELECT
+			num_pedido,
+			num_prenota,
+			COALESCE(num_envio, '') as num_envio,
+			COALESCE(num_notafiscal, '') as num_notafiscal,
+			COALESCE(nom_tipoenvio, 'Mercado Envios') as nom_tipo_envio,
+			flg_statuspedido,
+			COALESCE(nom_empresa, '') as nom_empresa
+		FROM integrator.fato_statusvenda
+		WHERE flg_statuspedido IN (1, 2) AND num_prenota IS NOT NULL
+		ORDER BY num_pedido
+	`
 
-	resp, err := s.makeHTTPRequest("GET", url, headers, nil)
+	rows, err := s.pgDB.QueryContext(ctx, query)
 	if err != nil {
-		return nil, fmt.Errorf("erro ao consultar status ML: %v", err)
+		return nil, fmt.Errorf("erro ao consultar pedidos pendentes: %w", err)
 	}
+	defer rows.Close()
 
-	var result map[string]interface{}
-	if err := json.Unmarshal(resp, &result); err != nil {
-		return nil, fmt.Errorf("erro ao parsear resposta ML: %v", err)
+	pedidos := []PedidoInfo{}
+	for rows.Next() {
+		var p PedidoInfo
+		if err := rows.Scan(&p.NumPedido, &p.NumPrenota, &p.NumEnvio, &p.NumNotaFiscal,
+			&p.NomTipoEnvio, &p.FlgStatusPedido, &p.NomEmpresa); err != nil {
+			return nil, fmt.Errorf("erro ao ler pedido pendente: %w", err)
+		}
+		pedidos = append(pedidos, p)
 	}
 
-	return result, nil
+	return pedidos, rows.Err()
 }
 
-// makeHTTPRequest faz uma requisição HTTP
-func (s *XMLIntegratorService) makeHTTPRequest(method, url string, headers map[string]string, body []byte) ([]byte, error) {
-	client := &http.Client{Timeout: 30 * time.Second}
-
-	var req *http.Request
-	var err error
-
-	if body != nil {
-		req, err = http.NewRequest(method, url, bytes.NewBuffer(body))
-	} else {
-		req, err = http.NewRequest(method, url, nil)
-	}
-
-	if err != nil {
-		return nil, err
+// FindPedidoByEnvio busca o num_pedido associado a um envio do Mercado Livre
+func (s *XMLIntegratorService) FindPedidoByEnvio(ctx context.Context, numEnvio string) (string, error) {
+	if s.pgDB == nil {
+		return "", fmt.Errorf("PostgreSQL não disponível")
 	}
 
-	for key, value := range headers {
-		req.Header.Set(key, value)
-	}
+	var numPedido string
+	ctx, cancel := withDeadline(ctx, s.config.DBQueryTimeoutSeconds)
+	defer cancel()
 
-	resp, err := client.Do(req)
+	err := s.pgDB.QueryRowContext(ctx, `SELECT num_pedido FROM integrator.fato_statusvenda WHERE num_envio = $1 LIMIT 1`, numEnvio).Scan(&numPedido)
 	if err != nil {
-		return nil, err
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("nenhum pedido encontrado para o envio %s", numEnvio)
+		}
+		return "", fmt.Errorf("erro ao consultar PostgreSQL: %w", err)
 	}
-	defer resp.Body.Close()
 
-	return io.ReadAll(resp.Body)
+	return numPedido, nil
 }
 
-func (s *XMLIntegratorService) getXMLDataFromOracle(codOrcMapa string) (*OracleXMLData, error) {
+func (s *XMLIntegratorService) getXMLDataFromOracle(ctx context.Context, codOrcMapa string) (*OracleXMLData, error) {
 	if s.oracleDB == nil {
 		log.Printf("❌ Oracle não disponível para cod_orc_mapa: %s", codOrcMapa)
 		return nil, fmt.Errorf("Oracle não disponível")
@@ -310,7 +263,8 @@ func (s *XMLIntegratorService) getXMLDataFromOracle(codOrcMapa string) (*OracleX
 			o.COD_ORC_MAPA,
 			o.PEDIDO_EXTERNO,
 			n.XML_NOTA,
-			o.STATUS
+			o.STATUS,
+			v.COD_EMPRESA
 		FROM nbs.orc_mapa o
 		LEFT JOIN nbs.vendas v 
 		ON v.cod_orc_mapa = o.cod_orc_mapa
@@ -322,13 +276,15 @@ func (s *XMLIntegratorService) getXMLDataFromOracle(codOrcMapa string) (*OracleX
 	`
 
 	var xmlData OracleXMLData
-	err := s.oracleDB.QueryRow(query, codOrcMapa).Scan(
+	var codEmpresa sql.NullString
+	err := s.oracleDB.QueryRowContext(ctx, query, codOrcMapa).Scan(
 		&xmlData.Controle,
 		&xmlData.Emissao,
 		&xmlData.CodOrcMapa,
 		&xmlData.PedidoExterno,
 		&xmlData.XMLNota,
 		&xmlData.Status,
+		&codEmpresa,
 	)
 
 	if err != nil {
@@ -339,11 +295,68 @@ func (s *XMLIntegratorService) getXMLDataFromOracle(codOrcMapa string) (*OracleX
 		return nil, fmt.Errorf("erro ao buscar XML: %w", err)
 	}
 
+	xmlData.CodEmpresa = codEmpresa.String
 	log.Printf("✅ XML encontrado - Controle: %s, Status: %s", xmlData.Controle, xmlData.Status)
 	return &xmlData, nil
 }
 
-func (s *XMLIntegratorService) updatePostgresStatus(numPrenota, numNotaFiscal, xmlData, status string) error {
+// validateInvoice lê a NF-e da pré-nota e a confere com a empresa (CNPJ das
+// regras de roteamento) e com o pedido do Mercado Livre. Retorna o resumo da
+// nota e um erro que embrulha ErrInvalidNFe se o envio deve ser bloqueado.
+func (s *XMLIntegratorService) validateInvoice(ctx context.Context, conta string, pedido PedidoInfo, xmlData *OracleXMLData) (*NFeSummary, error) {
+	summary, err := ParseNFe(xmlData.XMLNota)
+	if err != nil {
+		return summary, err
+	}
+
+	order, err := s.marketplace.FetchOrder(ctx, conta, pedido.NumPedido)
+	if err != nil {
+		return summary, fmt.Errorf("erro ao buscar pedido ML para validar a nota: %w", err)
+	}
+
+	prenotas, err := s.countPrenotas(ctx, pedido.NumPedido)
+	if err != nil {
+		return summary, err
+	}
+
+	exp := NFeExpectations{
+		OrderTotal: order.Total(),
+		Partial:    prenotas > 1,
+	}
+	for _, item := range order.Items {
+		exp.OrderQuantity += item.Quantity
+	}
+
+	if cnpj, ok := s.routing.CNPJForEmpresa(xmlData.CodEmpresa); ok {
+		exp.CNPJ = cnpj
+	} else {
+		summary.Warnings = append(summary.Warnings,
+			fmt.Sprintf("CNPJ da empresa %q não cadastrado nas regras de roteamento: emitente não conferido", xmlData.CodEmpresa))
+	}
+
+	if err := summary.Validate(exp); err != nil {
+		return summary, err
+	}
+
+	log.Printf("✅ NF-e %s validada - Chave: %s, Valor: %.2f", summary.Numero, summary.Chave, summary.ValorTotal)
+	return summary, nil
+}
+
+// countPrenotas conta as pré-notas geradas para o pedido
+func (s *XMLIntegratorService) countPrenotas(ctx context.Context, numPedido string) (int, error) {
+	if s.pgDB == nil {
+		return 0, fmt.Errorf("PostgreSQL não disponível")
+	}
+
+	var count int
+	query := `SELECT COUNT(*) FROM integrator.fato_statusvenda WHERE num_pedido = $1`
+	if err := s.pgDB.QueryRowContext(ctx, query, numPedido).Scan(&count); err != nil {
+		return 0, fmt.Errorf("erro ao consultar PostgreSQL: %w", err)
+	}
+	return count, nil
+}
+
+func (s *XMLIntegratorService) updatePostgresStatus(ctx context.Context, numPrenota, numNotaFiscal, xmlData, status string) error {
 	if s.pgDB == nil {
 		log.Printf("❌ PostgreSQL não disponível para atualização da prenota: %s", numPrenota)
 		return fmt.Errorf("PostgreSQL não disponível")
@@ -361,7 +374,7 @@ func (s *XMLIntegratorService) updatePostgresStatus(numPrenota, numNotaFiscal, x
 		WHERE num_prenota = $4
 	`
 
-	_, err := s.pgDB.Exec(query, xmlData, numNotaFiscal, status, numPrenota)
+	_, err := s.pgDB.ExecContext(ctx, query, xmlData, numNotaFiscal, status, numPrenota)
 	if err != nil {
 		return fmt.Errorf("erro ao atualizar status: %w", err)
 	}
@@ -370,92 +383,49 @@ func (s *XMLIntegratorService) updatePostgresStatus(numPrenota, numNotaFiscal, x
 	return nil
 }
 
-func (s *XMLIntegratorService) getMLShipmentStatus(numEnvio, token string) (*MLResponse, error) {
-	log.Printf("🚚 Verificando status REAL do envio ML: %s", numEnvio)
-
-	url := fmt.Sprintf("https://api.mercadolibre.com/shipments/%s", numEnvio)
-	headers := map[string]string{
-		"Authorization": fmt.Sprintf("Bearer %s", token),
-		"x-format-new":  "true",
-	}
+// ProcessXMLIntegration envia os XMLs das pré-notas do pedido e grava a
+// execução e os logs em xml_integrator_runs/xml_integrator_logs. O ID da
+// execução é retornado em data.process_id.
+func (s *XMLIntegratorService) ProcessXMLIntegration(ctx context.Context, scope Scope, numPedido, userID string) (*models.APIResponse, error) {
+	ctx, cancel := withDeadline(ctx, s.config.XMLIntegrationTimeoutSeconds)
+	defer cancel()
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("erro ao criar request: %w", err)
-	}
-
-	for key, value := range headers {
-		req.Header.Set(key, value)
-	}
-
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("erro ao fazer request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("status code: %d", resp.StatusCode)
-	}
+	runID := s.startRun(ctx, numPedido, userID)
+	result, err := s.processXMLIntegration(ctx, scope, runID, numPedido)
+	s.finishRun(ctx, runID, numPedido, result, err)
 
-	var mlResponse MLResponse
-	if err := json.NewDecoder(resp.Body).Decode(&mlResponse); err != nil {
-		return nil, fmt.Errorf("erro ao decodificar resposta: %w", err)
+	if data, ok := resultData(result); ok && runID != "" {
+		data["process_id"] = runID
 	}
-
-	log.Printf("📊 Status ML - Status: %s, Substatus: %s", mlResponse.Status, mlResponse.Substatus)
-	return &mlResponse, nil
+	return result, err
 }
 
-func (s *XMLIntegratorService) sendXMLToML(numEnvio, xmlData, token string) error {
-	log.Printf("📤 Enviando XML REAL para ML - Envio: %s", numEnvio)
-
-	url := fmt.Sprintf("https://api.mercadolibre.com/shipments/%s/invoice_data?siteId=MLB", numEnvio)
-	headers := map[string]string{
-		"Authorization": fmt.Sprintf("Bearer %s", token),
-		"Content-Type":  "application/xml",
-		"Accept":        "application/xml",
-	}
-
-	req, err := http.NewRequest("POST", url, strings.NewReader(xmlData))
-	if err != nil {
-		return fmt.Errorf("erro ao criar request: %w", err)
-	}
-
-	for key, value := range headers {
-		req.Header.Set(key, value)
-	}
+func (s *XMLIntegratorService) processXMLIntegration(ctx context.Context, scope Scope, runID, numPedido string) (*models.APIResponse, error) {
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("erro ao fazer request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	responseBody, _ := io.ReadAll(resp.Body)
-	log.Printf("📤 Resposta ML - Status: %d, Body: %s", resp.StatusCode, string(responseBody))
-
-	if resp.StatusCode == 406 {
-		log.Println("✅ XML enviado com sucesso!")
-		return nil
-	}
-
-	return fmt.Errorf("erro ao enviar XML - Status: %d, Body: %s", resp.StatusCode, string(responseBody))
-}
-
-func (s *XMLIntegratorService) ProcessXMLIntegration(numPedido string) (*models.APIResponse, error) {
 	log.Printf("🚀 Iniciando processamento XML para pedido: %s", numPedido)
 
 	// Buscar pedidos primeiro para obter a empresa
-	pedidos, err := s.getPedidosFromPostgres(numPedido)
+	pedidos, err := s.getPedidosFromPostgres(ctx, numPedido)
 	if err != nil {
 		errorMsg := fmt.Sprintf("❌ Erro ao buscar pedidos: %v", err)
-		s.sendTelegramMessage(errorMsg)
+		s.notifier.Notify(notify.Event{
+			Kind:     notify.EventXMLFailed,
+			Severity: notify.SeverityError,
+			Data:     map[string]interface{}{"num_pedido": numPedido, "erro": err.Error()},
+		})
 		return nil, fmt.Errorf(errorMsg)
 	}
 
+	// A conta do marketplace é a empresa do primeiro pedido; sem pedidos a
+	// conta é desconhecida e só escopos sem restrição prosseguem
+	conta := ""
+	if len(pedidos) > 0 {
+		conta = strings.ToLower(pedidos[0].NomEmpresa)
+	}
+	if err := scope.check(conta); err != nil {
+		return nil, err
+	}
+
 	if len(pedidos) == 0 {
 		msg := fmt.Sprintf("⚠️ Nenhum pedido encontrado para: %s", numPedido)
 		log.Println(msg)
@@ -488,9 +458,6 @@ func (s *XMLIntegratorService) ProcessXMLIntegration(numPedido string) (*models.
 			},
 		}
 
-		// Armazenar logs
-		s.logs[numPedido] = processLogs
-
 		// Enviar logs via WebSocket
 		if s.wsHub != nil {
 			for _, logEntry := range processLogs {
@@ -500,7 +467,9 @@ func (s *XMLIntegratorService) ProcessXMLIntegration(numPedido string) (*models.
 					Level:     logEntry["level"].(string),
 					Step:      logEntry["step"].(string),
 					Message:   logEntry["message"].(string),
-					ProcessID: numPedido,
+					ProcessID: runID,
+					NumPedido: numPedido,
+					Conta:     conta,
 				})
 			}
 		}
@@ -518,15 +487,6 @@ func (s *XMLIntegratorService) ProcessXMLIntegration(numPedido string) (*models.
 		}, nil
 	}
 
-	// Obter token ML usando a empresa do primeiro pedido
-	nomEmpresa := pedidos[0].NomEmpresa
-	token, err := s.getMLToken(nomEmpresa)
-	if err != nil {
-		errorMsg := fmt.Sprintf("❌ Erro ao obter token ML para empresa %s: %v", nomEmpresa, err)
-		s.sendTelegramMessage(errorMsg)
-		return nil, fmt.Errorf(errorMsg)
-	}
-
 	var results []map[string]interface{}
 	var logs []map[string]interface{}
 	successCount := 0
@@ -541,6 +501,11 @@ func (s *XMLIntegratorService) ProcessXMLIntegration(numPedido string) (*models.
 	})
 
 	for _, pedido := range pedidos {
+		// Requisição encerrada, deadline estourado ou servidor desligando
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("processamento XML do pedido %s interrompido: %w", numPedido, err)
+		}
+
 		log.Printf("📦 Processando pedido: %s", pedido.NumPedido)
 
 		// Log início do processamento do pedido
@@ -560,11 +525,11 @@ func (s *XMLIntegratorService) ProcessXMLIntegration(numPedido string) (*models.
 		})
 
 		// Buscar dados XML
-		xmlData, err := s.getXMLDataFromOracle(pedido.NumPrenota)
+		xmlData, err := s.getXMLDataFromOracle(ctx, pedido.NumPrenota)
 		if err != nil {
 			errorMsg := fmt.Sprintf("❌ Erro ao buscar XML para pedido %s: %v", pedido.NumPedido, err)
 			log.Println(errorMsg)
-			s.sendTelegramMessage(errorMsg)
+			s.notifyInvoiceError(conta, pedido.NumPedido, "", fmt.Sprintf("Erro ao buscar XML: %v", err))
 			errorCount++
 
 			logs = append(logs, map[string]interface{}{
@@ -586,7 +551,7 @@ func (s *XMLIntegratorService) ProcessXMLIntegration(numPedido string) (*models.
 		if xmlData == nil {
 			msg := fmt.Sprintf("⚠️ Pedido %s ainda não possui XML", pedido.NumPedido)
 			log.Println(msg)
-			s.sendTelegramMessage(msg)
+			s.notifyInvoiceError(conta, pedido.NumPedido, "", "Pedido ainda não possui XML")
 			errorCount++
 
 			logs = append(logs, map[string]interface{}{
@@ -609,7 +574,7 @@ func (s *XMLIntegratorService) ProcessXMLIntegration(numPedido string) (*models.
 		if xmlData.XMLNota == "" {
 			msg := fmt.Sprintf("⚠️ Pedido %s possui XML vazio", pedido.NumPedido)
 			log.Println(msg)
-			s.sendTelegramMessage(msg)
+			s.notifyInvoiceError(conta, pedido.NumPedido, "", "Pedido possui XML vazio")
 			errorCount++
 
 			logs = append(logs, map[string]interface{}{
@@ -644,7 +609,7 @@ func (s *XMLIntegratorService) ProcessXMLIntegration(numPedido string) (*models.
 		})
 
 		// Atualizar status no PostgreSQL
-		err = s.updatePostgresStatus(pedido.NumPrenota, xmlData.Controle, xmlData.XMLNota, "2")
+		err = s.updatePostgresStatus(ctx, pedido.NumPrenota, xmlData.Controle, xmlData.XMLNota, "2")
 		if err != nil {
 			log.Printf("❌ Erro ao atualizar status PostgreSQL: %v", err)
 
@@ -673,7 +638,7 @@ func (s *XMLIntegratorService) ProcessXMLIntegration(numPedido string) (*models.
 		})
 
 		// Verificar status do envio ML
-		mlStatus, err := s.getMLShipmentStatus(pedido.NumEnvio, token)
+		mlStatus, err := s.marketplace.FetchShipment(ctx, conta, pedido.NumEnvio)
 		if err != nil {
 			log.Printf("❌ Erro ao verificar status ML: %v", err)
 
@@ -693,57 +658,112 @@ func (s *XMLIntegratorService) ProcessXMLIntegration(numPedido string) (*models.
 			"message":   fmt.Sprintf("Status ML obtido - Status: %s, Substatus: %s", mlStatus.Status, mlStatus.Substatus),
 		})
 
+		// Resumo da NF-e validada antes do envio ao ML
+		var nfeSummary *NFeSummary
+		var nfeError string
+
 		// Processar baseado no status
 		if mlStatus.Status == "ready_to_ship" && mlStatus.Substatus == "invoice_pending" {
-			log.Printf("📤 Enviando XML para ML - Pedido: %s", pedido.NumPedido)
-
 			logs = append(logs, map[string]interface{}{
 				"timestamp": time.Now().Format(time.RFC3339),
 				"level":     "info",
-				"step":      "Envio XML",
-				"message":   fmt.Sprintf("Enviando XML para ML - Pedido: %s", pedido.NumPedido),
+				"step":      "Validação NF-e",
+				"message":   "Validando chave de acesso, emitente, totais e autorização da NF-e",
 			})
 
-			err = s.sendXMLToML(pedido.NumEnvio, xmlData.XMLNota, token)
+			nfeSummary, err = s.validateInvoice(ctx, conta, pedido, xmlData)
 			if err != nil {
-				log.Printf("❌ Falha ao enviar XML do Pedido %s: %v", pedido.NumPedido, err)
+				errorMsg := fmt.Sprintf("❌ %s (MeLi) - Pedido %s, NF: %s - Envio do XML bloqueado: %v",
+					strings.ToUpper(conta), pedido.NumPedido, xmlData.Controle, err)
+				log.Println(errorMsg)
+				s.notifyInvoiceError(conta, pedido.NumPedido, xmlData.Controle, fmt.Sprintf("Envio do XML bloqueado: %v", err))
 				errorCount++
+				nfeError = err.Error()
 
 				logs = append(logs, map[string]interface{}{
 					"timestamp": time.Now().Format(time.RFC3339),
 					"level":     "error",
-					"step":      "Envio XML",
-					"message":   fmt.Sprintf("Falha ao enviar XML: %v", err),
+					"step":      "Validação NF-e",
+					"message":   fmt.Sprintf("Envio bloqueado: %v", err),
 				})
 			} else {
-				log.Printf("✅ %s (MeLi) - Pedido %s, NF: %s - XML enviado com sucesso!",
-					strings.ToUpper(nomEmpresa), pedido.NumPedido, xmlData.Controle)
-
 				logs = append(logs, map[string]interface{}{
 					"timestamp": time.Now().Format(time.RFC3339),
 					"level":     "success",
+					"step":      "Validação NF-e",
+					"message":   fmt.Sprintf("NF-e %s validada - Chave: %s", nfeSummary.Numero, nfeSummary.Chave),
+				})
+
+				log.Printf("📤 Enviando XML para ML - Pedido: %s", pedido.NumPedido)
+
+				logs = append(logs, map[string]interface{}{
+					"timestamp": time.Now().Format(time.RFC3339),
+					"level":     "info",
 					"step":      "Envio XML",
-					"message":   "XML enviado com sucesso para o Mercado Livre",
+					"message":   fmt.Sprintf("Enviando XML para ML - Pedido: %s", pedido.NumPedido),
 				})
 
-				// Atualizar status para 3 (concluído)
-				s.updatePostgresStatus(pedido.NumPrenota, xmlData.Controle, xmlData.XMLNota, "3")
-				successCount++
+				err = s.marketplace.UploadInvoice(ctx, conta, pedido.NumEnvio, xmlData.XMLNota)
+				if err != nil {
+					log.Printf("❌ Falha ao enviar XML do Pedido %s: %v", pedido.NumPedido, err)
+					errorCount++
+
+					logs = append(logs, map[string]interface{}{
+						"timestamp": time.Now().Format(time.RFC3339),
+						"level":     "error",
+						"step":      "Envio XML",
+						"message":   fmt.Sprintf("Falha ao enviar XML: %v", err),
+					})
+				} else {
+					log.Printf("✅ %s (MeLi) - Pedido %s, NF: %s - XML enviado com sucesso!",
+						strings.ToUpper(conta), pedido.NumPedido, xmlData.Controle)
+
+					logs = append(logs, map[string]interface{}{
+						"timestamp": time.Now().Format(time.RFC3339),
+						"level":     "success",
+						"step":      "Envio XML",
+						"message":   "XML enviado com sucesso para o Mercado Livre",
+					})
+
+					// Atualizar status para 3 (concluído); o XML já foi aceito pelo ML,
+					// então a gravação não é cancelada junto com a requisição
+					s.updatePostgresStatus(context.WithoutCancel(ctx), pedido.NumPrenota, xmlData.Controle, xmlData.XMLNota, "3")
+					successCount++
+				}
 			}
 		} else if mlStatus.Status == "pending" && mlStatus.Substatus == "buffered" {
 			log.Printf("⏰ %s (MeLi) - Pedido %s, NF: %s - Entrega Agendada para: %s | Status: %s | Substatus: %s",
-				strings.ToUpper(nomEmpresa), pedido.NumPedido, xmlData.Controle,
-				mlStatus.LeadTime.Buffering.Date, mlStatus.Status, mlStatus.Substatus)
+				strings.ToUpper(conta), pedido.NumPedido, xmlData.Controle,
+				mlStatus.BufferingDate, mlStatus.Status, mlStatus.Substatus)
+
+			// O XML é enviado pelo worker de envios agendados quando a data chegar
+			if err := s.deferUpload(ctx, pedido, conta, mlStatus.BufferingDate); err != nil {
+				log.Printf("⚠️ Erro ao agendar envio do XML do pedido %s: %v", pedido.NumPedido, err)
+
+				logs = append(logs, map[string]interface{}{
+					"timestamp": time.Now().Format(time.RFC3339),
+					"level":     "error",
+					"step":      "Agendamento",
+					"message":   fmt.Sprintf("Erro ao agendar envio do XML: %v", err),
+				})
+			} else {
+				logs = append(logs, map[string]interface{}{
+					"timestamp": time.Now().Format(time.RFC3339),
+					"level":     "info",
+					"step":      "Agendamento",
+					"message":   fmt.Sprintf("Envio do XML agendado para %s", mlStatus.BufferingDate),
+				})
+			}
 		} else {
 			// Envio Flex ou outros casos
 			if pedido.NumNotaFiscal != "" {
 				log.Printf("🚚 %s (MeLi) - Pedido %s, NF: %s - Envio Flex!",
-					strings.ToUpper(nomEmpresa), pedido.NumPedido, xmlData.Controle)
-				s.updatePostgresStatus(pedido.NumPrenota, xmlData.Controle, xmlData.XMLNota, "3")
+					strings.ToUpper(conta), pedido.NumPedido, xmlData.Controle)
+				s.updatePostgresStatus(ctx, pedido.NumPrenota, xmlData.Controle, xmlData.XMLNota, "3")
 				successCount++
 			} else {
 				log.Printf("⏳ %s (MeLi) - Pedido %s - Aguardando Nota Fiscal - Envio Flex!",
-					strings.ToUpper(nomEmpresa), pedido.NumPedido)
+					strings.ToUpper(conta), pedido.NumPedido)
 			}
 		}
 
@@ -754,6 +774,8 @@ func (s *XMLIntegratorService) ProcessXMLIntegration(numPedido string) (*models.
 			"nota_fiscal": xmlData.Controle,
 			"status":      mlStatus.Status,
 			"substatus":   mlStatus.Substatus,
+			"nfe":         nfeSummary,
+			"nfe_error":   nfeError,
 		})
 	}
 
@@ -768,7 +790,9 @@ func (s *XMLIntegratorService) ProcessXMLIntegration(numPedido string) (*models.
 		pedido := result["pedido"].(string)
 		notaFiscal := result["nota_fiscal"].(string)
 
-		if status == "ready_to_ship" {
+		if nfeError := result["nfe_error"].(string); nfeError != "" {
+			details = append(details, fmt.Sprintf("🚫 Pedido %s (NF: %s) - Envio bloqueado: %s", pedido, notaFiscal, nfeError))
+		} else if status == "ready_to_ship" {
 			details = append(details, fmt.Sprintf("✅ Pedido %s (NF: %s) - XML enviado", pedido, notaFiscal))
 		} else if status == "pending" && substatus == "buffered" {
 			details = append(details, fmt.Sprintf("⏰ Pedido %s (NF: %s) - Entrega agendada", pedido, notaFiscal))
@@ -779,12 +803,23 @@ func (s *XMLIntegratorService) ProcessXMLIntegration(numPedido string) (*models.
 		}
 	}
 
-	// Mensagem unificada
-	finalMsg := fmt.Sprintf("📊 %s (MeLi) - Processamento XML Concluído\n\n📈 Resumo:\n• Total processado: %d\n• Sucessos: %d\n• Erros: %d\n\n📋 Detalhes:\n%s",
-		strings.ToUpper(nomEmpresa), totalProcessed, successCount, errorCount, strings.Join(details, "\n"))
-
-	log.Println("📊 Enviando resumo unificado para Telegram")
-	s.sendTelegramMessage(finalMsg)
+	// Resumo unificado
+	severity := notify.SeverityInfo
+	if errorCount > 0 {
+		severity = notify.SeverityWarning
+	}
+	s.notifier.Notify(notify.Event{
+		Kind:     notify.EventXMLProcessed,
+		Severity: severity,
+		Conta:    conta,
+		Data: map[string]interface{}{
+			"conta":         conta,
+			"total":         totalProcessed,
+			"success_count": successCount,
+			"error_count":   errorCount,
+			"details":       details,
+		},
+	})
 
 	log.Printf("🔍 Debug - results length: %d, results: %v", len(results), results)
 	log.Printf("🔍 Debug - logs length: %d, logs: %v", len(logs), logs)
@@ -801,6 +836,7 @@ func (s *XMLIntegratorService) ProcessXMLIntegration(numPedido string) (*models.
 		Success: true,
 		Message: "Processamento XML concluído",
 		Data: map[string]interface{}{
+			"conta":           conta,
 			"total_processed": totalProcessed,
 			"success_count":   successCount,
 			"error_count":     errorCount,
@@ -810,14 +846,6 @@ func (s *XMLIntegratorService) ProcessXMLIntegration(numPedido string) (*models.
 	}, nil
 }
 
-// GetLogs returns logs for a specific process
-func (s *XMLIntegratorService) GetLogs(processID string) ([]map[string]interface{}, error) {
-	if logs, exists := s.logs[processID]; exists {
-		return logs, nil
-	}
-	return []map[string]interface{}{}, nil
-}
-
 // GetPostgresDB returns the PostgreSQL database connection
 func (s *XMLIntegratorService) GetPostgresDB() *sql.DB {
 	return s.pgDB
@@ -0,0 +1,431 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"amz-web-tools/backend/internal/models"
+)
+
+// Status de uma execução em xml_integrator_runs
+const (
+	XMLRunRunning   = "running"
+	XMLRunCompleted = "completed"
+	XMLRunFailed    = "failed"
+)
+
+// sqlLocalTime é o formato usado para comparar datas com colunas DATETIME2
+// gravadas com GETDATE(), sem conversão de fuso
+const sqlLocalTime = "2006-01-02T15:04:05"
+
+// ErrXMLRunNotFound indica um process_id sem execução gravada
+var ErrXMLRunNotFound = errors.New("execução do integrador XML não encontrada")
+
+// XMLIntegratorRun é uma execução do processamento XML de um pedido
+type XMLIntegratorRun struct {
+	ID           string             `json:"id"`
+	NumPedido    string             `json:"num_pedido"`
+	Conta        string             `json:"conta,omitempty"`
+	UserID       string             `json:"user_id,omitempty"`
+	Status       string             `json:"status"`
+	Total        int                `json:"total"`
+	SuccessCount int                `json:"success_count"`
+	ErrorCount   int                `json:"error_count"`
+	ErrorMessage string             `json:"error_message,omitempty"`
+	StartedAt    time.Time          `json:"started_at"`
+	FinishedAt   *time.Time         `json:"finished_at,omitempty"`
+	Logs         []XMLIntegratorLog `json:"logs,omitempty"`
+}
+
+// XMLIntegratorLog é uma entrada de log de uma execução
+type XMLIntegratorLog struct {
+	ID        int64     `json:"id"`
+	RunID     string    `json:"process_id"`
+	NumPedido string    `json:"num_pedido"`
+	Conta     string    `json:"conta,omitempty"`
+	Level     string    `json:"level"`
+	Step      string    `json:"step"`
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// XMLIntegratorLogFilter filtra o histórico de execuções e logs; campos vazios
+// não filtram
+type XMLIntegratorLogFilter struct {
+	ProcessID string
+	NumPedido string
+	Conta     string
+	Scope     Scope
+	Level     string // apenas para logs
+	Status    string // apenas para execuções
+	From      *time.Time
+	To        *time.Time // exclusivo
+	Page      int
+	PageSize  int
+}
+
+// startRun grava o início de uma execução e retorna o ID. Sem o banco do
+// portal, o processamento continua sem histórico.
+func (s *XMLIntegratorService) startRun(ctx context.Context, numPedido, userID string) string {
+	if s.sqlDB == nil {
+		return ""
+	}
+
+	query := `
+		INSERT INTO xml_integrator_runs (num_pedido, user_id, status)
+		OUTPUT CAST(INSERTED.id AS NVARCHAR(36))
+		VALUES (@p1, TRY_CONVERT(UNIQUEIDENTIFIER, @p2), @p3)`
+
+	var runID string
+	err := s.sqlDB.QueryRowContext(ctx, query, numPedido, sql.NullString{String: userID, Valid: userID != ""},
+		XMLRunRunning).Scan(&runID)
+	if err != nil {
+		log.Printf("⚠️ Erro ao registrar execução XML do pedido %s: %v", numPedido, err)
+		return ""
+	}
+	return runID
+}
+
+// finishRun grava o resultado e os logs da execução. A gravação não é
+// cancelada junto com a requisição.
+func (s *XMLIntegratorService) finishRun(ctx context.Context, runID, numPedido string, result *models.APIResponse, runErr error) {
+	if runID == "" {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+
+	run := XMLIntegratorRun{ID: runID, NumPedido: numPedido, Status: XMLRunCompleted}
+	var entries []map[string]interface{}
+
+	if data, ok := resultData(result); ok {
+		run.Conta, _ = data["conta"].(string)
+		run.Total, _ = data["total_processed"].(int)
+		run.SuccessCount, _ = data["success_count"].(int)
+		run.ErrorCount, _ = data["error_count"].(int)
+		entries, _ = data["logs"].([]map[string]interface{})
+	}
+	if runErr != nil {
+		run.Status = XMLRunFailed
+		run.ErrorMessage = runErr.Error()
+		entries = append(entries, map[string]interface{}{
+			"timestamp": time.Now().Format(time.RFC3339),
+			"level":     "error",
+			"step":      "Conclusão",
+			"message":   runErr.Error(),
+		})
+	}
+
+	tx, err := s.sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("⚠️ Erro ao gravar execução XML %s: %v", runID, err)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE xml_integrator_runs
+		SET conta = @p1, status = @p2, total = @p3, success_count = @p4, error_count = @p5,
+		    error_message = @p6, finished_at = GETDATE()
+		WHERE id = @p7`,
+		sql.NullString{String: run.Conta, Valid: run.Conta != ""}, run.Status, run.Total, run.SuccessCount, run.ErrorCount,
+		sql.NullString{String: run.ErrorMessage, Valid: run.ErrorMessage != ""}, runID)
+	if err != nil {
+		log.Printf("⚠️ Erro ao gravar execução XML %s: %v", runID, err)
+		return
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO xml_integrator_logs (run_id, num_pedido, conta, level, step, message, created_at)
+		VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7)`)
+	if err != nil {
+		log.Printf("⚠️ Erro ao gravar logs da execução XML %s: %v", runID, err)
+		return
+	}
+	defer stmt.Close()
+
+	for _, entry := range entries {
+		level, _ := entry["level"].(string)
+		step, _ := entry["step"].(string)
+		message, _ := entry["message"].(string)
+
+		timestamp := time.Now()
+		if value, ok := entry["timestamp"].(string); ok {
+			if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+				timestamp = parsed
+			}
+		}
+
+		_, err := stmt.ExecContext(ctx, runID, numPedido, sql.NullString{String: run.Conta, Valid: run.Conta != ""},
+			level, step, message, timestamp.Local().Format(sqlLocalTime))
+		if err != nil {
+			log.Printf("⚠️ Erro ao gravar logs da execução XML %s: %v", runID, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("⚠️ Erro ao gravar execução XML %s: %v", runID, err)
+	}
+}
+
+// GetRun retorna uma execução com todos os seus logs; execuções de contas fora
+// do escopo não são encontradas
+func (s *XMLIntegratorService) GetRun(ctx context.Context, scope Scope, processID string) (*XMLIntegratorRun, error) {
+	if s.sqlDB == nil {
+		return nil, fmt.Errorf("banco do portal não disponível")
+	}
+
+	ctx, cancel := withDeadline(ctx, s.config.DBQueryTimeoutSeconds)
+	defer cancel()
+
+	runs, _, err := s.ListRuns(ctx, XMLIntegratorLogFilter{ProcessID: processID, Scope: scope, Page: 1, PageSize: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, ErrXMLRunNotFound
+	}
+	run := runs[0]
+
+	run.Logs, _, err = s.ListLogs(ctx, XMLIntegratorLogFilter{ProcessID: run.ID})
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// ListRuns lista as execuções mais recentes que atendem ao filtro e o total
+func (s *XMLIntegratorService) ListRuns(ctx context.Context, filter XMLIntegratorLogFilter) ([]XMLIntegratorRun, int, error) {
+	if s.sqlDB == nil {
+		return nil, 0, fmt.Errorf("banco do portal não disponível")
+	}
+
+	ctx, cancel := withDeadline(ctx, s.config.DBQueryTimeoutSeconds)
+	defer cancel()
+
+	where, args := xmlLogConditions(filter, "id", "started_at")
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		where = append(where, fmt.Sprintf("status = @p%d", len(args)))
+	}
+	whereClause := sqlWhere(where)
+
+	var total int
+	if err := s.sqlDB.QueryRowContext(ctx, `SELECT COUNT(*) FROM xml_integrator_runs `+whereClause, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("erro ao contar execuções do integrador XML: %w", err)
+	}
+
+	query := `
+		SELECT CAST(id AS NVARCHAR(36)), num_pedido, COALESCE(conta, ''), COALESCE(CAST(user_id AS NVARCHAR(36)), ''),
+		       status, COALESCE(total, 0), COALESCE(success_count, 0), COALESCE(error_count, 0),
+		       COALESCE(error_message, ''), started_at, finished_at
+		FROM xml_integrator_runs ` + whereClause + `
+		ORDER BY started_at DESC` + sqlPage(filter, &args)
+
+	rows, err := s.sqlDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("erro ao buscar execuções do integrador XML: %w", err)
+	}
+	defer rows.Close()
+
+	runs := []XMLIntegratorRun{}
+	for rows.Next() {
+		var run XMLIntegratorRun
+		var finishedAt sql.NullTime
+		if err := rows.Scan(&run.ID, &run.NumPedido, &run.Conta, &run.UserID, &run.Status, &run.Total,
+			&run.SuccessCount, &run.ErrorCount, &run.ErrorMessage, &run.StartedAt, &finishedAt); err != nil {
+			return nil, 0, fmt.Errorf("erro ao ler execução do integrador XML: %w", err)
+		}
+		if finishedAt.Valid {
+			run.FinishedAt = &finishedAt.Time
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, total, rows.Err()
+}
+
+// ListLogs lista as entradas de log que atendem ao filtro, em ordem
+// cronológica, e o total. Sem paginação retorna todas as entradas.
+func (s *XMLIntegratorService) ListLogs(ctx context.Context, filter XMLIntegratorLogFilter) ([]XMLIntegratorLog, int, error) {
+	if s.sqlDB == nil {
+		return nil, 0, fmt.Errorf("banco do portal não disponível")
+	}
+
+	ctx, cancel := withDeadline(ctx, s.config.DBQueryTimeoutSeconds)
+	defer cancel()
+
+	where, args := xmlLogConditions(filter, "run_id", "created_at")
+	if filter.Level != "" {
+		args = append(args, filter.Level)
+		where = append(where, fmt.Sprintf("level = @p%d", len(args)))
+	}
+	whereClause := sqlWhere(where)
+
+	var total int
+	if err := s.sqlDB.QueryRowContext(ctx, `SELECT COUNT(*) FROM xml_integrator_logs `+whereClause, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("erro ao contar logs do integrador XML: %w", err)
+	}
+
+	// O histórico paginado começa pelos mais recentes; os logs completos de uma
+	// execução ficam na ordem em que foram gravados
+	order := "created_at DESC, id DESC"
+	if filter.PageSize <= 0 {
+		order = "id"
+	}
+
+	query := `
+		SELECT id, CAST(run_id AS NVARCHAR(36)), num_pedido, COALESCE(conta, ''), level, step, message, created_at
+		FROM xml_integrator_logs ` + whereClause + `
+		ORDER BY ` + order + sqlPage(filter, &args)
+
+	rows, err := s.sqlDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("erro ao buscar logs do integrador XML: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []XMLIntegratorLog{}
+	for rows.Next() {
+		var entry XMLIntegratorLog
+		if err := rows.Scan(&entry.ID, &entry.RunID, &entry.NumPedido, &entry.Conta, &entry.Level,
+			&entry.Step, &entry.Message, &entry.Timestamp); err != nil {
+			return nil, 0, fmt.Errorf("erro ao ler log do integrador XML: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, total, rows.Err()
+}
+
+// PurgeRuns remove as execuções e os logs mais antigos que retentionDays
+func (s *XMLIntegratorService) PurgeRuns(ctx context.Context, retentionDays int) error {
+	if s.sqlDB == nil || retentionDays <= 0 {
+		return nil
+	}
+
+	query := `
+		DELETE FROM xml_integrator_logs WHERE created_at < DATEADD(DAY, -@p1, GETDATE());
+		DELETE FROM xml_integrator_runs WHERE started_at < DATEADD(DAY, -@p1, GETDATE());`
+
+	if _, err := s.sqlDB.ExecContext(ctx, query, retentionDays); err != nil {
+		return fmt.Errorf("erro ao remover logs antigos do integrador XML: %w", err)
+	}
+	return nil
+}
+
+// StartLogRetention remove diariamente os logs do integrador XML mais antigos
+// que XML_INTEGRATOR_LOG_RETENTION_DAYS; para quando ctx é cancelado
+func (s *XMLIntegratorService) StartLogRetention(ctx context.Context) {
+	if s.sqlDB == nil || s.config.XMLIntegratorLogRetentionDays <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
+
+		for {
+			if err := s.PurgeRuns(ctx, s.config.XMLIntegratorLogRetentionDays); err != nil {
+				log.Printf("⚠️ %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// recoverInterruptedRuns marca como falhas as execuções que estavam em
+// andamento quando o servidor parou
+func (s *XMLIntegratorService) recoverInterruptedRuns() {
+	if s.sqlDB == nil {
+		return
+	}
+
+	query := `
+		UPDATE xml_integrator_runs
+		SET status = @p1, error_message = @p2, finished_at = GETDATE()
+		WHERE status = @p3`
+
+	result, err := s.sqlDB.Exec(query, XMLRunFailed, "Execução interrompida pelo reinício do servidor", XMLRunRunning)
+	if err != nil {
+		log.Printf("⚠️ Erro ao recuperar execuções XML interrompidas: %v", err)
+		return
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
+		log.Printf("⚠️ %d execução(ões) XML interrompida(s) marcadas como falhas", rowsAffected)
+	}
+}
+
+// xmlLogConditions monta os filtros comuns a execuções e logs; idColumn é a
+// coluna comparada com ProcessID e dateColumn a comparada com From/To
+func xmlLogConditions(filter XMLIntegratorLogFilter, idColumn, dateColumn string) ([]string, []interface{}) {
+	var where []string
+	var args []interface{}
+
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		where = append(where, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.ProcessID != "" {
+		add(idColumn+" = TRY_CONVERT(UNIQUEIDENTIFIER, @p%d)", filter.ProcessID)
+	}
+	if filter.NumPedido != "" {
+		add("num_pedido = @p%d", filter.NumPedido)
+	}
+	if filter.Conta != "" {
+		add("conta = @p%d", strings.ToLower(filter.Conta))
+	}
+	if condition := filter.Scope.sqlCondition("conta", &args); condition != "" {
+		where = append(where, condition)
+	}
+	if filter.From != nil {
+		add(dateColumn+" >= @p%d", filter.From.Local().Format(sqlLocalTime))
+	}
+	if filter.To != nil {
+		add(dateColumn+" < @p%d", filter.To.Local().Format(sqlLocalTime))
+	}
+
+	return where, args
+}
+
+func sqlWhere(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(conditions, " AND ")
+}
+
+// sqlPage acrescenta OFFSET/FETCH quando o filtro é paginado
+func sqlPage(filter XMLIntegratorLogFilter, args *[]interface{}) string {
+	if filter.PageSize <= 0 {
+		return ""
+	}
+	page := filter.Page
+	if page < 1 {
+		page = 1
+	}
+
+	*args = append(*args, (page-1)*filter.PageSize, filter.PageSize)
+	return fmt.Sprintf(" OFFSET @p%d ROWS FETCH NEXT @p%d ROWS ONLY", len(*args)-1, len(*args))
+}
+
+// resultData retorna o mapa de dados da resposta do processamento XML
+func resultData(result *models.APIResponse) (map[string]interface{}, bool) {
+	if result == nil {
+		return nil, false
+	}
+	data, ok := result.Data.(map[string]interface{})
+	return data, ok
+}
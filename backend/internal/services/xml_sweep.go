@@ -0,0 +1,467 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"amz-web-tools/backend/internal/config"
+	"amz-web-tools/backend/internal/notify"
+)
+
+// Origem de uma varredura
+const (
+	XMLSweepScheduled = "scheduled"
+	XMLSweepManual    = "manual"
+)
+
+// Status de uma varredura em xml_sweep_runs
+const (
+	XMLSweepRunning   = "running"
+	XMLSweepCompleted = "completed"
+	XMLSweepFailed    = "failed"
+)
+
+// Resultado de cada pré-nota na varredura
+const (
+	XMLSweepUploaded = "uploaded" // XML enviado ao Mercado Livre, status 3
+	XMLSweepWaiting  = "waiting"  // envio ainda não pede a nota fiscal
+	XMLSweepNoXML    = "no_xml"   // pré-nota ainda sem XML no Oracle
+	XMLSweepError    = "error"
+)
+
+// xmlSweepMaxErrors limita os erros listados na notificação da varredura
+const xmlSweepMaxErrors = 20
+
+var (
+	// ErrXMLSweepRunning indica que já existe uma varredura em andamento
+	ErrXMLSweepRunning = errors.New("já existe uma varredura de XML em andamento")
+	// ErrXMLSweepRunNotFound indica que a varredura pedida não existe
+	ErrXMLSweepRunNotFound = errors.New("varredura de XML não encontrada")
+)
+
+// XMLSweepService envia periodicamente os XMLs de todas as pré-notas pendentes
+// (flg_statuspedido 1 ou 2) cujos envios já pedem a nota fiscal no Mercado Livre
+type XMLSweepService struct {
+	sqlDB       *sql.DB
+	xml         *XMLIntegratorService
+	enabled     bool
+	interval    time.Duration
+	concurrency int
+	timeout     int // deadline por pré-nota, em segundos
+	mu          sync.Mutex
+}
+
+// XMLSweepItem é o resultado de uma pré-nota na varredura
+type XMLSweepItem struct {
+	NumPedido  string      `json:"num_pedido"`
+	NumPrenota string      `json:"num_prenota"`
+	NumEnvio   string      `json:"num_envio"`
+	Conta      string      `json:"conta"`
+	NotaFiscal string      `json:"nota_fiscal,omitempty"`
+	MLStatus   string      `json:"ml_status,omitempty"`
+	Result     string      `json:"result"`
+	Message    string      `json:"message,omitempty"`
+	NFe        *NFeSummary `json:"nfe,omitempty"`
+}
+
+// XMLSweepRun é o resumo de uma varredura gravado em xml_sweep_runs
+type XMLSweepRun struct {
+	ID           string         `json:"id"`
+	Trigger      string         `json:"trigger"`
+	TriggeredBy  string         `json:"triggered_by,omitempty"`
+	Status       string         `json:"status"`
+	Total        int            `json:"total"`
+	Uploaded     int            `json:"uploaded"`
+	Waiting      int            `json:"waiting"`
+	NoXML        int            `json:"no_xml"`
+	Errors       int            `json:"errors"`
+	ErrorMessage string         `json:"error_message,omitempty"`
+	Items        []XMLSweepItem `json:"items,omitempty"`
+	StartedAt    time.Time      `json:"started_at"`
+	FinishedAt   *time.Time     `json:"finished_at,omitempty"`
+}
+
+func NewXMLSweepService(sqlDB *sql.DB, xmlIntegrator *XMLIntegratorService, cfg *config.Config) *XMLSweepService {
+	interval := time.Duration(cfg.XMLSweepIntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = 30 * time.Minute
+	}
+	concurrency := cfg.XMLSweepConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	return &XMLSweepService{
+		sqlDB:       sqlDB,
+		xml:         xmlIntegrator,
+		enabled:     cfg.XMLSweepEnabled,
+		interval:    interval,
+		concurrency: concurrency,
+		timeout:     cfg.XMLIntegrationTimeoutSeconds,
+	}
+}
+
+// Start marca como falhas as varreduras interrompidas e inicia o agendamento
+// em background, se habilitado; o agendamento para quando ctx é cancelado
+func (s *XMLSweepService) Start(ctx context.Context) {
+	s.recoverInterruptedRuns(ctx)
+
+	if !s.enabled {
+		log.Printf("⏸️ Varredura de XML desabilitada")
+		return
+	}
+
+	log.Printf("⏰ Varredura de XML iniciada (intervalo: %s, concorrência: %d)", s.interval, s.concurrency)
+
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := s.RunNow(ctx, XMLSweepScheduled, ""); err != nil {
+					log.Printf("⚠️ Varredura de XML: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// RunNow executa uma varredura e grava o resumo. Retorna ErrXMLSweepRunning se
+// outra varredura estiver em andamento.
+func (s *XMLSweepService) RunNow(ctx context.Context, trigger, triggeredBy string) (*XMLSweepRun, error) {
+	if !s.mu.TryLock() {
+		return nil, ErrXMLSweepRunning
+	}
+	defer s.mu.Unlock()
+
+	run := &XMLSweepRun{
+		Trigger:     trigger,
+		TriggeredBy: triggeredBy,
+		Status:      XMLSweepRunning,
+		Items:       []XMLSweepItem{},
+		StartedAt:   time.Now(),
+	}
+
+	query := `
+		INSERT INTO xml_sweep_runs (trigger_type, triggered_by, status)
+		OUTPUT CAST(INSERTED.id AS NVARCHAR(36))
+		VALUES (@p1, @p2, @p3)`
+
+	err := s.sqlDB.QueryRowContext(ctx, query, trigger, sql.NullString{String: triggeredBy, Valid: triggeredBy != ""},
+		XMLSweepRunning).Scan(&run.ID)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao registrar varredura de XML: %w", err)
+	}
+
+	log.Printf("🔍 Varredura de XML %s iniciada (%s)", run.ID, trigger)
+
+	pedidos, err := s.xml.getPendingInvoicesFromPostgres(ctx)
+	if err != nil {
+		run.Status = XMLSweepFailed
+		run.ErrorMessage = err.Error()
+		s.finishRun(ctx, run)
+		return run, nil
+	}
+
+	run.Items = s.processAll(ctx, pedidos)
+	run.Total = len(run.Items)
+	for _, item := range run.Items {
+		switch item.Result {
+		case XMLSweepUploaded:
+			run.Uploaded++
+		case XMLSweepWaiting:
+			run.Waiting++
+		case XMLSweepNoXML:
+			run.NoXML++
+		default:
+			run.Errors++
+		}
+	}
+
+	run.Status = XMLSweepCompleted
+	if err := ctx.Err(); err != nil {
+		run.Status = XMLSweepFailed
+		run.ErrorMessage = fmt.Sprintf("varredura interrompida: %v", err)
+	}
+	s.finishRun(ctx, run)
+
+	log.Printf("📊 Varredura de XML %s concluída: %d pendente(s), %d enviado(s), %d aguardando, %d sem XML, %d erro(s)",
+		run.ID, run.Total, run.Uploaded, run.Waiting, run.NoXML, run.Errors)
+
+	if run.Total > 0 {
+		s.xml.notifier.Notify(s.summary(run))
+	}
+
+	return run, nil
+}
+
+// processAll processa as pré-notas com no máximo s.concurrency ao mesmo tempo,
+// mantendo a ordem da consulta nos resultados
+func (s *XMLSweepService) processAll(ctx context.Context, pedidos []PedidoInfo) []XMLSweepItem {
+	items := make([]XMLSweepItem, len(pedidos))
+	sem := make(chan struct{}, s.concurrency)
+	var wg sync.WaitGroup
+
+	for i, pedido := range pedidos {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			items[i] = sweepItem(pedido)
+			items[i].Result = XMLSweepError
+			items[i].Message = fmt.Sprintf("varredura interrompida: %v", ctx.Err())
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, pedido PedidoInfo) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			items[i] = s.processPedido(ctx, pedido)
+		}(i, pedido)
+	}
+
+	wg.Wait()
+	return items
+}
+
+// processPedido busca o XML da pré-nota e o envia quando o Mercado Livre
+// aguarda a nota fiscal (ready_to_ship/invoice_pending)
+func (s *XMLSweepService) processPedido(ctx context.Context, pedido PedidoInfo) XMLSweepItem {
+	ctx, cancel := withDeadline(ctx, s.timeout)
+	defer cancel()
+
+	item := sweepItem(pedido)
+	fail := func(format string, args ...interface{}) XMLSweepItem {
+		item.Result = XMLSweepError
+		item.Message = fmt.Sprintf(format, args...)
+		return item
+	}
+
+	xmlData, err := s.xml.getXMLDataFromOracle(ctx, pedido.NumPrenota)
+	if err != nil {
+		return fail("erro ao buscar XML: %v", err)
+	}
+	if xmlData == nil || xmlData.XMLNota == "" {
+		item.Result = XMLSweepNoXML
+		return item
+	}
+	item.NotaFiscal = xmlData.Controle
+
+	if pedido.FlgStatusPedido != 2 {
+		if err := s.xml.updatePostgresStatus(ctx, pedido.NumPrenota, xmlData.Controle, xmlData.XMLNota, "2"); err != nil {
+			return fail("%v", err)
+		}
+	}
+
+	if pedido.NumEnvio == "" {
+		item.Result = XMLSweepWaiting
+		item.Message = "pedido sem envio"
+		return item
+	}
+
+	shipment, err := s.xml.marketplace.FetchShipment(ctx, item.Conta, pedido.NumEnvio)
+	if err != nil {
+		return fail("erro ao verificar status ML: %v", err)
+	}
+	item.MLStatus = shipment.Status + "/" + shipment.Substatus
+
+	if shipment.Status == "pending" && shipment.Substatus == "buffered" {
+		item.Result = XMLSweepWaiting
+		if err := s.xml.deferUpload(ctx, pedido, item.Conta, shipment.BufferingDate); err != nil {
+			item.Message = fmt.Sprintf("erro ao agendar envio: %v", err)
+		} else {
+			item.Message = "envio agendado para " + shipment.BufferingDate
+		}
+		return item
+	}
+
+	if shipment.Status != "ready_to_ship" || shipment.Substatus != "invoice_pending" {
+		item.Result = XMLSweepWaiting
+		return item
+	}
+
+	nfe, err := s.xml.validateInvoice(ctx, item.Conta, pedido, xmlData)
+	item.NFe = nfe
+	if err != nil {
+		return fail("envio bloqueado: %v", err)
+	}
+
+	if err := s.xml.marketplace.UploadInvoice(ctx, item.Conta, pedido.NumEnvio, xmlData.XMLNota); err != nil {
+		return fail("falha ao enviar XML: %v", err)
+	}
+
+	// O XML já foi aceito pelo ML: a gravação não é cancelada junto com a varredura
+	if err := s.xml.updatePostgresStatus(context.WithoutCancel(ctx), pedido.NumPrenota, xmlData.Controle, xmlData.XMLNota, "3"); err != nil {
+		return fail("XML enviado, mas %v", err)
+	}
+
+	log.Printf("✅ %s (MeLi) - Pedido %s, NF: %s - XML enviado pela varredura",
+		strings.ToUpper(item.Conta), pedido.NumPedido, xmlData.Controle)
+	item.Result = XMLSweepUploaded
+	return item
+}
+
+func sweepItem(pedido PedidoInfo) XMLSweepItem {
+	return XMLSweepItem{
+		NumPedido:  pedido.NumPedido,
+		NumPrenota: pedido.NumPrenota,
+		NumEnvio:   pedido.NumEnvio,
+		Conta:      strings.ToLower(pedido.NomEmpresa),
+	}
+}
+
+// summary monta a notificação única da varredura
+func (s *XMLSweepService) summary(run *XMLSweepRun) notify.Event {
+	var erros []string
+	for _, item := range run.Items {
+		if item.Result != XMLSweepError {
+			continue
+		}
+		if len(erros) == xmlSweepMaxErrors {
+			erros = append(erros, fmt.Sprintf("... e mais %d erro(s)", run.Errors-xmlSweepMaxErrors))
+			break
+		}
+		erros = append(erros, fmt.Sprintf("❌ Pedido %s (%s): %s", item.NumPedido, strings.ToUpper(item.Conta), item.Message))
+	}
+
+	severity := notify.SeverityInfo
+	if run.Errors > 0 || run.ErrorMessage != "" {
+		severity = notify.SeverityWarning
+	}
+
+	return notify.Event{
+		Kind:     notify.EventXMLSweepCompleted,
+		Severity: severity,
+		Data: map[string]interface{}{
+			"total":         run.Total,
+			"uploaded":      run.Uploaded,
+			"waiting":       run.Waiting,
+			"no_xml":        run.NoXML,
+			"errors":        run.Errors,
+			"error_message": run.ErrorMessage,
+			"erros":         erros,
+		},
+	}
+}
+
+func (s *XMLSweepService) finishRun(ctx context.Context, run *XMLSweepRun) {
+	now := time.Now()
+	run.FinishedAt = &now
+
+	items, err := json.Marshal(run.Items)
+	if err != nil {
+		log.Printf("⚠️ Erro ao serializar resultados da varredura %s: %v", run.ID, err)
+		items = []byte("[]")
+	}
+
+	query := `
+		UPDATE xml_sweep_runs
+		SET status = @p1, total = @p2, uploaded = @p3, waiting = @p4, no_xml = @p5, errors = @p6,
+		    error_message = @p7, items = @p8, finished_at = GETDATE()
+		WHERE id = @p9`
+
+	// O resumo é gravado mesmo se a varredura foi cancelada
+	_, err = s.sqlDB.ExecContext(context.WithoutCancel(ctx), query, run.Status, run.Total, run.Uploaded, run.Waiting,
+		run.NoXML, run.Errors, sql.NullString{String: run.ErrorMessage, Valid: run.ErrorMessage != ""}, string(items), run.ID)
+	if err != nil {
+		log.Printf("⚠️ Erro ao gravar resumo da varredura %s: %v", run.ID, err)
+	}
+}
+
+// GetRuns lista as últimas varreduras, sem os resultados por pré-nota
+func (s *XMLSweepService) GetRuns(ctx context.Context, limit int) ([]XMLSweepRun, error) {
+	query := `
+		SELECT TOP (@p1) CAST(id AS NVARCHAR(36)), trigger_type, COALESCE(triggered_by, ''), status,
+		       COALESCE(total, 0), COALESCE(uploaded, 0), COALESCE(waiting, 0), COALESCE(no_xml, 0), COALESCE(errors, 0),
+		       COALESCE(error_message, ''), started_at, finished_at
+		FROM xml_sweep_runs
+		ORDER BY started_at DESC`
+
+	rows, err := s.sqlDB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar varreduras de XML: %w", err)
+	}
+	defer rows.Close()
+
+	runs := []XMLSweepRun{}
+	for rows.Next() {
+		var run XMLSweepRun
+		var finishedAt sql.NullTime
+		if err := rows.Scan(&run.ID, &run.Trigger, &run.TriggeredBy, &run.Status,
+			&run.Total, &run.Uploaded, &run.Waiting, &run.NoXML, &run.Errors,
+			&run.ErrorMessage, &run.StartedAt, &finishedAt); err != nil {
+			return nil, fmt.Errorf("erro ao ler varredura de XML: %w", err)
+		}
+		if finishedAt.Valid {
+			run.FinishedAt = &finishedAt.Time
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+// GetRun retorna uma varredura com o resultado de cada pré-nota
+func (s *XMLSweepService) GetRun(ctx context.Context, id string) (*XMLSweepRun, error) {
+	query := `
+		SELECT CAST(id AS NVARCHAR(36)), trigger_type, COALESCE(triggered_by, ''), status,
+		       COALESCE(total, 0), COALESCE(uploaded, 0), COALESCE(waiting, 0), COALESCE(no_xml, 0), COALESCE(errors, 0),
+		       COALESCE(error_message, ''), COALESCE(items, ''), started_at, finished_at
+		FROM xml_sweep_runs
+		WHERE id = @p1`
+
+	var run XMLSweepRun
+	var items string
+	var finishedAt sql.NullTime
+	err := s.sqlDB.QueryRowContext(ctx, query, id).Scan(&run.ID, &run.Trigger, &run.TriggeredBy, &run.Status,
+		&run.Total, &run.Uploaded, &run.Waiting, &run.NoXML, &run.Errors,
+		&run.ErrorMessage, &items, &run.StartedAt, &finishedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrXMLSweepRunNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar varredura de XML: %w", err)
+	}
+
+	if finishedAt.Valid {
+		run.FinishedAt = &finishedAt.Time
+	}
+	run.Items = []XMLSweepItem{}
+	if items != "" {
+		if err := json.Unmarshal([]byte(items), &run.Items); err != nil {
+			return nil, fmt.Errorf("erro ao decodificar resultados da varredura: %w", err)
+		}
+	}
+
+	return &run, nil
+}
+
+// recoverInterruptedRuns marca como falhas as varreduras que estavam em
+// andamento quando o servidor parou
+func (s *XMLSweepService) recoverInterruptedRuns(ctx context.Context) {
+	query := `
+		UPDATE xml_sweep_runs
+		SET status = @p1, error_message = @p2, finished_at = GETDATE()
+		WHERE status = @p3`
+
+	result, err := s.sqlDB.ExecContext(ctx, query, XMLSweepFailed, "Varredura interrompida pelo reinício do servidor", XMLSweepRunning)
+	if err != nil {
+		log.Printf("⚠️ Erro ao recuperar varreduras de XML interrompidas: %v", err)
+		return
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
+		log.Printf("⚠️ %d varredura(s) de XML interrompida(s) marcadas como falhas", rowsAffected)
+	}
+}
@@ -1,29 +1,132 @@
 package websocket
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"amz-web-tools/backend/internal/middleware"
+	"amz-web-tools/backend/internal/models"
+	"amz-web-tools/backend/internal/permissions"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
 
-// HandleWebSocket gerencia conexões WebSocket
-func HandleWebSocket(hub *Hub) gin.HandlerFunc {
+// maxMessageSize limita as mensagens enviadas pelo cliente, que só alteram
+// assinaturas
+const maxMessageSize = 1024
+
+// sessionCheckInterval é o intervalo em que a sessão de cada conexão é
+// conferida; conexões de sessões encerradas (logout, revogação, exclusão do
+// usuário) são fechadas
+const sessionCheckInterval = 30 * time.Second
+
+// HandleWebSocket gerencia conexões WebSocket. O cliente se autentica com o
+// mesmo JWT das rotas protegidas (?token= ou header Authorization), cuja sessão
+// precisa estar ativa em sessions (e é reconferida a cada
+// sessionCheckInterval), e assina os tópicos iniciais com
+// ?process_id=, ?conta= e ?all=true (apenas admin); com
+// ?since=<seq> as mensagens do processo posteriores a seq são reenviadas
+// antes das novas. Conexões de navegador só são aceitas das origens em
+// allowedOrigins.
+func HandleWebSocket(hub *Hub, jwtSecret string, sessions middleware.SessionChecker, allowedOrigins []string) gin.HandlerFunc {
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     originChecker(allowedOrigins),
+	}
+
 	return func(c *gin.Context) {
-		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		token := c.Query("token")
+		if authHeader := c.GetHeader("Authorization"); token == "" && strings.HasPrefix(authHeader, "Bearer ") {
+			token = strings.TrimPrefix(authHeader, "Bearer ")
+		}
+		if token == "" {
+			c.JSON(http.StatusUnauthorized, models.APIResponse{
+				Success: false,
+				Message: "Token required",
+			})
+			return
+		}
+
+		claims, err := middleware.Authenticate(c.Request.Context(), token, jwtSecret, sessions)
 		if err != nil {
-			log.Printf("❌ Erro ao fazer upgrade WebSocket: %v", err)
+			log.Printf("❌ WebSocket: %v", err)
+			c.JSON(http.StatusUnauthorized, models.APIResponse{
+				Success: false,
+				Message: "Invalid or expired token",
+			})
 			return
 		}
 
 		client := &Client{
-			hub:  hub,
-			conn: conn,
-			send: make(chan []byte, 256),
+			hub:         hub,
+			send:        make(chan []byte, 256),
+			userID:      claims.UserID,
+			sessionID:   claims.SessionID,
+			sessions:    sessions,
+			permissions: claims.Permissions,
+			contas:      claims.Contas,
+			topics:      make(map[string]bool),
 		}
 
+		var since *uint64
+		if value := c.Query("since"); value != "" {
+			seq, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, models.APIResponse{
+					Success: false,
+					Message: "since inválido",
+				})
+				return
+			}
+			since = &seq
+		}
+
+		var topics []string
+		processID := c.Query("process_id")
+		if processID != "" && since == nil {
+			topics = append(topics, ProcessTopic(processID))
+		}
+		if conta := c.Query("conta"); conta != "" {
+			topics = append(topics, ContaTopic(conta))
+		}
+		if c.Query("all") == "true" {
+			topics = append(topics, TopicAll)
+		}
+		for _, topic := range topics {
+			if err := client.authorize(topic); err != nil {
+				c.JSON(http.StatusForbidden, models.APIResponse{
+					Success: false,
+					Message: err.Error(),
+				})
+				return
+			}
+			client.topics[topic] = true
+		}
+
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			log.Printf("❌ Erro ao fazer upgrade WebSocket: %v", err)
+			return
+		}
+		client.conn = conn
+
 		client.hub.register <- client
 
+		// O processo é assinado pelo hub para que o replay chegue antes das
+		// mensagens novas
+		if processID != "" && since != nil {
+			client.hub.subscriptions <- subscription{client: client, topic: ProcessTopic(processID), subscribe: true, since: since}
+		}
+
 		// Permitir coleta de memória referenciada pelo chamador fazendo todo o trabalho
 		// em novas goroutines
 		go client.writePump()
@@ -31,30 +134,154 @@ func HandleWebSocket(hub *Hub) gin.HandlerFunc {
 	}
 }
 
-// readPump bombeia mensagens do WebSocket para o hub
+// originChecker aceita requisições sem Origin (clientes fora do navegador) e
+// as origens configuradas em CORS_ALLOWED_ORIGINS
+func originChecker(allowedOrigins []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+
+		for _, allowed := range allowedOrigins {
+			allowed = strings.TrimRight(strings.TrimSpace(allowed), "/")
+			if allowed == "*" || strings.EqualFold(allowed, origin) {
+				return true
+			}
+		}
+
+		log.Printf("🚫 WebSocket: origem não permitida: %s", origin)
+		return false
+	}
+}
+
+// authorize confere se o cliente pode assinar o tópico: todas as mensagens
+// apenas com a permissão admin:access; processos para qualquer usuário
+// autenticado, que recebe só as mensagens das suas contas, e contas dentro das
+// contas atribuídas ao usuário
+func (c *Client) authorize(topic string) error {
+	switch {
+	case topic == TopicAll:
+		if !permissions.Has(c.permissions, permissions.AdminAccess) {
+			return errors.New("apenas administradores podem assinar todas as mensagens")
+		}
+	case strings.HasPrefix(topic, topicProcessPrefix) && len(topic) > len(topicProcessPrefix):
+	case strings.HasPrefix(topic, topicContaPrefix) && len(topic) > len(topicContaPrefix):
+		if !c.allowsConta(strings.TrimPrefix(topic, topicContaPrefix)) {
+			return fmt.Errorf("conta fora do escopo do usuário: %s", strings.TrimPrefix(topic, topicContaPrefix))
+		}
+	default:
+		return fmt.Errorf("tópico inválido: %q", topic)
+	}
+	return nil
+}
+
+// allowsConta informa se a conta está entre as atribuídas ao usuário;
+// administradores e usuários com "*" veem todas, inclusive as mensagens sem
+// conta
+func (c *Client) allowsConta(conta string) bool {
+	if permissions.Has(c.permissions, permissions.AdminAccess) {
+		return true
+	}
+	for _, allowed := range c.contas {
+		if allowed == "*" || strings.EqualFold(allowed, conta) {
+			return true
+		}
+	}
+	return false
+}
+
+// subscriptionRequest é a mensagem enviada pelo cliente para alterar suas
+// assinaturas: {"action": "subscribe"|"unsubscribe", "topic": "process:<id>"}.
+// Since, ao assinar um processo, pede o reenvio das mensagens posteriores.
+type subscriptionRequest struct {
+	Action string  `json:"action"`
+	Topic  string  `json:"topic"`
+	Since  *uint64 `json:"since,omitempty"`
+}
+
+// readPump lê as mensagens de assinatura do cliente e as repassa ao hub
 func (c *Client) readPump() {
 	defer func() {
 		c.hub.unregister <- c
 		c.conn.Close()
 	}()
 
+	c.conn.SetReadLimit(maxMessageSize)
+
 	for {
-		_, _, err := c.conn.ReadMessage()
+		_, message, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("❌ Erro WebSocket: %v", err)
 			}
 			break
 		}
+
+		var req subscriptionRequest
+		if err := json.Unmarshal(message, &req); err != nil {
+			c.replyError("mensagem inválida: esperado {\"action\", \"topic\"}")
+			continue
+		}
+		if strings.HasPrefix(req.Topic, topicContaPrefix) {
+			req.Topic = ContaTopic(strings.TrimPrefix(req.Topic, topicContaPrefix))
+		}
+
+		switch req.Action {
+		case "subscribe":
+			if err := c.authorize(req.Topic); err != nil {
+				c.replyError(err.Error())
+				continue
+			}
+			c.hub.subscriptions <- subscription{client: c, topic: req.Topic, subscribe: true, since: req.Since}
+		case "unsubscribe":
+			c.hub.subscriptions <- subscription{client: c, topic: req.Topic}
+		default:
+			c.replyError(fmt.Sprintf("ação inválida: %q", req.Action))
+		}
 	}
 }
 
-// writePump bombeia mensagens do hub para o WebSocket
+// sessionActive confere a sessão do cliente; um erro na consulta mantém a
+// conexão
+func (c *Client) sessionActive() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	active, err := c.sessions.SessionActive(ctx, c.sessionID)
+	if err != nil {
+		log.Printf("⚠️ WebSocket: erro ao conferir sessão: %v", err)
+		return true
+	}
+	return active
+}
+
+func (c *Client) replyError(message string) {
+	data, err := json.Marshal(map[string]string{"type": "error", "message": message})
+	if err == nil {
+		c.hub.replies <- clientMessage{client: c, data: data}
+	}
+}
+
+// writePump bombeia mensagens do hub para o WebSocket e fecha a conexão
+// quando a sessão do cliente deixa de estar ativa
 func (c *Client) writePump() {
-	defer c.conn.Close()
+	ticker := time.NewTicker(sessionCheckInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
 
 	for {
 		select {
+		case <-ticker.C:
+			if !c.sessionActive() {
+				log.Printf("🔌 Sessão encerrada: fechando WebSocket do usuário %s", c.userID)
+				c.conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "sessão encerrada"))
+				return
+			}
+
 		case message, ok := <-c.send:
 			if !ok {
 				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
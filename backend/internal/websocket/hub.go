@@ -3,53 +3,154 @@ package websocket
 import (
 	"encoding/json"
 	"log"
-	"net/http"
+	"strings"
 	"sync"
+	"time"
+
+	"amz-web-tools/backend/internal/middleware"
 
 	"github.com/gorilla/websocket"
 )
 
-// Hub mantém as conexões WebSocket ativas
+// Tópicos de assinatura: um processo, uma conta ou todas as mensagens (admin)
+const (
+	TopicAll = "all"
+
+	topicProcessPrefix = "process:"
+	topicContaPrefix   = "conta:"
+)
+
+// Cada tópico de processo guarda as últimas replayBufferSize mensagens para
+// quem assina depois do início; os buffers dos processos mais antigos são
+// descartados acima de maxReplayTopics
+const (
+	replayBufferSize = 200
+	maxReplayTopics  = 500
+)
+
+// ProcessTopic retorna o tópico das mensagens de um process_id
+func ProcessTopic(processID string) string {
+	return topicProcessPrefix + processID
+}
+
+// ContaTopic retorna o tópico das mensagens de uma conta
+func ContaTopic(conta string) string {
+	return topicContaPrefix + strings.ToLower(conta)
+}
+
+// Hub mantém as conexões WebSocket ativas e entrega cada mensagem apenas aos
+// clientes assinantes de um dos seus tópicos
 type Hub struct {
-	clients    map[*Client]bool
-	broadcast  chan []byte
-	register   chan *Client
-	unregister chan *Client
-	mutex      sync.RWMutex
+	clients       map[*Client]bool
+	broadcast     chan LogMessage
+	register      chan *Client
+	unregister    chan *Client
+	subscriptions chan subscription
+	replies       chan clientMessage
+	userMessages  chan userMessage
+	mutex         sync.RWMutex
+
+	// Usados apenas pela goroutine do hub
+	seq          uint64
+	buffers      map[string]*replayBuffer
+	bufferTopics []string
 }
 
-// Client representa uma conexão WebSocket
+// Client representa uma conexão WebSocket autenticada. topics só é alterado
+// pela goroutine do hub depois do registro.
 type Client struct {
-	hub  *Hub
-	conn *websocket.Conn
-	send chan []byte
+	hub         *Hub
+	conn        *websocket.Conn
+	send        chan []byte
+	userID      string
+	sessionID   string
+	sessions    middleware.SessionChecker
+	permissions []string
+	contas      []string // "*": todas as contas
+	topics      map[string]bool
 }
 
-// LogMessage representa uma mensagem de log
+// LogMessage representa uma mensagem de log. Seq é atribuído pelo hub e
+// cresce a cada mensagem, inclusive entre reinícios do servidor.
 type LogMessage struct {
+	Seq       uint64 `json:"seq"`
 	Type      string `json:"type"`
 	Timestamp string `json:"timestamp"`
 	Level     string `json:"level"`
 	Step      string `json:"step"`
 	Message   string `json:"message"`
 	ProcessID string `json:"process_id,omitempty"`
+	NumPedido string `json:"num_pedido,omitempty"`
+	Conta     string `json:"conta,omitempty"`
+}
+
+type clientMessage struct {
+	client *Client
+	data   []byte
+}
+
+// userMessage é entregue a todas as conexões de um usuário, sem depender
+// dos tópicos assinados
+type userMessage struct {
+	userID string
+	data   []byte
+}
+
+type subscription struct {
+	client    *Client
+	topic     string
+	subscribe bool
+	since     *uint64 // reenviar as mensagens com seq maior; nil só recebe as novas
+}
+
+// replayBuffer é um buffer circular com as últimas mensagens de um processo
+type replayBuffer struct {
+	messages []bufferedMessage
+	next     int
+	dropped  uint64 // seq da última mensagem descartada
 }
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Permitir todas as origens para desenvolvimento
-	},
+type bufferedMessage struct {
+	seq   uint64
+	conta string
+	data  []byte
+}
+
+func (b *replayBuffer) add(msg bufferedMessage) {
+	if len(b.messages) < replayBufferSize {
+		b.messages = append(b.messages, msg)
+		return
+	}
+	b.dropped = b.messages[b.next].seq
+	b.messages[b.next] = msg
+	b.next = (b.next + 1) % replayBufferSize
+}
+
+// since retorna, em ordem, as mensagens com seq maior que seq das contas
+// aceitas por allowed e se alguma delas já foi descartada do buffer
+func (b *replayBuffer) since(seq uint64, allowed func(conta string) bool) (messages []json.RawMessage, truncated bool) {
+	for i := range b.messages {
+		msg := b.messages[(b.next+i)%len(b.messages)]
+		if msg.seq > seq && allowed(msg.conta) {
+			messages = append(messages, msg.data)
+		}
+	}
+	return messages, b.dropped > seq
 }
 
 // NewHub cria uma nova instância do Hub
 func NewHub() *Hub {
 	return &Hub{
-		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
+		clients:       make(map[*Client]bool),
+		broadcast:     make(chan LogMessage),
+		register:      make(chan *Client),
+		unregister:    make(chan *Client),
+		subscriptions: make(chan subscription),
+		replies:       make(chan clientMessage),
+		userMessages:  make(chan userMessage),
+		// Começar do relógio mantém a sequência crescente entre reinícios
+		seq:     uint64(time.Now().UnixMicro()),
+		buffers: make(map[string]*replayBuffer),
 	}
 }
 
@@ -61,47 +162,165 @@ func (h *Hub) Run() {
 			h.mutex.Lock()
 			h.clients[client] = true
 			h.mutex.Unlock()
-			log.Printf("🔌 Cliente WebSocket conectado. Total: %d", len(h.clients))
+			log.Printf("🔌 Cliente WebSocket conectado (usuário %s). Total: %d", client.userID, len(h.clients))
 
 		case client := <-h.unregister:
 			h.mutex.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-			}
+			h.remove(client)
 			h.mutex.Unlock()
 			log.Printf("🔌 Cliente WebSocket desconectado. Total: %d", len(h.clients))
 
-		case message := <-h.broadcast:
-			h.mutex.RLock()
+		case sub := <-h.subscriptions:
+			h.mutex.Lock()
+			if h.clients[sub.client] {
+				reply := map[string]interface{}{"type": "subscribed", "topic": sub.topic}
+				if sub.subscribe {
+					sub.client.topics[sub.topic] = true
+				} else {
+					delete(sub.client.topics, sub.topic)
+					reply["type"] = "unsubscribed"
+				}
+				if data, err := json.Marshal(reply); err == nil {
+					h.deliver(sub.client, data)
+				}
+				if sub.subscribe && sub.since != nil {
+					h.replay(sub.client, sub.topic, *sub.since)
+				}
+			}
+			h.mutex.Unlock()
+
+		case reply := <-h.replies:
+			h.mutex.Lock()
+			if h.clients[reply.client] {
+				h.deliver(reply.client, reply.data)
+			}
+			h.mutex.Unlock()
+
+		case msg := <-h.userMessages:
+			h.mutex.Lock()
 			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
+				if strings.EqualFold(client.userID, msg.userID) {
+					h.deliver(client, msg.data)
 				}
 			}
-			h.mutex.RUnlock()
+			h.mutex.Unlock()
+
+		case logMsg := <-h.broadcast:
+			h.seq++
+			logMsg.Seq = h.seq
+
+			data, err := json.Marshal(logMsg)
+			if err != nil {
+				continue
+			}
+
+			topics := []string{TopicAll}
+			if logMsg.ProcessID != "" {
+				topic := ProcessTopic(logMsg.ProcessID)
+				topics = append(topics, topic)
+				h.buffer(topic).add(bufferedMessage{seq: logMsg.Seq, conta: logMsg.Conta, data: data})
+			}
+			if logMsg.Conta != "" {
+				topics = append(topics, ContaTopic(logMsg.Conta))
+			}
+
+			// Assinar um processo não basta: a mensagem precisa ser de uma conta
+			// do usuário
+			h.mutex.Lock()
+			for client := range h.clients {
+				if client.subscribedTo(topics) && client.allowsConta(logMsg.Conta) {
+					h.deliver(client, data)
+				}
+			}
+			h.mutex.Unlock()
 		}
 	}
 }
 
-// BroadcastLog envia um log para todos os clientes conectados
-func (h *Hub) BroadcastLog(logMsg LogMessage) {
-	message := map[string]interface{}{
-		"type":       logMsg.Type,
-		"timestamp":  logMsg.Timestamp,
-		"level":      logMsg.Level,
-		"step":       logMsg.Step,
-		"message":    logMsg.Message,
-		"process_id": logMsg.ProcessID,
+// deliver enfileira a mensagem para o cliente; um cliente que não acompanha o
+// ritmo é desconectado. Deve ser chamado com o mutex travado.
+func (h *Hub) deliver(client *Client, data []byte) {
+	select {
+	case client.send <- data:
+	default:
+		h.remove(client)
+	}
+}
+
+// buffer retorna o buffer de replay do tópico, criando-o e descartando o mais
+// antigo quando há tópicos demais
+func (h *Hub) buffer(topic string) *replayBuffer {
+	if b, ok := h.buffers[topic]; ok {
+		return b
+	}
+
+	if len(h.bufferTopics) >= maxReplayTopics {
+		delete(h.buffers, h.bufferTopics[0])
+		h.bufferTopics = h.bufferTopics[1:]
+	}
+
+	b := &replayBuffer{}
+	h.buffers[topic] = b
+	h.bufferTopics = append(h.bufferTopics, topic)
+	return b
+}
+
+// replay envia ao cliente, numa única mensagem, as mensagens do tópico
+// perdidas desde since, apenas das contas do cliente. truncated indica que
+// parte delas não está mais no buffer. Deve ser chamado com o mutex travado.
+func (h *Hub) replay(client *Client, topic string, since uint64) {
+	var messages []json.RawMessage
+	var truncated bool
+	if b, ok := h.buffers[topic]; ok {
+		messages, truncated = b.since(since, client.allowsConta)
 	}
+	if messages == nil {
+		messages = []json.RawMessage{}
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"type":      "replay",
+		"topic":     topic,
+		"since":     since,
+		"messages":  messages,
+		"truncated": truncated,
+	})
+	if err == nil {
+		h.deliver(client, data)
+	}
+}
+
+func (h *Hub) remove(client *Client) {
+	if _, ok := h.clients[client]; ok {
+		delete(h.clients, client)
+		close(client.send)
+	}
+}
+
+func (c *Client) subscribedTo(topics []string) bool {
+	for _, topic := range topics {
+		if c.topics[topic] {
+			return true
+		}
+	}
+	return false
+}
+
+// BroadcastLog envia um log aos clientes assinantes do processo, da conta ou
+// de todas as mensagens
+func (h *Hub) BroadcastLog(logMsg LogMessage) {
+	h.broadcast <- logMsg
+}
 
-	// Converter para JSON e enviar
-	if jsonData, err := json.Marshal(message); err == nil {
-		h.broadcast <- jsonData
+// SendToUser envia a mensagem, serializada em JSON, a todas as conexões do
+// usuário
+func (h *Hub) SendToUser(userID string, message interface{}) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
 	}
+	h.userMessages <- userMessage{userID: userID, data: data}
+	return nil
 }
 
 // GetClientCount retorna o número de clientes conectados
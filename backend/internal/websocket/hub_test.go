@@ -0,0 +1,110 @@
+package websocket
+
+import (
+	"strconv"
+	"testing"
+)
+
+// newTestBuffer adiciona ao buffer as mensagens de seq 1 a n, com o próprio
+// seq como conteúdo; as ímpares são da conta psa e as pares da lcs
+func newTestBuffer(n int) *replayBuffer {
+	b := &replayBuffer{}
+	for seq := 1; seq <= n; seq++ {
+		conta := "psa"
+		if seq%2 == 0 {
+			conta = "lcs"
+		}
+		b.add(bufferedMessage{seq: uint64(seq), conta: conta, data: []byte(strconv.Itoa(seq))})
+	}
+	return b
+}
+
+func allContas(string) bool { return true }
+
+// replayedSeqs executa since e devolve os seqs reenviados, conferindo a ordem
+func replayedSeqs(t *testing.T, b *replayBuffer, since uint64, allowed func(string) bool) ([]int, bool) {
+	t.Helper()
+
+	messages, truncated := b.since(since, allowed)
+	seqs := make([]int, len(messages))
+	for i, message := range messages {
+		seq, err := strconv.Atoi(string(message))
+		if err != nil {
+			t.Fatalf("mensagem inesperada %q", message)
+		}
+		if i > 0 && seq <= seqs[i-1] {
+			t.Fatalf("mensagens fora de ordem: %d depois de %d", seq, seqs[i-1])
+		}
+		seqs[i] = seq
+	}
+	return seqs, truncated
+}
+
+func TestReplayBufferSince(t *testing.T) {
+	if seqs, truncated := replayedSeqs(t, &replayBuffer{}, 0, allContas); len(seqs) != 0 || truncated {
+		t.Errorf("buffer vazio: %v, truncated = %v", seqs, truncated)
+	}
+
+	b := newTestBuffer(5)
+	tests := []struct {
+		since uint64
+		first int
+		count int
+	}{
+		{0, 1, 5},
+		{3, 4, 2},
+		{5, 0, 0},
+	}
+	for _, tt := range tests {
+		seqs, truncated := replayedSeqs(t, b, tt.since, allContas)
+		if truncated {
+			t.Errorf("since(%d): truncated sem descarte", tt.since)
+		}
+		if len(seqs) != tt.count || (tt.count > 0 && seqs[0] != tt.first) {
+			t.Errorf("since(%d) = %v, want %d mensagem(ns) a partir de %d", tt.since, seqs, tt.count, tt.first)
+		}
+	}
+}
+
+func TestReplayBufferWrapsAround(t *testing.T) {
+	const extra = 10
+	b := newTestBuffer(replayBufferSize + extra)
+
+	// As extra primeiras mensagens foram descartadas; a ordem é mantida após a volta
+	seqs, truncated := replayedSeqs(t, b, 0, allContas)
+	if len(seqs) != replayBufferSize || seqs[0] != extra+1 || seqs[len(seqs)-1] != replayBufferSize+extra {
+		t.Fatalf("since(0): %d mensagens, de %d a %d", len(seqs), seqs[0], seqs[len(seqs)-1])
+	}
+	if !truncated {
+		t.Error("since(0): esperava truncated")
+	}
+
+	if _, truncated := replayedSeqs(t, b, extra, allContas); truncated {
+		t.Errorf("since(%d): a última mensagem descartada é a %d, não deveria truncar", extra, extra)
+	}
+	if _, truncated := replayedSeqs(t, b, extra-1, allContas); !truncated {
+		t.Errorf("since(%d): a mensagem %d já foi descartada", extra-1, extra)
+	}
+}
+
+func TestReplayBufferSinceFiltersContas(t *testing.T) {
+	only := func(allowed string) func(string) bool {
+		return func(conta string) bool { return conta == allowed }
+	}
+
+	seqs, _ := replayedSeqs(t, newTestBuffer(5), 0, only("lcs"))
+	if len(seqs) != 2 || seqs[0] != 2 || seqs[1] != 4 {
+		t.Errorf("lcs: %v, want [2 4]", seqs)
+	}
+
+	if seqs, _ := replayedSeqs(t, newTestBuffer(5), 0, only("xyz")); len(seqs) != 0 {
+		t.Errorf("conta sem mensagens: %v", seqs)
+	}
+
+	// Após a volta do buffer
+	b := newTestBuffer(replayBufferSize + 10)
+	seqs, truncated := replayedSeqs(t, b, replayBufferSize, only("psa"))
+	if len(seqs) != 5 || seqs[0] != replayBufferSize+1 || seqs[4] != replayBufferSize+9 || truncated {
+		t.Errorf("psa após a volta: %v, truncated = %v", seqs, truncated)
+	}
+}
@@ -1,13 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"amz-web-tools/backend/internal/config"
 	"amz-web-tools/backend/internal/database"
 	"amz-web-tools/backend/internal/handlers"
 	"amz-web-tools/backend/internal/middleware"
+	"amz-web-tools/backend/internal/permissions"
 	"amz-web-tools/backend/internal/websocket"
 
 	"github.com/gin-contrib/cors"
@@ -55,8 +63,13 @@ func main() {
 	wsHub := websocket.NewHub()
 	go wsHub.Run()
 
+	// Cancelled on SIGINT/SIGTERM: stops background integration jobs and
+	// in-flight requests (every request context derives from it)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Initialize handlers
-	h, err := handlers.New(db, cfg, wsHub)
+	h, err := handlers.New(ctx, db, cfg, wsHub)
 	if err != nil {
 		log.Fatalf("Failed to initialize handlers: %v", err)
 	}
@@ -66,32 +79,13 @@ func main() {
 	{
 		public.POST("/auth/login", h.Login)
 		public.POST("/auth/register", h.Register)
-		// Temporary route for testing car plate without auth
-		public.GET("/test/plate-history", h.GetCarPlateHistory)
-		public.GET("/test/car-plate/:plate", h.GetCarPlate)
-		// Temporary route for testing depara without auth
-		public.GET("/test/depara/tables", h.GetAvailableTables)
-		public.GET("/test/depara/options", h.GetTableOptions)
-		public.POST("/test/depara/search", h.SearchDeParaProducts)
-		public.POST("/test/depara", h.CreateDeParaProduct)
-		public.GET("/test/depara/:id", h.GetDeParaProduct)
-		public.PUT("/test/depara/:id", h.UpdateDeParaProduct)
-		public.DELETE("/test/depara/:id", h.DeleteDeParaProduct)
-
-		// Test audit routes
-		public.GET("/test/audit/logs", h.GetAuditLogs)
-		public.POST("/test/audit/rollback/:audit_id", h.ExecuteRollback)
-
-		// Test stock routes
-		public.GET("/test/stock", h.GetStock)
-		public.POST("/test/stock/search", h.SearchStock)
-
-		// Test XML Integrator route (temporário para testes)
-		public.POST("/test/xml-integrator/process", h.ProcessXMLIntegration)
-		public.GET("/test/xml-integrator/logs/:process_id", h.GetXMLIntegrationLogs)
-
-		// WebSocket route para logs em tempo real
-		public.GET("/ws/logs", websocket.HandleWebSocket(wsHub))
+		public.POST("/auth/refresh", h.RefreshToken)
+		public.POST("/auth/logout", h.Logout)
+		// Mercado Livre notifications
+		public.POST("/webhooks/mercadolivre", h.MercadoLivreWebhook)
+
+		// WebSocket route para logs em tempo real (autentica pelo JWT em ?token=)
+		public.GET("/ws/logs", websocket.HandleWebSocket(wsHub, cfg.JWTSecret, h.Sessions(), cfg.CORSAllowedOrigins))
 
 		// Teste simples para verificar se a rota está funcionando
 		public.GET("/test/ws", func(c *gin.Context) {
@@ -101,7 +95,7 @@ func main() {
 
 	// Protected routes
 	protected := r.Group("/api/v1")
-	protected.Use(middleware.AuthMiddleware(cfg.JWTSecret))
+	protected.Use(middleware.AuthMiddleware(cfg.JWTSecret, h.Sessions()))
 	{
 		// Profile routes
 		protected.GET("/profile", h.GetProfile)
@@ -109,52 +103,73 @@ func main() {
 		protected.PUT("/profile/password", h.UpdatePassword)
 
 		// Car Plate routes
-		protected.GET("/car-plate/:plate", h.GetCarPlate)
-		protected.GET("/car-plate/history", h.GetCarPlateHistory)
+		carPlateView := middleware.RequirePermission(permissions.CarPlateView)
+		protected.GET("/car-plate/:plate", carPlateView, h.GetCarPlate)
+		protected.GET("/car-plate/history", carPlateView, h.GetCarPlateHistory)
 
 		// Integration routes
-		protected.POST("/integration/execute", h.ExecuteIntegration)
-		protected.GET("/integration/status/:id", h.GetIntegrationStatus)
+		integrationExecute := middleware.RequirePermission(permissions.IntegrationExecute)
+		protected.POST("/integration/execute", integrationExecute, h.ExecuteIntegration)
+		protected.GET("/integration/status/:id", middleware.RequirePermission(permissions.IntegrationView), h.GetIntegrationStatus)
+		protected.POST("/integration/resume/:id", integrationExecute, h.ResumeIntegration)
 
 		// Import XML routes
-		protected.POST("/import/xml", h.ImportXML)
-		protected.GET("/import/status/:id", h.GetImportStatus)
+		xmlProcess := middleware.RequirePermission(permissions.XMLProcess)
+		xmlView := middleware.RequirePermission(permissions.XMLView)
+		protected.POST("/import/xml", xmlProcess, h.ImportXML)
+		protected.GET("/import/status/:id", xmlView, h.GetImportStatus)
 
 		// XML Integrator routes
-		protected.POST("/xml-integrator/process", h.ProcessXMLIntegration)
-		protected.GET("/xml-integrator/logs/:process_id", h.GetXMLIntegrationLogs)
+		protected.POST("/xml-integrator/process", xmlProcess, h.ProcessXMLIntegration)
+		protected.GET("/xml-integrator/logs", xmlView, h.GetXMLIntegrationLogHistory)
+		protected.GET("/xml-integrator/logs/:process_id", xmlView, h.GetXMLIntegrationLogs)
+		protected.GET("/xml-integrator/runs", xmlView, h.GetXMLIntegrationRuns)
+		protected.GET("/xml-integrator/scheduled", xmlView, h.GetScheduledXMLUploads)
+
+		// Notification inbox and subscriptions of the logged-in user
+		protected.GET("/notifications", h.GetNotifications)
+		protected.POST("/notifications/:id/read", h.MarkNotificationRead)
+		protected.POST("/notifications/read-all", h.MarkAllNotificationsRead)
+		protected.GET("/notifications/subscriptions", h.GetNotificationSubscriptions)
+		protected.POST("/notifications/subscriptions", h.SubscribeNotifications)
+		protected.DELETE("/notifications/subscriptions/:id", h.UnsubscribeNotifications)
 
-		// DePara routes
-		protected.GET("/depara/tables", h.GetAvailableTables)
-		protected.POST("/depara/search", h.SearchDeParaProducts)
-		protected.GET("/depara", h.GetDeParaProducts)
+		// DePara routes; writes also need depara:write:{conta} for the table
+		deParaView := middleware.RequirePermission(permissions.DeParaView)
+		protected.GET("/depara/tables", deParaView, h.GetAvailableTables)
+		protected.GET("/depara/options", deParaView, h.GetTableOptions)
+		protected.POST("/depara/search", deParaView, h.SearchDeParaProducts)
+		protected.GET("/depara", deParaView, h.GetDeParaProducts)
 		protected.POST("/depara", h.CreateDeParaProduct)
-		protected.GET("/depara/:id", h.GetDeParaProduct)
+		protected.GET("/depara/:id", deParaView, h.GetDeParaProduct)
 		protected.PUT("/depara/:id", h.UpdateDeParaProduct)
 		protected.DELETE("/depara/:id", h.DeleteDeParaProduct)
 
-		// Audit routes
-		protected.GET("/audit/logs", h.GetAuditLogs)
-		protected.POST("/audit/rollback/:audit_id", h.ExecuteRollback)
+		// Audit routes; rollback also needs write access to the audited table
+		protected.GET("/audit/logs", middleware.RequirePermission(permissions.AuditView), h.GetAuditLogs)
+		protected.POST("/audit/rollback/:audit_id", middleware.RequirePermission(permissions.AuditRollback), h.ExecuteRollback)
 
-		// Stock routes
-		protected.GET("/stock", h.GetStock)
-		protected.POST("/stock/search", h.SearchStock)
+		// Stock routes; cost columns need stock:view-cost
+		stockView := middleware.RequirePermission(permissions.StockView)
+		protected.GET("/stock", stockView, h.GetStock)
+		protected.POST("/stock/search", stockView, h.SearchStock)
 
 		// First login routes
 		protected.POST("/auth/first-login", h.ChangePasswordFirstLogin)
 
 		// Dashboard routes
-		protected.GET("/dashboard/stats", h.GetDashboardStats)
-		protected.GET("/dashboard/test-tables", h.TestDashboardTables)
-		protected.POST("/dashboard/populate-test", h.PopulateTestData)
-		protected.GET("/dashboard/debug-queries", h.DebugQueries)
-		protected.GET("/test/tables-check", h.TestTablesCheck)
+		dashboardView := middleware.RequirePermission(permissions.DashboardView)
+		adminAccess := middleware.RequirePermission(permissions.AdminAccess)
+		protected.GET("/dashboard/stats", dashboardView, h.GetDashboardStats)
+		protected.GET("/dashboard/test-tables", dashboardView, h.TestDashboardTables)
+		protected.POST("/dashboard/populate-test", adminAccess, h.PopulateTestData)
+		protected.GET("/dashboard/debug-queries", adminAccess, h.DebugQueries)
+		protected.GET("/test/tables-check", dashboardView, h.TestTablesCheck)
 	}
 
 	// Admin-only routes
 	admin := r.Group("/api/v1")
-	admin.Use(middleware.AuthMiddleware(cfg.JWTSecret))
+	admin.Use(middleware.AuthMiddleware(cfg.JWTSecret, h.Sessions()))
 	admin.Use(middleware.AdminMiddleware())
 	{
 		// User management routes
@@ -163,6 +178,57 @@ func main() {
 		admin.PUT("/users", h.UpdateUser)
 		admin.POST("/users/reset-password", h.ResetUserPassword)
 		admin.DELETE("/users/:id", h.DeleteUser)
+		admin.POST("/users/:id/revoke-sessions", h.RevokeUserSessions)
+		admin.GET("/users/:id/contas", h.GetUserContas)
+		admin.PUT("/users/:id/contas", h.SetUserContas)
+
+		// Roles (named permission sets)
+		admin.GET("/permissions", h.GetPermissions)
+		admin.GET("/roles", h.GetRoles)
+		admin.POST("/roles", h.CreateRole)
+		admin.PUT("/roles/:name", h.UpdateRole)
+		admin.DELETE("/roles/:name", h.DeleteRole)
+
+		// Order poller routes
+		admin.GET("/order-poller/contas", h.GetOrderPollerContas)
+		admin.PUT("/order-poller/contas/:conta", h.UpdateOrderPollerConta)
+		admin.POST("/order-poller/run", h.RunOrderPoller)
+
+		// Scheduled XML invoice sweep
+		admin.GET("/xml-sweep/runs", h.GetXMLSweepRuns)
+		admin.GET("/xml-sweep/runs/:id", h.GetXMLSweepRun)
+		admin.POST("/xml-sweep/run", h.RunXMLSweep)
+
+		// Mercado Livre OAuth credentials
+		admin.GET("/ml-credentials", h.GetMLCredentials)
+		admin.PUT("/ml-credentials/:conta", h.SaveMLCredentials)
+		admin.POST("/ml-credentials/:conta/refresh", h.RefreshMLToken)
+		admin.DELETE("/ml-credentials/:conta", h.DeleteMLCredentials)
+
+		// Empresa/fornecedor routing rules
+		admin.GET("/routing-rules", h.GetRoutingRules)
+		admin.POST("/routing-rules", h.CreateRoutingRule)
+		admin.POST("/routing-rules/reload", h.ReloadRoutingRules)
+		admin.PUT("/routing-rules/:id", h.UpdateRoutingRule)
+		admin.DELETE("/routing-rules/:id", h.DeleteRoutingRule)
+
+		// Notification channels, routes, templates and delivery queue
+		admin.GET("/notifications/channels", h.GetNotificationChannels)
+		admin.POST("/notifications/channels", h.CreateNotificationChannel)
+		admin.PUT("/notifications/channels/:id", h.UpdateNotificationChannel)
+		admin.DELETE("/notifications/channels/:id", h.DeleteNotificationChannel)
+		admin.POST("/notifications/channels/:id/test", h.TestNotificationChannel)
+		admin.GET("/notifications/routes", h.GetNotificationRoutes)
+		admin.POST("/notifications/routes", h.CreateNotificationRoute)
+		admin.DELETE("/notifications/routes/:id", h.DeleteNotificationRoute)
+		admin.GET("/notifications/templates", h.GetNotificationTemplates)
+		admin.PUT("/notifications/templates/:kind", h.UpdateNotificationTemplate)
+		admin.DELETE("/notifications/templates/:kind", h.DeleteNotificationTemplate)
+		admin.GET("/notifications/outbox", h.GetNotificationOutbox)
+		admin.POST("/notifications/digest", h.SendNotificationDigest)
+
+		// Outbound HTTP metrics
+		admin.GET("/http-metrics", h.GetHTTPMetrics)
 	}
 
 	// Health check
@@ -176,6 +242,32 @@ func main() {
 		port = "8080"
 	}
 
-	log.Printf("Server starting on port %s", port)
-	log.Fatal(r.Run(":" + port))
+	// Requests run on their own base context, cancelled only after Shutdown has
+	// drained them or timed out, so in-flight requests survive the signal
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
+	srv := &http.Server{
+		Addr:        ":" + port,
+		Handler:     r,
+		BaseContext: func(net.Listener) context.Context { return requestCtx },
+	}
+
+	go func() {
+		log.Printf("Server starting on port %s", port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Println("🛑 Shutting down server...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("⚠️ Server shutdown: %v", err)
+	}
+	cancelRequests()
 }
@@ -69,7 +69,7 @@ export default function DeParaPage() {
 
   const loadTables = async () => {
     try {
-      const response = await axios.get('/api/v1/test/depara/tables');
+      const response = await axios.get('/api/v1/depara/tables');
       if (response.data.success) {
         setTables(response.data.data);
       }
@@ -80,7 +80,7 @@ export default function DeParaPage() {
 
   const loadTableOptions = async () => {
     try {
-      const response = await axios.get('/api/v1/test/depara/options');
+      const response = await axios.get('/api/v1/depara/options');
       if (response.data.success) {
         setTableOptions(response.data.data);
       }
@@ -125,7 +125,7 @@ export default function DeParaPage() {
     
     try {
       const searchType = detectSearchType(searchQuery);
-      const response = await axios.post(`/api/v1/test/depara/search`, {
+      const response = await axios.post(`/api/v1/depara/search`, {
         table_name: getCurrentTableName(),
         query: searchQuery.trim(),
         search_by: searchType
@@ -197,7 +197,7 @@ export default function DeParaPage() {
     }
     
     try {
-      const response = await axios.put(`/api/v1/test/depara/${selectedProduct.id}?table=${getCurrentTableName()}`, {
+      const response = await axios.put(`/api/v1/depara/${selectedProduct.id}?table=${getCurrentTableName()}`, {
         sku: editForm.sku.trim(),
         company: editForm.company.trim()
       });
@@ -229,7 +229,7 @@ export default function DeParaPage() {
     }
     
     try {
-      const response = await axios.delete(`/api/v1/test/depara/${selectedProduct.id}?table=${getCurrentTableName()}`);
+      const response = await axios.delete(`/api/v1/depara/${selectedProduct.id}?table=${getCurrentTableName()}`);
       
       if (response.data.success) {
         setProducts(products.filter(p => p.id !== selectedProduct.id));
@@ -274,7 +274,7 @@ export default function DeParaPage() {
     }
     
     try {
-      const response = await axios.post('/api/v1/test/depara', {
+      const response = await axios.post('/api/v1/depara', {
         table_name: getCurrentTableName(),
         id: createForm.id.trim(),
         sku: createForm.sku.trim(),
@@ -58,6 +58,26 @@ export default function IntegrationPage() {
     }));
   };
 
+  const waitForIntegrationJob = async (jobId: string) => {
+    while (true) {
+      await new Promise(resolve => setTimeout(resolve, 2000));
+
+      const response = await fetch(`/api/v1/integration/status/${jobId}`, {
+        headers: {
+          'Authorization': `Bearer ${localStorage.getItem('token')}`,
+        },
+      });
+      const data = await response.json();
+
+      if (!response.ok) {
+        throw new Error(data.message || 'Erro ao consultar integração');
+      }
+      if (data.data.status === 'completed' || data.data.status === 'failed') {
+        return data.data;
+      }
+    }
+  };
+
   const handleProcess = async () => {
     if (!formData.conta || !formData.marketplace || !formData.num_pedido) {
       setError('Todos os campos são obrigatórios');
@@ -82,10 +102,20 @@ export default function IntegrationPage() {
       const data = await response.json();
 
       if (response.ok) {
-        setResult(data.data);
-        console.log('🔍 API Response data:', data);
-        console.log('🔍 API Response data.data:', data.data);
-        console.log('🔍 API Response data.data.logs:', data.data?.logs);
+        console.log('🔍 Job de integração criado:', data.data);
+        const job = await waitForIntegrationJob(data.data.id);
+        if (job.status === 'failed') {
+          setError(job.error_message || 'Erro ao processar integração');
+        }
+        setResult({
+          total_processed: 1,
+          success_count: job.status === 'completed' ? 1 : 0,
+          error_count: job.status === 'completed' ? 0 : 1,
+          results: job.status === 'completed'
+            ? [{ pedido: job.num_pedido, numero_pedido: job.prenota || '', status: 'sucesso' }]
+            : [],
+          logs: job.logs || [],
+        });
       } else {
         setError(data.message || 'Erro ao processar integração');
       }
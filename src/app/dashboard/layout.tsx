@@ -4,6 +4,7 @@ import { useEffect, useState } from 'react'
 import { useRouter } from 'next/navigation'
 import Sidebar from '@/components/Sidebar'
 import Header from '@/components/Header'
+import { installAuthFetch } from '@/lib/auth'
 
 export default function DashboardLayout({
   children,
@@ -15,6 +16,8 @@ export default function DashboardLayout({
   const router = useRouter()
 
   useEffect(() => {
+    installAuthFetch()
+
     const token = localStorage.getItem('token')
     const userData = localStorage.getItem('user')
 
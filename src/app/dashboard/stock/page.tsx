@@ -44,7 +44,7 @@ export default function StockPage() {
     setStockData(null);
 
     try {
-      const response = await axios.post<StockResponse>('/api/v1/test/stock/search', {
+      const response = await axios.post<StockResponse>('/api/v1/stock/search', {
         sku: sku.trim()
       });
 
@@ -3,6 +3,7 @@
 import { useState } from 'react'
 import { useRouter } from 'next/navigation'
 import { Eye, EyeOff, LogIn, Car, Package, Database, Upload } from 'lucide-react'
+import { saveSession } from '@/lib/auth'
 
 export default function HomePage() {
   const [showPassword, setShowPassword] = useState(false)
@@ -29,8 +30,7 @@ export default function HomePage() {
       const data = await response.json()
 
       if (data.success) {
-        localStorage.setItem('token', data.data.token)
-        localStorage.setItem('user', JSON.stringify(data.data.user))
+        saveSession(data.data)
         router.push('/dashboard')
       } else {
         alert(data.message || 'Erro ao fazer login')
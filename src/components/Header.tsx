@@ -2,6 +2,7 @@
 
 import { useState } from 'react'
 import { Bell, Search, User, LogOut, Settings } from 'lucide-react'
+import { logout } from '@/lib/auth'
 
 interface HeaderProps {
   user: any
@@ -10,9 +11,8 @@ interface HeaderProps {
 export default function Header({ user }: HeaderProps) {
   const [isProfileOpen, setIsProfileOpen] = useState(false)
 
-  const handleLogout = () => {
-    localStorage.removeItem('token')
-    localStorage.removeItem('user')
+  const handleLogout = async () => {
+    await logout()
     window.location.href = '/'
   }
 
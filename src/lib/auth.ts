@@ -0,0 +1,119 @@
+// Sessões do portal: o token de acesso dura poucos minutos e é renovado com o
+// refresh token (rotacionado a cada uso) quando a API responde 401.
+
+import axios, { AxiosError, InternalAxiosRequestConfig } from 'axios'
+
+let refreshing: Promise<boolean> | null = null
+let installed = false
+
+function clearSession() {
+  localStorage.removeItem('token')
+  localStorage.removeItem('refresh_token')
+  localStorage.removeItem('user')
+}
+
+export function saveSession(data: { token: string; refresh_token: string; user?: unknown }) {
+  localStorage.setItem('token', data.token)
+  localStorage.setItem('refresh_token', data.refresh_token)
+  if (data.user) {
+    localStorage.setItem('user', JSON.stringify(data.user))
+  }
+}
+
+// Troca o refresh token por um novo par; chamadas simultâneas compartilham a
+// mesma renovação, já que o refresh token só pode ser usado uma vez
+function refreshSession(originalFetch: typeof fetch): Promise<boolean> {
+  if (!refreshing) {
+    refreshing = (async () => {
+      const refreshToken = localStorage.getItem('refresh_token')
+      if (!refreshToken) return false
+      try {
+        const response = await originalFetch('/api/v1/auth/refresh', {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ refresh_token: refreshToken }),
+        })
+        const data = await response.json()
+        if (!response.ok || !data.success) return false
+        saveSession(data.data)
+        return true
+      } catch {
+        return false
+      }
+    })().finally(() => {
+      refreshing = null
+    })
+  }
+  return refreshing
+}
+
+// Instala o fetch e os interceptors do axios que renovam a sessão e repetem a
+// requisição uma vez quando /api/v1 responde 401; sem sessão válida volta
+// para o login
+export function installAuthFetch() {
+  if (installed || typeof window === 'undefined') return
+  installed = true
+
+  const originalFetch = window.fetch.bind(window)
+  window.fetch = async (input: RequestInfo | URL, init?: RequestInit) => {
+    const response = await originalFetch(input, init)
+    const url = typeof input === 'string' ? input : input instanceof URL ? input.href : input.url
+    if (response.status !== 401 || !url.includes('/api/v1/') || url.includes('/api/v1/auth/')) {
+      return response
+    }
+
+    if (!(await refreshSession(originalFetch))) {
+      clearSession()
+      window.location.href = '/'
+      return response
+    }
+
+    const headers = new Headers(init?.headers ?? (input instanceof Request ? input.headers : undefined))
+    headers.set('Authorization', `Bearer ${localStorage.getItem('token')}`)
+    return originalFetch(input, { ...init, headers })
+  }
+
+  // As páginas que usam axios recebem o mesmo tratamento: o token vai no
+  // header e um 401 renova a sessão e repete a requisição uma vez
+  axios.interceptors.request.use((config) => {
+    const token = localStorage.getItem('token')
+    if (token && config.url?.startsWith('/api/v1/') && !config.headers.Authorization) {
+      config.headers.Authorization = `Bearer ${token}`
+    }
+    return config
+  })
+  axios.interceptors.response.use(undefined, async (error: AxiosError) => {
+    const config = error.config as (InternalAxiosRequestConfig & { retried?: boolean }) | undefined
+    const url = config?.url ?? ''
+    if (!config || config.retried || error.response?.status !== 401 || !url.startsWith('/api/v1/') || url.startsWith('/api/v1/auth/')) {
+      throw error
+    }
+
+    if (!(await refreshSession(originalFetch))) {
+      clearSession()
+      window.location.href = '/'
+      throw error
+    }
+
+    config.retried = true
+    config.headers.Authorization = `Bearer ${localStorage.getItem('token')}`
+    return axios(config)
+  })
+}
+
+// Encerra a sessão no backend e localmente
+export async function logout() {
+  const refreshToken = localStorage.getItem('refresh_token')
+  if (refreshToken) {
+    try {
+      await fetch('/api/v1/auth/logout', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ refresh_token: refreshToken }),
+      })
+    } catch {
+      // A sessão expira sozinha se o backend estiver fora
+    }
+  }
+  clearSession()
+}